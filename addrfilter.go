@@ -0,0 +1,86 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/exp/slog"
+)
+
+// An AddressFilter reports whether a name server address learned during
+// resolution, from glue records or by resolving a name server's name, may be
+// queried.
+type AddressFilter func(addr net.IP) bool
+
+// DefaultAddressFilter rejects private (RFC 1918 and RFC 4193) addresses,
+// which are unreachable from the public internet.
+func DefaultAddressFilter(addr net.IP) bool {
+	return !addr.IsPrivate()
+}
+
+// AllowAllAddresses accepts every address, which may be useful when
+// resolving names served by internal name servers.
+func AllowAllAddresses(addr net.IP) bool {
+	return true
+}
+
+// specialPurposeNets are address blocks that should never be used by name
+// servers on the public internet:
+// https://www.iana.org/assignments/iana-ipv4-special-registry
+// https://www.iana.org/assignments/iana-ipv6-special-registry
+var specialPurposeNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001:db8::/32",
+)
+
+// StrictAddressFilter additionally rejects loopback, link-local, multicast,
+// unspecified and other special-purpose addresses, so that a malicious
+// delegation cannot direct queries at hosts on the local network.
+func StrictAddressFilter(addr net.IP) bool {
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, n := range specialPurposeNets {
+		if n.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterNameServerAddrs returns the given name server's addresses that are
+// accepted by the resolver's address filter.
+func (r *Resolver) filterNameServerAddrs(ctx context.Context, name string, addrs []net.IP) []net.IP {
+	allowed := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if !r.addrFilter(addr) {
+			r.log(ctx).Debug(
+				"skipping filtered name server address",
+				slog.String("ns_domain", name),
+				slog.String("ns_addr", addr.String()),
+			)
+			continue
+		}
+		allowed = append(allowed, addr)
+	}
+	return allowed
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	results := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		results[i] = mustParseCIDR(cidr)
+	}
+	return results
+}
@@ -0,0 +1,86 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestAddressFilters(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		wantDefault bool
+		wantStrict  bool
+	}{
+		"8.8.8.8":              {true, true},
+		"2001:4860:4860::8888": {true, true},
+		"10.0.0.1":             {false, false},
+		"192.168.1.1":          {false, false},
+		"fd00::1":              {false, false},
+		"127.0.0.1":            {true, false},
+		"::1":                  {true, false},
+		"169.254.169.254":      {true, false},
+		"fe80::1":              {true, false},
+		"0.0.0.0":              {true, false},
+		"224.0.0.251":          {true, false},
+		"100.64.0.1":           {true, false},
+		"192.0.2.1":            {true, false},
+		"2001:db8::1":          {true, false},
+	}
+	for addr, tc := range testCases {
+		addr, tc := addr, tc
+		t.Run(addr, func(t *testing.T) {
+			t.Parallel()
+			ip := net.ParseIP(addr)
+			be.Equal(t, tc.wantDefault, DefaultAddressFilter(ip))
+			be.Equal(t, tc.wantStrict, StrictAddressFilter(ip))
+			be.True(t, AllowAllAddresses(ip))
+		})
+	}
+}
+
+func TestLookupIPAddressFilter(t *testing.T) {
+	t.Parallel()
+
+	// the root delegates example.test to a name server whose glue and A
+	// record both point at loopback
+	newServer := func() string {
+		var mu sync.Mutex
+		var n int
+		return startTestServer(t, func(q Message) Message {
+			mu.Lock()
+			defer mu.Unlock()
+			n++
+			if n == 1 {
+				return referral("example.test", "ns1.example.test")
+			}
+			data := []byte{1, 2, 3, 4}
+			if strings.EqualFold(string(q.Questions[0].Name), "ns1.example.test") {
+				data = []byte{127, 0, 0, 1}
+			}
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: data}},
+			}
+		})
+	}
+
+	t.Run("default filter allows loopback", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(), nil)
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, "1.2.3.4", ips[0].String())
+	})
+
+	t.Run("strict filter rejects loopback", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(), &Opts{AddressFilter: StrictAddressFilter})
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.In(t, `no IP addresses found for nameserver "ns1.example.test"`, err.Error())
+	})
+}
@@ -0,0 +1,218 @@
+package dnstoy
+
+import (
+	"net"
+	"sort"
+)
+
+// normalizeIPs returns the given addresses with IPv4 addresses, including
+// IPv4-mapped IPv6 addresses, in their 4-byte form, and duplicates removed,
+// keeping the first occurrence of each address.
+func normalizeIPs(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	seen := make(map[string]bool, len(ips))
+	results := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if key := string(ip); !seen[key] {
+			seen[key] = true
+			results = append(results, ip)
+		}
+	}
+	return results
+}
+
+// sortRFC6724 sorts the given addresses, in place, in the order in which
+// they should be tried according to RFC 6724's destination address
+// selection rules, taking into account the source addresses this host
+// would use to reach each one.
+// https://datatracker.ietf.org/doc/html/rfc6724#section-6
+func sortRFC6724(ips []net.IP) {
+	if len(ips) < 2 {
+		return
+	}
+	srcs := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		srcs[i] = sourceAddr(ip)
+	}
+	sortAddrs(ips, srcs)
+}
+
+// sourceAddr returns the source address this host would use to send
+// packets to the given destination, or nil if it is unreachable. Connecting
+// a UDP socket sends no packets.
+func sourceAddr(dst net.IP) net.IP {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// sortAddrs sorts the given destination addresses, in place, according to
+// RFC 6724, given the source address for each one.
+func sortAddrs(ips, srcs []net.IP) {
+	type candidate struct {
+		dst, src       net.IP
+		dstPol, srcPol policy
+	}
+	candidates := make([]candidate, len(ips))
+	for i := range ips {
+		candidates[i] = candidate{dst: ips[i], src: srcs[i], dstPol: policyOf(ips[i])}
+		if srcs[i] != nil {
+			candidates[i].srcPol = policyOf(srcs[i])
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		// Rule 1: avoid unusable destinations
+		if (a.src == nil) != (b.src == nil) {
+			return b.src == nil
+		}
+		if a.src == nil {
+			return false
+		}
+
+		// Rule 2: prefer matching scope
+		aScope, bScope := addrScope(a.dst), addrScope(b.dst)
+		aMatch, bMatch := aScope == addrScope(a.src), bScope == addrScope(b.src)
+		if aMatch != bMatch {
+			return aMatch
+		}
+
+		// Rules 3 and 4, avoiding deprecated addresses and preferring home
+		// addresses, need information about source addresses that is not
+		// available here.
+
+		// Rule 5: prefer matching label
+		aMatch, bMatch = a.dstPol.label == a.srcPol.label, b.dstPol.label == b.srcPol.label
+		if aMatch != bMatch {
+			return aMatch
+		}
+
+		// Rule 6: prefer higher precedence
+		if a.dstPol.precedence != b.dstPol.precedence {
+			return a.dstPol.precedence > b.dstPol.precedence
+		}
+
+		// Rule 7, preferring native transport, is covered by the labels of
+		// the policy table.
+
+		// Rule 8: prefer smaller scope
+		if aScope != bScope {
+			return aScope < bScope
+		}
+
+		// Rule 9: use longest matching prefix, for IPv6 addresses only, as
+		// many IPv4 networks are not allocated hierarchically
+		if a.dst.To4() == nil && b.dst.To4() == nil {
+			aLen, bLen := commonPrefixLen(a.dst, a.src), commonPrefixLen(b.dst, b.src)
+			if aLen != bLen {
+				return aLen > bLen
+			}
+		}
+
+		// Rule 10: otherwise, leave the order unchanged
+		return false
+	})
+	for i, c := range candidates {
+		ips[i] = c.dst
+	}
+}
+
+type policy struct {
+	precedence int
+	label      int
+}
+
+// policyTable is RFC 6724's default policy table, with the longest prefixes
+// first.
+// https://datatracker.ietf.org/doc/html/rfc6724#section-2.1
+var policyTable = []struct {
+	prefix *net.IPNet
+	policy
+}{
+	{mustParseCIDR("::1/128"), policy{50, 0}},
+	{mustParseCIDR("::ffff:0:0/96"), policy{35, 4}},
+	{mustParseCIDR("::/96"), policy{1, 3}},
+	{mustParseCIDR("2001::/32"), policy{5, 5}},
+	{mustParseCIDR("2002::/16"), policy{30, 2}},
+	{mustParseCIDR("3ffe::/16"), policy{1, 12}},
+	{mustParseCIDR("fec0::/10"), policy{1, 11}},
+	{mustParseCIDR("fc00::/7"), policy{3, 13}},
+	{mustParseCIDR("::/0"), policy{40, 1}},
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return prefix
+}
+
+// policyOf returns the policy for the given address, with IPv4 addresses
+// treated as IPv4-mapped IPv6 addresses.
+func policyOf(ip net.IP) policy {
+	// net.IPNet.Contains never matches IPv4 addresses against IPv6 prefixes
+	if ip.To4() != nil {
+		return policyTable[1].policy
+	}
+	for _, entry := range policyTable {
+		if entry.prefix.Contains(ip) {
+			return entry.policy
+		}
+	}
+	return policyTable[len(policyTable)-1].policy
+}
+
+// Address scopes, from RFC 4291 and RFC 6724 section 3.2.
+const (
+	scopeLinkLocal = 0x2
+	scopeSiteLocal = 0x5
+	scopeGlobal    = 0xe
+)
+
+func addrScope(ip net.IP) int {
+	if ip4 := ip.To4(); ip4 != nil {
+		if ip4.IsLoopback() || ip4.IsLinkLocalUnicast() {
+			return scopeLinkLocal
+		}
+		return scopeGlobal
+	}
+	switch {
+	case ip.IsMulticast():
+		return int(ip[1] & 0xf)
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case ip[0] == 0xfe && ip[1]&0xc0 == 0xc0:
+		return scopeSiteLocal
+	}
+	return scopeGlobal
+}
+
+// commonPrefixLen returns the length of the prefix shared by the given IPv6
+// addresses, up to the 64 bits of their network prefixes.
+func commonPrefixLen(a, b net.IP) int {
+	a, b = a.To16(), b.To16()
+	n := 0
+	for i := 0; i < 8; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
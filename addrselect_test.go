@@ -0,0 +1,101 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func parseIPs(strs ...string) []net.IP {
+	ips := make([]net.IP, len(strs))
+	for i, s := range strs {
+		ips[i] = net.ParseIP(s)
+	}
+	return ips
+}
+
+func TestNormalizeIPs(t *testing.T) {
+	t.Parallel()
+
+	ips := normalizeIPs(parseIPs("192.0.2.1", "2001:db8::1", "::ffff:192.0.2.1", "192.0.2.2", "2001:db8::1"))
+	be.AllEqual(t, []string{"192.0.2.1", "2001:db8::1", "192.0.2.2"}, ipStrings(ips))
+	be.Equal(t, net.IPv4len, len(ips[0]))
+	be.Equal(t, net.IPv6len, len(ips[1]))
+}
+
+func TestSortAddrs(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dsts []string
+		srcs []string // "" for unreachable destinations
+		want []string
+	}{
+		"unreachable last": {
+			dsts: []string{"2001:db8::1", "192.0.2.1"},
+			srcs: []string{"", "192.0.2.100"},
+			want: []string{"192.0.2.1", "2001:db8::1"},
+		},
+		"IPv6 preferred when reachable": {
+			dsts: []string{"192.0.2.1", "2600::1"},
+			srcs: []string{"192.0.2.100", "2600::100"},
+			want: []string{"2600::1", "192.0.2.1"},
+		},
+		"matching scope preferred": {
+			dsts: []string{"2600::1", "fe80::1"},
+			srcs: []string{"fe80::100", "fe80::100"},
+			want: []string{"fe80::1", "2600::1"},
+		},
+		"matching label preferred": {
+			dsts: []string{"2002:c000:201::1", "2600::1"},
+			srcs: []string{"2600::100", "2600::100"},
+			want: []string{"2600::1", "2002:c000:201::1"},
+		},
+		"longest matching prefix": {
+			dsts: []string{"2600:1::1", "2600:2::1"},
+			srcs: []string{"2600:1::100", "2600:2::100"},
+			want: []string{"2600:1::1", "2600:2::1"},
+		},
+		"IPv4 order preserved": {
+			dsts: []string{"203.0.113.1", "192.0.2.1"},
+			srcs: []string{"192.0.2.100", "192.0.2.100"},
+			want: []string{"203.0.113.1", "192.0.2.1"},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ips := parseIPs(tc.dsts...)
+			srcs := parseIPs(tc.srcs...)
+			sortAddrs(ips, srcs)
+			be.AllEqual(t, tc.want, ipStrings(ips))
+		})
+	}
+}
+
+func TestLookupIPDedupes(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		question := q.Questions[0]
+		data := []byte{192, 0, 2, 1}
+		if question.Type == RecordTypeAAAA {
+			data = net.ParseIP("::ffff:192.0.2.1")
+		}
+		return Message{
+			Answers: []Record{
+				{Name: question.Name, Type: question.Type, Class: ResourceClassIN, TTL: 60, Data: data},
+				{Name: question.Name, Type: question.Type, Class: ResourceClassIN, TTL: 60, Data: data},
+			},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	ips, err := r.LookupIP(context.Background(), "ip", "www.example.test")
+	be.NilErr(t, err)
+	be.AllEqual(t, []string{"192.0.2.1"}, ipStrings(ips))
+	be.Equal(t, net.IPv4len, len(ips[0]))
+}
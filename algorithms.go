@@ -0,0 +1,172 @@
+package dnstoy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// DNSSEC algorithm numbers:
+// https://www.iana.org/assignments/dns-sec-alg-numbers/dns-sec-alg-numbers.xhtml
+const (
+	AlgorithmRSASHA256       = 8
+	AlgorithmECDSAP256SHA256 = 13
+	AlgorithmECDSAP384SHA384 = 14
+	AlgorithmED25519         = 15
+)
+
+// AlgorithmName returns the mnemonic for a DNSSEC algorithm number, e.g.
+// "RSASHA256" for 8.
+func AlgorithmName(algorithm uint8) string {
+	switch algorithm {
+	case 5:
+		return "RSASHA1"
+	case 7:
+		return "RSASHA1-NSEC3-SHA1"
+	case AlgorithmRSASHA256:
+		return "RSASHA256"
+	case 10:
+		return "RSASHA512"
+	case AlgorithmECDSAP256SHA256:
+		return "ECDSAP256SHA256"
+	case AlgorithmECDSAP384SHA384:
+		return "ECDSAP384SHA384"
+	case AlgorithmED25519:
+		return "ED25519"
+	case 16:
+		return "ED448"
+	default:
+		return fmt.Sprintf("ALG%d", algorithm)
+	}
+}
+
+// ErrUnsupportedAlgorithm is returned when verifying a signature made with an
+// algorithm that has no SignatureVerifier.
+var ErrUnsupportedAlgorithm = errors.New("unsupported DNSSEC algorithm")
+
+// A SignatureVerifier checks that a signature over the given data was made
+// with the private key corresponding to the given public key, which is in
+// the format used by DNSKEY records for the verifier's algorithm.
+type SignatureVerifier func(publicKey, data, signature []byte) error
+
+// Algorithms maps DNSSEC algorithm numbers to the SignatureVerifier for each
+// algorithm.
+type Algorithms map[uint8]SignatureVerifier
+
+// defaultAlgorithms holds the built-in algorithms, and must not be modified.
+var defaultAlgorithms = Algorithms{
+	AlgorithmRSASHA256:       verifyRSA(crypto.SHA256),
+	AlgorithmECDSAP256SHA256: verifyECDSA(elliptic.P256(), crypto.SHA256),
+	AlgorithmECDSAP384SHA384: verifyECDSA(elliptic.P384(), crypto.SHA384),
+	AlgorithmED25519:         verifyEd25519,
+}
+
+// DefaultAlgorithms returns the algorithms supported by dnstoy: RSASHA256,
+// ECDSAP256SHA256, ECDSAP384SHA384 and ED25519. The returned map may be
+// modified to add or remove algorithms before using it in Opts.
+func DefaultAlgorithms() Algorithms {
+	algorithms := make(Algorithms, len(defaultAlgorithms))
+	for alg, verifier := range defaultAlgorithms {
+		algorithms[alg] = verifier
+	}
+	return algorithms
+}
+
+// supportsDS returns true if the DS record's digest type and the algorithm
+// of the key it refers to are both supported. Zones whose DS records are all
+// unsupported are treated as unsigned:
+// https://datatracker.ietf.org/doc/html/rfc4035#section-5.2
+func (a Algorithms) supportsDS(ds DS) bool {
+	switch ds.DigestType {
+	case DigestTypeSHA1, DigestTypeSHA256, DigestTypeSHA384:
+		_, found := a[ds.Algorithm]
+		return found
+	default:
+		return false
+	}
+}
+
+// verifyRSA returns a SignatureVerifier for RSA signatures using the given
+// hash:
+// https://datatracker.ietf.org/doc/html/rfc5702
+func verifyRSA(hash crypto.Hash) SignatureVerifier {
+	return func(publicKey, data, signature []byte) error {
+		pub, err := parseRSAPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature)
+	}
+}
+
+// parseRSAPublicKey parses an RSA public key in the format used by DNSKEY
+// records:
+// https://datatracker.ietf.org/doc/html/rfc3110#section-2
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	if len(data) < 3 {
+		return nil, errors.New("RSA public key too short")
+	}
+	expLen, data := int(data[0]), data[1:]
+	if expLen == 0 {
+		expLen, data = int(binary.BigEndian.Uint16(data[0:2])), data[2:]
+	}
+	if expLen == 0 || expLen > 4 || len(data) <= expLen {
+		return nil, fmt.Errorf("invalid RSA public key exponent length %d", expLen)
+	}
+	var exp int
+	for _, b := range data[:expLen] {
+		exp = exp<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(data[expLen:]), E: exp}, nil
+}
+
+// verifyECDSA returns a SignatureVerifier for ECDSA signatures using the given
+// curve and hash. Public keys and signatures are the concatenation of two
+// integers of the curve's size, X and Y or R and S respectively:
+// https://datatracker.ietf.org/doc/html/rfc6605#section-4
+func verifyECDSA(curve elliptic.Curve, hash crypto.Hash) SignatureVerifier {
+	size := (curve.Params().BitSize + 7) / 8
+	return func(publicKey, data, signature []byte) error {
+		if len(publicKey) != 2*size {
+			return fmt.Errorf("invalid ECDSA public key length %d", len(publicKey))
+		}
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid ECDSA signature length %d", len(signature))
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(publicKey[:size]),
+			Y:     new(big.Int).SetBytes(publicKey[size:]),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return errors.New("invalid ECDSA public key")
+		}
+		h := hash.New()
+		h.Write(data)
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("ECDSA verification error")
+		}
+		return nil
+	}
+}
+
+// verifyEd25519 verifies Ed25519 signatures:
+// https://datatracker.ietf.org/doc/html/rfc8080#section-3
+func verifyEd25519(publicKey, data, signature []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid Ed25519 public key length %d", len(publicKey))
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), data, signature) {
+		return errors.New("Ed25519 verification error")
+	}
+	return nil
+}
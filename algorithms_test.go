@@ -0,0 +1,65 @@
+package dnstoy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rrset := []Record{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}
+	tampered := []Record{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{4, 3, 2, 1}}}
+	for _, algorithm := range []uint8{AlgorithmRSASHA256, AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384, AlgorithmED25519} {
+		algorithm := algorithm
+		t.Run(fmt.Sprint(algorithm), func(t *testing.T) {
+			t.Parallel()
+			key := newTestZoneKeyWithAlgorithm(t, "example.com", algorithm)
+			sig, err := parseRRSIG(key.sign(t, rrset, now.Add(-time.Hour), now.Add(time.Hour)).Data)
+			be.NilErr(t, err)
+			be.NilErr(t, sig.Verify(key.key, rrset))
+			be.Nonzero(t, sig.Verify(key.key, tampered))
+
+			// the signature is truncated or the key corrupted
+			truncated := sig
+			truncated.Signature = sig.Signature[:len(sig.Signature)-1]
+			be.Nonzero(t, truncated.Verify(key.key, rrset))
+
+			// without the algorithm, verification fails
+			algorithms := DefaultAlgorithms()
+			delete(algorithms, algorithm)
+			err = sig.verify(algorithms, key.key, rrset)
+			be.True(t, errors.Is(err, ErrUnsupportedAlgorithm))
+		})
+	}
+}
+
+func TestAlgorithmsCustom(t *testing.T) {
+	t.Parallel()
+
+	rrset := []Record{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}
+	key := DNSKEY{Flags: DNSKEYFlagZone, Protocol: 3, Algorithm: 253, PublicKey: []byte("key")}
+	sig := RRSIG{TypeCovered: RecordTypeA, Algorithm: 253, KeyTag: key.KeyTag(), SignerName: "example.com", Signature: []byte("signed by key")}
+
+	algorithms := DefaultAlgorithms()
+	algorithms[253] = func(publicKey, data, signature []byte) error {
+		if string(signature) != "signed by "+string(publicKey) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	be.NilErr(t, sig.verify(algorithms, key, rrset))
+	be.True(t, errors.Is(sig.Verify(key, rrset), ErrUnsupportedAlgorithm))
+
+	// a private algorithm's DS records are supported once it is added
+	ds := DS{KeyTag: key.KeyTag(), Algorithm: 253, DigestType: DigestTypeSHA256}
+	be.True(t, algorithms.supportsDS(ds))
+	be.False(t, DefaultAlgorithms().supportsDS(ds))
+	ds.DigestType = 3
+	be.False(t, algorithms.supportsDS(ds))
+}
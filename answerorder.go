@@ -0,0 +1,67 @@
+package dnstoy
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+)
+
+// AnswerOrder controls the order of the addresses returned by LookupIP,
+// LookupIPAddr and LookupHost.
+type AnswerOrder int
+
+// Supported answer orders
+const (
+	// AnswerOrderPreserve returns addresses in the order they were received
+	// from name servers, with IPv4 addresses before IPv6 addresses.
+	AnswerOrderPreserve AnswerOrder = iota
+
+	// AnswerOrderShuffle returns addresses in a random order.
+	AnswerOrderShuffle
+
+	// AnswerOrderRoundRobin rotates addresses by one position each time
+	// the same name is looked up, so that callers connecting to the first
+	// address spread their connections across all of them.
+	AnswerOrderRoundRobin
+)
+
+// maxRoundRobinNames bounds the number of names whose rotation is tracked,
+// beyond which every rotation is forgotten and starts over.
+const maxRoundRobinNames = 10000
+
+// roundRobin tracks the rotation of the addresses of each name looked up
+// with AnswerOrderRoundRobin.
+type roundRobin struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+// rotation returns the number of positions to rotate the addresses found
+// for the given key, advancing it for the next lookup.
+func (rr *roundRobin) rotation(key string) int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.next == nil || len(rr.next) >= maxRoundRobinNames {
+		rr.next = make(map[string]int)
+	}
+	n := rr.next[key]
+	rr.next[key] = n + 1
+	return n
+}
+
+// orderAnswers reorders, in place, the addresses found for the given host
+// according to the resolver's answer order.
+func (r *Resolver) orderAnswers(network, host string, ips []net.IP) {
+	if len(ips) < 2 {
+		return
+	}
+	switch r.answerOrder {
+	case AnswerOrderShuffle:
+		rand.Shuffle(len(ips), func(i, j int) { ips[i], ips[j] = ips[j], ips[i] })
+	case AnswerOrderRoundRobin:
+		n := r.roundRobin.rotation(network+" "+strings.ToLower(host)) % len(ips)
+		rotated := append(append(make([]net.IP, 0, len(ips)), ips[n:]...), ips[:n]...)
+		copy(ips, rotated)
+	}
+}
@@ -0,0 +1,81 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func answerFourAddrs(q Message) Message {
+	msg := Message{}
+	for i := byte(1); i <= 4; i++ {
+		msg.Answers = append(msg.Answers, Record{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{10, 0, 0, i}})
+	}
+	return msg
+}
+
+func ipStrings(ips []net.IP) []string {
+	strs := make([]string, len(ips))
+	for i, ip := range ips {
+		strs[i] = ip.String()
+	}
+	return strs
+}
+
+func TestAnswerOrder(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		order AnswerOrder
+		want  [][]string
+	}{
+		"preserve": {
+			order: AnswerOrderPreserve,
+			want: [][]string{
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+			},
+		},
+		"round robin": {
+			order: AnswerOrderRoundRobin,
+			want: [][]string{
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+				{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.1"},
+				{"10.0.0.3", "10.0.0.4", "10.0.0.1", "10.0.0.2"},
+				{"10.0.0.4", "10.0.0.1", "10.0.0.2", "10.0.0.3"},
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			port := startTestServer(t, answerFourAddrs)
+			r := newTestResolver(port, &Opts{AnswerOrder: tc.order})
+			for _, want := range tc.want {
+				ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+				be.NilErr(t, err)
+				be.AllEqual(t, want, ipStrings(ips))
+			}
+		})
+	}
+}
+
+func TestAnswerOrderShuffle(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, answerFourAddrs)
+	r := newTestResolver(port, &Opts{AnswerOrder: AnswerOrderShuffle})
+	orders := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, 4, len(ips))
+		orders[ips[0].String()] = true
+	}
+	be.True(t, len(orders) > 1)
+}
@@ -0,0 +1,113 @@
+package dnstoy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryAttempt describes a single failed attempt to query a name server.
+type QueryAttempt struct {
+	NameServer string
+	Addr       net.IP // nil for DNS-over-HTTPS upstreams
+	Transport  string // "udp", "tcp", "tls" or "https"
+
+	// RCode is the response's RCODE, or -1 if there was no usable
+	// response. Err explains why the attempt failed either way.
+	RCode int
+	Err   error
+
+	Duration time.Duration
+}
+
+func (a QueryAttempt) String() string {
+	server := a.NameServer
+	if a.Addr != nil {
+		server = fmt.Sprintf("%s (%s)", a.NameServer, a.Addr)
+	}
+	return fmt.Sprintf("%s over %s after %s: %s", server, a.Transport, a.Duration.Round(time.Millisecond), a.Err)
+}
+
+// AttemptsError is returned when a query could not be answered by any of
+// the name servers tried. It records each failed attempt, including retries
+// after timeouts, in order. Use errors.Is and errors.As to check for the
+// errors of individual attempts, e.g. ErrServFail.
+type AttemptsError struct {
+	Name     string
+	Type     RecordType
+	Attempts []QueryAttempt
+
+	// Err is the error that ended the search for a name server to answer,
+	// which is usually the error of the last attempt.
+	Err error
+}
+
+func (e *AttemptsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%d failed attempts: ", e.Err, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(attempt.String())
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// Unwrap returns the final error along with the error of each attempt.
+func (e *AttemptsError) Unwrap() []error {
+	errs := make([]error, 0, 1+len(e.Attempts))
+	errs = append(errs, e.Err)
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// attemptLogKey is the context key used to carry the log of failed attempts
+// to answer a single question.
+type attemptLogKey struct{}
+
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []QueryAttempt
+}
+
+// withAttemptLog returns a context that collects the failed attempts made
+// while querying name servers with it. Nested lookups, e.g. for the
+// addresses of name servers, collect their own.
+func withAttemptLog(ctx context.Context) (context.Context, *attemptLog) {
+	log := &attemptLog{}
+	return context.WithValue(ctx, attemptLogKey{}, log), log
+}
+
+// recordAttempt records a failed attempt in the log carried by ctx, if any.
+func recordAttempt(ctx context.Context, attempt QueryAttempt) {
+	log, ok := ctx.Value(attemptLogKey{}).(*attemptLog)
+	if !ok {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.attempts = append(log.attempts, attempt)
+}
+
+// wrap returns err as an *AttemptsError listing the failed attempts, unless
+// none were made.
+func (l *attemptLog) wrap(domainName string, recordType RecordType, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.attempts) == 0 {
+		return err
+	}
+	return &AttemptsError{
+		Name:     domainName,
+		Type:     recordType,
+		Attempts: append([]QueryAttempt(nil), l.attempts...),
+		Err:      err,
+	}
+}
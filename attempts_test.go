@@ -0,0 +1,69 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestAttemptsError(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n == 1 {
+			msg := referral("fail.test", "ns1.fail.test")
+			ns2 := referral("fail.test", "ns2.fail.test")
+			msg.Authorities = append(msg.Authorities, ns2.Authorities...)
+			msg.Additionals = append(msg.Additionals, ns2.Additionals...)
+			return msg
+		}
+		return Message{Header: Header{Flags: uint16(RCodeServFail)}}
+	})
+	r := newTestResolver(port, nil)
+
+	_, err := r.LookupIP(context.Background(), "ip4", "www.fail.test")
+	be.True(t, errors.Is(err, ErrServFail))
+	var attemptsErr *AttemptsError
+	be.True(t, errors.As(err, &attemptsErr))
+	be.Equal(t, "www.fail.test", attemptsErr.Name)
+	be.Equal(t, 2, len(attemptsErr.Attempts))
+	servers := map[string]bool{}
+	for _, attempt := range attemptsErr.Attempts {
+		servers[attempt.NameServer] = true
+		be.Equal(t, "127.0.0.1", attempt.Addr.String())
+		be.Equal(t, "udp", attempt.Transport)
+		be.Equal(t, int(RCodeServFail), attempt.RCode)
+		be.True(t, errors.Is(attempt.Err, ErrServFail))
+	}
+	be.True(t, servers["ns1.fail.test"] && servers["ns2.fail.test"])
+	be.In(t, "2 failed attempts", err.Error())
+}
+
+func TestAttemptsErrorRetries(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		return noResponse
+	})
+	r := newTestResolver(port, &Opts{QueryTimeout: 20 * time.Millisecond, QueryAttempts: 2, RetryBackoff: time.Millisecond})
+
+	_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	var attemptsErr *AttemptsError
+	be.True(t, errors.As(err, &attemptsErr))
+	be.Equal(t, 2, len(attemptsErr.Attempts))
+	for _, attempt := range attemptsErr.Attempts {
+		be.Equal(t, "root.test", attempt.NameServer)
+		be.Equal(t, -1, attempt.RCode)
+		be.True(t, isTimeout(attempt.Err))
+		be.True(t, attempt.Duration >= 20*time.Millisecond)
+	}
+}
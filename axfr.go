@@ -0,0 +1,117 @@
+package dnstoy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Transfer requests a full transfer of the given zone (AXFR) from the name
+// server at the given address, over TCP, returning the zone's records in
+// the order received, beginning with its SOA record. The copy of the SOA
+// record that ends the transfer is omitted. If key is not nil, the query is
+// signed with it using TSIG, and so must be the response.
+// https://datatracker.ietf.org/doc/html/rfc5936
+func (r *Resolver) Transfer(ctx context.Context, zone string, server netip.AddrPort, key *TSIGKey) ([]Record, error) {
+	ctx, done, err := r.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if err := validateName(zone); err != nil {
+		return nil, err
+	}
+	if !server.IsValid() {
+		return nil, fmt.Errorf("invalid server address %q", server)
+	}
+	records, err := r.transfer(ctx, zone, server, key)
+	if err != nil {
+		return nil, fmt.Errorf("transfer %s from %s: %w", presentName(zone), server, err)
+	}
+	return records, nil
+}
+
+func (r *Resolver) transfer(ctx context.Context, zone string, server netip.AddrPort, key *TSIGKey) ([]Record, error) {
+	query := NewQuery(zone, RecordTypeAXFR)
+	data := query.Encode()
+	var verifier *tsigVerifier
+	if key != nil {
+		signed, mac, err := key.sign(data, nil, false, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		data = signed
+		verifier = &tsigVerifier{key: *key, prior: mac, now: time.Now}
+	}
+
+	conn, err := r.dialer.DialContext(ctx, "tcp", server.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// reads are interrupted by closing the connection when ctx is done
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)); err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		// each message must arrive in time, however long the transfer
+		conn.SetReadDeadline(time.Now().Add(r.queryTimeout))
+		buf, err := readStreamMessage(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if verifier != nil {
+			if err := verifier.verify(buf); err != nil {
+				return nil, err
+			}
+		}
+		msg, err := ParseMessage(buf, r.parseMode)
+		var partialErr *PartialMessageError
+		if err != nil && !errors.As(err, &partialErr) {
+			return nil, err
+		}
+		if msg.Header.ID != query.Header.ID || !msg.Header.QR() {
+			return nil, fmt.Errorf("%w: got ID %d, expected %d", ErrMismatchedResponse, msg.Header.ID, query.Header.ID)
+		}
+		if err := rcodeError(msg.Header.RCode()); err != nil {
+			return nil, err
+		}
+		if len(msg.Answers) == 0 {
+			return nil, errors.New("response has no records")
+		}
+		for _, rec := range msg.Answers {
+			switch {
+			case len(records) == 0:
+				if rec.Type != RecordTypeSOA || canonicalName(string(rec.Name)) != canonicalName(zone) {
+					return nil, fmt.Errorf("transfer begins with %s %s record, not the zone's SOA record", presentName(string(rec.Name)), rec.Type)
+				}
+			case rec.Type == RecordTypeSOA:
+				// the SOA record is repeated to end the transfer
+				if verifier != nil {
+					if err := verifier.done(); err != nil {
+						return nil, err
+					}
+				}
+				return records, nil
+			}
+			records = append(records, rec)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package dnstoy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// startTransferServer starts a name server on localhost that answers a
+// single zone transfer with the messages returned by respond for the query
+// received, given in wire format.
+func startTransferServer(t *testing.T, respond func(query []byte) [][]byte) netip.AddrPort {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query, err := readStreamMessage(conn)
+		if err != nil {
+			return
+		}
+		for _, msg := range respond(query) {
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+		}
+	}()
+	return netip.MustParseAddrPort(ln.Addr().String())
+}
+
+// transferResponse returns the messages of a transfer of example.test in
+// response to the given query, with the records split between them.
+func transferResponse(query []byte, rcode RCode, sections ...[]Record) [][]byte {
+	q, err := parseMessage(byteview.New(query))
+	if err != nil {
+		return nil
+	}
+	var msgs [][]byte
+	for _, answers := range sections {
+		msg := Message{Header: Header{ID: q.Header.ID}, Questions: q.Questions, Answers: answers}
+		msg.Header.SetQR(true)
+		msg.Header.SetAA(true)
+		msg.Header.SetRCode(rcode)
+		msgs = append(msgs, msg.Encode())
+	}
+	return msgs
+}
+
+// signResponse signs the messages of a response to a signed query, leaving
+// those at the given indexes unsigned.
+func signResponse(t *testing.T, key TSIGKey, query []byte, msgs [][]byte, unsigned ...int) [][]byte {
+	_, _, qt, ok, err := splitTSIG(query)
+	be.NilErr(t, err)
+	be.True(t, ok)
+	prior := qt.mac
+	var pending []byte
+	var out [][]byte
+outer:
+	for i, msg := range msgs {
+		for _, j := range unsigned {
+			if i == j {
+				out = append(out, msg)
+				pending = append(pending, msg...)
+				continue outer
+			}
+		}
+		tr := tsigRecord{algorithm: key.algorithm(), timeSigned: uint64(time.Now().Unix()), fudge: tsigFudge, originalID: binary.BigEndian.Uint16(msg)}
+		tr.mac, err = key.mac(append(pending, msg...), prior, tr, i > 0)
+		be.NilErr(t, err)
+		rec := Record{Name: []byte(key.Name), Type: RecordTypeTSIG, Class: ResourceClassANY, Data: tr.encode()}
+		signed := append(append([]byte(nil), msg...), rec.Encode()...)
+		binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(msg[10:])+1)
+		out = append(out, signed)
+		prior, pending = tr.mac, nil
+	}
+	return out
+}
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+
+	soa := Record{Name: []byte("example.test"), Type: RecordTypeSOA, Class: ResourceClassIN, TTL: 3600, Data: append(append(encodeName("ns1.example.test"), encodeName("hostmaster.example.test")...), make([]byte, 20)...)}
+	ns := Record{Name: []byte("example.test"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 3600, Data: []byte("ns1.example.test")}
+	a := Record{Name: []byte("ns1.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{192, 0, 2, 53}}
+	key := TSIGKey{Name: "transfer.key", Secret: []byte("0123456789abcdef")}
+	otherKey := TSIGKey{Name: "transfer.key", Secret: []byte("fedcba9876543210")}
+
+	testCases := map[string]struct {
+		key     *TSIGKey
+		respond func(t *testing.T, query []byte) [][]byte
+		want    int
+		wantErr error
+	}{
+		"single message": {
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return transferResponse(query, RCodeNoError, []Record{soa, ns, a, soa})
+			},
+			want: 3,
+		},
+		"several messages": {
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return transferResponse(query, RCodeNoError, []Record{soa, ns}, []Record{a}, []Record{soa})
+			},
+			want: 3,
+		},
+		"refused": {
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return transferResponse(query, RCodeRefused, nil)
+			},
+			wantErr: ErrRefused,
+		},
+		"signed": {
+			key: &key,
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return signResponse(t, key, query, transferResponse(query, RCodeNoError, []Record{soa, ns}, []Record{a}, []Record{soa}))
+			},
+			want: 3,
+		},
+		"signed with unsigned messages": {
+			key: &key,
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return signResponse(t, key, query, transferResponse(query, RCodeNoError, []Record{soa}, []Record{ns}, []Record{a, soa}), 1)
+			},
+			want: 3,
+		},
+		"unsigned last message": {
+			key: &key,
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return signResponse(t, key, query, transferResponse(query, RCodeNoError, []Record{soa, ns}, []Record{a, soa}), 1)
+			},
+			wantErr: ErrTSIG,
+		},
+		"unsigned response": {
+			key: &key,
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return transferResponse(query, RCodeNoError, []Record{soa, ns, a, soa})
+			},
+			wantErr: ErrTSIG,
+		},
+		"wrong key": {
+			key: &key,
+			respond: func(t *testing.T, query []byte) [][]byte {
+				return signResponse(t, otherKey, query, transferResponse(query, RCodeNoError, []Record{soa, ns, a, soa}))
+			},
+			wantErr: ErrTSIG,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server := startTransferServer(t, func(query []byte) [][]byte { return tc.respond(t, query) })
+			r := New(&Opts{QueryTimeout: time.Second})
+			records, err := r.Transfer(context.Background(), "example.test", server, tc.key)
+			if tc.wantErr != nil {
+				be.True(t, errors.Is(err, tc.wantErr))
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, len(records))
+			be.Equal(t, RecordTypeSOA, records[0].Type)
+			be.Equal(t, "ns1.example.test.\t3600\tIN\tA\t192.0.2.53", records[2].String())
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// IPResult is the outcome of resolving one domain in a batch lookup.
+type IPResult struct {
+	Domain string
+	IPs    []net.IP
+	Err    error
+}
+
+// LookupIPs resolves the IPv4 and IPv6 addresses of each of the given
+// domains, like LookupIP with the "ip" network, resolving at most
+// concurrency domains at a time, or one at a time if concurrency is less than
+// 1. Results are returned in the same order as the domains. Lookups in the
+// batch share the resolver's cache and its knowledge of name servers, so
+// domains sharing a zone only need its delegation to be resolved once.
+//
+// If ctx is canceled, domains that have not yet been resolved fail with the
+// context's error.
+func (r *Resolver) LookupIPs(ctx context.Context, domains []string, concurrency int, opts ...LookupOption) []IPResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(domains) {
+		concurrency = len(domains)
+	}
+
+	results := make([]IPResult, len(domains))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				domain := domains[i]
+				if err := ctx.Err(); err != nil {
+					results[i] = IPResult{Domain: domain, Err: err}
+					continue
+				}
+				ips, err := r.LookupIP(ctx, "ip", domain, opts...)
+				results[i] = IPResult{Domain: domain, IPs: ips, Err: err}
+			}
+		}()
+	}
+	for i := range domains {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,57 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestLookupIPs(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		name := canonicalName(string(q.Questions[0].Name))
+		if !strings.HasSuffix(name, ".found.test") {
+			return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+		}
+		if q.Questions[0].Type != RecordTypeA {
+			return Message{}
+		}
+		ip := net.IPv4(192, 0, 2, byte(len(name)))
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: ip.To4()}},
+		}
+	})
+
+	domains := []string{"a.found.test", "missing.test", "bb.found.test", "ccc.found.test", "2001:db8::1"}
+	for _, concurrency := range []int{0, 1, 3, 10} {
+		r := newTestResolver(port, nil)
+		results := r.LookupIPs(context.Background(), domains, concurrency)
+		be.Equal(t, len(domains), len(results))
+		for i, result := range results {
+			be.Equal(t, domains[i], result.Domain)
+		}
+		be.NilErr(t, results[0].Err)
+		be.Equal(t, "192.0.2.12", results[0].IPs[0].String())
+		be.True(t, errors.Is(results[1].Err, ErrNXDomain))
+		be.Equal(t, "192.0.2.13", results[2].IPs[0].String())
+		be.Equal(t, "192.0.2.14", results[3].IPs[0].String())
+		be.Equal(t, "2001:db8::1", results[4].IPs[0].String())
+	}
+}
+
+func TestLookupIPsCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := New(nil).LookupIPs(ctx, []string{"example.com", "example.org"}, 2)
+	be.Equal(t, 2, len(results))
+	for _, result := range results {
+		be.True(t, errors.Is(result.Err, context.Canceled))
+	}
+}
@@ -0,0 +1,232 @@
+package dnstoy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache stores RRsets on behalf of a Resolver. Implementations must be safe
+// for concurrent use.
+type Cache interface {
+	// Get returns the RRset stored under the given key, if it has not yet
+	// expired. The returned records' TTLs should reflect the time remaining
+	// before the RRset expires.
+	Get(key CacheKey) ([]Record, bool)
+
+	// Set stores an RRset under the given key for the given TTL.
+	Set(key CacheKey, records []Record, ttl time.Duration)
+
+	// Delete removes the RRset stored under the given key, if any.
+	Delete(key CacheKey)
+}
+
+// CacheKey identifies a cached RRset.
+type CacheKey struct {
+	Name  string
+	Type  RecordType
+	Class ResourceClass
+}
+
+// NewCacheKey returns a CacheKey for the given name, type and class, with the
+// name normalized so that keys compare case-insensitively.
+func NewCacheKey(name string, rtype RecordType, class ResourceClass) CacheKey {
+	return CacheKey{Name: canonicalName(name), Type: rtype, Class: class}
+}
+
+type cacheEntry struct {
+	key     CacheKey
+	records []Record
+	expires time.Time
+	ttl     time.Duration
+	size    int
+
+	// for prefetching
+	hits        int
+	prefetching bool
+}
+
+// cacheRecordOverhead approximates the per-record memory used beyond the
+// record's name and data (type, class, TTL and slice headers).
+const cacheRecordOverhead = 64
+
+// MemoryCache is the default in-memory Cache implementation. It may
+// optionally be bounded by entry count and/or approximate size in bytes, in
+// which case the least recently used entries are evicted to make room for new
+// ones.
+type MemoryCache struct {
+	maxEntries int // zero means unlimited
+	maxBytes   int // zero means unlimited
+
+	mu      sync.Mutex
+	entries map[CacheKey]*list.Element
+	lru     *list.List // front is most recently used
+	bytes   int
+	now     func() time.Time // may be overridden in tests
+}
+
+// NewMemoryCache creates a new MemoryCache holding at most maxEntries RRsets
+// and approximately maxBytes bytes. Zero means unlimited.
+func NewMemoryCache(maxEntries int, maxBytes int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[CacheKey]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get returns the cached RRset for the given key, with each record's TTL
+// adjusted to reflect the time remaining before the entry expires.
+func (c *MemoryCache) Get(key CacheKey) ([]Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	remaining := entry.expires.Sub(c.now())
+	if remaining <= 0 {
+		c.remove(elem)
+		return nil, false
+	}
+	entry.hits++
+	c.lru.MoveToFront(elem)
+
+	ttl := uint32(remaining / time.Second)
+	records := make([]Record, len(entry.records))
+	for i, r := range entry.records {
+		r.TTL = ttl
+		records[i] = r
+	}
+	return records, true
+}
+
+// Set stores an RRset for the given TTL. RRsets with a zero TTL are not
+// cached.
+func (c *MemoryCache) Set(key CacheKey, records []Record, ttl time.Duration) {
+	if len(records) == 0 || ttl <= 0 {
+		return
+	}
+	size := cacheEntrySize(key, records)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(&cacheEntry{
+		key:     key,
+		records: records,
+		expires: c.now().Add(ttl),
+		ttl:     ttl,
+		size:    size,
+	})
+}
+
+// Delete removes the RRset stored under the given key, if any.
+func (c *MemoryCache) Delete(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[key]; found {
+		c.remove(elem)
+	}
+}
+
+// insert adds an entry to the cache, replacing any existing entry with the
+// same key. The caller must hold c.mu.
+func (c *MemoryCache) insert(entry *cacheEntry) {
+	if elem, found := c.entries[entry.key]; found {
+		c.remove(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	c.evict()
+}
+
+// cacheEntrySize approximates the memory used by a cached RRset.
+func cacheEntrySize(key CacheKey, records []Record) int {
+	size := len(key.Name)
+	for _, r := range records {
+		size += len(r.Name) + len(r.Data) + cacheRecordOverhead
+	}
+	return size
+}
+
+// count returns the number of entries in the cache, including any expired
+// entries that have not yet been removed.
+func (c *MemoryCache) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// evict removes least recently used entries until the cache is within its
+// limits. The caller must hold c.mu.
+func (c *MemoryCache) evict() {
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes a single entry. The caller must hold c.mu.
+func (c *MemoryCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// claimPrefetch reports whether the given entry should be prefetched, which
+// is the case when it has been hit at least minHits times and is within
+// threshold percent of its original TTL of expiring. A true result marks
+// the entry as being prefetched, so that concurrent callers do not issue
+// duplicate refreshes.
+func (c *MemoryCache) claimPrefetch(key CacheKey, threshold float64, minHits int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.prefetching || entry.hits < minHits {
+		return false
+	}
+	remaining := entry.expires.Sub(c.now())
+	if remaining <= 0 || float64(remaining) > float64(entry.ttl)*threshold/100 {
+		return false
+	}
+	entry.prefetching = true
+	return true
+}
+
+// rrsetTTL returns the TTL of an RRset, which is the smallest TTL of its
+// records.
+func rrsetTTL(records []Record) time.Duration {
+	if len(records) == 0 {
+		return 0
+	}
+	ttl := records[0].TTL
+	for _, r := range records[1:] {
+		if r.TTL < ttl {
+			ttl = r.TTL
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// groupRRsets groups records into RRsets, which share the same owner name,
+// type and class.
+// https://datatracker.ietf.org/doc/html/rfc2181#section-5
+func groupRRsets(records []Record) map[CacheKey][]Record {
+	results := make(map[CacheKey][]Record)
+	for _, r := range records {
+		key := NewCacheKey(string(r.Name), r.Type, r.Class)
+		results[key] = append(results[key], r)
+	}
+	return results
+}
@@ -0,0 +1,206 @@
+package dnstoy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func newTestRRset(name string, ttl uint32, data ...byte) []Record {
+	return []Record{{Name: []byte(name), Type: RecordTypeA, Class: ResourceClassIN, TTL: ttl, Data: data}}
+}
+
+func TestMemoryCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache(0, 0)
+	cache.now = func() time.Time { return now }
+
+	key := NewCacheKey("WWW.Example.com.", RecordTypeA, ResourceClassIN)
+	rrset := []Record{
+		{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{1, 2, 3, 4}},
+		{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{5, 6, 7, 8}},
+	}
+	be.Equal(t, 60*time.Second, rrsetTTL(rrset))
+	cache.Set(key, rrset, rrsetTTL(rrset))
+
+	// lookups are case-insensitive and RRsets expire with their smallest TTL
+	{
+		got, found := cache.Get(NewCacheKey("www.example.com", RecordTypeA, ResourceClassIN))
+		be.True(t, found)
+		be.Equal(t, 2, len(got))
+		be.Equal(t, uint32(60), got[0].TTL)
+		be.Equal(t, uint32(60), got[1].TTL)
+	}
+
+	// remaining TTL is reflected in cached records
+	now = now.Add(45 * time.Second)
+	{
+		got, found := cache.Get(key)
+		be.True(t, found)
+		be.Equal(t, uint32(15), got[0].TTL)
+	}
+
+	// entries expire
+	now = now.Add(15 * time.Second)
+	{
+		_, found := cache.Get(key)
+		be.False(t, found)
+	}
+
+	// zero TTLs are not cached
+	cache.Set(key, newTestRRset("www.example.com", 0, 1, 2, 3, 4), 0)
+	{
+		_, found := cache.Get(key)
+		be.False(t, found)
+	}
+
+	// entries may be deleted
+	cache.Set(key, newTestRRset("www.example.com", 300, 1, 2, 3, 4), 300*time.Second)
+	cache.Delete(key)
+	{
+		_, found := cache.Get(key)
+		be.False(t, found)
+		be.Equal(t, 0, cache.count())
+	}
+}
+
+func TestGroupRRsets(t *testing.T) {
+	t.Parallel()
+
+	records := []Record{
+		{Name: []byte("www.facebook.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, Data: []byte("star-mini.c10r.facebook.com")},
+		{Name: []byte("star-mini.c10r.facebook.com"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{1, 2, 3, 4}},
+		{Name: []byte("Star-Mini.c10r.facebook.com"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{5, 6, 7, 8}},
+	}
+	got := groupRRsets(records)
+	be.Equal(t, 2, len(got))
+	be.Equal(t, 1, len(got[NewCacheKey("www.facebook.com", RecordTypeCNAME, ResourceClassIN)]))
+	be.Equal(t, 2, len(got[NewCacheKey("star-mini.c10r.facebook.com", RecordTypeA, ResourceClassIN)]))
+}
+
+func TestMemoryCacheClaimPrefetch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache(0, 0)
+	cache.now = func() time.Time { return now }
+
+	key := NewCacheKey("example.com", RecordTypeA, ResourceClassIN)
+	cache.Set(key, newTestRRset("example.com", 100, 1, 2, 3, 4), 100*time.Second)
+
+	// not yet popular enough
+	cache.Get(key)
+	be.False(t, cache.claimPrefetch(key, 10, 2))
+
+	// popular, but not close enough to expiry
+	cache.Get(key)
+	be.False(t, cache.claimPrefetch(key, 10, 2))
+
+	// popular and within 10% of expiry, may only be claimed once
+	now = now.Add(91 * time.Second)
+	be.True(t, cache.claimPrefetch(key, 10, 2))
+	be.False(t, cache.claimPrefetch(key, 10, 2))
+
+	// refreshing the entry resets its prefetch state
+	cache.Set(key, newTestRRset("example.com", 100, 1, 2, 3, 4), 100*time.Second)
+	be.False(t, cache.claimPrefetch(key, 10, 2))
+
+	// unknown entries are never prefetched
+	be.False(t, cache.claimPrefetch(NewCacheKey("example.org", RecordTypeA, ResourceClassIN), 10, 0))
+}
+
+func TestMemoryCacheLRUEviction(t *testing.T) {
+	t.Parallel()
+
+	keyA := NewCacheKey("a.example.com", RecordTypeA, ResourceClassIN)
+	keyB := NewCacheKey("b.example.com", RecordTypeA, ResourceClassIN)
+	keyC := NewCacheKey("c.example.com", RecordTypeA, ResourceClassIN)
+	set := func(cache *MemoryCache, key CacheKey) {
+		cache.Set(key, newTestRRset(key.Name, 300, 1, 2, 3, 4), 300*time.Second)
+	}
+
+	t.Run("max entries", func(t *testing.T) {
+		t.Parallel()
+		cache := NewMemoryCache(2, 0)
+		set(cache, keyA)
+		set(cache, keyB)
+
+		// touch a so that b becomes the least recently used entry
+		_, found := cache.Get(keyA)
+		be.True(t, found)
+
+		set(cache, keyC)
+		be.Equal(t, 2, cache.count())
+		_, found = cache.Get(keyB)
+		be.False(t, found)
+		_, found = cache.Get(keyA)
+		be.True(t, found)
+		_, found = cache.Get(keyC)
+		be.True(t, found)
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		t.Parallel()
+		entrySize := len("a.example.com")*2 + 4 + cacheRecordOverhead
+		cache := NewMemoryCache(0, entrySize*2)
+		set(cache, keyA)
+		set(cache, keyB)
+		set(cache, keyC)
+		be.Equal(t, 2, cache.count())
+		_, found := cache.Get(keyA)
+		be.False(t, found)
+
+		// replacing an entry does not double count its size
+		set(cache, keyC)
+		be.Equal(t, 2, cache.count())
+		be.Equal(t, entrySize*2, cache.bytes)
+	})
+
+	t.Run("oversized entries are not cached", func(t *testing.T) {
+		t.Parallel()
+		cache := NewMemoryCache(0, 10)
+		set(cache, keyA)
+		be.Equal(t, 0, cache.count())
+	})
+}
+
+func TestMemoryCacheSnapshot(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	src := NewMemoryCache(0, 0)
+	src.now = clock
+	keyA := NewCacheKey("a.example.com", RecordTypeA, ResourceClassIN)
+	keyB := NewCacheKey("b.example.com", RecordTypeA, ResourceClassIN)
+	src.Set(keyA, newTestRRset("a.example.com", 300, 1, 2, 3, 4), 300*time.Second)
+	src.Set(keyB, newTestRRset("b.example.com", 30, 5, 6, 7, 8), 30*time.Second)
+
+	var buf bytes.Buffer
+	be.NilErr(t, src.Save(&buf))
+
+	// time passes between saving and loading, during which b expires
+	now = now.Add(60 * time.Second)
+	dst := NewMemoryCache(0, 0)
+	dst.now = clock
+	be.NilErr(t, dst.Load(&buf))
+	be.Equal(t, 1, dst.count())
+
+	got, found := dst.Get(keyA)
+	be.True(t, found)
+	be.Equal(t, uint32(240), got[0].TTL)
+	be.Equal(t, "\x01\x02\x03\x04", string(got[0].Data))
+	_, found = dst.Get(keyB)
+	be.False(t, found)
+
+	// incompatible snapshots are rejected
+	err := dst.Load(strings.NewReader(`{"version": 999}`))
+	be.Nonzero(t, err)
+	be.Equal(t, "unsupported cache snapshot version 999", err.Error())
+}
@@ -0,0 +1,122 @@
+package dnstoy
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A child zone publishes CDS and CDNSKEY records, which have the same format
+// as DS and DNSKEY records, to signal the DS records it wants its parent zone
+// to publish. A single record with algorithm 0 asks for all of the DS
+// records to be removed:
+// https://datatracker.ietf.org/doc/html/rfc7344
+// https://datatracker.ietf.org/doc/html/rfc8078#section-4
+var (
+	cdsDelete     = DS{Digest: []byte{0}}
+	cdnskeyDelete = DNSKEY{Protocol: 3, PublicKey: []byte{0}}
+)
+
+// DSFromRecords returns the DS records described by the DS, CDS, DNSKEY and
+// CDNSKEY records owned by the given zone. Keys are converted to DS records
+// using the given digest type; only key signing keys are converted. A CDS or
+// CDNSKEY deletion record is returned as a DS record with algorithm 0, which
+// CompareCDS understands.
+func DSFromRecords(zone string, records []Record, digestType uint8) ([]DS, error) {
+	var results []DS
+	for _, rec := range records {
+		if canonicalName(string(rec.Name)) != canonicalName(zone) {
+			continue
+		}
+		switch rec.Type {
+		case RecordTypeDS, RecordTypeCDS:
+			ds, err := parseDS(rec.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s record: %w", rec.Type, err)
+			}
+			results = append(results, ds)
+		case RecordTypeDNSKEY, RecordTypeCDNSKEY:
+			key, err := parseDNSKEY(rec.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s record: %w", rec.Type, err)
+			}
+			if rec.Type == RecordTypeCDNSKEY && isCDNSKEYDelete(key) {
+				results = append(results, cdsDelete)
+				continue
+			}
+			if !key.IsSEP() {
+				continue
+			}
+			ds, err := key.ToDS(zone, digestType)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, ds)
+		}
+	}
+	return results, nil
+}
+
+func isCDSDelete(ds DS) bool {
+	return ds.KeyTag == 0 && ds.Algorithm == 0 && ds.DigestType == 0 && bytes.Equal(ds.Digest, cdsDelete.Digest)
+}
+
+func isCDNSKEYDelete(key DNSKEY) bool {
+	return key.Flags == 0 && key.Protocol == cdnskeyDelete.Protocol && key.Algorithm == 0 && bytes.Equal(key.PublicKey, cdnskeyDelete.PublicKey)
+}
+
+// DSChanges describes the changes a parent zone must make to its DS records
+// for a child zone to match the child's CDS records.
+type DSChanges struct {
+	Add    []DS
+	Remove []DS
+
+	// DeleteAll is set if the child asked for all of its DS records to be
+	// removed, turning off DNSSEC validation for the zone.
+	DeleteAll bool
+}
+
+// Unchanged returns true if no changes are needed.
+func (c DSChanges) Unchanged() bool {
+	return len(c.Add) == 0 && len(c.Remove) == 0
+}
+
+// CompareCDS compares a child zone's CDS records against the DS records its
+// parent zone publishes for it, returning the changes needed for the parent
+// to match. It is an error for the deletion record to be mixed with other
+// CDS records. An empty CDS RRset means that the child has no opinion, and
+// no changes are needed:
+// https://datatracker.ietf.org/doc/html/rfc7344#section-4.1
+func CompareCDS(cds, ds []DS) (DSChanges, error) {
+	var changes DSChanges
+	if len(cds) == 0 {
+		return changes, nil
+	}
+	for _, rec := range cds {
+		if isCDSDelete(rec) {
+			if len(cds) > 1 {
+				return DSChanges{}, fmt.Errorf("CDS deletion record must be the only CDS record, found %d", len(cds))
+			}
+			return DSChanges{Remove: ds, DeleteAll: true}, nil
+		}
+	}
+	for _, want := range cds {
+		if !containsDS(ds, want) && !containsDS(changes.Add, want) {
+			changes.Add = append(changes.Add, want)
+		}
+	}
+	for _, have := range ds {
+		if !containsDS(cds, have) {
+			changes.Remove = append(changes.Remove, have)
+		}
+	}
+	return changes, nil
+}
+
+func containsDS(set []DS, ds DS) bool {
+	for _, candidate := range set {
+		if candidate.KeyTag == ds.KeyTag && candidate.Algorithm == ds.Algorithm && candidate.DigestType == ds.DigestType && bytes.Equal(candidate.Digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package dnstoy
+
+import (
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestCompareCDS(t *testing.T) {
+	t.Parallel()
+
+	oldKey := DNSKEY{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: AlgorithmRSASHA256, PublicKey: []byte("old key")}
+	newKey := DNSKEY{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: AlgorithmECDSAP256SHA256, PublicKey: []byte("new key")}
+	zsk := DNSKEY{Flags: DNSKEYFlagZone, Protocol: 3, Algorithm: AlgorithmECDSAP256SHA256, PublicKey: []byte("zone key")}
+	oldDS, err := oldKey.ToDS("example.com", DigestTypeSHA256)
+	be.NilErr(t, err)
+	newDS, err := newKey.ToDS("example.com", DigestTypeSHA256)
+	be.NilErr(t, err)
+
+	record := func(typ RecordType, data []byte) Record {
+		return Record{Name: []byte("example.com"), Type: typ, Class: ResourceClassIN, TTL: 3600, Data: data}
+	}
+	parent, err := DSFromRecords("example.com", []Record{record(RecordTypeDS, oldDS.Encode())}, DigestTypeSHA256)
+	be.NilErr(t, err)
+
+	testCases := map[string]struct {
+		child      []Record
+		wantAdd    []DS
+		wantRemove []DS
+		wantDelete bool
+		wantErr    string
+	}{
+		"unchanged": {
+			child: []Record{record(RecordTypeCDS, oldDS.Encode())},
+		},
+		"no opinion": {
+			child: nil,
+		},
+		"key rollover": {
+			child:      []Record{record(RecordTypeCDS, newDS.Encode())},
+			wantAdd:    []DS{newDS},
+			wantRemove: []DS{oldDS},
+		},
+		"key added via CDNSKEY": {
+			child:   []Record{record(RecordTypeCDNSKEY, oldKey.Encode()), record(RecordTypeCDNSKEY, newKey.Encode()), record(RecordTypeCDNSKEY, zsk.Encode())},
+			wantAdd: []DS{newDS},
+		},
+		"duplicated in CDS and CDNSKEY": {
+			child:   []Record{record(RecordTypeCDS, oldDS.Encode()), record(RecordTypeCDS, newDS.Encode()), record(RecordTypeCDNSKEY, newKey.Encode())},
+			wantAdd: []DS{newDS},
+		},
+		"delete via CDS": {
+			child:      []Record{record(RecordTypeCDS, []byte{0, 0, 0, 0, 0})},
+			wantRemove: []DS{oldDS},
+			wantDelete: true,
+		},
+		"delete via CDNSKEY": {
+			child:      []Record{record(RecordTypeCDNSKEY, []byte{0, 0, 3, 0, 0})},
+			wantRemove: []DS{oldDS},
+			wantDelete: true,
+		},
+		"delete mixed with other records": {
+			child:   []Record{record(RecordTypeCDS, []byte{0, 0, 0, 0, 0}), record(RecordTypeCDS, newDS.Encode())},
+			wantErr: "must be the only CDS record",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			cds, err := DSFromRecords("example.com", tc.child, DigestTypeSHA256)
+			be.NilErr(t, err)
+			changes, err := CompareCDS(cds, parent)
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+			be.DeepEqual(t, tc.wantAdd, changes.Add)
+			be.DeepEqual(t, tc.wantRemove, changes.Remove)
+			be.Equal(t, tc.wantDelete, changes.DeleteAll)
+			be.Equal(t, len(tc.wantAdd) == 0 && len(tc.wantRemove) == 0, changes.Unchanged())
+		})
+	}
+}
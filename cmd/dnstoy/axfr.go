@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// axfr runs the axfr command, which transfers a zone from a name server and
+// prints its records, returning the exit code.
+func axfr(args []string) int {
+	fs := flag.NewFlagSet("axfr", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s axfr [flags] zone @server\n\nTransfer a zone from a name server (AXFR) and print its records, in master file\nformat or as JSON.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for connecting and for each message of the transfer")
+	jsonFlag := fs.Bool("json", false, "Print the zone as a line of JSON instead of in master file format")
+	keyFlag := fs.String("y", "", "Sign the transfer with TSIG, using a key given as [algorithm:]name:base64secret (algorithm defaults to hmac-sha256)")
+	fs.Parse(args)
+	server, options, names := parseArgs(fs.Args(), "")
+	if len(names) != 1 || server == "" || len(options) > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	zone := names[0]
+
+	var key *dnstoy.TSIGKey
+	if *keyFlag != "" {
+		k, err := dnstoy.ParseTSIGKey(*keyFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid -y: %s\n", err)
+			return exitUsage
+		}
+		key = &k
+	}
+
+	resolver := dnstoy.New(&dnstoy.Opts{
+		Dialer:       &net.Dialer{Timeout: *timeout},
+		QueryTimeout: *timeout,
+	})
+	defer resolver.Close()
+	ctx := context.Background()
+	addr, err := resolveServer(ctx, resolver, server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: resolving server %s: %s\n", server, err)
+		return exitError
+	}
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		addrPort, err = netip.ParseAddrPort(net.JoinHostPort(addr, "53"))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid server %s\n", server)
+		return exitUsage
+	}
+
+	records, err := resolver.Transfer(ctx, zone, addrPort, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return exitCode(err)
+	}
+	if *jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(struct {
+			Zone    string          `json:"zone"`
+			Server  string          `json:"server"`
+			Records []dnstoy.Record `json:"records"`
+		}{zone, addrPort.String(), records})
+		return exitOK
+	}
+	fmt.Printf("; <<>> dnstoy <<>> axfr %s @%s\n", zone, addrPort)
+	for _, rec := range records {
+		fmt.Println(rec)
+	}
+	fmt.Printf(";; XFR size: %d records\n", len(records))
+	return exitOK
+}
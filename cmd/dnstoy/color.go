@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// ANSI escape sequences used to color output.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// isTerminal reports whether f is a terminal, rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorDisabled reports whether colors are disabled by the NO_COLOR
+// environment variable: https://no-color.org
+func colorDisabled() bool {
+	return os.Getenv("NO_COLOR") != ""
+}
+
+// alignColumns aligns the tab-separated fields of consecutive lines, such
+// as the records of a section, in columns separated by spaces.
+func alignColumns(out []byte) []byte {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	tw.Write(out)
+	tw.Flush()
+	return buf.Bytes()
+}
+
+// colorize colors the lines of a lookup's output: section headers in bold,
+// the types of records in cyan, errors and response codes other than
+// NOERROR in red, and the differences found by -compare in red or green,
+// for answers found only by dnstoy or only by the system resolver.
+func colorize(out []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(out), "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		buf.WriteString(colorizeLine(text))
+		buf.WriteString(line[len(text):])
+	}
+	return buf.Bytes()
+}
+
+func colorizeLine(line string) string {
+	switch {
+	case line == "":
+		return line
+	case strings.HasPrefix(line, ";; ") && strings.HasSuffix(line, " SECTION:"):
+		return ansiBold + line + ansiReset
+	case strings.HasPrefix(line, ";; ->>HEADER<<-") && !strings.Contains(line, "status: NOERROR,"):
+		return ansiRed + line + ansiReset
+	case strings.HasPrefix(line, "- "):
+		return ansiRed + line + ansiReset
+	case strings.HasPrefix(line, "+ "):
+		return ansiGreen + line + ansiReset
+	case line == errResultsDiffer.Error():
+		return ansiYellow + line + ansiReset
+	case line == "results match":
+		return ansiGreen + line + ansiReset
+	}
+	if start, end, ok := recordTypeField(line); ok {
+		return line[:start] + ansiCyan + line[start:end] + ansiReset + line[end:]
+	}
+	if strings.Contains(line, "error: ") || strings.HasPrefix(line, "error resolving ") {
+		return ansiRed + line + ansiReset
+	}
+	return line
+}
+
+// recordTypeField returns the offsets of the type of a record given in
+// presentation format, as name, TTL, class, type and data, if the line is
+// one.
+func recordTypeField(line string) (int, int, bool) {
+	if strings.HasPrefix(line, ";") {
+		return 0, 0, false
+	}
+	var starts, ends []int
+	inField := false
+	for i, c := range line {
+		switch isSpace := c == ' ' || c == '\t'; {
+		case !isSpace && !inField:
+			starts = append(starts, i)
+			inField = true
+		case isSpace && inField:
+			ends = append(ends, i)
+			inField = false
+		}
+		if len(ends) == 4 {
+			break
+		}
+	}
+	if inField {
+		ends = append(ends, len(line))
+	}
+	if len(ends) < 4 {
+		return 0, 0, false
+	}
+	if _, err := strconv.ParseUint(line[starts[1]:ends[1]], 10, 32); err != nil {
+		return 0, 0, false
+	}
+	if _, err := dnstoy.ParseResourceClass(line[starts[2]:ends[2]]); err != nil {
+		return 0, 0, false
+	}
+	return starts[3], ends[3], true
+}
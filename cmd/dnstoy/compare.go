@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// compareTypes are the record types that can be looked up with the system
+// resolver.
+var compareTypes = []dnstoy.RecordType{
+	dnstoy.RecordTypeA, dnstoy.RecordTypeAAAA, dnstoy.RecordTypeCNAME,
+	dnstoy.RecordTypeMX, dnstoy.RecordTypeNS, dnstoy.RecordTypeTXT,
+}
+
+// errResultsDiffer is returned by lookupCompare when dnstoy and the system
+// resolver disagree.
+var errResultsDiffer = errors.New("results differ")
+
+// lookupCompare looks up a name with both dnstoy and the system resolver,
+// writing the answers they agree on, prefixed with "=", followed by those
+// found only by dnstoy, prefixed with "-", and only by the system resolver,
+// prefixed with "+". Differences may be bugs in dnstoy, or signs of a
+// system resolver that answers differently, e.g. for split-horizon names or
+// behind a captive portal. It returns dnstoy's error, if any, or
+// errResultsDiffer.
+func (q *query) lookupCompare(ctx context.Context, w io.Writer, domain string) error {
+	fmt.Fprintf(w, "\ncomparing %s %s ...\n", displayName(domain), q.recordType)
+	_, records, _, err := q.lookupRecords(ctx, domain)
+	ours, err := recordValues(records, q.recordType, err)
+	if err != nil {
+		fmt.Fprintf(w, "dnstoy error: %s\n", err)
+	}
+	theirs, sysErr := systemLookup(ctx, domain, q.recordType)
+	if sysErr != nil {
+		fmt.Fprintf(w, "system resolver error: %s\n", sysErr)
+	}
+
+	inOurs := make(map[string]bool, len(ours))
+	for _, v := range ours {
+		inOurs[v] = true
+	}
+	inTheirs := make(map[string]bool, len(theirs))
+	for _, v := range theirs {
+		inTheirs[v] = true
+	}
+	same := (err == nil) == (sysErr == nil)
+	for _, v := range ours {
+		if inTheirs[v] {
+			fmt.Fprintf(w, "= %s\n", v)
+		}
+	}
+	for _, v := range ours {
+		if !inTheirs[v] {
+			fmt.Fprintf(w, "- %s\t(dnstoy only)\n", v)
+			same = false
+		}
+	}
+	for _, v := range theirs {
+		if !inOurs[v] {
+			fmt.Fprintf(w, "+ %s\t(system only)\n", v)
+			same = false
+		}
+	}
+	if !same {
+		fmt.Fprintln(w, errResultsDiffer)
+		if err != nil {
+			return err
+		}
+		return errResultsDiffer
+	}
+	fmt.Fprintln(w, "results match")
+	return nil
+}
+
+// recordValues returns the data of the records of the given type found by a
+// lookup that failed with err, in the form given by the system resolver, and
+// sorted. Names are lowercased and absolute, and the strings of each TXT
+// record are concatenated. Lookups that found no records succeed with no
+// values, as they do with the system resolver.
+func recordValues(records []dnstoy.Record, recordType dnstoy.RecordType, err error) ([]string, error) {
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var values []string
+	for _, rec := range records {
+		if rec.Type != recordType {
+			continue
+		}
+		var v string
+		if rec.Type == dnstoy.RecordTypeTXT {
+			strs, err := dnstoy.ParseTXT(rec.Data)
+			if err != nil {
+				return nil, err
+			}
+			v = strings.Join(strs, "")
+		} else {
+			// the data is the last field of the record in presentation format
+			fields := strings.Split(rec.String(), "\t")
+			v = strings.ToLower(fields[len(fields)-1])
+		}
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// canCompare reports whether records of the given type can be looked up
+// with the system resolver.
+func canCompare(recordType dnstoy.RecordType) bool {
+	for _, t := range compareTypes {
+		if t == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// systemLookup looks up the records of the given type with the system
+// resolver, returning their data as recordValues does.
+func systemLookup(ctx context.Context, name string, recordType dnstoy.RecordType) ([]string, error) {
+	r := net.DefaultResolver
+	var (
+		values []string
+		err    error
+	)
+	switch recordType {
+	case dnstoy.RecordTypeA, dnstoy.RecordTypeAAAA:
+		network := "ip4"
+		if recordType == dnstoy.RecordTypeAAAA {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = r.LookupIP(ctx, network, name)
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+	case dnstoy.RecordTypeCNAME:
+		var cname string
+		cname, err = r.LookupCNAME(ctx, name)
+		// the system resolver returns the name itself if it has no CNAME
+		if err == nil && !strings.EqualFold(strings.TrimSuffix(cname, "."), strings.TrimSuffix(name, ".")) {
+			values = append(values, strings.ToLower(cname))
+		}
+	case dnstoy.RecordTypeMX:
+		var mxs []*net.MX
+		mxs, err = r.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			values = append(values, fmt.Sprintf("%d %s", mx.Pref, strings.ToLower(mx.Host)))
+		}
+	case dnstoy.RecordTypeNS:
+		var nss []*net.NS
+		nss, err = r.LookupNS(ctx, name)
+		for _, ns := range nss {
+			values = append(values, strings.ToLower(ns.Host))
+		}
+	case dnstoy.RecordTypeTXT:
+		values, err = r.LookupTXT(ctx, name)
+	default:
+		return nil, fmt.Errorf("cannot look up %s records with the system resolver", recordType)
+	}
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// isNotFound reports whether a lookup failed because the name or its
+// records of the requested type do not exist, rather than because of an
+// error, with either resolver.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return errors.Is(err, dnstoy.ErrNXDomain) || errors.Is(err, dnstoy.ErrNoData)
+}
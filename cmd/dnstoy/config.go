@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadConfig sets the flags in fs that were not given on the command line
+// from a config file, in a subset of TOML whose keys are the names of the
+// flags, e.g.:
+//
+//	listen = "127.0.0.1:5353"
+//	upstream = ["1.1.1.1", "8.8.8.8"]
+//	cache-size = 10000
+//	debug = true
+//
+//	[forward]
+//	"corp.example" = ["10.0.0.53", "10.0.0.54"]
+//
+// Values may be strings, numbers, booleans or single-line arrays of them,
+// which are joined with commas for the flags that take comma-separated
+// lists. The keys of a table are given, with their values, to the flag the
+// table is named for, as key=value, for flags that may be repeated.
+// https://toml.io/en/v1.0.0
+func loadConfig(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	set := func(line int, name, value string) error {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, line, name)
+		}
+		if given[name] {
+			return nil
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: invalid %s: %w", path, line, name, err)
+		}
+		return nil
+	}
+
+	var table string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			if fs.Lookup(table) == nil {
+				return fmt.Errorf("%s:%d: unknown setting %q", path, line, table)
+			}
+			continue
+		}
+		key, rawValue, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, line)
+		}
+		key, err := parseConfigKey(strings.TrimSpace(key))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		value, err := parseConfigValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid value for %s: %w", path, line, key, err)
+		}
+		if table != "" {
+			err = set(line, table, key+"="+value)
+		} else {
+			err = set(line, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// stripComment removes a comment, starting with "#" outside of a string,
+// from a line.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++ // the escaped character cannot end the string
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigKey parses a bare or quoted key.
+func parseConfigKey(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return parseConfigString(s)
+	}
+	if s == "" || strings.IndexFunc(s, func(c rune) bool {
+		return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_')
+	}) >= 0 {
+		return "", fmt.Errorf("invalid key %q", s)
+	}
+	return s, nil
+}
+
+// parseConfigValue parses a value, returning it as given to a flag.
+func parseConfigValue(s string) (string, error) {
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return "", fmt.Errorf("unterminated array %s", s)
+		}
+		var values []string
+		for _, elem := range splitConfigArray(s[1 : len(s)-1]) {
+			if elem = strings.TrimSpace(elem); elem == "" {
+				continue // a trailing comma
+			}
+			v, err := parseConfigValue(elem)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, v)
+		}
+		return strings.Join(values, ","), nil
+	}
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return parseConfigString(s)
+	}
+	if s == "true" || s == "false" {
+		return s, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(s, "_", ""), nil
+	}
+	return "", fmt.Errorf("%s is not a string, number, boolean or array", s)
+}
+
+// parseConfigString parses a basic string, in double quotes and with
+// escapes, or a literal string, in single quotes.
+func parseConfigString(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' && !strings.Contains(s[1:len(s)-1], "'") {
+		return s[1 : len(s)-1], nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return "", fmt.Errorf("invalid string %s", s)
+}
+
+// splitConfigArray splits the elements of an array, given without its
+// brackets, at the commas outside of strings.
+func splitConfigArray(s string) []string {
+	var (
+		elems []string
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, s[start:])
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestStripComment(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		`debug = true # verbose`:         `debug = true `,
+		`# a whole line`:                 ``,
+		`name = "a # b" # comment`:       `name = "a # b" `,
+		`name = 'a # b'`:                 `name = 'a # b'`,
+		`name = "a \" # b"`:              `name = "a \" # b"`,
+		`list = ["x#y", 'z#'] # comment`: `list = ["x#y", 'z#'] `,
+		`no comment`:                     `no comment`,
+	}
+	for line, want := range testCases {
+		line, want := line, want
+		t.Run(line, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, want, stripComment(line))
+		})
+	}
+}
+
+func TestParseConfigKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		"bare":             {key: "cache-size", want: "cache-size"},
+		"underscore":       {key: "cache_size", want: "cache_size"},
+		"basic string":     {key: `"corp.example"`, want: "corp.example"},
+		"literal string":   {key: `'corp.example'`, want: "corp.example"},
+		"bare with dot":    {key: "corp.example", wantErr: true},
+		"bare with space":  {key: "cache size", wantErr: true},
+		"empty":            {key: "", wantErr: true},
+		"unterminated key": {key: `"corp.example`, wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseConfigKey(tc.key)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		"string":           {value: `"127.0.0.1:5353"`, want: "127.0.0.1:5353"},
+		"literal string":   {value: `'C:\hosts'`, want: `C:\hosts`},
+		"integer":          {value: "10000", want: "10000"},
+		"underscores":      {value: "10_000", want: "10000"},
+		"float":            {value: "1.5", want: "1.5"},
+		"boolean":          {value: "true", want: "true"},
+		"array":            {value: `["1.1.1.1", "8.8.8.8"]`, want: "1.1.1.1,8.8.8.8"},
+		"trailing comma":   {value: `["1.1.1.1", ]`, want: "1.1.1.1"},
+		"array of numbers": {value: "[1, 2]", want: "1,2"},
+		"empty array":      {value: "[]", want: ""},
+		"bare word":        {value: "yes", wantErr: true},
+		"unterminated":     {value: `["1.1.1.1"`, wantErr: true},
+		"invalid element":  {value: `["1.1.1.1", yes]`, wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseConfigValue(tc.value)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseConfigString(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		"basic":                {s: `"a b"`, want: "a b"},
+		"escapes":              {s: `"a\tb\"c\""`, want: "a\tb\"c\""},
+		"literal":              {s: `'a\tb'`, want: `a\tb`},
+		"empty":                {s: `""`, want: ""},
+		"quote within literal": {s: `'a'b'`, wantErr: true},
+		"unterminated basic":   {s: `"a`, wantErr: true},
+		"unterminated literal": {s: `'a`, wantErr: true},
+		"unquoted":             {s: "a", wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseConfigString(tc.s)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSplitConfigArray(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string][]string{
+		`"a", "b"`:     {`"a"`, ` "b"`},
+		`"a,b", 'c,d'`: {`"a,b"`, ` 'c,d'`},
+		`"a\",b", "c"`: {`"a\",b"`, ` "c"`},
+		`1, 2,`:        {`1`, ` 2`, ``},
+		``:             {``},
+		`"only"`:       {`"only"`},
+		`'x\', "y, z"`: {`'x\'`, ` "y, z"`},
+	}
+	for s, want := range testCases {
+		s, want := s, want
+		t.Run(s, func(t *testing.T) {
+			t.Parallel()
+			be.AllEqual(t, want, splitConfigArray(s))
+		})
+	}
+}
+
+// writeConfig writes a config file to a temporary directory, returning its
+// path.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dnstoy.toml")
+	be.NilErr(t, os.WriteFile(path, []byte(config), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	config := `# settings for the resolver
+listen = "127.0.0.1:5353"
+upstream = ["1.1.1.1", "8.8.8.8"] # preferred first
+cache-size = 10_000
+timeout = "2s"
+
+[forward]
+"corp.example" = ["10.0.0.53", "10.0.0.54"]
+'lab.example' = "192.0.2.53"
+`
+	testCases := map[string]struct {
+		config  string
+		args    []string
+		check   func(t *testing.T, fs *flag.FlagSet, flags *resolverFlags)
+		wantErr string
+	}{
+		"settings": {
+			config: config,
+			check: func(t *testing.T, fs *flag.FlagSet, flags *resolverFlags) {
+				be.Equal(t, "127.0.0.1:5353", fs.Lookup("listen").Value.String())
+				be.Equal(t, "1.1.1.1,8.8.8.8", *flags.upstreams)
+				be.Equal(t, 10000, *flags.cacheSize)
+				be.Equal(t, 2*time.Second, *flags.timeout)
+				be.AllEqual(t, []string{"10.0.0.53", "10.0.0.54"}, flags.forwardZones["corp.example"])
+				be.AllEqual(t, []string{"192.0.2.53"}, flags.forwardZones["lab.example"])
+			},
+		},
+		"command-line flags win": {
+			config: config,
+			args:   []string{"-upstream", "9.9.9.9", "-forward", "corp.example=10.0.0.1"},
+			check: func(t *testing.T, fs *flag.FlagSet, flags *resolverFlags) {
+				be.Equal(t, "9.9.9.9", *flags.upstreams)
+				be.Equal(t, 10000, *flags.cacheSize)
+				be.AllEqual(t, []string{"10.0.0.1"}, flags.forwardZones["corp.example"])
+				be.Equal(t, 0, len(flags.forwardZones["lab.example"]))
+			},
+		},
+		"unknown key": {
+			config:  "listen = \"127.0.0.1:53\"\ncolour = true\n",
+			wantErr: `:2: unknown setting "colour"`,
+		},
+		"unknown table": {
+			config:  "[forwards]\n\"corp.example\" = \"10.0.0.53\"\n",
+			wantErr: `:1: unknown setting "forwards"`,
+		},
+		"invalid value": {
+			config:  "cache-size = \"lots\"\n",
+			wantErr: ":1: invalid cache-size",
+		},
+		"invalid table entry": {
+			config:  "[forward]\n\"corp.example\" = \"\"\n",
+			wantErr: ":2: invalid forward",
+		},
+		"missing value": {
+			config:  "debug\n",
+			wantErr: ":1: expected key = value",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("listen", "", "")
+			flags := registerResolverFlags(fs)
+			be.NilErr(t, fs.Parse(tc.args))
+
+			err := loadConfig(fs, writeConfig(t, tc.config))
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+			tc.check(t, fs, flags)
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	t.Parallel()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := loadConfig(fs, filepath.Join(t.TempDir(), "missing.toml"))
+	be.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// printResponse prints the result of a lookup in the manner of dig: the
+// header and sections of the final response, with the answer section
+// holding every record found, including CNAMEs followed across zones,
+// followed by the server that answered and the time taken.
+func printResponse(w io.Writer, name string, recordType dnstoy.RecordType, class dnstoy.ResourceClass, records []dnstoy.Record, steps []dnstoy.TraceStep, elapsed time.Duration, err error) {
+	fmt.Fprintf(w, "\n; <<>> dnstoy <<>> %s %s\n", displayName(name), questionTypes(recordType, class))
+	step, found := answeringStep(steps, recordType)
+	if !found {
+		fmt.Fprintf(w, ";; error: %s\n", err)
+		return
+	}
+
+	var msg dnstoy.Message
+	if step.Cached {
+		msg.Header.SetQR(true)
+		msg.Header.SetRD(true)
+		msg.Header.SetRA(true)
+		rcode, _ := lookupRCode(err)
+		msg.Header.SetRCode(rcode)
+	} else {
+		msg = step.Response
+	}
+	// the question is the one asked, rather than the last asked on its
+	// behalf, e.g. about the target of a CNAME, and without the random case
+	msg.Questions = []dnstoy.Question{{Name: []byte(name), Type: recordType, Class: class}}
+	if err == nil || step.Cached {
+		msg.Answers = records
+	}
+	fmt.Fprint(w, msg)
+
+	fmt.Fprintln(w)
+	if step.Cached {
+		fmt.Fprintln(w, ";; SERVER: cache")
+	} else if step.Addr != nil && step.Addr.String() != step.NameServer {
+		fmt.Fprintf(w, ";; SERVER: %s (%s)\n", step.NameServer, step.Addr)
+	} else {
+		fmt.Fprintf(w, ";; SERVER: %s\n", step.NameServer)
+	}
+	fmt.Fprintf(w, ";; Query time: %d msec\n", elapsed.Milliseconds())
+}
+
+// questionTypes returns the type of records looked up, preceded by their
+// class unless it is IN, e.g. "CH TXT", as given to dig.
+func questionTypes(recordType dnstoy.RecordType, class dnstoy.ResourceClass) string {
+	if class == dnstoy.ResourceClassIN {
+		return recordType.String()
+	}
+	return class.String() + " " + recordType.String()
+}
+
+// answeringStep returns the last step of a lookup that found an answer for
+// the name looked up, or a CNAME it was an alias for, rather than for the
+// address of a name server, if there is one.
+func answeringStep(steps []dnstoy.TraceStep, recordType dnstoy.RecordType) (dnstoy.TraceStep, bool) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		if step := steps[i]; step.Err == nil && step.Type == recordType {
+			return step, true
+		}
+	}
+	return dnstoy.TraceStep{}, false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// Exit codes, distinguishing why a lookup failed so that scripts and health
+// checks can tell a missing name from an unreachable or broken name server.
+const (
+	exitOK            = 0
+	exitError         = 1 // any other failure
+	exitUsage         = 2 // invalid flags or arguments
+	exitNotFound      = 3 // NXDOMAIN, or no records of the requested type
+	exitTimeout       = 4 // no answer in time
+	exitServerFailure = 5 // SERVFAIL, REFUSED, FORMERR or NOTIMP, or bogus DNSSEC
+)
+
+// exitCode returns the exit code for a lookup that failed with err.
+func exitCode(err error) int {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, dnstoy.ErrNXDomain), errors.Is(err, dnstoy.ErrNoData):
+		return exitNotFound
+	case errors.Is(err, dnstoy.ErrServFail), errors.Is(err, dnstoy.ErrRefused),
+		errors.Is(err, dnstoy.ErrFormErr), errors.Is(err, dnstoy.ErrNotImp),
+		errors.Is(err, dnstoy.ErrBogus):
+		return exitServerFailure
+	case errors.Is(err, dnstoy.ErrResolutionTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return exitTimeout
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// jsonResult is the JSON output for a single lookup, written as one line.
+type jsonResult struct {
+	Question dnstoy.Question `json:"question"`
+
+	// UnicodeName is the Unicode form of the name looked up, if it has any
+	// A-labels.
+	UnicodeName string `json:"unicode_name,omitempty"`
+
+	// Status is the RCODE of the final response, e.g. "NOERROR" or
+	// "NXDOMAIN", or empty if there was none, in which case Error says why.
+	Status  string          `json:"status,omitempty"`
+	Answers []dnstoy.Record `json:"answers"`
+
+	// Server is the name server that answered, or "cache" if the answer was
+	// cached, and ServerAddr its address, if known.
+	Server     string `json:"server,omitempty"`
+	ServerAddr net.IP `json:"server_addr,omitempty"`
+
+	DurationMS float64         `json:"duration_ms"`
+	Trace      []jsonTraceStep `json:"trace,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type jsonTraceStep struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Zone       string          `json:"zone,omitempty"`
+	NameServer string          `json:"name_server,omitempty"`
+	Addr       net.IP          `json:"addr,omitempty"`
+	Cached     bool            `json:"cached,omitempty"`
+	Response   *dnstoy.Message `json:"response,omitempty"`
+	RTTMS      float64         `json:"rtt_ms,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// newJSONResult returns the JSON output for a lookup of the records of the
+// given type and class for a name, which took the given steps and time, including the
+// steps if trace is set.
+func newJSONResult(name string, recordType dnstoy.RecordType, class dnstoy.ResourceClass, records []dnstoy.Record, steps []dnstoy.TraceStep, elapsed time.Duration, err error, trace bool) jsonResult {
+	result := jsonResult{
+		Question:   dnstoy.Question{Name: []byte(name), Type: recordType, Class: class},
+		Status:     lookupStatus(err),
+		Answers:    records,
+		DurationMS: float64(elapsed) / float64(time.Millisecond),
+	}
+	if unicode := dnstoy.ToUnicode(name); unicode != name {
+		result.UnicodeName = unicode
+	}
+	if result.Answers == nil {
+		result.Answers = []dnstoy.Record{}
+	}
+	if err != nil && !errors.Is(err, dnstoy.ErrNoData) {
+		result.Error = err.Error()
+	}
+	if step, found := answeringStep(steps, recordType); found && step.Cached {
+		result.Server = "cache"
+	} else if found {
+		result.Server, result.ServerAddr = step.NameServer, step.Addr
+	}
+	if trace {
+		for _, step := range steps {
+			js := jsonTraceStep{
+				Name:       step.Name,
+				Type:       step.Type.String(),
+				Zone:       step.Zone,
+				NameServer: step.NameServer,
+				Addr:       step.Addr,
+				Cached:     step.Cached,
+				RTTMS:      float64(step.RTT) / float64(time.Millisecond),
+			}
+			if step.Err != nil {
+				js.Error = step.Err.Error()
+			} else if !step.Cached {
+				resp := step.Response
+				js.Response = &resp
+			}
+			result.Trace = append(result.Trace, js)
+		}
+	}
+	return result
+}
+
+// lookupStatus returns the RCODE of the final response to a lookup that
+// failed with err, or an empty string if it is unknown.
+func lookupStatus(err error) string {
+	if rcode, ok := lookupRCode(err); ok {
+		return rcode.String()
+	}
+	return ""
+}
+
+// lookupRCode returns the RCODE of the final response to a lookup that
+// failed with err, if it is known.
+func lookupRCode(err error) (dnstoy.RCode, bool) {
+	switch {
+	case err == nil, errors.Is(err, dnstoy.ErrNoData):
+		return dnstoy.RCodeNoError, true
+	case errors.Is(err, dnstoy.ErrNXDomain):
+		return dnstoy.RCodeNXDomain, true
+	case errors.Is(err, dnstoy.ErrServFail):
+		return dnstoy.RCodeServFail, true
+	case errors.Is(err, dnstoy.ErrRefused):
+		return dnstoy.RCodeRefused, true
+	case errors.Is(err, dnstoy.ErrFormErr):
+		return dnstoy.RCodeFormErr, true
+	case errors.Is(err, dnstoy.ErrNotImp):
+		return dnstoy.RCodeNotImp, true
+	default:
+		return 0, false
+	}
+}
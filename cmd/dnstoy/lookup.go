@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// query describes the lookups to make for each name given on the command
+// line, and how to print their results.
+type query struct {
+	resolver   *dnstoy.Resolver
+	recordType dnstoy.RecordType
+	class      dnstoy.ResourceClass
+	opts       []dnstoy.LookupOption
+	trace      bool
+	json       bool
+	dnssec     bool
+	compare    bool
+
+	// align and color the output, when it is written to a terminal
+	align bool
+	color bool
+}
+
+// lookupAll looks up each of the given names, at most concurrency at a time,
+// writing their output to w in the order of the names as soon as it is
+// complete. It returns statistics for each lookup, and the error of the
+// first name, in order, whose lookup failed.
+func (q *query) lookupAll(ctx context.Context, w io.Writer, names []string, concurrency int) ([]lookupStat, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(names) {
+		concurrency = len(names)
+	}
+
+	outputs := make([]chan []byte, len(names))
+	for i := range outputs {
+		outputs[i] = make(chan []byte, 1)
+	}
+	stats := make([]lookupStat, len(names))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				var buf bytes.Buffer
+				start := time.Now()
+				steps, err := q.lookup(ctx, &buf, names[i])
+				stats[i] = newLookupStat(names[i], time.Since(start), steps, err)
+				outputs[i] <- buf.Bytes()
+			}
+		}()
+	}
+	go func() {
+		for i := range names {
+			next <- i
+		}
+		close(next)
+	}()
+	for _, output := range outputs {
+		w.Write(q.style(<-output))
+	}
+	wg.Wait()
+	for _, stat := range stats {
+		if stat.err != nil {
+			return stats, stat.err
+		}
+	}
+	return stats, nil
+}
+
+// style aligns and colors the output of a lookup, as configured.
+func (q *query) style(out []byte) []byte {
+	if q.align {
+		out = alignColumns(out)
+	}
+	if q.color {
+		out = colorize(out)
+	}
+	return out
+}
+
+// lookup looks up a single name, writing its result to w, and returns the
+// steps taken, if traced, and the error that the lookup failed with, if any.
+func (q *query) lookup(ctx context.Context, w io.Writer, domain string) ([]dnstoy.TraceStep, error) {
+	if q.compare {
+		return nil, q.lookupCompare(ctx, w, domain)
+	}
+	if q.validatesAddrs() {
+		fmt.Fprintf(w, "\nresolving %s ...\n", displayName(domain))
+		result, err := q.resolver.LookupIPResult(ctx, domain, q.opts...)
+		printChainOfTrust(w, result)
+		if err != nil {
+			fmt.Fprintf(w, "error resolving %s: %s\n", displayName(domain), err)
+			return nil, err
+		}
+		fmt.Fprintf(w, "%s resolves to: %s (%s)\n", displayName(domain), result.IPs, result.Status)
+		return nil, nil
+	}
+
+	start := time.Now()
+	domain, records, steps, err := q.lookupRecords(ctx, domain)
+	elapsed := time.Since(start)
+	switch {
+	case q.json:
+		result := newJSONResult(domain, q.recordType, q.class, records, steps, elapsed, err, q.trace)
+		if encodeErr := json.NewEncoder(w).Encode(result); encodeErr != nil {
+			fmt.Fprintf(w, "error: %s\n", encodeErr)
+			return steps, encodeErr
+		}
+	case q.trace:
+		fmt.Fprintf(w, "\n; <<>> dnstoy <<>> %s %s +trace\n", displayName(domain), questionTypes(q.recordType, q.class))
+		printTrace(w, steps)
+		if err != nil {
+			fmt.Fprintf(w, ";; error: %s\n", err)
+		}
+	default:
+		printResponse(w, domain, q.recordType, q.class, records, steps, elapsed, err)
+	}
+	return steps, err
+}
+
+// validatesAddrs reports whether lookups resolve addresses with
+// LookupIPResult, printing the chain of trust validating them, rather than
+// looking up records.
+func (q *query) validatesAddrs() bool {
+	return q.dnssec && q.recordType == dnstoy.RecordTypeA && q.class == dnstoy.ResourceClassIN && !q.json
+}
+
+// lookupRecords looks up the records of a name, trying each of the names
+// given by the resolver's search list in turn until one is found, like the
+// system stub resolver, and returns the name last looked up with its
+// records, trace steps and error.
+func (q *query) lookupRecords(ctx context.Context, domain string) (string, []dnstoy.Record, []dnstoy.TraceStep, error) {
+	var (
+		name    string
+		records []dnstoy.Record
+		steps   []dnstoy.TraceStep
+		err     error
+	)
+	for _, name = range q.resolver.SearchNames(domain) {
+		records, steps, err = q.resolver.LookupRecordsWithTrace(ctx, name, dnstoy.QueryOpts{Type: q.recordType, Class: q.class}, q.opts...)
+		if !errors.Is(err, dnstoy.ErrNXDomain) && !errors.Is(err, dnstoy.ErrNoData) {
+			break
+		}
+	}
+	return name, records, steps, err
+}
@@ -1,28 +1,99 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"golang.org/x/exp/slog"
-
 	"github.com/mccutchen/dnstoy"
 )
 
 func main() {
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	timeout := flag.Duration("timeout", 5*time.Second, "Timeout for DNS queries")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(serve(os.Args[2:]))
+		case "zonecheck":
+			os.Exit(zonecheck(os.Args[2:]))
+		case "axfr":
+			os.Exit(axfr(os.Args[2:]))
+		}
+	}
+	os.Exit(resolve())
+}
+
+// resolve looks up the names given on the command line, returning the exit
+// code.
+func resolve() int {
+	flags := registerResolverFlags(flag.CommandLine)
+	typeFlag := flag.String("type", "A", "Type of records to look up, as a mnemonic (e.g. AAAA, MX, TXT, CAA) or a number")
+	classFlag := flag.String("class", "IN", "Class of records to look up (IN, CH, HS or ANY), e.g. CH for version.bind TXT, or a number")
+	serverFlag := flag.String("server", "", "Send queries directly to this name server (IP[:port], host name or https:// URL) instead of iterating from the root; may also be given as @server")
+	traceFlag := flag.Bool("trace", false, "Print each query sent while resolving, from the root down, like dig +trace; may also be given as +trace")
+	jsonFlag := flag.Bool("json", false, "Print the result of each lookup as a line of JSON, with its answers, status, the server that answered and timings")
+	concurrency := flag.Int("concurrency", 1, "Number of names to resolve at once, with output still printed in the order given")
+	fileFlag := flag.String("f", "", "Read names to look up from this file, one per line, or from stdin if \"-\"")
+	statsFlag := flag.Bool("stats", false, "Print the time taken by each lookup, the queries it sent and its cache hits, and their distributions, to stderr once all are done")
+	compareFlag := flag.Bool("compare", false, "Also look up each name with the system resolver, printing the differences between their answers (A, AAAA, CNAME, MX, NS and TXT only)")
+	noColorFlag := flag.Bool("no-color", false, "Do not color output, which is otherwise colored when written to a terminal (also disabled by setting NO_COLOR)")
 	flag.Parse()
+	recordType, err := parseRecordType(*typeFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid -type: %s\n", err)
+		return exitUsage
+	}
+	class, err := parseResourceClass(*classFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid -class: %s\n", err)
+		return exitUsage
+	}
+	if *compareFlag && (class != dnstoy.ResourceClassIN || !canCompare(recordType)) {
+		fmt.Fprintf(os.Stderr, "error: cannot compare %s records with the system resolver\n", questionTypes(recordType, class))
+		return exitUsage
+	}
+	if *statsFlag && (*compareFlag || (*flags.dnssec && recordType == dnstoy.RecordTypeA && class == dnstoy.ResourceClassIN && !*jsonFlag)) {
+		fmt.Fprintln(os.Stderr, "error: -stats cannot be combined with -compare or -dnssec")
+		return exitUsage
+	}
 
-	var domains []string
-	if flag.NArg() > 0 {
-		domains = flag.Args()
-	} else {
+	server, options, domains := parseArgs(flag.Args(), *serverFlag)
+	var transportOpts transportOpts
+	for _, opt := range options {
+		name, value, _ := strings.Cut(opt, "=")
+		switch {
+		case name == "trace":
+			*traceFlag = true
+		case transportOpts.parseOption(name, value):
+		default:
+			fmt.Fprintf(os.Stderr, "error: unknown option +%s\n", opt)
+			return exitUsage
+		}
+	}
+	server, transport, err := transportOpts.configure(server, *flags.timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return exitUsage
+	}
+	if *fileFlag != "" {
+		names, err := readNamesFile(*fileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: reading names: %s\n", err)
+			return exitError
+		}
+		domains = append(domains, names...)
+	}
+	if len(domains) == 0 {
 		// use a default set of domains to exercise DNS resolution
 		domains = []string{
 			"example.com",
@@ -35,29 +106,252 @@ func main() {
 		}
 	}
 
-	logLevel := slog.LevelInfo
-	if isDebugEnabled(*debug) {
-		logLevel = slog.LevelDebug
+	// names given in Unicode are looked up in their A-label form
+	for i, domain := range domains {
+		ascii, err := dnstoy.ToASCII(domain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return exitUsage
+		}
+		domains[i] = ascii
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
 
-	resolver := dnstoy.New(&dnstoy.Opts{
-		Logger: logger,
-		Dialer: &net.Dialer{
-			Timeout: *timeout,
-		},
-		QueryTimeout: *timeout,
-	})
+	logger := flags.logger()
+	resolver, err := flags.newResolver(logger, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return exitError
+	}
+	defer flags.closeResolver(resolver, logger)
 
-	for _, domain := range domains {
-		fmt.Printf("\nresolving %s ...\n", domain)
-		ips, err := resolver.LookupIP(context.Background(), domain)
+	var lookupOpts []dnstoy.LookupOption
+	if server != "" {
+		addr, err := resolveServer(context.Background(), resolver, server)
 		if err != nil {
-			fmt.Printf("error resolving %s: %s\n", domain, err)
-			continue
+			fmt.Fprintf(os.Stderr, "error: resolving server %s: %s\n", server, err)
+			return exitError
+		}
+		lookupOpts = append(lookupOpts, dnstoy.WithServer(addr))
+	}
+	if transport != nil {
+		lookupOpts = append(lookupOpts, dnstoy.WithTransport(transport))
+		if closer, ok := transport.(io.Closer); ok {
+			defer closer.Close()
 		}
-		fmt.Printf("%s resolves to: %s\n", domain, ips)
 	}
+	if *traceFlag {
+		// every step is traced, rather than answers being found in the cache
+		lookupOpts = append(lookupOpts, dnstoy.WithNoCache())
+	}
+
+	q := &query{
+		resolver:   resolver,
+		recordType: recordType,
+		class:      class,
+		opts:       lookupOpts,
+		trace:      *traceFlag,
+		json:       *jsonFlag,
+		dnssec:     *flags.dnssec,
+		compare:    *compareFlag,
+	}
+	if isTerminal(os.Stdout) && !*jsonFlag {
+		q.align = true
+		q.color = !*noColorFlag && !colorDisabled()
+	}
+	stats, err := q.lookupAll(context.Background(), os.Stdout, domains, *concurrency)
+	if *statsFlag {
+		printStats(os.Stderr, stats)
+	}
+	return exitCode(err)
+}
+
+// parseArgs separates dig-style "@server" and "+option" arguments from the
+// names to look up, returning the server, which defaults to the given one,
+// the options, without their "+", and the names.
+func parseArgs(args []string, server string) (string, []string, []string) {
+	var options, names []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			server = arg[1:]
+		case strings.HasPrefix(arg, "+") && len(arg) > 1:
+			options = append(options, arg[1:])
+		default:
+			names = append(names, arg)
+		}
+	}
+	return server, options, names
+}
+
+// displayName returns a domain name for display, followed by its Unicode
+// form if it has any A-labels, e.g. "xn--bcher-kva.example (bücher.example)".
+func displayName(name string) string {
+	if unicode := dnstoy.ToUnicode(name); unicode != name {
+		return fmt.Sprintf("%s (%s)", name, unicode)
+	}
+	return name
+}
+
+// readNamesFile reads the names to look up from a file, or from stdin if the
+// path is "-".
+func readNamesFile(path string) ([]string, error) {
+	if path == "-" {
+		return readNames(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readNames(f)
+}
+
+// readNames reads one name per line, ignoring blank lines and comments
+// starting with "#".
+func readNames(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// resolveServer returns the given name server in the form accepted by
+// dnstoy.WithServer, resolving its address first if it is given by host
+// name, e.g. "ns1.example.com" or "ns1.example.com:53".
+func resolveServer(ctx context.Context, resolver *dnstoy.Resolver, server string) (string, error) {
+	if strings.HasPrefix(server, "https://") {
+		return server, nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), ""
+	}
+	if net.ParseIP(host) != nil {
+		return server, nil
+	}
+	ips, err := resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if port == "" {
+		return ips[0].String(), nil
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// parseRecordType parses a record type given as a mnemonic, in the generic
+// TYPEnn form, or as a bare number.
+func parseRecordType(s string) (dnstoy.RecordType, error) {
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return dnstoy.RecordType(n), nil
+	}
+	return dnstoy.ParseRecordType(s)
+}
+
+// parseResourceClass parses a class given as a mnemonic, in the generic
+// CLASSnn form, or as a bare number.
+func parseResourceClass(s string) (dnstoy.ResourceClass, error) {
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return dnstoy.ResourceClass(n), nil
+	}
+	return dnstoy.ParseResourceClass(s)
+}
+
+// printChainOfTrust prints each zone in the chain of trust used to validate
+// a lookup, with its DS records, DNSKEYs and the signatures over them,
+// followed by the signatures over the answer.
+func printChainOfTrust(w io.Writer, result dnstoy.LookupResult) {
+	if len(result.Chain) == 0 && len(result.RRSets) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "chain of trust:")
+	for _, zone := range result.Chain {
+		fmt.Fprintf(tw, "  %s\t%s\t\t\t\n", zone.Zone, zone.Status)
+		for _, ds := range zone.DS {
+			source := "published by parent"
+			if zone.TrustAnchor {
+				source = "trust anchor"
+			}
+			fmt.Fprintf(tw, "\tDS\t%d\t%s\t%s, %s\n", ds.KeyTag, dnstoy.AlgorithmName(ds.Algorithm), digestName(ds.DigestType), source)
+		}
+		for _, key := range zone.Keys {
+			role := "ZSK"
+			if key.IsSEP() {
+				role = "KSK"
+			}
+			fmt.Fprintf(tw, "\tDNSKEY\t%d\t%s\t%s\n", key.KeyTag(), dnstoy.AlgorithmName(key.Algorithm), role)
+		}
+		printSignatures(tw, zone.Signatures)
+		if zone.Err != nil {
+			fmt.Fprintf(tw, "\terror: %s\t\t\t\n", zone.Err)
+		}
+	}
+	fmt.Fprintln(tw, "answer:")
+	for _, rrset := range result.RRSets {
+		fmt.Fprintf(tw, "  %s %s\t%s\t\t\t\n", rrset.Name, rrset.Type, rrset.Status)
+		printSignatures(tw, rrset.Signatures)
+		if rrset.Err != nil {
+			fmt.Fprintf(tw, "\terror: %s\t\t\t\n", rrset.Err)
+		}
+	}
+}
+
+func printSignatures(w io.Writer, sigs []dnstoy.RRSIG) {
+	for _, sig := range sigs {
+		expires := time.Unix(int64(sig.Expiration), 0).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, "\tRRSIG\t%d\t%s\tsigned by %s, expires %s\n", sig.KeyTag, dnstoy.AlgorithmName(sig.Algorithm), sig.SignerName, expires)
+	}
+}
+
+func digestName(digestType uint8) string {
+	switch digestType {
+	case dnstoy.DigestTypeSHA1:
+		return "SHA-1"
+	case dnstoy.DigestTypeSHA256:
+		return "SHA-256"
+	case dnstoy.DigestTypeSHA384:
+		return "SHA-384"
+	default:
+		return fmt.Sprintf("digest %d", digestType)
+	}
+}
+
+// loadCache restores the resolver's cache from a snapshot file, if the file
+// exists.
+func loadCache(resolver *dnstoy.Resolver, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return resolver.LoadCache(f)
+}
+
+// saveCache atomically writes a snapshot of the resolver's cache to a file.
+func saveCache(resolver *dnstoy.Resolver, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := resolver.SaveCache(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 func isDebugEnabled(debugFlag bool) bool {
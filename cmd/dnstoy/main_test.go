@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestParseArgs(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		args        []string
+		server      string
+		wantServer  string
+		wantOptions []string
+		wantNames   []string
+	}{
+		"names": {
+			args:      []string{"example.com", "example.org"},
+			wantNames: []string{"example.com", "example.org"},
+		},
+		"server": {
+			args:       []string{"@1.1.1.1", "example.com"},
+			wantServer: "1.1.1.1",
+			wantNames:  []string{"example.com"},
+		},
+		"server overrides flag": {
+			args:       []string{"example.com", "@8.8.8.8"},
+			server:     "1.1.1.1",
+			wantServer: "8.8.8.8",
+			wantNames:  []string{"example.com"},
+		},
+		"server from flag": {
+			args:       []string{"example.com"},
+			server:     "1.1.1.1",
+			wantServer: "1.1.1.1",
+			wantNames:  []string{"example.com"},
+		},
+		"options": {
+			args:        []string{"+trace", "example.com", "+tcp", "+edns=0"},
+			wantOptions: []string{"trace", "tcp", "edns=0"},
+			wantNames:   []string{"example.com"},
+		},
+		"bare @ and + are names": {
+			args:      []string{"@", "+"},
+			wantNames: []string{"@", "+"},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server, options, names := parseArgs(tc.args, tc.server)
+			be.Equal(t, tc.wantServer, server)
+			be.AllEqual(t, tc.wantOptions, options)
+			be.AllEqual(t, tc.wantNames, names)
+		})
+	}
+}
+
+func TestReadNamesFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "names.txt")
+	be.NilErr(t, os.WriteFile(path, []byte("# names to look up\nexample.com\n\n  example.org  # indented\n#example.net\n"), 0o600))
+	names, err := readNamesFile(path)
+	be.NilErr(t, err)
+	be.AllEqual(t, []string{"example.com", "example.org"}, names)
+
+	_, err = readNamesFile(filepath.Join(t.TempDir(), "missing.txt"))
+	be.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+// startTestServer starts a UDP name server that answers A queries for
+// a.test and c.test, after the given delays, with NXDOMAIN for b.test and
+// SERVFAIL for anything else. It returns the server's address.
+func startTestServer(t *testing.T, delays map[string]time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		for {
+			buf := make([]byte, 65535)
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			go func() {
+				query, err := dnstoy.ParseMessage(buf[:n], dnstoy.ParseStrict)
+				if err != nil || len(query.Questions) != 1 {
+					return
+				}
+				q := query.Questions[0]
+				name := strings.ToLower(string(q.Name))
+				time.Sleep(delays[name])
+				resp := dnstoy.Message{Header: query.Header, Questions: query.Questions}
+				resp.Header.SetQR(true)
+				resp.Header.SetRA(true)
+				switch name {
+				case "a.test", "c.test":
+					resp.Answers = []dnstoy.Record{{Name: q.Name, Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 60, Data: []byte{192, 0, 2, 1}}}
+				case "b.test":
+					resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+				default:
+					resp.Header.SetRCode(dnstoy.RCodeServFail)
+				}
+				conn.WriteTo(resp.Encode(), addr)
+			}()
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupAll(t *testing.T) {
+	t.Parallel()
+
+	// the first name is answered last, and d.test fails before b.test
+	addr := startTestServer(t, map[string]time.Duration{"a.test": 50 * time.Millisecond, "b.test": 20 * time.Millisecond})
+	names := []string{"a.test", "b.test", "c.test", "d.test"}
+
+	testCases := map[string]struct {
+		names       []string
+		concurrency int
+		wantErr     error
+	}{
+		"sequential": {
+			names:       names,
+			concurrency: 1,
+			wantErr:     dnstoy.ErrNXDomain,
+		},
+		"concurrent": {
+			names:       names,
+			concurrency: 4,
+			wantErr:     dnstoy.ErrNXDomain,
+		},
+		"more workers than names": {
+			names:       []string{"a.test", "c.test"},
+			concurrency: 10,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			q := &query{
+				resolver:   dnstoy.New(&dnstoy.Opts{Upstreams: []string{addr}, QueryTimeout: time.Second}),
+				recordType: dnstoy.RecordTypeA,
+				class:      dnstoy.ResourceClassIN,
+			}
+			var out bytes.Buffer
+			stats, err := q.lookupAll(context.Background(), &out, tc.names, tc.concurrency)
+			if tc.wantErr != nil {
+				be.True(t, errors.Is(err, tc.wantErr))
+			} else {
+				be.NilErr(t, err)
+			}
+			be.Equal(t, len(tc.names), len(stats))
+
+			// each name's output follows the last's, whichever finished
+			// first
+			last := -1
+			for _, name := range tc.names {
+				i := strings.Index(out.String(), "<<>> "+name+" ")
+				be.True(t, i > last)
+				last = i
+			}
+		})
+	}
+}
+
+// tcpTimeoutError returns the error from a lookup over TCP, as with +tcp,
+// from a name server that accepts connections but never responds.
+func tcpTimeoutError(t *testing.T) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	r := dnstoy.New(&dnstoy.Opts{
+		Upstreams: []string{ln.Addr().String()},
+		Transport: &dnstoy.TCPTransport{Timeout: 50 * time.Millisecond},
+	})
+	defer r.Close()
+	_, err = r.LookupIP(context.Background(), "ip4", "example.com")
+	be.Nonzero(t, err)
+	return err
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		err  error
+		want int
+	}{
+		"success":          {err: nil, want: exitOK},
+		"nxdomain":         {err: fmt.Errorf("lookup example.com: %w", dnstoy.ErrNXDomain), want: exitNotFound},
+		"no data":          {err: dnstoy.ErrNoData, want: exitNotFound},
+		"servfail":         {err: dnstoy.ErrServFail, want: exitServerFailure},
+		"refused":          {err: dnstoy.ErrRefused, want: exitServerFailure},
+		"bogus":            {err: dnstoy.ErrBogus, want: exitServerFailure},
+		"resolution timed": {err: dnstoy.ErrResolutionTimeout, want: exitTimeout},
+		"deadline":         {err: context.DeadlineExceeded, want: exitTimeout},
+		"network timeout":  {err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, want: exitTimeout},
+		"tcp timeout":      {err: tcpTimeoutError(t), want: exitTimeout},
+		"other":            {err: errors.New("something went wrong"), want: exitError},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, tc.want, exitCode(tc.err))
+		})
+	}
+}
+
+func TestParseRootHints(t *testing.T) {
+	t.Parallel()
+
+	hintsFile := filepath.Join(t.TempDir(), "named.root")
+	be.NilErr(t, os.WriteFile(hintsFile, []byte(". 3600000 NS a.root.test.\na.root.test. 3600000 A 192.0.2.1\n"), 0o600))
+
+	testCases := map[string]struct {
+		value   string
+		wantErr bool
+	}{
+		"address":          {value: "192.0.2.1"},
+		"addresses":        {value: "192.0.2.1, 2001:db8::1"},
+		"file":             {value: hintsFile},
+		"missing file":     {value: filepath.Join(t.TempDir(), "missing.root"), wantErr: true},
+		"invalid address":  {value: "192.0.2.1,192.0.2.256", wantErr: true},
+		"hints without ns": {value: os.DevNull, wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			hints, err := parseRootHints(tc.value)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Nonzero(t, hints)
+		})
+	}
+}
+
+func TestAlignColumns(t *testing.T) {
+	t.Parallel()
+
+	in := "example.com.\t60\tIN\tA\t192.0.2.1\nwww.example.com.\t3600\tIN\tAAAA\t2001:db8::1\n"
+	want := "example.com.      60    IN  A     192.0.2.1\nwww.example.com.  3600  IN  AAAA  2001:db8::1\n"
+	be.Equal(t, want, string(alignColumns([]byte(in))))
+}
+
+func TestColorize(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		line, want string
+	}{
+		"section header": {
+			line: ";; ANSWER SECTION:",
+			want: ansiBold + ";; ANSWER SECTION:" + ansiReset,
+		},
+		"error status": {
+			line: ";; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 1",
+			want: ansiRed + ";; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 1" + ansiReset,
+		},
+		"NOERROR status": {
+			line: ";; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1",
+			want: ";; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1",
+		},
+		"aligned record": {
+			line: "example.com.  60  IN  A  192.0.2.1",
+			want: "example.com.  60  IN  " + ansiCyan + "A" + ansiReset + "  192.0.2.1",
+		},
+		"tab-separated record": {
+			line: "version.bind.\t0\tCH\tTXT\t\"9.18\"",
+			want: "version.bind.\t0\tCH\t" + ansiCyan + "TXT" + ansiReset + "\t\"9.18\"",
+		},
+		"not a record": {
+			line: "example.com.  sixty  IN  A  192.0.2.1",
+			want: "example.com.  sixty  IN  A  192.0.2.1",
+		},
+		"only found by dnstoy": {
+			line: "- example.com. A 192.0.2.1",
+			want: ansiRed + "- example.com. A 192.0.2.1" + ansiReset,
+		},
+		"only found by the system resolver": {
+			line: "+ example.com. A 192.0.2.2",
+			want: ansiGreen + "+ example.com. A 192.0.2.2" + ansiReset,
+		},
+		"results match": {
+			line: "results match",
+			want: ansiGreen + "results match" + ansiReset,
+		},
+		"error": {
+			line: ";; error: lookup example.com: server misbehaving",
+			want: ansiRed + ";; error: lookup example.com: server misbehaving" + ansiReset,
+		},
+		"comment": {
+			line: ";; Query time: 12 msec",
+			want: ";; Query time: 12 msec",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, tc.want, string(colorize([]byte(tc.line))))
+			be.Equal(t, tc.want+"\n", string(colorize([]byte(tc.line+"\n"))))
+		})
+	}
+}
+
+func TestParseRecordType(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		want    dnstoy.RecordType
+		wantErr bool
+	}{
+		"A":      {want: dnstoy.RecordTypeA},
+		"aaaa":   {want: dnstoy.RecordTypeAAAA},
+		"MX":     {want: dnstoy.RecordTypeMX},
+		"TYPE65": {want: dnstoy.RecordType(65)},
+		"257":    {want: dnstoy.RecordType(257)},
+		"65536":  {wantErr: true},
+		"BOGUS":  {wantErr: true},
+	}
+	for s, tc := range testCases {
+		s, tc := s, tc
+		t.Run(s, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseRecordType(s)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseResourceClass(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		want      dnstoy.ResourceClass
+		wantTypes string
+		wantErr   bool
+	}{
+		"IN":      {want: dnstoy.ResourceClassIN, wantTypes: "TXT"},
+		"ch":      {want: dnstoy.ResourceClassCH, wantTypes: "CH TXT"},
+		"HS":      {want: dnstoy.ResourceClassHS, wantTypes: "HS TXT"},
+		"3":       {want: dnstoy.ResourceClassCH, wantTypes: "CH TXT"},
+		"CLASS42": {want: dnstoy.ResourceClass(42), wantTypes: "CLASS42 TXT"},
+		"65536":   {wantErr: true},
+		"XX":      {wantErr: true},
+	}
+	for s, tc := range testCases {
+		s, tc := s, tc
+		t.Run(s, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseResourceClass(s)
+			if tc.wantErr {
+				be.Nonzero(t, err)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, got)
+			be.Equal(t, tc.wantTypes, questionTypes(dnstoy.RecordTypeTXT, got))
+		})
+	}
+}
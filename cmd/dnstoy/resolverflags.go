@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// resolverFlags are the flags that configure the resolver, shared by every
+// command.
+type resolverFlags struct {
+	debug          *bool
+	timeout        *time.Duration
+	network        *string
+	cacheSize      *int
+	cacheFile      *string
+	aggressiveNSEC *bool
+	hostsFile      *string
+	nsid           *bool
+	upstreams      *string
+	resolvConf     *string
+	forwardZones   map[string][]string
+	healthCheck    *time.Duration
+	lenient        *bool
+	dnssec         *bool
+	pcapFile       *string
+	rootHints      *string
+
+	capture io.Closer // the capture file, once opened
+}
+
+func registerResolverFlags(fs *flag.FlagSet) *resolverFlags {
+	f := &resolverFlags{
+		debug:          fs.Bool("debug", false, "Enable debug logging"),
+		timeout:        fs.Duration("timeout", 5*time.Second, "Timeout for DNS queries"),
+		network:        fs.String("network", "udp", "Network for DNS queries (udp, udp4, udp6, tcp, tcp4, tcp6)"),
+		cacheSize:      fs.Int("cache-size", 0, "Maximum number of cached RRsets (0 for unlimited)"),
+		cacheFile:      fs.String("cache-file", "", "Restore the cache from this file on startup and save it on exit"),
+		aggressiveNSEC: fs.Bool("aggressive-nsec", false, "Synthesize NXDOMAIN answers from cached NSEC records (RFC 8198)"),
+		hostsFile:      fs.String("hosts", "", "Answer lookups from this hosts file (e.g. /etc/hosts) before querying"),
+		nsid:           fs.Bool("nsid", false, "Request and print name server identifiers (NSID)"),
+		upstreams:      fs.String("upstream", "", "Comma-separated recursive resolvers (IP[:port] or https:// URL) to forward queries to, instead of iterating from the root"),
+		resolvConf:     fs.String("resolv-conf", "", "Forward queries to the name servers in this resolv.conf file (e.g. /etc/resolv.conf), using its search list, ndots and attempts, like the system stub resolver"),
+		healthCheck:    fs.Duration("health-check", 0, "Probe upstreams at this interval, preferring healthy ones and failing over from those that stop answering (0 to disable)"),
+		lenient:        fs.Bool("lenient", false, "Salvage what can be parsed from malformed responses, logging the parse errors"),
+		rootHints:      fs.String("root-hints", "", "Start resolving names from the root name servers in this root hints file (e.g. named.root), or at these comma-separated addresses, instead of the internet's"),
+		pcapFile:       fs.String("pcap", "", "Write each query sent, and its response, to this file as packets in pcap format, e.g. for Wireshark"),
+		dnssec:         fs.Bool("dnssec", false, "Validate answers with DNSSEC, printing the chain of trust for each"),
+		forwardZones:   make(map[string][]string),
+	}
+	fs.Func("forward", "Forward queries for the names in a zone to comma-separated recursive resolvers, as zone=IP[:port],... (may be repeated)", f.addForwardZone)
+	return f
+}
+
+// addForwardZone parses a -forward flag.
+func (f *resolverFlags) addForwardZone(value string) error {
+	zone, upstreams, ok := strings.Cut(value, "=")
+	if !ok || zone == "" || upstreams == "" {
+		return fmt.Errorf("must be zone=upstream[,upstream...]")
+	}
+	f.forwardZones[zone] = append(f.forwardZones[zone], strings.Split(upstreams, ",")...)
+	return nil
+}
+
+// parseRootHints parses a -root-hints flag, loading the root hints file it
+// names unless it lists addresses.
+func parseRootHints(value string) (*dnstoy.RootHints, error) {
+	var addrs []net.IP
+	for _, s := range strings.Split(value, ",") {
+		addr := net.ParseIP(strings.TrimSpace(s))
+		if addr == nil {
+			return dnstoy.LoadRootHints(value)
+		}
+		addrs = append(addrs, addr)
+	}
+	return dnstoy.RootHintsFromAddrs(addrs...), nil
+}
+
+func (f *resolverFlags) logger() *slog.Logger {
+	logLevel := slog.LevelInfo
+	if isDebugEnabled(*f.debug) {
+		logLevel = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// newResolver creates a resolver configured by the flags, restoring its
+// cache from the cache file, if any. Its measurements are reported to
+// metrics, if not nil.
+func (f *resolverFlags) newResolver(logger *slog.Logger, metrics dnstoy.Metrics) (*dnstoy.Resolver, error) {
+	var hosts *dnstoy.Hosts
+	if *f.hostsFile != "" {
+		var err error
+		hosts, err = dnstoy.LoadHosts(*f.hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading hosts file: %w", err)
+		}
+	}
+
+	var upstreamList []string
+	if *f.upstreams != "" {
+		upstreamList = strings.Split(*f.upstreams, ",")
+	}
+
+	// without a resolv.conf file, there is no search list and the resolver's
+	// defaults apply
+	stubOpts := &dnstoy.Opts{}
+	if *f.resolvConf != "" {
+		conf, err := dnstoy.LoadResolvConf(*f.resolvConf)
+		if err != nil {
+			return nil, fmt.Errorf("loading resolv.conf: %w", err)
+		}
+		stubOpts = conf.Opts()
+		if len(stubOpts.Upstreams) == 0 {
+			// like the system stub resolver, fall back to a local name server
+			stubOpts.Upstreams = []string{"127.0.0.1"}
+		}
+		if upstreamList == nil {
+			upstreamList = stubOpts.Upstreams
+		}
+	}
+
+	parseMode := dnstoy.ParseStrict
+	if *f.lenient {
+		parseMode = dnstoy.ParseLenient
+	}
+
+	var rootHints *dnstoy.RootHints
+	if *f.rootHints != "" {
+		var err error
+		rootHints, err = parseRootHints(*f.rootHints)
+		if err != nil {
+			return nil, fmt.Errorf("loading root hints: %w", err)
+		}
+	}
+
+	var capture *dnstoy.PcapWriter
+	if *f.pcapFile != "" {
+		file, err := os.Create(*f.pcapFile)
+		if err != nil {
+			return nil, fmt.Errorf("creating capture file: %w", err)
+		}
+		if capture, err = dnstoy.NewPcapWriter(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("writing capture file: %w", err)
+		}
+		f.capture = file
+	}
+
+	resolver := dnstoy.New(&dnstoy.Opts{
+		Logger: logger,
+		Dialer: &net.Dialer{
+			Timeout: *f.timeout,
+		},
+		QueryTimeout:        *f.timeout,
+		Network:             *f.network,
+		RequestNSID:         *f.nsid,
+		CacheMaxEntries:     *f.cacheSize,
+		AggressiveNSEC:      *f.aggressiveNSEC,
+		Hosts:               hosts,
+		Upstreams:           upstreamList,
+		ForwardZones:        f.forwardZones,
+		HealthCheckInterval: *f.healthCheck,
+		QueryAttempts:       stubOpts.QueryAttempts,
+		Search:              stubOpts.Search,
+		Ndots:               stubOpts.Ndots,
+		ParseMode:           parseMode,
+		DNSSEC:              *f.dnssec,
+		Metrics:             metrics,
+		Capture:             capture,
+		RootHints:           rootHints,
+	})
+
+	if *f.cacheFile != "" {
+		if err := loadCache(resolver, *f.cacheFile); err != nil {
+			logger.Warn("failed to load cache", slog.String("path", *f.cacheFile), slog.String("err", err.Error()))
+		}
+	}
+	return resolver, nil
+}
+
+// closeResolver saves the resolver's cache to the cache file, if any, and
+// closes it, and the capture file, if any.
+func (f *resolverFlags) closeResolver(resolver *dnstoy.Resolver, logger *slog.Logger) {
+	if *f.cacheFile != "" {
+		if err := saveCache(resolver, *f.cacheFile); err != nil {
+			logger.Warn("failed to save cache", slog.String("path", *f.cacheFile), slog.String("err", err.Error()))
+		}
+	}
+	resolver.Close()
+	if f.capture != nil {
+		if err := f.capture.Close(); err != nil {
+			logger.Warn("failed to write capture file", slog.String("path", *f.pcapFile), slog.String("err", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+	"github.com/mccutchen/dnstoy/server"
+)
+
+// shutdownTimeout bounds the time spent answering the queries in progress
+// when the server is stopped.
+const shutdownTimeout = 5 * time.Second
+
+// serve runs the serve command, which answers DNS queries by resolving them
+// until interrupted, returning the exit code.
+func serve(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s serve [flags]\n\nRun a recursive resolver answering DNS queries over UDP and, optionally, HTTPS.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	listen := fs.String("listen", "127.0.0.1:5353", "UDP address to answer queries on")
+	dohListen := fs.String("doh-listen", "", "Address to answer DNS-over-HTTPS queries on, over plain HTTP for use behind a TLS-terminating proxy (disabled if empty)")
+	dohPath := fs.String("doh-path", "/dns-query", "Path to answer DNS-over-HTTPS queries on")
+	blocklists := fs.String("blocklist", "", "Comma-separated blocklist files, in hosts file format or listing one name per line, whose names are not resolved")
+	blockResponse := fs.String("block-response", "nxdomain", "Answer to queries for blocked names (nxdomain, or null for 0.0.0.0 and ::)")
+	metricsListen := fs.String("metrics-listen", "", "Address to serve Prometheus metrics on, at /metrics, which may be the same as -doh-listen (disabled if empty)")
+	policyZones := fs.String("rpz", "", "Comma-separated response policy zone files, whose rules are applied to queries in order")
+	configFile := fs.String("config", "", "Read settings from this file, in TOML with the flags' names as keys, e.g. upstream = [\"1.1.1.1\"]; flags given on the command line take precedence")
+	flags := registerResolverFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if *configFile != "" {
+		if err := loadConfig(fs, *configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: loading config: %s\n", err)
+			return 2
+		}
+	}
+
+	logger := flags.logger()
+	var (
+		metrics         *server.Metrics
+		resolverMetrics dnstoy.Metrics
+	)
+	if *metricsListen != "" {
+		metrics = &server.Metrics{}
+		resolverMetrics = metrics
+	}
+	resolver, err := flags.newResolver(logger, resolverMetrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return 1
+	}
+	defer flags.closeResolver(resolver, logger)
+
+	handler := server.Recursive(resolver)
+	if *policyZones != "" {
+		rpz, err := newRPZ(*policyZones, handler)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		for _, z := range rpz.Zones {
+			logger.Info("loaded response policy zone", slog.String("zone", z.Name), slog.Int("rules", z.Len()))
+		}
+		defer func() {
+			logger.Info("queries answered by response policy", slog.Uint64("hits", rpz.Hits()))
+		}()
+		if metrics != nil {
+			metrics.Counter("dnstoy_rpz_hits_total", "Queries answered by a response policy rule.", rpz.Hits)
+		}
+		handler = rpz
+	}
+	if *blocklists != "" {
+		blocker, err := newBlocker(*blocklists, *blockResponse, handler)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		logger.Info("loaded blocklists", slog.Int("entries", blocker.List.Len()))
+		defer func() {
+			logger.Info("blocked queries", slog.Uint64("blocked", blocker.Blocked()), slog.Uint64("queries", blocker.Queries()))
+		}()
+		if metrics != nil {
+			metrics.Counter("dnstoy_blocked_queries_total", "Queries for names in the blocklists.", blocker.Blocked)
+		}
+		handler = blocker
+	}
+	if metrics != nil {
+		metrics.Next = handler
+		handler = metrics
+	}
+
+	srv := &server.Server{
+		Addr:    *listen,
+		Handler: handler,
+		Logger:  logger,
+	}
+	// DNS-over-HTTPS queries and metrics may be served on the same address
+	var httpSrvs []*http.Server
+	muxes := make(map[string]*http.ServeMux)
+	handle := func(addr, path string, h http.Handler) {
+		mux, ok := muxes[addr]
+		if !ok {
+			mux = http.NewServeMux()
+			muxes[addr] = mux
+			httpSrvs = append(httpSrvs, &http.Server{Addr: addr, Handler: mux})
+		}
+		mux.Handle(path, h)
+	}
+	if *dohListen != "" {
+		handle(*dohListen, *dohPath, srv.HTTPHandler())
+	}
+	if metrics != nil {
+		handle(*metricsListen, "/metrics", metrics)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1+len(httpSrvs))
+	logger.Info("serving DNS", slog.String("addr", *listen))
+	go func() { errs <- srv.ListenAndServe() }()
+	if *dohListen != "" {
+		logger.Info("serving DNS over HTTP", slog.String("addr", *dohListen), slog.String("path", *dohPath))
+	}
+	if metrics != nil {
+		logger.Info("serving metrics", slog.String("addr", *metricsListen), slog.String("path", "/metrics"))
+	}
+	for _, httpSrv := range httpSrvs {
+		httpSrv := httpSrv
+		go func() { errs <- httpSrv.ListenAndServe() }()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		exitCode = 1
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, httpSrv := range httpSrvs {
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warn("HTTP requests still in progress at shutdown were canceled")
+		}
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("queries still in progress at shutdown were canceled")
+	}
+	return exitCode
+}
+
+// newRPZ creates a handler applying the rules of the given comma-separated
+// policy zone files before passing queries on to next.
+func newRPZ(paths string, next server.Handler) (*server.RPZ, error) {
+	rpz := &server.RPZ{Next: next}
+	for _, path := range strings.Split(paths, ",") {
+		z, err := server.LoadPolicyZone(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading response policy zone: %w", err)
+		}
+		rpz.Zones = append(rpz.Zones, z)
+	}
+	return rpz, nil
+}
+
+// newBlocker creates a handler blocking the names in the given
+// comma-separated blocklist files before passing queries on to next.
+func newBlocker(paths, response string, next server.Handler) (*server.Blocker, error) {
+	blocker := &server.Blocker{Next: next}
+	switch response {
+	case "nxdomain":
+		blocker.Response = server.BlockNXDomain
+	case "null":
+		blocker.Response = server.BlockNullAddress
+	default:
+		return nil, fmt.Errorf("invalid block response %q: must be nxdomain or null", response)
+	}
+	var err error
+	blocker.List, err = server.LoadBlocklist(strings.Split(paths, ",")...)
+	if err != nil {
+		return nil, fmt.Errorf("loading blocklist: %w", err)
+	}
+	return blocker, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// lookupStat measures a single lookup.
+type lookupStat struct {
+	name      string
+	elapsed   time.Duration
+	queries   int // sent to name servers
+	cacheHits int
+	err       error
+}
+
+// newLookupStat measures a lookup from the steps it took.
+func newLookupStat(name string, elapsed time.Duration, steps []dnstoy.TraceStep, err error) lookupStat {
+	stat := lookupStat{name: name, elapsed: elapsed, err: err}
+	for _, step := range steps {
+		if step.Cached {
+			stat.cacheHits++
+		} else {
+			stat.queries++
+		}
+	}
+	return stat
+}
+
+// printStats prints the time taken by each lookup, the queries it sent and
+// the answers it found in the cache, followed by their distributions across
+// all of the lookups.
+func printStats(w io.Writer, stats []lookupStat) {
+	if len(stats) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\n;; NAME\tTIME\tQUERIES\tCACHE HITS\tSTATUS")
+	var (
+		durations         = make([]time.Duration, len(stats))
+		queries           = make([]int, len(stats))
+		cacheHits, failed int
+		totalTime         time.Duration
+		totalQueries      int
+	)
+	for i, stat := range stats {
+		status := lookupStatus(stat.err)
+		if status == "" {
+			status = "error"
+		}
+		fmt.Fprintf(tw, ";; %s\t%s\t%d\t%d\t%s\n", stat.name, formatRTT(stat.elapsed), stat.queries, stat.cacheHits, status)
+		durations[i], queries[i] = stat.elapsed, stat.queries
+		totalTime += stat.elapsed
+		totalQueries += stat.queries
+		cacheHits += stat.cacheHits
+		if stat.err != nil {
+			failed++
+		}
+	}
+	tw.Flush()
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	sort.Ints(queries)
+	n := len(stats)
+	fmt.Fprintf(w, ";; %d lookups, %d failed\n", n, failed)
+	fmt.Fprintf(w, ";; time: min %s, avg %s, p95 %s, max %s\n",
+		formatRTT(durations[0]), formatRTT(totalTime/time.Duration(n)), formatRTT(durations[percentileIndex(n, 0.95)]), formatRTT(durations[n-1]))
+	fmt.Fprintf(w, ";; queries per lookup: min %d, avg %.1f, p95 %d, max %d\n",
+		queries[0], float64(totalQueries)/float64(n), queries[percentileIndex(n, 0.95)], queries[n-1])
+	fmt.Fprintf(w, ";; cache hits: %d\n", cacheHits)
+}
+
+// percentileIndex returns the index of the pth percentile, by the nearest
+// rank method, of n sorted values.
+func percentileIndex(n int, p float64) int {
+	i := int(math.Ceil(float64(n)*p)) - 1
+	if i < 0 {
+		return 0
+	}
+	return i
+}
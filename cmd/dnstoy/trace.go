@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// printTrace prints each step taken by a lookup, in the manner of dig
+// +trace: the name server asked and the zone it was asked about, how long
+// it took to respond, and whether it referred the resolver elsewhere or
+// answered, followed by the records of any answer.
+func printTrace(w io.Writer, steps []dnstoy.TraceStep) {
+	for _, step := range steps {
+		if step.Cached {
+			fmt.Fprintf(w, ";; %s %s: answered from cache\n", step.Name, step.Type)
+			continue
+		}
+		server := step.NameServer
+		if step.Addr != nil && step.Addr.String() != server {
+			server = fmt.Sprintf("%s (%s)", server, step.Addr)
+		}
+		fmt.Fprintf(w, ";; %s %s from %s for %s in %s: %s\n", step.Name, step.Type, server, step.Zone, formatRTT(step.RTT), summarizeStep(step))
+		if step.Err == nil {
+			for _, rec := range step.Response.Answers {
+				fmt.Fprintf(w, "%s\n", rec)
+			}
+		}
+	}
+}
+
+// summarizeStep describes the outcome of a query sent while resolving.
+func summarizeStep(step dnstoy.TraceStep) string {
+	if step.Err != nil {
+		return "error: " + step.Err.Error()
+	}
+	resp := step.Response
+	if rcode := resp.Header.RCode(); rcode != dnstoy.RCodeNoError {
+		return rcode.String()
+	}
+	if len(resp.Answers) > 0 {
+		return "answer"
+	}
+	var zone string
+	var nameServers []string
+	for _, rec := range resp.Authorities {
+		if rec.Type == dnstoy.RecordTypeNS {
+			zone = string(rec.Name)
+			nameServers = append(nameServers, string(rec.Data))
+		}
+	}
+	if len(nameServers) > 0 {
+		return fmt.Sprintf("referral to %s: %s", zone, strings.Join(nameServers, ", "))
+	}
+	return "no records"
+}
+
+func formatRTT(rtt time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(rtt)/float64(time.Millisecond))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// transportOpts are the transport selected by the dig-style +tcp, +tls and
+// +https options.
+type transportOpts struct {
+	kind     string // "tcp", "tls", "https" or empty for the default
+	tlsName  string // name to verify the server's certificate against
+	httpsURL string
+}
+
+// parseOption applies a "+name[=value]" option, given without its "+",
+// returning false if it does not select a transport.
+func (t *transportOpts) parseOption(name, value string) bool {
+	switch name {
+	case "tcp":
+		t.kind = "tcp"
+	case "tls":
+		t.kind, t.tlsName = "tls", value
+	case "https":
+		t.kind, t.httpsURL = "https", value
+	default:
+		return false
+	}
+	return true
+}
+
+// configure returns the server to query with the selected transport, and
+// the transport to send the queries with, or nil for the resolver's. DNS
+// over TLS defaults to port 853 and verifies the server's certificate
+// against its host name, if it was given by name. DNS over HTTPS is sent to
+// the given URL, or to /dns-query on the server.
+func (t transportOpts) configure(server string, timeout time.Duration) (string, dnstoy.Transport, error) {
+	switch t.kind {
+	case "tcp":
+		return server, &dnstoy.TCPTransport{Timeout: timeout}, nil
+	case "tls":
+		if server == "" {
+			return "", nil, errors.New("+tls requires a server, given as @server")
+		}
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host, port = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), "853"
+		}
+		name := t.tlsName
+		if name == "" && net.ParseIP(host) == nil {
+			name = host
+		}
+		transport := &dnstoy.TLSTransport{
+			Config:  &tls.Config{ServerName: name},
+			Timeout: timeout,
+		}
+		return net.JoinHostPort(host, port), transport, nil
+	case "https":
+		if t.httpsURL != "" {
+			return t.httpsURL, nil, nil
+		}
+		if server == "" {
+			return "", nil, errors.New("+https requires a URL, given as +https=URL, or a server, given as @server")
+		}
+		if strings.HasPrefix(server, "https://") {
+			return server, nil, nil
+		}
+		return "https://" + server + "/dns-query", nil, nil
+	default:
+		return server, nil, nil
+	}
+}
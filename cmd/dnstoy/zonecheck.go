@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// zonecheck runs the zonecheck command, which reports the problems found in
+// zone files, returning the exit code.
+func zonecheck(args []string) int {
+	fs := flag.NewFlagSet("zonecheck", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s zonecheck [flags] zonefile...\n\nCheck zone files for syntax errors, misplaced or missing SOA and NS records,\ninvalid TTLs, out-of-zone data, CNAMEs alongside other data and missing glue.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	origin := fs.String("origin", "", "Origin of the zone, for relative names before any $ORIGIN directive (defaults to the owner of the SOA record)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		problems, err := checkZoneFile(path, *origin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			code = exitError
+			continue
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", path, problem)
+		}
+		if len(problems) > 0 {
+			code = exitError
+		} else {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	return code
+}
+
+func checkZoneFile(path, origin string) ([]dnstoy.ZoneProblem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dnstoy.CheckZone(f, origin)
+}
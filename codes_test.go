@@ -0,0 +1,20 @@
+package dnstoy
+
+import (
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestCodeStrings(t *testing.T) {
+	t.Parallel()
+
+	be.Equal(t, "NOERROR", RCodeNoError.String())
+	be.Equal(t, "NXDOMAIN", RCodeNXDomain.String())
+	be.Equal(t, "REFUSED", RCodeRefused.String())
+	be.Equal(t, "RCODE9", RCode(9).String())
+
+	be.Equal(t, "QUERY", OpcodeQuery.String())
+	be.Equal(t, "NOTIFY", OpcodeNotify.String())
+	be.Equal(t, "OPCODE3", Opcode(3).String())
+}
@@ -0,0 +1,107 @@
+package dnstoy
+
+import (
+	"context"
+
+	"golang.org/x/exp/slog"
+)
+
+// delegation records a zone cut crossed during a lookup, along with the
+// secure delegation data published by the parent zone: either the child
+// zone's DS RRset or, for an unsigned child, the NSEC or NSEC3 records
+// proving that it has none.
+type delegation struct {
+	zone   string
+	parent string
+	ds     []Record // DS records and the RRSIGs covering them
+	denial []Record // NSEC/NSEC3 records and their RRSIGs, if there are no DS records
+}
+
+// fetchDelegationDS collects the DS RRset for a child zone that the given
+// referral delegated to, which is needed to extend the chain of trust from
+// the parent zone into the child. Signed parent zones include the DS RRset,
+// or proof of its absence, in referrals; otherwise the parent's name servers
+// are asked for it directly, preferring the one that sent the referral. The
+// DS RRset is cached, and failure to fetch it does not fail the lookup.
+func (r *Resolver) fetchDelegationDS(ctx context.Context, state *lookupState, nameServers []nameServerDef, referrer nameServerDef, referral Message, zone string, depth int) int {
+	d := delegation{zone: zone, parent: referrer.authority}
+	defer func() { state.delegations = append(state.delegations, d) }()
+
+	d.ds, d.denial = dsFromSection(referral.Authorities, zone)
+	if len(d.ds) > 0 {
+		if lookupOptsFrom(ctx).useCache() {
+			r.cacheAnswers(Message{Answers: d.ds})
+		}
+		return depth
+	}
+	if len(d.denial) > 0 {
+		return depth
+	}
+
+	if r.cache != nil && lookupOptsFrom(ctx).useCache() {
+		if records, found := r.cache.Get(NewCacheKey(zone, RecordTypeDS, ResourceClassIN)); found {
+			d.ds = records
+			return depth
+		}
+	}
+
+	parentNameServers := make([]nameServerDef, 0, len(nameServers))
+	parentNameServers = append(parentNameServers, referrer)
+	for _, ns := range nameServers {
+		if canonicalName(ns.name) != canonicalName(referrer.name) {
+			parentNameServers = append(parentNameServers, ns)
+		}
+	}
+	r.log(ctx).Debug(
+		"fetching DS records for delegation",
+		slog.String("zone", zone),
+		slog.String("parent", referrer.authority),
+		slog.Int("depth", depth),
+	)
+	msg, _, newDepth, err := r.queryNameServers(ctx, state, parentNameServers, zone, RecordTypeDS, depth)
+	if err != nil {
+		r.log(ctx).Debug("failed to fetch DS records", slog.String("zone", zone), slog.String("err", err.Error()))
+		return newDepth
+	}
+	d.ds, _ = dsFromSection(msg.Answers, zone)
+	if len(d.ds) == 0 {
+		_, d.denial = dsFromSection(msg.Authorities, zone)
+	}
+	return newDepth
+}
+
+// dsFromSection returns the DS records for the given zone in a section of a
+// response, with the RRSIGs covering them. If there are none, it instead
+// returns any NSEC and NSEC3 records in the section, with their RRSIGs,
+// which may prove that the zone has no DS records.
+func dsFromSection(records []Record, zone string) (ds []Record, denial []Record) {
+	for _, rec := range records {
+		if rec.Type == RecordTypeDS && canonicalName(string(rec.Name)) == canonicalName(zone) {
+			ds = append(ds, rec)
+		}
+	}
+	if len(ds) == 0 {
+		return nil, denialRecords(records)
+	}
+	return append(ds, coveringSignatures(records, zone, RecordTypeDS)...), nil
+}
+
+// denialRecords returns the NSEC and NSEC3 records in a section of a
+// response, with the RRSIGs covering them.
+func denialRecords(records []Record) []Record {
+	var denial []Record
+	for _, rec := range records {
+		if rec.Type == RecordTypeNSEC || rec.Type == RecordTypeNSEC3 {
+			denial = append(denial, rec)
+		}
+	}
+	for _, rec := range records {
+		if rec.Type != RecordTypeRRSIG {
+			continue
+		}
+		if sig, err := parseRRSIG(rec.Data); err == nil && (sig.TypeCovered == RecordTypeNSEC || sig.TypeCovered == RecordTypeNSEC3) {
+			denial = append(denial, rec)
+		}
+	}
+	return denial
+}
@@ -0,0 +1,85 @@
+package dnstoy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestLookupIPFetchesDelegationDS(t *testing.T) {
+	t.Parallel()
+
+	dsData := DS{KeyTag: 12345, Algorithm: 8, DigestType: DigestTypeSHA256, Digest: make([]byte, 32)}.Encode()
+
+	// the root delegates example.test without including its DS records in
+	// the referral, and signed.test with them
+	newServer := func() (string, func() []string) {
+		var mu sync.Mutex
+		var queries []string
+		port := startTestServer(t, func(q Message) Message {
+			mu.Lock()
+			defer mu.Unlock()
+			name := strings.ToLower(string(q.Questions[0].Name))
+			queries = append(queries, name+" "+q.Questions[0].Type.String())
+			dsRecord := Record{Name: q.Questions[0].Name, Type: RecordTypeDS, Class: ResourceClassIN, TTL: 60, Data: dsData}
+			switch {
+			case q.Questions[0].Type == RecordTypeDS:
+				return Message{Answers: []Record{dsRecord}}
+			case len(queries) == 1 && name == "www.signed.test":
+				resp := referral("signed.test", "ns1.signed.test")
+				resp.Authorities = append(resp.Authorities, Record{Name: []byte("signed.test"), Type: RecordTypeDS, Class: ResourceClassIN, TTL: 60, Data: dsData})
+				return resp
+			case len(queries) == 1:
+				return referral("example.test", "ns1.example.test")
+			}
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+			}
+		})
+		// the queries received so far, copied since the server may still be
+		// handling others
+		received := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), queries...)
+		}
+		return port, received
+	}
+
+	t.Run("fetched from parent", func(t *testing.T) {
+		t.Parallel()
+		port, queries := newServer()
+		r := newTestResolver(port, &Opts{DNSSEC: true})
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.AllEqual(t, []string{"www.example.test A", "example.test DS", "www.example.test A"}, queries())
+
+		cached, found := r.cache.Get(NewCacheKey("example.test", RecordTypeDS, ResourceClassIN))
+		be.True(t, found)
+		be.Equal(t, 1, len(cached))
+	})
+
+	t.Run("included in referral", func(t *testing.T) {
+		t.Parallel()
+		port, queries := newServer()
+		r := newTestResolver(port, &Opts{DNSSEC: true})
+		_, err := r.LookupIP(context.Background(), "ip4", "www.signed.test")
+		be.NilErr(t, err)
+		be.AllEqual(t, []string{"www.signed.test A", "www.signed.test A"}, queries())
+
+		_, found := r.cache.Get(NewCacheKey("signed.test", RecordTypeDS, ResourceClassIN))
+		be.True(t, found)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		port, queries := newServer()
+		r := newTestResolver(port, nil)
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.AllEqual(t, []string{"www.example.test A", "www.example.test A"}, queries())
+	})
+}
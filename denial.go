@@ -0,0 +1,517 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// NSEC3 holds the data of an NSEC3 record, which asserts that no names
+// exist whose hashes fall between the hash in the record's owner name and
+// NextHashedOwner, and lists the types that exist at the name whose hash is
+// in the owner name:
+// https://datatracker.ietf.org/doc/html/rfc5155#section-3
+type NSEC3 struct {
+	HashAlgorithm   uint8
+	Flags           uint8
+	Iterations      uint16
+	Salt            []byte
+	NextHashedOwner []byte
+	Types           []RecordType
+}
+
+// NSEC3 hash algorithms and flags:
+// https://datatracker.ietf.org/doc/html/rfc5155#section-11
+const (
+	nsec3HashSHA1   = 1
+	nsec3FlagOptOut = 1
+)
+
+// maxNSEC3Iterations is the largest number of additional hash iterations
+// that will be computed. Responses using more are treated as unproven, as
+// recommended by RFC 9276.
+// https://datatracker.ietf.org/doc/html/rfc9276#section-3.2
+const maxNSEC3Iterations = 150
+
+// nsec3Encoding is the base32 encoding with extended hex alphabet used in
+// NSEC3 owner names.
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// OptOut returns true if the NSEC3 record may cover unsigned delegations.
+func (n NSEC3) OptOut() bool {
+	return n.Flags&nsec3FlagOptOut != 0
+}
+
+// parseNSEC3 parses the data of an NSEC3 record.
+func parseNSEC3(data []byte) (NSEC3, error) {
+	v := byteview.New(data)
+	bs, err := v.Next(5) // 5 == hash algorithm, flags, iterations and salt length
+	if err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: %w", err)
+	}
+	n := NSEC3{
+		HashAlgorithm: bs[0],
+		Flags:         bs[1],
+		Iterations:    binary.BigEndian.Uint16(bs[2:4]),
+	}
+	if n.Salt, err = v.Next(uint16(bs[4])); err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: error reading salt: %w", err)
+	}
+	hashLen, err := v.NextByte()
+	if err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: %w", err)
+	}
+	if n.NextHashedOwner, err = v.Next(uint16(hashLen)); err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: error reading next hashed owner: %w", err)
+	}
+	bitmap, err := v.Next(uint16(v.Remaining()))
+	if err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: %w", err)
+	}
+	if n.Types, err = parseTypeBitmap(bitmap); err != nil {
+		return NSEC3{}, fmt.Errorf("parseNSEC3: %w", err)
+	}
+	return n, nil
+}
+
+// nsec3Hash computes the hash of a name with the given salt and number of
+// additional iterations:
+// https://datatracker.ietf.org/doc/html/rfc5155#section-5
+func nsec3Hash(name string, salt []byte, iterations uint16) []byte {
+	h := sha1.New()
+	h.Write(encodeName(canonicalName(name)))
+	h.Write(salt)
+	digest := h.Sum(nil)
+	for i := 0; i < int(iterations); i++ {
+		h.Reset()
+		h.Write(digest)
+		h.Write(salt)
+		digest = h.Sum(digest[:0])
+	}
+	return digest
+}
+
+func hasType(types []RecordType, t RecordType) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// denialNSEC is an NSEC record used as proof of nonexistence.
+type denialNSEC struct {
+	entry nsecEntry
+	types []RecordType
+}
+
+// denialNSEC3 is an NSEC3 record used as proof of nonexistence.
+type denialNSEC3 struct {
+	NSEC3
+	ownerHash []byte
+}
+
+// matches returns true if the record's owner is the hash of the given name.
+func (n denialNSEC3) matches(hash []byte) bool {
+	return bytes.Equal(n.ownerHash, hash)
+}
+
+// covers returns true if the record proves that no name with the given hash
+// exists.
+func (n denialNSEC3) covers(hash []byte) bool {
+	afterOwner := bytes.Compare(hash, n.ownerHash) > 0
+	beforeNext := bytes.Compare(hash, n.NextHashedOwner) < 0
+	// the last NSEC3 in a zone's hash order wraps around to the first
+	if bytes.Compare(n.NextHashedOwner, n.ownerHash) <= 0 {
+		return afterOwner || beforeNext
+	}
+	return afterOwner && beforeNext
+}
+
+// denialProof holds the NSEC and NSEC3 records in a negative response from
+// the given zone, which may prove that a name or RRset does not exist:
+// https://datatracker.ietf.org/doc/html/rfc4035#section-5.4
+// https://datatracker.ietf.org/doc/html/rfc5155#section-8
+type denialProof struct {
+	zone   string
+	nsecs  []denialNSEC
+	nsec3s []denialNSEC3
+}
+
+// newDenialProof collects the NSEC and NSEC3 records from the given records
+// that belong to the given zone.
+func newDenialProof(zone string, records []Record) (denialProof, error) {
+	p := denialProof{zone: zone}
+	for _, rec := range records {
+		owner := string(rec.Name)
+		if !isSubdomain(owner, zone) {
+			continue
+		}
+		switch rec.Type {
+		case RecordTypeNSEC:
+			nsec, err := parseNSEC(rec.Data)
+			if err != nil {
+				return p, err
+			}
+			p.nsecs = append(p.nsecs, denialNSEC{
+				entry: nsecEntry{zone: zone, owner: owner, next: nsec.NextName},
+				types: nsec.Types,
+			})
+		case RecordTypeNSEC3:
+			nsec3, err := parseNSEC3(rec.Data)
+			if err != nil {
+				return p, err
+			}
+			label, parent, _ := strings.Cut(canonicalName(owner), ".")
+			if parent != canonicalName(zone) {
+				continue
+			}
+			ownerHash, err := nsec3Encoding.DecodeString(strings.ToUpper(label))
+			if err != nil {
+				return p, fmt.Errorf("invalid NSEC3 owner name %q: %w", owner, err)
+			}
+			p.nsec3s = append(p.nsec3s, denialNSEC3{NSEC3: nsec3, ownerHash: ownerHash})
+		}
+	}
+	return p, nil
+}
+
+// verifyNXDomain checks that the proof shows that the given name does not
+// exist.
+func (p denialProof) verifyNXDomain(name string) error {
+	if len(p.nsecs) > 0 {
+		return p.verifyNXDomainNSEC(name)
+	}
+	if len(p.nsec3s) > 0 {
+		ce, _, err := p.closestEncloserNSEC3(name)
+		if err != nil {
+			return err
+		}
+		return p.verifyWildcardNSEC3(ce)
+	}
+	return fmt.Errorf("no NSEC or NSEC3 records prove that %s does not exist", name)
+}
+
+// verifyNoData checks that the proof shows that the given name has no
+// records of the given type.
+func (p denialProof) verifyNoData(name string, recordType RecordType) error {
+	if len(p.nsecs) > 0 {
+		return p.verifyNoDataNSEC(name, recordType)
+	}
+	if len(p.nsec3s) > 0 {
+		return p.verifyNoDataNSEC3(name, recordType)
+	}
+	return fmt.Errorf("no NSEC or NSEC3 records prove that %s has no %s records", name, recordType)
+}
+
+// verifyNoCloserMatch checks that the proof shows that no name closer to
+// the given name than its closest encloser ce exists, so that records for
+// the name may be synthesized from the wildcard at the closest encloser:
+// https://datatracker.ietf.org/doc/html/rfc4035#section-5.3.4
+// https://datatracker.ietf.org/doc/html/rfc5155#section-8.8
+func (p denialProof) verifyNoCloserMatch(name, ce string) error {
+	name, ce = canonicalName(name), canonicalName(ce)
+	if len(p.nsecs) > 0 {
+		covering, found := p.coveringNSEC(name)
+		if !found {
+			return fmt.Errorf("no NSEC record covers %s", name)
+		}
+		if closest := p.closestEncloserNSEC(name, covering); closest != ce {
+			return fmt.Errorf("NSEC record shows that the closest encloser of %s is %s, not %s", name, closest, ce)
+		}
+		return nil
+	}
+	if len(p.nsec3s) > 0 {
+		labels := strings.Split(name, ".")
+		nextCloser := strings.Join(labels[len(labels)-len(canonicalLabels(ce))-1:], ".")
+		hash, err := p.hash(nextCloser)
+		if err != nil {
+			return err
+		}
+		for _, nsec3 := range p.nsec3s {
+			if nsec3.covers(hash) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no NSEC3 record covers next closer name %s", nextCloser)
+	}
+	return fmt.Errorf("no NSEC or NSEC3 records prove that no name closer to %s than %s exists", name, ce)
+}
+
+func (p denialProof) verifyNXDomainNSEC(name string) error {
+	covering, found := p.coveringNSEC(name)
+	if !found {
+		return fmt.Errorf("no NSEC record covers %s", name)
+	}
+	wildcard := wildcardName(p.closestEncloserNSEC(name, covering))
+	if _, found := p.coveringNSEC(wildcard); !found {
+		return fmt.Errorf("no NSEC record covers wildcard %s", wildcard)
+	}
+	return nil
+}
+
+func (p denialProof) verifyNoDataNSEC(name string, recordType RecordType) error {
+	if nsec, found := p.matchingNSEC(name); found {
+		return checkNoDataTypes(name, recordType, nsec.types)
+	}
+	// a wildcard may match the name, but have no records of the type
+	covering, found := p.coveringNSEC(name)
+	if !found {
+		return fmt.Errorf("no NSEC record matches or covers %s", name)
+	}
+	wildcard := wildcardName(p.closestEncloserNSEC(name, covering))
+	nsec, found := p.matchingNSEC(wildcard)
+	if !found {
+		return fmt.Errorf("no NSEC record matches wildcard %s", wildcard)
+	}
+	return checkNoDataTypes(wildcard, recordType, nsec.types)
+}
+
+// checkNoDataTypes checks that the type bitmap of an NSEC or NSEC3 record
+// matching name proves that it has no records of the given type.
+func checkNoDataTypes(name string, recordType RecordType, types []RecordType) error {
+	if hasType(types, recordType) || hasType(types, RecordTypeCNAME) {
+		return fmt.Errorf("NSEC type bitmap shows that %s has %s records", name, recordType)
+	}
+	// an NSEC from the parent side of a zone cut only proves the absence
+	// of DS records, since the child zone is authoritative for the rest
+	// https://datatracker.ietf.org/doc/html/rfc6840#section-4.1
+	if recordType != RecordTypeDS && hasType(types, RecordTypeNS) && !hasType(types, RecordTypeSOA) {
+		return fmt.Errorf("NSEC record for %s is from the parent side of a zone cut", name)
+	}
+	return nil
+}
+
+func (p denialProof) matchingNSEC(name string) (denialNSEC, bool) {
+	for _, nsec := range p.nsecs {
+		if canonicalName(nsec.entry.owner) == canonicalName(name) {
+			return nsec, true
+		}
+	}
+	return denialNSEC{}, false
+}
+
+func (p denialProof) coveringNSEC(name string) (denialNSEC, bool) {
+	for _, nsec := range p.nsecs {
+		// names below a zone cut are not in this zone, so an NSEC at the
+		// cut cannot prove anything about them
+		// https://datatracker.ietf.org/doc/html/rfc6840#section-4.1
+		delegation := hasType(nsec.types, RecordTypeNS) && !hasType(nsec.types, RecordTypeSOA)
+		if delegation && isSubdomain(name, nsec.entry.owner) {
+			continue
+		}
+		if nsec.entry.covers(name) {
+			return nsec, true
+		}
+	}
+	return denialNSEC{}, false
+}
+
+// closestEncloserNSEC returns the closest encloser of a name covered by the
+// given NSEC record, which is the longest existing ancestor of the name.
+func (p denialProof) closestEncloserNSEC(name string, covering denialNSEC) string {
+	ce := commonAncestor(name, covering.entry.owner)
+	if other := commonAncestor(name, covering.entry.next); len(other) > len(ce) {
+		ce = other
+	}
+	if !isSubdomain(ce, p.zone) {
+		ce = canonicalName(p.zone)
+	}
+	return ce
+}
+
+func (p denialProof) verifyNoDataNSEC3(name string, recordType RecordType) error {
+	hash, err := p.hash(name)
+	if err != nil {
+		return err
+	}
+	for _, nsec3 := range p.nsec3s {
+		if nsec3.matches(hash) {
+			return checkNoDataTypes(name, recordType, nsec3.Types)
+		}
+	}
+	// with no matching NSEC3, the name may be covered by an opt-out NSEC3,
+	// which proves that it is an unsigned delegation with no DS records
+	// https://datatracker.ietf.org/doc/html/rfc5155#section-8.6
+	ce, nextCloser, err := p.closestEncloserNSEC3(name)
+	if err != nil {
+		return err
+	}
+	if recordType == RecordTypeDS && nextCloser.OptOut() {
+		return nil
+	}
+	// otherwise, a wildcard at the closest encloser may match the name, but
+	// have no records of the type
+	// https://datatracker.ietf.org/doc/html/rfc5155#section-8.7
+	wildcard := wildcardName(ce)
+	wildcardHash, err := p.hash(wildcard)
+	if err != nil {
+		return err
+	}
+	for _, nsec3 := range p.nsec3s {
+		if nsec3.matches(wildcardHash) {
+			return checkNoDataTypes(wildcard, recordType, nsec3.Types)
+		}
+	}
+	return fmt.Errorf("no NSEC3 record matches %s or wildcard %s", name, wildcard)
+}
+
+// closestEncloserNSEC3 finds the closest encloser of a name: its longest
+// ancestor whose hash matches an NSEC3 record, where the hash of the next
+// closer name, one label longer, is covered by another. It returns the
+// closest encloser and the NSEC3 record covering the next closer name:
+// https://datatracker.ietf.org/doc/html/rfc5155#section-8.3
+func (p denialProof) closestEncloserNSEC3(name string) (string, denialNSEC3, error) {
+	name = canonicalName(name)
+	nextCloser := name
+	for candidate := name; isSubdomain(candidate, p.zone); {
+		hash, err := p.hash(candidate)
+		if err != nil {
+			return "", denialNSEC3{}, err
+		}
+		for _, nsec3 := range p.nsec3s {
+			if !nsec3.matches(hash) {
+				continue
+			}
+			if candidate == nextCloser {
+				return "", denialNSEC3{}, fmt.Errorf("NSEC3 record shows that %s exists", name)
+			}
+			nextCloserHash, err := p.hash(nextCloser)
+			if err != nil {
+				return "", denialNSEC3{}, err
+			}
+			for _, covering := range p.nsec3s {
+				if covering.covers(nextCloserHash) {
+					return candidate, covering, nil
+				}
+			}
+			return "", denialNSEC3{}, fmt.Errorf("no NSEC3 record covers next closer name %s", nextCloser)
+		}
+		if candidate == "" {
+			break
+		}
+		nextCloser = candidate
+		_, candidate, _ = strings.Cut(candidate, ".")
+	}
+	return "", denialNSEC3{}, fmt.Errorf("no NSEC3 record proves the closest encloser of %s", name)
+}
+
+// verifyWildcardNSEC3 checks that no wildcard exists at the given closest
+// encloser.
+func (p denialProof) verifyWildcardNSEC3(ce string) error {
+	wildcard := wildcardName(ce)
+	hash, err := p.hash(wildcard)
+	if err != nil {
+		return err
+	}
+	for _, nsec3 := range p.nsec3s {
+		if nsec3.covers(hash) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no NSEC3 record covers wildcard %s", wildcard)
+}
+
+// hash computes the NSEC3 hash of a name using the parameters of the proof's
+// NSEC3 records, which must all agree.
+func (p denialProof) hash(name string) ([]byte, error) {
+	params := p.nsec3s[0].NSEC3
+	for _, nsec3 := range p.nsec3s[1:] {
+		if nsec3.HashAlgorithm != params.HashAlgorithm || nsec3.Iterations != params.Iterations || !bytes.Equal(nsec3.Salt, params.Salt) {
+			return nil, fmt.Errorf("NSEC3 records have inconsistent parameters")
+		}
+	}
+	if params.HashAlgorithm != nsec3HashSHA1 {
+		return nil, fmt.Errorf("unsupported NSEC3 hash algorithm %d", params.HashAlgorithm)
+	}
+	if params.Iterations > maxNSEC3Iterations {
+		return nil, fmt.Errorf("too many NSEC3 iterations (%d > %d)", params.Iterations, maxNSEC3Iterations)
+	}
+	return nsec3Hash(name, params.Salt, params.Iterations), nil
+}
+
+// wildcardName returns the wildcard name at the given closest encloser.
+func wildcardName(ce string) string {
+	if ce == "" {
+		return "*"
+	}
+	return "*." + ce
+}
+
+// verifyDenial checks that a negative response from a signed zone proves
+// that the name (if nxdomain) or the RRset does not exist, with NSEC or NSEC3
+// records signed by the zone's validated keys, returning an ErrBogus error
+// if not. Zones are considered signed if they have a trust anchor, if the
+// parent zone published DS records for them, or if the response carries
+// signatures.
+func (r *Resolver) verifyDenial(ctx context.Context, state *lookupState, nameServer nameServerDef, msg Message, domainName string, recordType RecordType, nxdomain bool) error {
+	zone := nameServer.authority
+	if nameServer.recursive || !r.isSignedZone(state, zone, msg) {
+		return nil
+	}
+	proof, err := newDenialProof(zone, msg.Authorities)
+	if err == nil {
+		if nxdomain {
+			err = proof.verifyNXDomain(domainName)
+		} else {
+			err = proof.verifyNoData(domainName, recordType)
+		}
+	}
+	if err == nil {
+		err = r.verifyDenialSignatures(ctx, state, zone, msg)
+	}
+	if err != nil {
+		return fmt.Errorf("lookup %s %s from %s: %w: %s", domainName, recordType, nameServer.name, ErrBogus, err)
+	}
+	r.log(ctx).Debug(
+		"verified denial of existence",
+		slog.String("query_name", domainName),
+		slog.String("resource_type", recordType.String()),
+		slog.Bool("nxdomain", nxdomain),
+	)
+	return nil
+}
+
+// verifyDenialSignatures checks the signatures over the NSEC and NSEC3
+// records in a negative response from the given zone. Zones that turn out to
+// be provably unsigned need no signatures.
+func (r *Resolver) verifyDenialSignatures(ctx context.Context, state *lookupState, zone string, msg Message) error {
+	v, found := ctx.Value(validatorKey{}).(*validator)
+	if !found {
+		v = r.newValidator(state)
+	}
+	zk := v.zoneKeys(ctx, zone, 0)
+	switch zk.status {
+	case SecuritySecure:
+		return v.verifyDenialRecords(zone, zk.keys, denialRecords(msg.Authorities))
+	case SecurityInsecure:
+		return nil
+	default:
+		return fmt.Errorf("cannot verify the signatures over the proof: %w", zk.err)
+	}
+}
+
+// isSignedZone returns true if the given zone is known to be signed.
+func (r *Resolver) isSignedZone(state *lookupState, zone string, msg Message) bool {
+	for _, anchor := range r.currentTrustAnchors() {
+		if canonicalName(anchor.Zone) == canonicalName(zone) {
+			return true
+		}
+	}
+	for _, d := range state.delegations {
+		if canonicalName(d.zone) == canonicalName(zone) && len(d.ds) > 0 {
+			return true
+		}
+	}
+	_, found := matchRecord(msg.Authorities, RecordTypeRRSIG)
+	return found
+}
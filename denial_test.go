@@ -0,0 +1,276 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// encodeTypeBitmap encodes types as an NSEC or NSEC3 type bitmap, assuming
+// they are all in the first window.
+func encodeTypeBitmap(types ...RecordType) []byte {
+	bitmap := make([]byte, 32)
+	for _, t := range types {
+		bitmap[t/8] |= 0x80 >> (t % 8)
+	}
+	n := len(bitmap)
+	for n > 0 && bitmap[n-1] == 0 {
+		n--
+	}
+	return append([]byte{0, byte(n)}, bitmap[:n]...)
+}
+
+func nsecRecord(owner, next string, types ...RecordType) Record {
+	return Record{
+		Name:  []byte(owner),
+		Type:  RecordTypeNSEC,
+		Class: ResourceClassIN,
+		TTL:   60,
+		Data:  append(encodeName(next), encodeTypeBitmap(types...)...),
+	}
+}
+
+// nsec3Chain returns the complete chain of NSEC3 records for a zone
+// containing the given names and their types.
+func nsec3Chain(zone string, flags uint8, salt []byte, iterations uint16, names map[string][]RecordType) []Record {
+	type hashed struct {
+		hash  []byte
+		types []RecordType
+	}
+	var entries []hashed
+	for name, types := range names {
+		entries = append(entries, hashed{nsec3Hash(name, salt, iterations), types})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].hash, entries[j].hash) < 0 })
+
+	records := make([]Record, 0, len(entries))
+	for i, entry := range entries {
+		next := entries[(i+1)%len(entries)].hash
+		data := []byte{nsec3HashSHA1, flags}
+		data = binary.BigEndian.AppendUint16(data, iterations)
+		data = append(data, byte(len(salt)))
+		data = append(data, salt...)
+		data = append(data, byte(len(next)))
+		data = append(data, next...)
+		data = append(data, encodeTypeBitmap(entry.types...)...)
+		records = append(records, Record{
+			Name:  []byte(strings.ToLower(nsec3Encoding.EncodeToString(entry.hash)) + "." + zone),
+			Type:  RecordTypeNSEC3,
+			Class: ResourceClassIN,
+			TTL:   60,
+			Data:  data,
+		})
+	}
+	return records
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	t.Parallel()
+
+	// example from the RFC
+	// https://datatracker.ietf.org/doc/html/rfc5155#appendix-A
+	salt, _ := hex.DecodeString("aabbccdd")
+	got := nsec3Hash("Example", salt, 12)
+	be.Equal(t, "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom", strings.ToLower(nsec3Encoding.EncodeToString(got)))
+}
+
+func TestDenialProofNSEC(t *testing.T) {
+	t.Parallel()
+
+	// zone example.com contains example.com, b.example.com and a delegation
+	// to d.example.com
+	records := []Record{
+		nsecRecord("example.com", "b.example.com", RecordTypeSOA, RecordTypeNS, RecordTypeRRSIG, RecordTypeNSEC),
+		nsecRecord("b.example.com", "d.example.com", RecordTypeA, RecordTypeRRSIG, RecordTypeNSEC),
+		nsecRecord("d.example.com", "example.com", RecordTypeNS, RecordTypeNSEC),
+		nsecRecord("other.org", "z.other.org", RecordTypeA),
+	}
+	proof, err := newDenialProof("example.com", records)
+	be.NilErr(t, err)
+	be.Equal(t, 3, len(proof.nsecs))
+
+	testCases := map[string]struct {
+		name     string
+		typ      RecordType
+		nxdomain bool
+		wildcard string // the closest encloser of a wildcard answer
+		wantErr  string
+	}{
+		"nxdomain":                {name: "c.example.com", nxdomain: true},
+		"nxdomain beyond last":    {name: "e.example.com", nxdomain: true},
+		"nxdomain for name":       {name: "b.example.com", nxdomain: true, wantErr: "no NSEC record covers b.example.com"},
+		"nxdomain below cut":      {name: "x.d.example.com", nxdomain: true, wantErr: "no NSEC record covers x.d.example.com"},
+		"nodata":                  {name: "B.Example.Com", typ: RecordTypeAAAA},
+		"nodata for type":         {name: "b.example.com", typ: RecordTypeA, wantErr: "has A records"},
+		"nodata for DS at cut":    {name: "d.example.com", typ: RecordTypeDS},
+		"nodata from parent side": {name: "d.example.com", typ: RecordTypeA, wantErr: "parent side of a zone cut"},
+		"nodata without wildcard": {name: "c.example.com", typ: RecordTypeA, wantErr: "no NSEC record matches wildcard *.example.com"},
+		"wildcard answer":         {name: "x.c.example.com", wildcard: "example.com"},
+		"wildcard for name":       {name: "b.example.com", wildcard: "example.com", wantErr: "no NSEC record covers b.example.com"},
+		"wildcard below a name":   {name: "x.b.example.com", wildcard: "example.com", wantErr: "closest encloser of x.b.example.com is b.example.com"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var err error
+			switch {
+			case tc.nxdomain:
+				err = proof.verifyNXDomain(tc.name)
+			case tc.wildcard != "":
+				err = proof.verifyNoCloserMatch(tc.name, tc.wildcard)
+			default:
+				err = proof.verifyNoData(tc.name, tc.typ)
+			}
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+		})
+	}
+}
+
+func TestDenialProofNSEC3(t *testing.T) {
+	t.Parallel()
+
+	salt := []byte{0xaa, 0xbb}
+	names := map[string][]RecordType{
+		"example.com":   {RecordTypeSOA, RecordTypeNS, RecordTypeRRSIG, RecordTypeNSEC3PARAM},
+		"b.example.com": {RecordTypeA, RecordTypeRRSIG},
+		"d.example.com": {RecordTypeNS, RecordTypeDS},
+	}
+
+	testCases := map[string]struct {
+		flags      uint8
+		iterations uint16
+		name       string
+		typ        RecordType
+		nxdomain   bool
+		wildcard   string // the closest encloser of a wildcard answer
+		wantErr    string
+	}{
+		"nxdomain":                 {name: "c.example.com", nxdomain: true},
+		"nxdomain below name":      {name: "x.c.example.com", nxdomain: true},
+		"nxdomain for name":        {name: "b.example.com", nxdomain: true, wantErr: "shows that b.example.com exists"},
+		"nodata":                   {name: "b.example.com", typ: RecordTypeAAAA},
+		"nodata for type":          {name: "b.example.com", typ: RecordTypeA, wantErr: "has A records"},
+		"nodata from parent side":  {name: "d.example.com", typ: RecordTypeA, wantErr: "parent side of a zone cut"},
+		"opt-out DS":               {flags: nsec3FlagOptOut, name: "u.example.com", typ: RecordTypeDS},
+		"DS without opt-out":       {name: "u.example.com", typ: RecordTypeDS, wantErr: "no NSEC3 record matches"},
+		"opt-out only proves DS":   {flags: nsec3FlagOptOut, name: "u.example.com", typ: RecordTypeA, wantErr: "no NSEC3 record matches"},
+		"iterations at limit":      {iterations: maxNSEC3Iterations, name: "c.example.com", nxdomain: true},
+		"iterations above limit":   {iterations: maxNSEC3Iterations + 1, name: "c.example.com", nxdomain: true, wantErr: "too many NSEC3 iterations"},
+		"nodata above limit":       {iterations: maxNSEC3Iterations + 1, name: "b.example.com", typ: RecordTypeAAAA, wantErr: "too many NSEC3 iterations"},
+		"name outside of the zone": {name: "c.example.org", nxdomain: true, wantErr: "no NSEC3 record proves the closest encloser"},
+		"wildcard answer":          {name: "x.c.example.com", wildcard: "example.com"},
+		"wildcard below a name":    {name: "x.b.example.com", wildcard: "example.com", wantErr: "no NSEC3 record covers next closer name b.example.com"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			proof, err := newDenialProof("example.com", nsec3Chain("example.com", tc.flags, salt, tc.iterations, names))
+			be.NilErr(t, err)
+			be.Equal(t, 3, len(proof.nsec3s))
+			switch {
+			case tc.nxdomain:
+				err = proof.verifyNXDomain(tc.name)
+			case tc.wildcard != "":
+				err = proof.verifyNoCloserMatch(tc.name, tc.wildcard)
+			default:
+				err = proof.verifyNoData(tc.name, tc.typ)
+			}
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+		})
+	}
+}
+
+func TestDenialProofNSEC3InconsistentParameters(t *testing.T) {
+	t.Parallel()
+
+	names := map[string][]RecordType{"example.com": {RecordTypeSOA}}
+	records := append(
+		nsec3Chain("example.com", 0, []byte{1}, 0, names),
+		nsec3Chain("example.com", 0, []byte{2}, 0, map[string][]RecordType{"b.example.com": {RecordTypeA}})...,
+	)
+	proof, err := newDenialProof("example.com", records)
+	be.NilErr(t, err)
+	err = proof.verifyNXDomain("c.example.com")
+	be.Nonzero(t, err)
+	be.In(t, "inconsistent parameters", err.Error())
+}
+
+func TestLookupIPVerifiesDenial(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rootKey := newTestZoneKey(t, ".")
+	otherKey := newTestZoneKey(t, ".")
+	anchor, err := rootKey.key.ToDS(".", DigestTypeSHA256)
+	be.NilErr(t, err)
+	signed := func(key testZoneKey, rrset ...Record) []Record {
+		return append(rrset, key.sign(t, rrset, now.Add(-time.Hour), now.Add(time.Hour)))
+	}
+
+	// the root zone contains only test, so missing does not exist
+	apexNSEC := nsecRecord(".", "test", RecordTypeSOA, RecordTypeNS, RecordTypeNSEC)
+	testNSEC := nsecRecord("test", ".", RecordTypeNS, RecordTypeDS, RecordTypeNSEC)
+	testCases := map[string]struct {
+		authorities []Record
+		anchors     []TrustAnchor
+		wantBogus   bool
+	}{
+		"proven": {
+			authorities: append(signed(rootKey, apexNSEC), signed(rootKey, testNSEC)...),
+		},
+		"unproven in signed zone": {
+			authorities: signed(rootKey, testNSEC),
+			wantBogus:   true,
+		},
+		"unsigned proof": {
+			authorities: []Record{apexNSEC, testNSEC},
+			wantBogus:   true,
+		},
+		"signed by the wrong key": {
+			authorities: append(signed(rootKey, apexNSEC), signed(otherKey, testNSEC)...),
+			wantBogus:   true,
+		},
+		"unsigned zone": {
+			anchors: []TrustAnchor{{Zone: "example", DS: anchor}},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			port := startTestServer(t, func(q Message) Message {
+				if canonicalName(string(q.Questions[0].Name)) == "" && q.Questions[0].Type == RecordTypeDNSKEY {
+					return Message{Answers: signed(rootKey, rootKey.record())}
+				}
+				return Message{Header: Header{Flags: uint16(RCodeNXDomain)}, Authorities: tc.authorities}
+			})
+			if tc.anchors == nil {
+				tc.anchors = []TrustAnchor{{Zone: ".", DS: anchor}}
+			}
+			r := newTestResolver(port, &Opts{DNSSEC: true, TrustAnchors: tc.anchors})
+			_, err := r.LookupIP(context.Background(), "ip4", "missing")
+			be.True(t, errors.Is(err, ErrNXDomain) != tc.wantBogus)
+			be.Equal(t, tc.wantBogus, errors.Is(err, ErrBogus))
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package dnstoy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// RRSIG holds the data of an RRSIG record:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-3.1
+type RRSIG struct {
+	TypeCovered RecordType
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// parseRRSIG parses the data of an RRSIG record.
+func parseRRSIG(data []byte) (RRSIG, error) {
+	v := byteview.New(data)
+	bs, err := v.Next(18) // 18 == size of the fixed-length fields preceding the signer name
+	if err != nil {
+		return RRSIG{}, fmt.Errorf("parseRRSIG: %w", err)
+	}
+	signer, err := decodeName(v)
+	if err != nil {
+		return RRSIG{}, fmt.Errorf("parseRRSIG: error decoding signer name: %w", err)
+	}
+	signature, err := v.Next(uint16(v.Remaining()))
+	if err != nil {
+		return RRSIG{}, fmt.Errorf("parseRRSIG: error reading signature: %w", err)
+	}
+	return RRSIG{
+		TypeCovered: RecordType(binary.BigEndian.Uint16(bs[0:2])),
+		Algorithm:   bs[2],
+		Labels:      bs[3],
+		OriginalTTL: binary.BigEndian.Uint32(bs[4:8]),
+		Expiration:  binary.BigEndian.Uint32(bs[8:12]),
+		Inception:   binary.BigEndian.Uint32(bs[12:16]),
+		KeyTag:      binary.BigEndian.Uint16(bs[16:18]),
+		SignerName:  string(signer),
+		Signature:   signature,
+	}, nil
+}
+
+// Encode encodes the RRSIG as record data in network order.
+func (s RRSIG) Encode() []byte {
+	signer := encodeName(s.SignerName)
+	out := make([]byte, 0, 18+len(signer)+len(s.Signature))
+	out = binary.BigEndian.AppendUint16(out, uint16(s.TypeCovered))
+	out = append(out, s.Algorithm, s.Labels)
+	out = binary.BigEndian.AppendUint32(out, s.OriginalTTL)
+	out = binary.BigEndian.AppendUint32(out, s.Expiration)
+	out = binary.BigEndian.AppendUint32(out, s.Inception)
+	out = binary.BigEndian.AppendUint16(out, s.KeyTag)
+	out = append(out, signer...)
+	out = append(out, s.Signature...)
+	return out
+}
+
+// ValidAt returns true if the signature's validity period includes the given
+// time. The inception and expiration times are compared using serial number
+// arithmetic, so that they wrap around in 2106:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-3.1.5
+func (s RRSIG) ValidAt(t time.Time) bool {
+	now := uint32(t.Unix())
+	return int32(now-s.Inception) >= 0 && int32(s.Expiration-now) >= 0
+}
+
+// signedData returns the data covered by the signature over the given RRset,
+// which is the signature's record data, less the signature itself, followed
+// by the records in canonical form and order:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-3.1.8.1
+func (s RRSIG) signedData(rrset []Record) []byte {
+	sig := s
+	sig.SignerName = canonicalName(s.SignerName)
+	sig.Signature = nil
+	out := sig.Encode()
+	if len(rrset) == 0 {
+		return out
+	}
+
+	// the owner of a record synthesized from a wildcard is the wildcard
+	// itself, identified by the signature covering fewer labels than the
+	// owner has
+	owner := canonicalName(string(rrset[0].Name))
+	labels := strings.Split(owner, ".")
+	if owner != "" && int(s.Labels) < len(labels) {
+		owner = strings.Join(append([]string{"*"}, labels[len(labels)-int(s.Labels):]...), ".")
+	}
+	ownerName := encodeName(owner)
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, rec := range rrset {
+		rdatas = append(rdatas, canonicalRecordData(rec))
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+	for i, rdata := range rdatas {
+		// duplicate records are removed from RRsets
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		out = append(out, ownerName...)
+		out = binary.BigEndian.AppendUint16(out, uint16(rrset[0].Type))
+		out = binary.BigEndian.AppendUint16(out, uint16(rrset[0].Class))
+		out = binary.BigEndian.AppendUint32(out, s.OriginalTTL)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+		out = append(out, rdata...)
+	}
+	return out
+}
+
+// rrsigLabels returns the number of labels in a name as counted by the
+// Labels field of RRSIG records, which excludes the root and any leading
+// wildcard label:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-3.1.3
+func rrsigLabels(name string) int {
+	labels := canonicalLabels(name)
+	if len(labels) > 0 && string(labels[len(labels)-1]) == "*" {
+		return len(labels) - 1
+	}
+	return len(labels)
+}
+
+// canonicalRecordData returns a record's data in canonical form, with any
+// names it holds lowercased and uncompressed:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-6.2
+func canonicalRecordData(rec Record) []byte {
+	switch rec.Type {
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		// names in these records' data are stored in decoded form
+		return encodeName(canonicalName(string(rec.Data)))
+	case RecordTypeMX:
+		// the others' are stored uncompressed, in wire format
+		return lowercaseWireNames(rec.Data, 2, 1) // after the preference
+	case RecordTypeSRV:
+		return lowercaseWireNames(rec.Data, 6, 1) // after the priority, weight and port
+	case RecordTypeSOA:
+		return lowercaseWireNames(rec.Data, 0, 2) // the primary name server and responsible mailbox
+	default:
+		return rec.Data
+	}
+}
+
+// lowercaseWireNames returns a copy of data with the given number of
+// consecutive uncompressed wire format names, starting at offset,
+// lowercased.
+func lowercaseWireNames(data []byte, offset, count int) []byte {
+	out := append([]byte(nil), data...)
+	for i := offset; count > 0 && i < len(out); count-- {
+		for i < len(out) && out[i] != 0 {
+			end := i + 1 + int(out[i])
+			for i++; i < end && i < len(out); i++ {
+				if c := out[i]; 'A' <= c && c <= 'Z' {
+					out[i] = c + 'a' - 'A'
+				}
+			}
+		}
+		i++ // the root label
+	}
+	return out
+}
+
+// Verify checks that the signature over the given RRset was made by the given
+// key, using the algorithms in DefaultAlgorithms. It does not check the
+// signature's validity period; see ValidAt.
+func (s RRSIG) Verify(key DNSKEY, rrset []Record) error {
+	return s.verify(defaultAlgorithms, key, rrset)
+}
+
+func (s RRSIG) verify(algorithms Algorithms, key DNSKEY, rrset []Record) error {
+	if key.Algorithm != s.Algorithm || key.KeyTag() != s.KeyTag {
+		return fmt.Errorf("signature was not made by key %d", key.KeyTag())
+	}
+	verifier, found := algorithms[s.Algorithm]
+	if !found {
+		return fmt.Errorf("%w %d", ErrUnsupportedAlgorithm, s.Algorithm)
+	}
+	return verifier(key.PublicKey, s.signedData(rrset), s.Signature)
+}
+
+// DNSKEY flag bits:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-2.1.1
+// https://datatracker.ietf.org/doc/html/rfc5011#section-7
+const (
+	DNSKEYFlagZone   = 1 << 8
+	DNSKEYFlagRevoke = 1 << 7
+	DNSKEYFlagSEP    = 1
+)
+
+// DNSKEY holds the data of a DNSKEY record:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-2.1
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// parseDNSKEY parses the data of a DNSKEY record.
+func parseDNSKEY(data []byte) (DNSKEY, error) {
+	if len(data) < 4 {
+		return DNSKEY{}, fmt.Errorf("parseDNSKEY: record data too short (%d bytes)", len(data))
+	}
+	return DNSKEY{
+		Flags:     binary.BigEndian.Uint16(data[0:2]),
+		Protocol:  data[2],
+		Algorithm: data[3],
+		PublicKey: data[4:],
+	}, nil
+}
+
+// Encode encodes the DNSKEY as record data in network order.
+func (k DNSKEY) Encode() []byte {
+	out := make([]byte, 0, 4+len(k.PublicKey))
+	out = binary.BigEndian.AppendUint16(out, k.Flags)
+	out = append(out, k.Protocol, k.Algorithm)
+	out = append(out, k.PublicKey...)
+	return out
+}
+
+// IsSEP returns true if the key is marked as a secure entry point, i.e. a key
+// signing key.
+func (k DNSKEY) IsSEP() bool {
+	return k.Flags&DNSKEYFlagSEP != 0
+}
+
+// IsRevoked returns true if the key has been revoked, as described in RFC
+// 5011.
+func (k DNSKEY) IsRevoked() bool {
+	return k.Flags&DNSKEYFlagRevoke != 0
+}
+
+// KeyTag computes the key's tag, which identifies the key in RRSIG and DS
+// records:
+// https://datatracker.ietf.org/doc/html/rfc4034#appendix-B
+func (k DNSKEY) KeyTag() uint16 {
+	var sum uint32
+	for i, b := range k.Encode() {
+		if i&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xffff
+	return uint16(sum & 0xffff)
+}
+
+// DS digest types:
+// https://www.iana.org/assignments/ds-rr-types/ds-rr-types.xhtml
+const (
+	DigestTypeSHA1   = 1
+	DigestTypeSHA256 = 2
+	DigestTypeSHA384 = 4
+)
+
+// ToDS returns a DS record referring to the key, which is owned by the given
+// zone, using the given digest type:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-5.1.4
+func (k DNSKEY) ToDS(zone string, digestType uint8) (DS, error) {
+	var h hash.Hash
+	switch digestType {
+	case DigestTypeSHA1:
+		h = sha1.New()
+	case DigestTypeSHA256:
+		h = sha256.New()
+	case DigestTypeSHA384:
+		h = sha512.New384()
+	default:
+		return DS{}, fmt.Errorf("unsupported DS digest type %d", digestType)
+	}
+	h.Write(encodeName(canonicalName(zone)))
+	h.Write(k.Encode())
+	return DS{
+		KeyTag:     k.KeyTag(),
+		Algorithm:  k.Algorithm,
+		DigestType: digestType,
+		Digest:     h.Sum(nil),
+	}, nil
+}
+
+// DS holds the data of a DS record, which identifies a child zone's key
+// signing key in the parent zone:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-5.1
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// parseDS parses the data of a DS record.
+func parseDS(data []byte) (DS, error) {
+	if len(data) < 4 {
+		return DS{}, fmt.Errorf("parseDS: record data too short (%d bytes)", len(data))
+	}
+	return DS{
+		KeyTag:     binary.BigEndian.Uint16(data[0:2]),
+		Algorithm:  data[2],
+		DigestType: data[3],
+		Digest:     data[4:],
+	}, nil
+}
+
+// Encode encodes the DS as record data in network order.
+func (ds DS) Encode() []byte {
+	out := make([]byte, 0, 4+len(ds.Digest))
+	out = binary.BigEndian.AppendUint16(out, ds.KeyTag)
+	out = append(out, ds.Algorithm, ds.DigestType)
+	out = append(out, ds.Digest...)
+	return out
+}
+
+// Matches returns true if the DS record refers to the given key, owned by
+// the given zone.
+func (ds DS) Matches(zone string, key DNSKEY) bool {
+	if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+		return false
+	}
+	computed, err := key.ToDS(zone, ds.DigestType)
+	return err == nil && bytes.Equal(computed.Digest, ds.Digest)
+}
@@ -0,0 +1,150 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"unicode"
+)
+
+// EDNS(0) option codes:
+// https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-11
+const (
+	EDNSOptionNSID uint16 = 3
+)
+
+// ednsUDPSize is the UDP payload size we advertise in OPT records, following
+// the DNS Flag Day 2020 recommendation.
+// https://www.dnsflagday.net/2020/
+const ednsUDPSize = 1232
+
+// EDNSOption is a single option carried in the data of an OPT pseudo-record:
+// https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.2
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// AddEDNS adds an OPT pseudo-record to the query's additional section,
+// advertising the given UDP payload size and carrying the given options.
+func (q *Query) AddEDNS(udpSize uint16, options ...EDNSOption) {
+	q.Additionals = append(q.Additionals, newOPTRecord(udpSize, options...))
+}
+
+// maxResponseSize returns the largest UDP response the query allows for,
+// which is the payload size advertised in its OPT record, if any.
+func (q Query) maxResponseSize() int {
+	if opt, found := matchRecord(q.Additionals, RecordTypeOPT); found && int(opt.Class) > maxMessageSize {
+		return int(opt.Class)
+	}
+	return maxMessageSize
+}
+
+// ednsFlagDO is the DNSSEC OK flag, carried in the TTL field of an OPT
+// record:
+// https://datatracker.ietf.org/doc/html/rfc3225#section-3
+const ednsFlagDO = 1 << 15
+
+// DO returns the DNSSEC OK flag from the query's OPT record, if any.
+func (q Query) DO() bool {
+	opt, found := matchRecord(q.Additionals, RecordTypeOPT)
+	return found && opt.TTL&ednsFlagDO != 0
+}
+
+// SetDO sets or clears the DNSSEC OK flag, which asks name servers to include
+// DNSSEC records in their responses. Because the flag is carried in the OPT
+// record, one is added if necessary.
+func (q *Query) SetDO(on bool) {
+	if _, found := matchRecord(q.Additionals, RecordTypeOPT); !found {
+		if !on {
+			return
+		}
+		q.AddEDNS(ednsUDPSize)
+	}
+	for i := range q.Additionals {
+		if q.Additionals[i].Type != RecordTypeOPT {
+			continue
+		}
+		if on {
+			q.Additionals[i].TTL |= ednsFlagDO
+		} else {
+			q.Additionals[i].TTL &^= ednsFlagDO
+		}
+	}
+}
+
+// DO returns the DNSSEC OK flag from the message's OPT record, if any, which
+// a name server echoes if it supports DNSSEC.
+func (m Message) DO() bool {
+	opt, found := matchRecord(m.Additionals, RecordTypeOPT)
+	return found && opt.TTL&ednsFlagDO != 0
+}
+
+// newOPTRecord creates an OPT pseudo-record, which reuses the CLASS field for
+// the requestor's UDP payload size and the TTL field for extended flags:
+// https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.2
+func newOPTRecord(udpSize uint16, options ...EDNSOption) Record {
+	var data []byte
+	for _, opt := range options {
+		data = binary.BigEndian.AppendUint16(data, opt.Code)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(opt.Data)))
+		data = append(data, opt.Data...)
+	}
+	return Record{
+		Name:  []byte{},
+		Type:  RecordTypeOPT,
+		Class: ResourceClass(udpSize),
+		Data:  data,
+	}
+}
+
+// parseEDNSOptions parses the options from the data of an OPT record.
+func parseEDNSOptions(data []byte) ([]EDNSOption, error) {
+	var options []EDNSOption
+	for len(data) > 0 {
+		if len(data) < 4 { // 4 == 2 bytes each for option code and length
+			return nil, fmt.Errorf("parseEDNSOptions: truncated option header: %q", data)
+		}
+		code := binary.BigEndian.Uint16(data[0:2])
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+size {
+			return nil, fmt.Errorf("parseEDNSOptions: truncated option data for code %d", code)
+		}
+		options = append(options, EDNSOption{Code: code, Data: data[4 : 4+size]})
+		data = data[4+size:]
+	}
+	return options, nil
+}
+
+// EDNSOptions returns the options carried in the message's OPT record, if
+// any.
+func (m Message) EDNSOptions() ([]EDNSOption, error) {
+	opt, found := matchRecord(m.Additionals, RecordTypeOPT)
+	if !found {
+		return nil, nil
+	}
+	return parseEDNSOptions(opt.Data)
+}
+
+// NSID returns the name server identifier included in the response, if the
+// server provided one. Printable identifiers are returned as-is, others are
+// hex encoded.
+// https://datatracker.ietf.org/doc/html/rfc5001
+func (m Message) NSID() (string, bool) {
+	options, err := m.EDNSOptions()
+	if err != nil {
+		return "", false
+	}
+	for _, opt := range options {
+		if opt.Code != EDNSOptionNSID {
+			continue
+		}
+		for _, b := range opt.Data {
+			if b > unicode.MaxASCII || !unicode.IsPrint(rune(b)) {
+				return hex.EncodeToString(opt.Data), true
+			}
+		}
+		return string(opt.Data), true
+	}
+	return "", false
+}
@@ -0,0 +1,68 @@
+package dnstoy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when a name server responds with a non-zero RCODE. They
+// are wrapped with additional context, so use errors.Is to check for them.
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.1
+var (
+	ErrFormErr  = errors.New("name server was unable to interpret the query (FORMERR)")
+	ErrServFail = errors.New("name server failure (SERVFAIL)")
+	ErrNXDomain = errors.New("name does not exist (NXDOMAIN)")
+	ErrNotImp   = errors.New("name server does not support the query (NOTIMP)")
+	ErrRefused  = errors.New("name server refused the query (REFUSED)")
+)
+
+// Errors returned when a lookup cannot make progress.
+var (
+	ErrMaxDepth          = errors.New("maximum lookup depth exceeded")
+	ErrLookupLoop        = errors.New("delegation loop detected")
+	ErrResolutionTimeout = errors.New("resolution timeout exceeded")
+
+	ErrCNAMELoop         = errors.New("CNAME loop detected")
+	ErrCNAMEChainTooLong = errors.New("maximum CNAME chain length exceeded")
+)
+
+// ErrNoData is returned when a name exists but has no records of the
+// requested type.
+var ErrNoData = errors.New("no records of the requested type")
+
+// ErrBogus is returned when DNSSEC data that should be present and valid is
+// missing or invalid, e.g. a negative response from a signed zone that does
+// not prove the nonexistence of the name.
+var ErrBogus = errors.New("DNSSEC validation failed")
+
+// ErrInvalidName is returned when looking up a name that cannot be encoded
+// in a query.
+var ErrInvalidName = errors.New("invalid domain name")
+
+// ErrClosed is returned by lookups on a Resolver that has been closed.
+var ErrClosed = errors.New("resolver closed")
+
+// ErrMismatchedResponse is returned when a response does not match the query
+// it is supposedly answering, which may indicate a spoofing attempt.
+var ErrMismatchedResponse = errors.New("response does not match query")
+
+// rcodeError returns the error corresponding to the given RCODE, or nil if
+// the RCODE indicates success.
+func rcodeError(rcode RCode) error {
+	switch rcode {
+	case RCodeNoError:
+		return nil
+	case RCodeFormErr:
+		return ErrFormErr
+	case RCodeServFail:
+		return ErrServFail
+	case RCodeNXDomain:
+		return ErrNXDomain
+	case RCodeNotImp:
+		return ErrNotImp
+	case RCodeRefused:
+		return ErrRefused
+	default:
+		return fmt.Errorf("name server returned unknown RCODE %d", rcode)
+	}
+}
@@ -0,0 +1,37 @@
+package dnstoy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestRCodeError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		flags   uint16
+		wantErr error
+	}{
+		{flags: 0x8180, wantErr: nil},
+		{flags: 0x8181, wantErr: ErrFormErr},
+		{flags: 0x8182, wantErr: ErrServFail},
+		{flags: 0x8183, wantErr: ErrNXDomain},
+		{flags: 0x8184, wantErr: ErrNotImp},
+		{flags: 0x8185, wantErr: ErrRefused},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(fmt.Sprintf("%#04x", tc.flags), func(t *testing.T) {
+			t.Parallel()
+			err := rcodeError(Header{Flags: tc.flags}.RCode())
+			be.True(t, errors.Is(err, tc.wantErr))
+		})
+	}
+
+	err := rcodeError(Header{Flags: 0x818f}.RCode())
+	be.Nonzero(t, err)
+	be.Equal(t, "name server returned unknown RCODE 15", err.Error())
+}
@@ -0,0 +1,74 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+	"golang.org/x/exp/slog"
+)
+
+// Exchange sends a single query to the name server at the given address and
+// returns its response, using the resolver's transport, timeouts, retries
+// and logging. Unlike LookupIP, the query is sent exactly as given:
+// referrals and CNAMEs are not followed, nothing is cached, and the response
+// is returned regardless of its rcode. The response must match the query's ID and
+// question.
+func (r *Resolver) Exchange(ctx context.Context, query Query, server netip.AddrPort) (Message, error) {
+	if r.configErr != nil {
+		return Message{}, r.configErr
+	}
+	ctx, done, err := r.begin(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	defer done()
+	if !server.IsValid() {
+		return Message{}, fmt.Errorf("invalid server address %q", server)
+	}
+	queryName, err := decodeName(byteview.New(query.Question.Name))
+	if err != nil {
+		return Message{}, fmt.Errorf("invalid query name: %w", err)
+	}
+	if err := validateName(string(queryName)); err != nil {
+		return Message{}, err
+	}
+
+	addr := net.IP(server.Addr().Unmap().AsSlice())
+	nameServer := nameServerDef{
+		name:  server.String(),
+		addrs: []net.IP{addr},
+		port:  strconv.Itoa(int(server.Port())),
+	}
+	if len(nameServer.addrsFor(r.network)) == 0 {
+		return Message{}, fmt.Errorf("nameserver %s has no address usable over %s", nameServer.name, r.network)
+	}
+
+	msg, rtt, err := r.exchangeWithRetry(ctx, nameServer, addr, query, string(queryName), query.Question.Type, 0)
+	var partialErr *PartialMessageError
+	if err != nil && !errors.As(err, &partialErr) {
+		return Message{}, err
+	}
+	if err != nil {
+		r.log(ctx).Warn(
+			"partially parsed DNS response",
+			slog.String("err", err.Error()),
+			slog.String("query_name", string(queryName)),
+			slog.String("ns_addr", nameServer.name),
+		)
+		err = nil
+	}
+	if err == nil {
+		err = validateResponse(query, msg, false)
+	}
+	if err != nil {
+		r.rtt.failure(addr, r.queryTimeout)
+		return Message{}, err
+	}
+	r.rtt.success(addr, rtt)
+	return msg, nil
+}
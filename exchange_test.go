@@ -0,0 +1,66 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		if q.Questions[0].Type != RecordTypeTXT {
+			return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+		}
+		// echo the query's flags so that the test can check they were sent
+		// as given
+		return Message{
+			Header:  Header{Flags: q.Header.Flags},
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeTXT, Class: ResourceClassIN, TTL: 60, Data: []byte("\x05hello")}},
+		}
+	})
+	portNum, err := strconv.Atoi(port)
+	be.NilErr(t, err)
+	server := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(portNum))
+	r := newTestResolver(port, nil)
+
+	t.Run("hand-built query", func(t *testing.T) {
+		t.Parallel()
+		query := NewQuery("Example.Test", RecordTypeTXT)
+		query.Header.SetCD(true)
+		msg, err := r.Exchange(context.Background(), query, server)
+		be.NilErr(t, err)
+		be.True(t, msg.Header.CD())
+		be.Equal(t, 1, len(msg.Answers))
+		be.Equal(t, "\x05hello", string(msg.Answers[0].Data))
+	})
+
+	t.Run("error rcodes are returned", func(t *testing.T) {
+		t.Parallel()
+		msg, err := r.Exchange(context.Background(), NewQuery("example.test", RecordTypeA), server)
+		be.NilErr(t, err)
+		be.Equal(t, RCodeNXDomain, msg.Header.RCode())
+	})
+
+	t.Run("invalid server", func(t *testing.T) {
+		t.Parallel()
+		_, err := r.Exchange(context.Background(), NewQuery("example.test", RecordTypeA), netip.AddrPort{})
+		be.Nonzero(t, err)
+	})
+
+	t.Run("mismatched response", func(t *testing.T) {
+		t.Parallel()
+		mismatched := startTestServer(t, func(q Message) Message {
+			return Message{Questions: []Question{{Name: []byte("other.test"), Type: RecordTypeA, Class: ResourceClassIN}}}
+		})
+		portNum, err := strconv.Atoi(mismatched)
+		be.NilErr(t, err)
+		_, err = r.Exchange(context.Background(), NewQuery("example.test", RecordTypeA), netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(portNum)))
+		be.True(t, errors.Is(err, ErrMismatchedResponse))
+	})
+}
@@ -0,0 +1,120 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// dohContentType is the media type of DNS messages sent over HTTPS:
+// https://datatracker.ietf.org/doc/html/rfc8484#section-6
+const dohContentType = "application/dns-message"
+
+// parseUpstream parses the address of an upstream recursive resolver, which
+// may be an IP address with an optional port (e.g. "8.8.8.8",
+// "[2001:4860:4860::8888]:53") or a DNS-over-HTTPS URL (e.g.
+// "https://dns.google/dns-query").
+func parseUpstream(upstream string) (nameServerDef, error) {
+	ns := nameServerDef{name: upstream, authority: ".", recursive: true}
+	if strings.HasPrefix(upstream, "https://") {
+		if _, err := url.Parse(upstream); err != nil {
+			return nameServerDef{}, fmt.Errorf("invalid upstream URL %q: %w", upstream, err)
+		}
+		ns.url = upstream
+		return ns, nil
+	}
+
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		// no port given
+		host, port = strings.TrimSuffix(strings.TrimPrefix(upstream, "["), "]"), ""
+	}
+	addr := net.ParseIP(host)
+	if addr == nil {
+		return nameServerDef{}, fmt.Errorf("invalid upstream %q: must be an IP address or https:// URL", upstream)
+	}
+	ns.addrs = []net.IP{addr}
+	ns.port = port
+	return ns, nil
+}
+
+// forwardZone is a zone whose names are resolved by forwarding queries to
+// its upstreams.
+type forwardZone struct {
+	zone      string
+	upstreams []nameServerDef
+}
+
+// parseForwardZones parses the zones configured by Opts.ForwardZones,
+// returning them with the most specific first. The zone is the authority of
+// its upstreams, so that they are not trusted with the names outside it,
+// such as the targets of CNAMEs.
+func parseForwardZones(zones map[string][]string) ([]forwardZone, error) {
+	var results []forwardZone
+	for zone, upstreams := range zones {
+		if err := validateName(zone); err != nil {
+			return nil, fmt.Errorf("invalid forward zone: %w", err)
+		}
+		if len(upstreams) == 0 {
+			return nil, fmt.Errorf("invalid forward zone %q: no upstreams", zone)
+		}
+		fz := forwardZone{zone: canonicalName(zone)}
+		for _, upstream := range upstreams {
+			ns, err := parseUpstream(upstream)
+			if err != nil {
+				return nil, fmt.Errorf("invalid forward zone %q: %w", zone, err)
+			}
+			ns.authority = fz.zone
+			fz.upstreams = append(fz.upstreams, ns)
+		}
+		results = append(results, fz)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if li, lj := len(canonicalLabels(results[i].zone)), len(canonicalLabels(results[j].zone)); li != lj {
+			return li > lj
+		}
+		return results[i].zone < results[j].zone
+	})
+	return results, nil
+}
+
+// forwardZoneFor returns the upstreams of the most specific forward zone
+// containing the given name, if any.
+func (r *Resolver) forwardZoneFor(name string) []nameServerDef {
+	for _, fz := range r.forwardZones {
+		if isSubdomain(name, fz.zone) {
+			return fz.upstreams
+		}
+	}
+	return nil
+}
+
+// exchangeHTTPS sends a query to a DNS-over-HTTPS endpoint and returns the
+// raw response.
+// https://datatracker.ietf.org/doc/html/rfc8484#section-4.1
+func exchangeHTTPS(ctx context.Context, client *http.Client, endpoint string, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s from %s", resp.Status, endpoint)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != dohContentType {
+		return nil, fmt.Errorf("unexpected content type %q from %s", ct, endpoint)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
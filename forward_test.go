@@ -0,0 +1,188 @@
+package dnstoy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+func TestParseUpstream(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		wantAddr string
+		wantPort string
+		wantURL  string
+		wantErr  string
+	}{
+		"8.8.8.8":                      {wantAddr: "8.8.8.8"},
+		"8.8.8.8:5353":                 {wantAddr: "8.8.8.8", wantPort: "5353"},
+		"2001:4860:4860::8888":         {wantAddr: "2001:4860:4860::8888"},
+		"[2001:4860:4860::8888]":       {wantAddr: "2001:4860:4860::8888"},
+		"[2001:4860:4860::8888]:53":    {wantAddr: "2001:4860:4860::8888", wantPort: "53"},
+		"https://dns.google/dns-query": {wantURL: "https://dns.google/dns-query"},
+		"dns.google":                   {wantErr: `invalid upstream "dns.google": must be an IP address or https:// URL`},
+	}
+	for upstream, tc := range testCases {
+		upstream, tc := upstream, tc
+		t.Run(upstream, func(t *testing.T) {
+			t.Parallel()
+			ns, err := parseUpstream(upstream)
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.Equal(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+			be.True(t, ns.recursive)
+			be.Equal(t, ".", ns.authority)
+			be.Equal(t, tc.wantPort, ns.port)
+			be.Equal(t, tc.wantURL, ns.url)
+			if tc.wantAddr != "" {
+				be.Equal(t, 1, len(ns.addrs))
+				be.Equal(t, tc.wantAddr, ns.addrs[0].String())
+			}
+		})
+	}
+}
+
+// recursiveAnswer answers a query with a CNAME and the address it points
+// to, as a recursive resolver would, if recursion was requested.
+func recursiveAnswer(q Message) Message {
+	if q.Header.Flags&headerFlagRD == 0 {
+		return Message{Header: Header{Flags: uint16(RCodeRefused)}}
+	}
+	return Message{
+		Answers: []Record{
+			{Name: q.Questions[0].Name, Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("target.example.test")},
+			{Name: []byte("target.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+		},
+	}
+}
+
+func TestLookupIPForwarding(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, recursiveAnswer)
+	r := New(&Opts{Upstreams: []string{"127.0.0.1:" + port}})
+	ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(ips))
+	be.Equal(t, "1.2.3.4", ips[0].String())
+
+	// invalid upstreams fail every lookup
+	r = New(&Opts{Upstreams: []string{"dns.google"}})
+	_, err = r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.Nonzero(t, err)
+}
+
+func TestLookupIPForwardingDNSSECFlags(t *testing.T) {
+	t.Parallel()
+
+	// the upstream asserts that its answer was validated unless checking
+	// was disabled
+	port := startTestServer(t, func(q Message) Message {
+		resp := recursiveAnswer(q)
+		if !q.DO() {
+			return Message{Header: Header{Flags: uint16(RCodeRefused)}}
+		}
+		resp.Header.SetAD(!q.Header.CD())
+		return resp
+	})
+
+	for _, checkingDisabled := range []bool{false, true} {
+		r := New(&Opts{Upstreams: []string{"127.0.0.1:" + port}, RequestDNSSEC: true, CheckingDisabled: checkingDisabled})
+		_, trace, err := r.LookupIPWithTrace(context.Background(), "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, !checkingDisabled, trace[0].Response.Header.AD())
+	}
+}
+
+func TestLookupIPForwardingDoH(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		query, err := parseMessage(byteview.New(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := recursiveAnswer(query)
+		resp.Header.ID = query.Header.ID
+		resp.Header.Flags |= headerFlagQR
+		resp.Questions = query.Questions
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(resp.Encode())
+	}))
+	t.Cleanup(srv.Close)
+
+	r := New(&Opts{Upstreams: []string{srv.URL + "/dns-query"}, HTTPClient: srv.Client()})
+	ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(ips))
+	be.Equal(t, "1.2.3.4", ips[0].String())
+}
+
+func TestLookupIPForwardZones(t *testing.T) {
+	t.Parallel()
+
+	public := startTestServer(t, recursiveAnswer)
+	// the internal resolver also answers for names outside its zone, which
+	// must not be trusted
+	internal := startTestServer(t, func(q Message) Message {
+		name := q.Questions[0].Name
+		if canonicalName(string(name)) == "alias.corp.example" {
+			return Message{Answers: []Record{
+				{Name: name, Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("www.example.test")},
+				{Name: []byte("www.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{10, 6, 6, 6}},
+			}}
+		}
+		return Message{Answers: []Record{{Name: name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{10, 0, 0, 1}}}}
+	})
+	r := New(&Opts{
+		Upstreams: []string{"127.0.0.1:" + public},
+		ForwardZones: map[string][]string{
+			"corp.example.":          {"127.0.0.1:" + internal},
+			"external.corp.example.": {"127.0.0.1:" + public},
+		},
+	})
+
+	testCases := map[string]string{
+		"corp.example":              "10.0.0.1",
+		"www.corp.example":          "10.0.0.1",
+		"www.external.corp.example": "1.2.3.4",
+		"www.example.test":          "1.2.3.4",
+		"alias.corp.example":        "1.2.3.4",
+	}
+	for name, want := range testCases {
+		name, want := name, want
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ips, err := r.LookupIP(context.Background(), "ip4", name)
+			be.NilErr(t, err)
+			be.AllEqual(t, []string{want}, ipStrings(ips))
+		})
+	}
+
+	// invalid forward zones fail every lookup
+	for _, zones := range []map[string][]string{
+		{"corp.example": {"dns.google"}},
+		{"corp.example": {}},
+		{"corp..example": {"127.0.0.1"}},
+	} {
+		r := New(&Opts{ForwardZones: zones})
+		_, err := r.LookupIP(context.Background(), "ip4", "www.corp.example")
+		be.Nonzero(t, err)
+	}
+}
@@ -0,0 +1,128 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// startHealthChecks probes the resolver's upstreams, including those of its
+// forward zones, every interval until the resolver is shut down.
+func (r *Resolver) startHealthChecks(interval time.Duration) {
+	var upstreams []nameServerDef
+	upstreams = append(upstreams, r.upstreams...)
+	for _, fz := range r.forwardZones {
+		upstreams = append(upstreams, fz.upstreams...)
+	}
+	if len(upstreams) == 0 {
+		return
+	}
+	ctx, done, err := r.beginBackground()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			r.checkUpstreams(ctx, upstreams)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkUpstreams probes each of the given upstreams concurrently, reporting
+// their health to the resolver's metrics.
+func (r *Resolver) checkUpstreams(ctx context.Context, upstreams []nameServerDef) {
+	var wg sync.WaitGroup
+	for _, upstream := range upstreams {
+		upstream := upstream
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := r.checkUpstream(ctx, upstream)
+			if ctx.Err() != nil {
+				// probes canceled by shutdown say nothing about the
+				// upstream's health
+				return
+			}
+			r.metrics.ObserveUpstreamHealth(upstream.name, healthy)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkUpstream probes each of an upstream's addresses with a query for the
+// root zone's name servers, recording the results so that unhealthy
+// addresses are tried only after healthy ones. An address is unhealthy
+// after several consecutive failed probes, and recovers as soon as one
+// succeeds. It reports whether any of the upstream's addresses is healthy.
+func (r *Resolver) checkUpstream(ctx context.Context, upstream nameServerDef) bool {
+	if upstream.url != "" {
+		// DNS-over-HTTPS upstreams have no addresses to track, so they are
+		// healthy if the probe succeeds
+		_, err := r.probe(ctx, upstream, nil)
+		return err == nil
+	}
+	healthy := false
+	for _, addr := range upstream.addrsFor(r.network) {
+		rtt, err := r.probe(ctx, upstream, addr)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			r.log(ctx).Debug(
+				"upstream health check failed",
+				slog.String("err", err.Error()),
+				slog.String("ns_name", upstream.name),
+				slog.String("ns_addr", addr.String()),
+			)
+			r.rtt.failure(addr, r.queryTimeout)
+		} else {
+			r.rtt.success(addr, rtt)
+		}
+		if r.rtt.healthy(addr) {
+			healthy = true
+		}
+	}
+	return healthy
+}
+
+// probe sends a single health check query to an upstream address, or to its
+// URL if addr is nil, returning the round trip time. Responses other than
+// SERVFAIL and REFUSED are considered successful.
+func (r *Resolver) probe(ctx context.Context, upstream nameServerDef, addr net.IP) (time.Duration, error) {
+	query := NewQuery(".", RecordTypeNS)
+	query.Header.SetRD(true)
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	var (
+		msg Message
+		err error
+	)
+	start := time.Now()
+	if addr == nil {
+		msg, err = r.exchangeDoH(ctx, upstream, query)
+	} else {
+		msg, err = r.exchange(ctx, upstream, addr, query)
+	}
+	rtt := time.Since(start)
+	if err == nil {
+		err = validateResponse(query, msg, false)
+	}
+	if err != nil {
+		return rtt, err
+	}
+	if rcode := msg.Header.RCode(); rcode == RCodeServFail || rcode == RCodeRefused {
+		return rtt, rcodeError(rcode)
+	}
+	return rtt, nil
+}
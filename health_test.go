@@ -0,0 +1,76 @@
+package dnstoy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	// the first upstream fails until it is brought back up, and counts the
+	// lookups it receives besides health checks
+	var down atomic.Bool
+	var lookups atomic.Int32
+	down.Store(true)
+	flaky := startTestServer(t, func(q Message) Message {
+		if canonicalName(string(q.Questions[0].Name)) != "" {
+			lookups.Add(1)
+		}
+		if down.Load() {
+			return Message{Header: Header{Flags: uint16(RCodeServFail)}}
+		}
+		return recursiveAnswer(q)
+	})
+	healthy := startTestServerOn(t, "127.0.0.2", recursiveAnswer)
+	flakyName, healthyName := "127.0.0.1:"+flaky, "127.0.0.2:"+healthy
+
+	metrics := &recordingMetrics{}
+	r := New(&Opts{
+		Upstreams:           []string{flakyName, healthyName},
+		HealthCheckInterval: 5 * time.Millisecond,
+		Metrics:             metrics,
+	})
+	defer r.Close()
+	lastHealth := func(upstream string) (bool, bool) {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		health := metrics.health[upstream]
+		if len(health) == 0 {
+			return false, false
+		}
+		return health[len(health)-1], true
+	}
+	waitFor := func(upstream string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if healthy, ok := lastHealth(upstream); ok && healthy == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s to be healthy=%v", upstream, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// once marked down, the flaky upstream is not queried
+	waitFor(flakyName, false)
+	waitFor(healthyName, true)
+	for i := 0; i < 5; i++ {
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test", WithNoCache())
+		be.NilErr(t, err)
+		be.Equal(t, "1.2.3.4", ips[0].String())
+	}
+	be.Equal(t, int32(0), lookups.Load())
+
+	// it recovers as soon as a probe succeeds
+	down.Store(false)
+	waitFor(flakyName, true)
+	be.True(t, r.rtt.healthy(r.upstreams[0].addrs[0]))
+}
@@ -0,0 +1,113 @@
+package dnstoy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultHostsFile is the conventional location of the system hosts file.
+const DefaultHostsFile = "/etc/hosts"
+
+// Hosts holds static name to address mappings parsed from a hosts file, in
+// the format described by hosts(5).
+type Hosts struct {
+	byName map[string][]net.IP
+	byAddr map[string][]string
+}
+
+// LoadHosts loads and parses the hosts file at the given path.
+func LoadHosts(path string) (*Hosts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hosts, err := ParseHosts(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return hosts, nil
+}
+
+// ParseHosts parses hosts file entries from r. Each line consists of an IP
+// address followed by one or more names, with comments introduced by "#".
+// Lines with invalid addresses are ignored, as most system resolvers do.
+func ParseHosts(r io.Reader) (*Hosts, error) {
+	h := &Hosts{
+		byName: make(map[string][]net.IP),
+		byAddr: make(map[string][]string),
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// strip any IPv6 zone, e.g. "fe80::1%lo0"
+		addr := net.ParseIP(strings.SplitN(fields[0], "%", 2)[0])
+		if addr == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			key := canonicalName(name)
+			h.byName[key] = append(h.byName[key], addr)
+			h.byAddr[addr.String()] = append(h.byAddr[addr.String()], name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// lookupIP returns the addresses of the given family (RecordTypeA or
+// RecordTypeAAAA) mapped to the given name.
+func (h *Hosts) lookupIP(name string, recordType RecordType) []net.IP {
+	if h == nil {
+		return nil
+	}
+	var results []net.IP
+	for _, addr := range h.byName[canonicalName(name)] {
+		if isV4 := addr.To4() != nil; isV4 == (recordType == RecordTypeA) {
+			results = append(results, addr)
+		}
+	}
+	return results
+}
+
+// lookupAddr returns the names mapped to the given address.
+func (h *Hosts) lookupAddr(addr net.IP) []string {
+	if h == nil {
+		return nil
+	}
+	return h.byAddr[addr.String()]
+}
+
+// reverseAddrName returns the name used for reverse (PTR) lookups of the
+// given address, e.g. "4.3.2.1.in-addr.arpa" for 1.2.3.4.
+// https://datatracker.ietf.org/doc/html/rfc1035#section-3.5
+// https://datatracker.ietf.org/doc/html/rfc3596#section-2.5
+func reverseAddrName(addr net.IP) string {
+	if v4 := addr.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	v6 := addr.To16()
+	var sb strings.Builder
+	for i := len(v6) - 1; i >= 0; i-- {
+		sb.WriteByte(hexDigits[v6[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hexDigits[v6[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa")
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package dnstoy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestParseHosts(t *testing.T) {
+	t.Parallel()
+
+	input := `
+# comment line
+127.0.0.1	localhost
+::1		localhost ip6-localhost # trailing comment
+192.168.1.10	nas.home.arpa nas
+fe80::1%lo0	link.local
+not-an-ip	ignored.example
+10.0.0.1
+`
+	hosts, err := ParseHosts(strings.NewReader(input))
+	be.NilErr(t, err)
+
+	be.DeepEqual(t, []net.IP{net.ParseIP("127.0.0.1")}, hosts.lookupIP("LocalHost.", RecordTypeA))
+	be.DeepEqual(t, []net.IP{net.ParseIP("::1")}, hosts.lookupIP("localhost", RecordTypeAAAA))
+	be.DeepEqual(t, []net.IP{net.ParseIP("192.168.1.10")}, hosts.lookupIP("nas", RecordTypeA))
+	be.DeepEqual(t, []net.IP{net.ParseIP("fe80::1")}, hosts.lookupIP("link.local", RecordTypeAAAA))
+	be.Equal(t, 0, len(hosts.lookupIP("nas", RecordTypeAAAA)))
+	be.Equal(t, 0, len(hosts.lookupIP("ignored.example", RecordTypeA)))
+
+	be.DeepEqual(t, []string{"nas.home.arpa", "nas"}, hosts.lookupAddr(net.ParseIP("192.168.1.10")))
+	be.DeepEqual(t, []string{"localhost", "ip6-localhost"}, hosts.lookupAddr(net.ParseIP("::1")))
+
+	// a nil *Hosts is valid and empty
+	var empty *Hosts
+	be.Equal(t, 0, len(empty.lookupIP("localhost", RecordTypeA)))
+	be.Equal(t, 0, len(empty.lookupAddr(net.ParseIP("127.0.0.1"))))
+}
+
+func TestReverseAddrName(t *testing.T) {
+	t.Parallel()
+
+	be.Equal(t, "4.3.2.1.in-addr.arpa", reverseAddrName(net.ParseIP("1.2.3.4")))
+	be.Equal(t,
+		"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa",
+		reverseAddrName(net.ParseIP("4321:0:1:2:3:4:567:89ab")),
+	)
+}
@@ -0,0 +1,64 @@
+package dnstoy
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mccutchen/dnstoy/internal/punycode"
+)
+
+// acePrefix marks a domain name label as Punycode-encoded:
+// https://datatracker.ietf.org/doc/html/rfc5890#section-2.3.2.5
+const acePrefix = "xn--"
+
+// ToASCII converts any Unicode labels in a domain name to their ASCII
+// Compatible Encoding (A-label) form, e.g. "bücher.example" to
+// "xn--bcher-kva.example". Unicode labels are lowercased, but not otherwise
+// normalized as full IDNA2008 processing would require.
+// https://datatracker.ietf.org/doc/html/rfc5891#section-4
+func ToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if !utf8.ValidString(label) {
+			return "", fmt.Errorf("invalid domain name %q: label %q is not valid UTF-8", name, label)
+		}
+		encoded, err := punycode.Encode(strings.ToLower(label))
+		if err != nil {
+			return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+		}
+		labels[i] = acePrefix + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode converts any A-labels in a domain name (e.g.
+// "xn--bcher-kva.example") back to their Unicode form ("bücher.example"),
+// for display. Labels that are not valid Punycode are left as-is.
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) <= len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			continue
+		}
+		if decoded, err := punycode.Decode(label[len(acePrefix):]); err == nil {
+			labels[i] = decoded
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package dnstoy
+
+import (
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestIDNA(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"bücher.example":  "xn--bcher-kva.example",
+		"www.münchen.de.": "www.xn--mnchen-3ya.de.",
+		"例え.テスト":          "xn--r8jz45g.xn--zckzah",
+		"example.com":     "example.com",
+	}
+	for unicode, ascii := range testCases {
+		unicode, ascii := unicode, ascii
+		t.Run(ascii, func(t *testing.T) {
+			t.Parallel()
+			got, err := ToASCII(unicode)
+			be.NilErr(t, err)
+			be.Equal(t, ascii, got)
+			be.Equal(t, unicode, ToUnicode(ascii))
+		})
+	}
+
+	// Unicode labels are lowercased
+	got, err := ToASCII("BÜCHER.example")
+	be.NilErr(t, err)
+	be.Equal(t, "xn--bcher-kva.example", got)
+
+	// invalid A-labels are left as-is
+	be.Equal(t, "xn--!!.example", ToUnicode("xn--!!.example"))
+
+	// invalid UTF-8 is rejected
+	_, err = ToASCII("\xff.example")
+	be.Nonzero(t, err)
+
+	// queries are sent in ASCII form, but names are otherwise encoded as
+	// given
+	be.Equal(t, "\x0dxn--bcher-kva\x07example\x00", string(NewQuery("bücher.example", RecordTypeA).Question.Name))
+	be.Equal(t, "\x07bücher\x07example\x00", string(encodeName("bücher.example")))
+}
@@ -52,6 +52,16 @@ func (v *View) Size() int {
 	return len(v.data)
 }
 
+// Offset returns the current offset into the underlying slice.
+func (v *View) Offset() int {
+	return int(v.offset)
+}
+
+// Remaining returns the number of bytes left to read.
+func (v *View) Remaining() int {
+	return v.Size() - v.Offset()
+}
+
 // WithOffset returns a ByteView with a new offset into the same underlying
 // slice of bytes.
 func (v *View) WithOffset(offset uint16) (*View, error) {
@@ -18,6 +18,8 @@ func TestByteView(t *testing.T) {
 		be.NilErr(t, err)
 		be.DeepEqual(t, bs, []byte("0123"))
 		be.Equal(t, 4, v.offset)
+		be.Equal(t, 4, v.Offset())
+		be.Equal(t, 6, v.Remaining())
 	}
 	// okay to read next 4 bytes
 	{
@@ -57,6 +59,7 @@ func TestByteView(t *testing.T) {
 		be.Equal(t, "EOF: cannot read 1 bytes (offset=10 size=10)", err.Error())
 		be.Equal(t, b, 0)
 		be.Equal(t, 10, v.offset)
+		be.Equal(t, 0, v.Remaining())
 	}
 
 	// new views into the same data
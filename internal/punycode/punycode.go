@@ -0,0 +1,192 @@
+// Package punycode implements the Punycode encoding of Unicode strings as
+// ASCII, as used for internationalized domain name labels:
+// https://datatracker.ietf.org/doc/html/rfc3492
+package punycode
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Bootstring parameters for Punycode:
+// https://datatracker.ietf.org/doc/html/rfc3492#section-5
+const (
+	base        = 36
+	tmin        = 1
+	tmax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+var (
+	errOverflow = errors.New("punycode: overflow")
+	errInvalid  = errors.New("punycode: invalid input")
+)
+
+// Encode encodes a Unicode string as Punycode, without the "xn--" prefix
+// used for domain name labels.
+// https://datatracker.ietf.org/doc/html/rfc3492#section-6.3
+func Encode(s string) (string, error) {
+	input := []rune(s)
+	var out strings.Builder
+	for _, c := range input {
+		if c < initialN {
+			out.WriteRune(c)
+		}
+	}
+	basic := out.Len()
+	handled := basic
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := rune(initialN), 0, initialBias
+	for handled < len(input) {
+		// find the smallest code point not yet handled
+		m := rune(math.MaxInt32)
+		for _, c := range input {
+			if c >= n && c < m {
+				m = c
+			}
+		}
+		if int(m-n) > (math.MaxInt32-delta)/(handled+1) {
+			return "", errOverflow
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, c := range input {
+			if c < n {
+				delta++
+				if delta == math.MaxInt32 {
+					return "", errOverflow
+				}
+			}
+			if c != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := threshold(k, bias)
+				if q < t {
+					break
+				}
+				out.WriteByte(encodeDigit(t + (q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out.WriteByte(encodeDigit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return out.String(), nil
+}
+
+// Decode decodes a Punycode string, without the "xn--" prefix used for
+// domain name labels.
+// https://datatracker.ietf.org/doc/html/rfc3492#section-6.2
+func Decode(s string) (string, error) {
+	var output []rune
+	pos := 0
+	if b := strings.LastIndexByte(s, '-'); b >= 0 {
+		for _, c := range s[:b] {
+			if c >= initialN {
+				return "", errInvalid
+			}
+			output = append(output, c)
+		}
+		pos = b + 1
+	}
+
+	n, i, bias := rune(initialN), 0, initialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if pos >= len(s) {
+				return "", errInvalid
+			}
+			digit, ok := decodeDigit(s[pos])
+			pos++
+			if !ok {
+				return "", errInvalid
+			}
+			if digit > (math.MaxInt32-i)/w {
+				return "", errOverflow
+			}
+			i += digit * w
+			t := threshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > math.MaxInt32/(base-t) {
+				return "", errOverflow
+			}
+			w *= base - t
+		}
+		bias = adapt(i-oldi, len(output)+1, oldi == 0)
+		if i/(len(output)+1) > math.MaxInt32-int(n) {
+			return "", errOverflow
+		}
+		n += rune(i / (len(output) + 1))
+		i %= len(output) + 1
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = n
+		i++
+	}
+	return string(output), nil
+}
+
+func threshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return tmin
+	case k >= bias+tmax:
+		return tmax
+	default:
+		return k - bias
+	}
+}
+
+// adapt is the bias adaptation function:
+// https://datatracker.ietf.org/doc/html/rfc3492#section-6.1
+func adapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((base-tmin)*tmax)/2 {
+		delta /= base - tmin
+		k += base
+	}
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
+
+func encodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func decodeDigit(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c-'0') + 26, true
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), true
+	case 'A' <= c && c <= 'Z':
+		return int(c - 'A'), true
+	default:
+		return 0, false
+	}
+}
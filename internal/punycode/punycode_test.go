@@ -0,0 +1,43 @@
+package punycode
+
+import (
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestPunycode(t *testing.T) {
+	t.Parallel()
+
+	// https://datatracker.ietf.org/doc/html/rfc3492#section-7.1
+	testCases := map[string]string{
+		"bücher":                 "bcher-kva",
+		"münchen":                "mnchen-3ya",
+		"ü":                      "tda",
+		"他们为什么不说中文":              "ihqwcrb4cv8a8dqg056pqjye",
+		"Pročprostěnemluvíčesky": "Proprostnemluvesky-uyb24dma41a",
+		"ascii":                  "ascii-",
+	}
+	for decoded, encoded := range testCases {
+		decoded, encoded := decoded, encoded
+		t.Run(encoded, func(t *testing.T) {
+			t.Parallel()
+			got, err := Encode(decoded)
+			be.NilErr(t, err)
+			be.Equal(t, encoded, got)
+
+			got, err = Decode(encoded)
+			be.NilErr(t, err)
+			be.Equal(t, decoded, got)
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"bcher-kv!", "bcher-k", "bü-kva", "99999999999"} {
+		_, err := Decode(input)
+		be.Nonzero(t, err)
+	}
+}
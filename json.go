@@ -0,0 +1,641 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// The JSON encodings of messages and records are meant to be read by people
+// and tools like jq, so types, classes, opcodes, rcodes and flags are given
+// as mnemonics, names are fully qualified and record data is broken out into
+// fields. Opaque data, such as keys, digests and signatures, is base64
+// encoded. The data of record types without a known structure, and
+// malformed data, is given as base64 in "rdata" instead of "data".
+
+type jsonMessage struct {
+	ID          uint16     `json:"id"`
+	Opcode      string     `json:"opcode"`
+	Status      string     `json:"status"`
+	Flags       []string   `json:"flags"`
+	Questions   []Question `json:"questions,omitempty"`
+	Answers     []Record   `json:"answers,omitempty"`
+	Authorities []Record   `json:"authorities,omitempty"`
+	Additionals []Record   `json:"additionals,omitempty"`
+}
+
+// MarshalJSON encodes the message as JSON.
+func (m Message) MarshalJSON() ([]byte, error) {
+	flags := []string{}
+	for _, flag := range headerFlagNames {
+		if m.Header.Flags&flag.bit != 0 {
+			flags = append(flags, flag.name)
+		}
+	}
+	return json.Marshal(jsonMessage{
+		ID:          m.Header.ID,
+		Opcode:      m.Header.Opcode().String(),
+		Status:      m.Header.RCode().String(),
+		Flags:       flags,
+		Questions:   m.Questions,
+		Answers:     m.Answers,
+		Authorities: m.Authorities,
+		Additionals: m.Additionals,
+	})
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON. The header's
+// section counts are set from the message's contents.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+	opcode, err := parseCodeName[Opcode](jm.Opcode, "opcode")
+	if err != nil {
+		return err
+	}
+	rcode, err := parseCodeName[RCode](jm.Status, "rcode")
+	if err != nil {
+		return err
+	}
+	header := Header{
+		ID:              jm.ID,
+		QuestionCount:   uint16(len(jm.Questions)),
+		AnswerCount:     uint16(len(jm.Answers)),
+		AuthorityCount:  uint16(len(jm.Authorities)),
+		AdditionalCount: uint16(len(jm.Additionals)),
+	}
+	header.SetOpcode(opcode)
+	header.SetRCode(rcode)
+	for _, name := range jm.Flags {
+		found := false
+		for _, flag := range headerFlagNames {
+			if flag.name == strings.ToLower(name) {
+				header.setFlag(flag.bit, true)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown header flag %q", name)
+		}
+	}
+	*m = Message{
+		Header:      header,
+		Questions:   jm.Questions,
+		Answers:     jm.Answers,
+		Authorities: jm.Authorities,
+		Additionals: jm.Additionals,
+	}
+	return nil
+}
+
+// parseCodeName parses a 4-bit opcode or rcode from its name.
+func parseCodeName[T interface {
+	~uint8
+	String() string
+}](s, kind string) (T, error) {
+	for code := T(0); code < 16; code++ {
+		if strings.EqualFold(s, code.String()) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q", kind, s)
+}
+
+type jsonQuestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+// MarshalJSON encodes the question as JSON. Its name must be decoded, as it
+// is in a parsed Message.
+func (q Question) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonQuestion{Name: presentName(string(q.Name)), Type: q.Type.String(), Class: q.Class.String()})
+}
+
+// UnmarshalJSON decodes a question encoded by MarshalJSON.
+func (q *Question) UnmarshalJSON(data []byte) error {
+	var jq jsonQuestion
+	if err := json.Unmarshal(data, &jq); err != nil {
+		return err
+	}
+	recordType, err := ParseRecordType(jq.Type)
+	if err != nil {
+		return err
+	}
+	class, err := ParseResourceClass(jq.Class)
+	if err != nil {
+		return err
+	}
+	*q = Question{Name: []byte(strings.TrimSuffix(jq.Name, ".")), Type: recordType, Class: class}
+	return nil
+}
+
+type jsonRecord struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Class string          `json:"class"`
+	TTL   uint32          `json:"ttl"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	RData []byte          `json:"rdata,omitempty"`
+}
+
+type (
+	jsonAddrData struct {
+		Address net.IP `json:"address"`
+	}
+	jsonNameData struct {
+		Target string `json:"target"`
+	}
+	jsonSOAData struct {
+		MName   string `json:"mname"`
+		RName   string `json:"rname"`
+		Serial  uint32 `json:"serial"`
+		Refresh uint32 `json:"refresh"`
+		Retry   uint32 `json:"retry"`
+		Expire  uint32 `json:"expire"`
+		Minimum uint32 `json:"minimum"`
+	}
+	jsonMXData struct {
+		Preference uint16 `json:"preference"`
+		Exchange   string `json:"exchange"`
+	}
+	jsonTXTData struct {
+		Strings []string `json:"strings"`
+	}
+	jsonSRVData struct {
+		Priority uint16 `json:"priority"`
+		Weight   uint16 `json:"weight"`
+		Port     uint16 `json:"port"`
+		Target   string `json:"target"`
+	}
+	jsonCAAData struct {
+		Flags uint8  `json:"flags"`
+		Tag   string `json:"tag"`
+		Value string `json:"value"`
+	}
+	jsonDSData struct {
+		KeyTag     uint16 `json:"key_tag"`
+		Algorithm  uint8  `json:"algorithm"`
+		DigestType uint8  `json:"digest_type"`
+		Digest     []byte `json:"digest"`
+	}
+	jsonDNSKEYData struct {
+		Flags     uint16 `json:"flags"`
+		Protocol  uint8  `json:"protocol"`
+		Algorithm uint8  `json:"algorithm"`
+		PublicKey []byte `json:"public_key"`
+	}
+	jsonRRSIGData struct {
+		TypeCovered string `json:"type_covered"`
+		Algorithm   uint8  `json:"algorithm"`
+		Labels      uint8  `json:"labels"`
+		OriginalTTL uint32 `json:"original_ttl"`
+		Expiration  uint32 `json:"expiration"`
+		Inception   uint32 `json:"inception"`
+		KeyTag      uint16 `json:"key_tag"`
+		SignerName  string `json:"signer_name"`
+		Signature   []byte `json:"signature"`
+	}
+	jsonNSECData struct {
+		NextName string   `json:"next_name"`
+		Types    []string `json:"types"`
+	}
+	jsonNSEC3Data struct {
+		HashAlgorithm   uint8    `json:"hash_algorithm"`
+		Flags           uint8    `json:"flags"`
+		Iterations      uint16   `json:"iterations"`
+		Salt            []byte   `json:"salt"`
+		NextHashedOwner []byte   `json:"next_hashed_owner,omitempty"`
+		Types           []string `json:"types,omitempty"`
+	}
+	jsonZONEMDData struct {
+		Serial        uint32 `json:"serial"`
+		Scheme        uint8  `json:"scheme"`
+		HashAlgorithm uint8  `json:"hash_algorithm"`
+		Digest        []byte `json:"digest"`
+	}
+)
+
+// MarshalJSON encodes the record as JSON, with its data broken out into
+// fields if its type has a known structure.
+func (r Record) MarshalJSON() ([]byte, error) {
+	jr := jsonRecord{
+		Name:  presentName(string(r.Name)),
+		Type:  r.Type.String(),
+		Class: r.Class.String(),
+		TTL:   r.TTL,
+	}
+	data, err := r.jsonData()
+	if err == nil && data != nil {
+		if jr.Data, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	} else {
+		jr.RData = r.Data
+	}
+	return json.Marshal(jr)
+}
+
+// jsonData returns the record's data as a struct to be encoded as JSON, or
+// nil if its type has no known structure.
+func (r Record) jsonData() (any, error) {
+	switch r.Type {
+	case RecordTypeA, RecordTypeAAAA:
+		ips, err := parseIPAddrs(r.Type, r.Data)
+		if err != nil || len(ips) != 1 {
+			return nil, fmt.Errorf("invalid %s record", r.Type)
+		}
+		return jsonAddrData{Address: ips[0]}, nil
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		return jsonNameData{Target: presentName(string(r.Data))}, nil
+	case RecordTypeSOA:
+		v := byteview.New(r.Data)
+		mname, err := decodeName(v)
+		if err != nil {
+			return nil, err
+		}
+		rname, err := decodeName(v)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := v.Next(20) // 20 == 5 32-bit fields
+		if err != nil || v.Remaining() != 0 {
+			return nil, fmt.Errorf("invalid SOA record")
+		}
+		return jsonSOAData{
+			MName:   presentName(string(mname)),
+			RName:   presentName(string(rname)),
+			Serial:  binary.BigEndian.Uint32(bs[0:4]),
+			Refresh: binary.BigEndian.Uint32(bs[4:8]),
+			Retry:   binary.BigEndian.Uint32(bs[8:12]),
+			Expire:  binary.BigEndian.Uint32(bs[12:16]),
+			Minimum: binary.BigEndian.Uint32(bs[16:20]),
+		}, nil
+	case RecordTypeMX:
+		mx, err := parseMX(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonMXData{Preference: mx.Preference, Exchange: presentName(mx.Exchange)}, nil
+	case RecordTypeSRV:
+		srv, err := parseSRV(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSRVData{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: presentName(srv.Target)}, nil
+	case RecordTypeCAA:
+		caa, err := parseCAA(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.ValidString(caa.Value) {
+			return nil, fmt.Errorf("CAA value is not valid UTF-8")
+		}
+		return jsonCAAData{Flags: caa.Flags, Tag: caa.Tag, Value: caa.Value}, nil
+	case RecordTypeTXT:
+		strs, err := ParseTXT(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		if strs == nil {
+			strs = []string{}
+		}
+		for _, s := range strs {
+			// JSON strings can't hold invalid UTF-8 without loss
+			if !utf8.ValidString(s) {
+				return nil, fmt.Errorf("TXT character-string is not valid UTF-8")
+			}
+		}
+		return jsonTXTData{Strings: strs}, nil
+	case RecordTypeDS, RecordTypeCDS:
+		ds, err := parseDS(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonDSData{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: ds.Digest}, nil
+	case RecordTypeDNSKEY, RecordTypeCDNSKEY:
+		key, err := parseDNSKEY(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonDNSKEYData{Flags: key.Flags, Protocol: key.Protocol, Algorithm: key.Algorithm, PublicKey: key.PublicKey}, nil
+	case RecordTypeRRSIG:
+		sig, err := parseRRSIG(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonRRSIGData{
+			TypeCovered: sig.TypeCovered.String(),
+			Algorithm:   sig.Algorithm,
+			Labels:      sig.Labels,
+			OriginalTTL: sig.OriginalTTL,
+			Expiration:  sig.Expiration,
+			Inception:   sig.Inception,
+			KeyTag:      sig.KeyTag,
+			SignerName:  presentName(sig.SignerName),
+			Signature:   sig.Signature,
+		}, nil
+	case RecordTypeNSEC:
+		nsec, err := parseNSEC(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonNSECData{NextName: presentName(nsec.NextName), Types: typeNames(nsec.Types)}, nil
+	case RecordTypeNSEC3:
+		nsec3, err := parseNSEC3(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonNSEC3Data{
+			HashAlgorithm:   nsec3.HashAlgorithm,
+			Flags:           nsec3.Flags,
+			Iterations:      nsec3.Iterations,
+			Salt:            nsec3.Salt,
+			NextHashedOwner: nsec3.NextHashedOwner,
+			Types:           typeNames(nsec3.Types),
+		}, nil
+	case RecordTypeNSEC3PARAM:
+		if len(r.Data) < 5 || len(r.Data) != 5+int(r.Data[4]) {
+			return nil, fmt.Errorf("invalid NSEC3PARAM record")
+		}
+		return jsonNSEC3Data{
+			HashAlgorithm: r.Data[0],
+			Flags:         r.Data[1],
+			Iterations:    binary.BigEndian.Uint16(r.Data[2:4]),
+			Salt:          r.Data[5:],
+		}, nil
+	case RecordTypeZONEMD:
+		zonemd, err := parseZONEMD(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		return jsonZONEMDData{Serial: zonemd.Serial, Scheme: zonemd.Scheme, HashAlgorithm: zonemd.HashAlgorithm, Digest: zonemd.Digest}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+	recordType, err := ParseRecordType(jr.Type)
+	if err != nil {
+		return err
+	}
+	class, err := ParseResourceClass(jr.Class)
+	if err != nil {
+		return err
+	}
+	rec := Record{
+		Name:  []byte(strings.TrimSuffix(jr.Name, ".")),
+		Type:  recordType,
+		Class: class,
+		TTL:   jr.TTL,
+		Data:  jr.RData,
+	}
+	if len(jr.Data) > 0 {
+		if rec.Data, err = decodeJSONData(recordType, jr.Data); err != nil {
+			return fmt.Errorf("invalid data for %s record %s: %w", recordType, jr.Name, err)
+		}
+	}
+	*r = rec
+	return nil
+}
+
+// decodeJSONData converts the JSON encoding of a record's data back into the
+// form it takes in a Record.
+func decodeJSONData(recordType RecordType, raw json.RawMessage) ([]byte, error) {
+	switch recordType {
+	case RecordTypeA, RecordTypeAAAA:
+		var d jsonAddrData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		if ip4 := d.Address.To4(); recordType == RecordTypeA && ip4 != nil {
+			return ip4, nil
+		}
+		if recordType == RecordTypeAAAA && len(d.Address) == net.IPv6len && d.Address.To4() == nil {
+			return d.Address, nil
+		}
+		return nil, fmt.Errorf("%s is not a valid %s address", d.Address, recordType)
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		var d jsonNameData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimSuffix(d.Target, ".")), nil
+	case RecordTypeSOA:
+		var d jsonSOAData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		out := append(encodeName(d.MName), encodeName(d.RName)...)
+		for _, v := range []uint32{d.Serial, d.Refresh, d.Retry, d.Expire, d.Minimum} {
+			out = binary.BigEndian.AppendUint32(out, v)
+		}
+		return out, nil
+	case RecordTypeMX:
+		var d jsonMXData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return MX{Preference: d.Preference, Exchange: strings.TrimSuffix(d.Exchange, ".")}.Encode(), nil
+	case RecordTypeSRV:
+		var d jsonSRVData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return SRV{Priority: d.Priority, Weight: d.Weight, Port: d.Port, Target: strings.TrimSuffix(d.Target, ".")}.Encode(), nil
+	case RecordTypeCAA:
+		var d jsonCAAData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		if err := validateCAATag(d.Tag); err != nil {
+			return nil, err
+		}
+		return CAA{Flags: d.Flags, Tag: d.Tag, Value: d.Value}.Encode(), nil
+	case RecordTypeTXT:
+		var d jsonTXTData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		var out []byte
+		for _, s := range d.Strings {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT character-string exceeds 255 bytes")
+			}
+			out = append(out, byte(len(s)))
+			out = append(out, s...)
+		}
+		return out, nil
+	case RecordTypeDS, RecordTypeCDS:
+		var d jsonDSData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return DS{KeyTag: d.KeyTag, Algorithm: d.Algorithm, DigestType: d.DigestType, Digest: d.Digest}.Encode(), nil
+	case RecordTypeDNSKEY, RecordTypeCDNSKEY:
+		var d jsonDNSKEYData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return DNSKEY{Flags: d.Flags, Protocol: d.Protocol, Algorithm: d.Algorithm, PublicKey: d.PublicKey}.Encode(), nil
+	case RecordTypeRRSIG:
+		var d jsonRRSIGData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		typeCovered, err := ParseRecordType(d.TypeCovered)
+		if err != nil {
+			return nil, err
+		}
+		return RRSIG{
+			TypeCovered: typeCovered,
+			Algorithm:   d.Algorithm,
+			Labels:      d.Labels,
+			OriginalTTL: d.OriginalTTL,
+			Expiration:  d.Expiration,
+			Inception:   d.Inception,
+			KeyTag:      d.KeyTag,
+			SignerName:  strings.TrimSuffix(d.SignerName, "."),
+			Signature:   d.Signature,
+		}.Encode(), nil
+	case RecordTypeNSEC:
+		var d jsonNSECData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		types, err := parseTypeNames(d.Types)
+		if err != nil {
+			return nil, err
+		}
+		return appendTypeBitmap(encodeName(d.NextName), types), nil
+	case RecordTypeNSEC3, RecordTypeNSEC3PARAM:
+		var d jsonNSEC3Data
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		if len(d.Salt) > 255 || len(d.NextHashedOwner) > 255 {
+			return nil, fmt.Errorf("salt or hash exceeds 255 bytes")
+		}
+		out := []byte{d.HashAlgorithm, d.Flags}
+		out = binary.BigEndian.AppendUint16(out, d.Iterations)
+		out = append(out, byte(len(d.Salt)))
+		out = append(out, d.Salt...)
+		if recordType == RecordTypeNSEC3PARAM {
+			return out, nil
+		}
+		types, err := parseTypeNames(d.Types)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, byte(len(d.NextHashedOwner)))
+		out = append(out, d.NextHashedOwner...)
+		return appendTypeBitmap(out, types), nil
+	case RecordTypeZONEMD:
+		var d jsonZONEMDData
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return ZONEMD{Serial: d.Serial, Scheme: d.Scheme, HashAlgorithm: d.HashAlgorithm, Digest: d.Digest}.Encode(), nil
+	default:
+		return nil, fmt.Errorf("structured data is not supported for %s records, use rdata", recordType)
+	}
+}
+
+func typeNames(types []RecordType) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return names
+}
+
+func parseTypeNames(names []string) ([]RecordType, error) {
+	types := make([]RecordType, len(names))
+	for i, name := range names {
+		t, err := ParseRecordType(name)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = t
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types, nil
+}
+
+type jsonLookupResult struct {
+	Addrs          []jsonAddrTTL     `json:"addrs"`
+	CanonicalName  string            `json:"canonical_name"`
+	CNAMEs         []Record          `json:"cnames,omitempty"`
+	Source         string            `json:"source"`
+	NameServer     string            `json:"name_server,omitempty"`
+	NameServerAddr net.IP            `json:"name_server_addr,omitempty"`
+	Status         string            `json:"status"`
+	RRSets         []jsonRRSetStatus `json:"rrsets,omitempty"`
+	Chain          []jsonZoneStatus  `json:"chain,omitempty"`
+}
+
+type jsonAddrTTL struct {
+	IP  net.IP `json:"ip"`
+	TTL uint32 `json:"ttl"`
+}
+
+type jsonRRSetStatus struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Err    string `json:"error,omitempty"`
+}
+
+type jsonZoneStatus struct {
+	Zone        string `json:"zone"`
+	Status      string `json:"status"`
+	TrustAnchor bool   `json:"trust_anchor,omitempty"`
+	Err         string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes a summary of the lookup result as JSON, with TTLs in
+// seconds. The keys and signatures used to validate it are left out, so
+// results cannot be decoded again.
+func (lr LookupResult) MarshalJSON() ([]byte, error) {
+	jr := jsonLookupResult{
+		Addrs:          make([]jsonAddrTTL, len(lr.Addrs)),
+		CanonicalName:  lr.CanonicalName,
+		CNAMEs:         lr.CNAMEs,
+		Source:         lr.Source.String(),
+		NameServer:     lr.NameServer,
+		NameServerAddr: lr.NameServerAddr,
+		Status:         lr.Status.String(),
+	}
+	for i, addr := range lr.Addrs {
+		jr.Addrs[i] = jsonAddrTTL{IP: addr.IP, TTL: uint32(addr.TTL.Seconds())}
+	}
+	for _, rrset := range lr.RRSets {
+		jr.RRSets = append(jr.RRSets, jsonRRSetStatus{Name: rrset.Name, Type: rrset.Type.String(), Status: rrset.Status.String(), Err: errString(rrset.Err)})
+	}
+	for _, zone := range lr.Chain {
+		jr.Chain = append(jr.Chain, jsonZoneStatus{Zone: zone.Zone, Status: zone.Status.String(), TrustAnchor: zone.TrustAnchor, Err: errString(zone.Err)})
+	}
+	return json.Marshal(jr)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,172 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestRecordJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	soa := append(encodeName("ns1.example.com"), encodeName("hostmaster.example.com")...)
+	for _, v := range []uint32{2023010101, 7200, 3600, 1209600, 300} {
+		soa = binary.BigEndian.AppendUint32(soa, v)
+	}
+	nsec3 := []byte{1, 1, 0, 10, 2, 0xab, 0xcd, 3, 1, 2, 3}
+	nsec3 = appendTypeBitmap(nsec3, []RecordType{RecordTypeA, RecordTypeRRSIG, RecordTypeZONEMD})
+
+	testCases := map[string]struct {
+		record Record
+		want   string
+	}{
+		"A": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{93, 184, 216, 34}},
+			want:   `{"name":"example.com.","type":"A","class":"IN","ttl":300,"data":{"address":"93.184.216.34"}}`,
+		},
+		"AAAA": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeAAAA, Class: ResourceClassIN, TTL: 300, Data: []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+			want:   `{"name":"example.com.","type":"AAAA","class":"IN","ttl":300,"data":{"address":"2001:db8::1"}}`,
+		},
+		"CNAME": {
+			record: Record{Name: []byte("www.example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("example.com")},
+			want:   `{"name":"www.example.com.","type":"CNAME","class":"IN","ttl":60,"data":{"target":"example.com."}}`,
+		},
+		"SOA": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeSOA, Class: ResourceClassIN, TTL: 3600, Data: soa},
+			want:   `{"name":"example.com.","type":"SOA","class":"IN","ttl":3600,"data":{"mname":"ns1.example.com.","rname":"hostmaster.example.com.","serial":2023010101,"refresh":7200,"retry":3600,"expire":1209600,"minimum":300}}`,
+		},
+		"TXT": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeTXT, Class: ResourceClassCH, TTL: 0, Data: []byte("\x05hello\x00")},
+			want:   `{"name":"example.com.","type":"TXT","class":"CH","ttl":0,"data":{"strings":["hello",""]}}`,
+		},
+		"DS": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeDS, Class: ResourceClassIN, TTL: 60, Data: DS{KeyTag: 1, Algorithm: 13, DigestType: 2, Digest: []byte{1, 2, 3}}.Encode()},
+			want:   `{"name":"example.com.","type":"DS","class":"IN","ttl":60,"data":{"key_tag":1,"algorithm":13,"digest_type":2,"digest":"AQID"}}`,
+		},
+		"NSEC": {
+			record: nsecRecord("a.example.com", "c.example.com", RecordTypeA, RecordTypeRRSIG, RecordTypeNSEC),
+			want:   `{"name":"a.example.com.","type":"NSEC","class":"IN","ttl":60,"data":{"next_name":"c.example.com.","types":["A","RRSIG","NSEC"]}}`,
+		},
+		"NSEC3": {
+			record: Record{Name: []byte("abc.example.com"), Type: RecordTypeNSEC3, Class: ResourceClassIN, TTL: 60, Data: nsec3},
+			want:   `{"name":"abc.example.com.","type":"NSEC3","class":"IN","ttl":60,"data":{"hash_algorithm":1,"flags":1,"iterations":10,"salt":"q80=","next_hashed_owner":"AQID","types":["A","RRSIG","ZONEMD"]}}`,
+		},
+		"unknown type": {
+			record: Record{Name: []byte("example.com"), Type: RecordType(99), Class: ResourceClassIN, TTL: 1, Data: []byte{0xab, 0xcd}},
+			want:   `{"name":"example.com.","type":"TYPE99","class":"IN","ttl":1,"rdata":"q80="}`,
+		},
+		"malformed data": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 1, Data: []byte{1, 2}},
+			want:   `{"name":"example.com.","type":"A","class":"IN","ttl":1,"rdata":"AQI="}`,
+		},
+		"OPT": {
+			record: newOPTRecord(1232, EDNSOption{Code: EDNSOptionNSID}),
+			want:   `{"name":".","type":"OPT","class":"CLASS1232","ttl":0,"rdata":"AAMAAA=="}`,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tc.record)
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, string(data))
+
+			var got Record
+			be.NilErr(t, json.Unmarshal(data, &got))
+			be.Equal(t, string(tc.record.Name), string(got.Name))
+			be.Equal(t, tc.record.Type, got.Type)
+			be.Equal(t, tc.record.Class, got.Class)
+			be.Equal(t, tc.record.TTL, got.TTL)
+			be.Equal(t, string(tc.record.Data), string(got.Data))
+		})
+	}
+}
+
+func TestMessageJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Header:    Header{ID: 4660, Flags: headerFlagQR | headerFlagAA | headerFlagRD | uint16(RCodeNXDomain), QuestionCount: 1, AuthorityCount: 1},
+		Questions: []Question{{Name: []byte("missing.example.com"), Type: RecordTypeA, Class: ResourceClassIN}},
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns1.example.com")},
+		},
+	}
+	data, err := json.Marshal(msg)
+	be.NilErr(t, err)
+	be.Equal(t, `{"id":4660,"opcode":"QUERY","status":"NXDOMAIN","flags":["qr","aa","rd"],`+
+		`"questions":[{"name":"missing.example.com.","type":"A","class":"IN"}],`+
+		`"authorities":[{"name":"example.com.","type":"NS","class":"IN","ttl":60,"data":{"target":"ns1.example.com."}}]}`, string(data))
+
+	var got Message
+	be.NilErr(t, json.Unmarshal(data, &got))
+	be.Equal(t, msg.Header, got.Header)
+	be.Equal(t, string(msg.Encode()), string(got.Encode()))
+}
+
+func TestMessageJSONErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"unknown flag":   `{"opcode":"QUERY","status":"NOERROR","flags":["xx"]}`,
+		"unknown status": `{"opcode":"QUERY","status":"BROKEN","flags":[]}`,
+		"unknown type":   `{"opcode":"QUERY","status":"NOERROR","questions":[{"name":".","type":"BOGUS","class":"IN"}]}`,
+		"bad address":    `{"opcode":"QUERY","status":"NOERROR","answers":[{"name":".","type":"A","class":"IN","data":{"address":"::1"}}]}`,
+		"long TXT":       `{"opcode":"QUERY","status":"NOERROR","answers":[{"name":".","type":"TXT","class":"IN","data":{"strings":["` + string(make([]byte, 256)) + `"]}}]}`,
+	}
+	for name, input := range testCases {
+		input := input
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var msg Message
+			be.True(t, json.Unmarshal([]byte(input), &msg) != nil)
+		})
+	}
+}
+
+func TestLookupResultJSON(t *testing.T) {
+	t.Parallel()
+
+	result := LookupResult{
+		IPs:            []net.IP{net.IPv4(1, 2, 3, 4)},
+		Addrs:          []AddrTTL{{IP: net.IPv4(1, 2, 3, 4), TTL: 59 * time.Second}},
+		CanonicalName:  "example.com",
+		Source:         SourceNameServer,
+		NameServer:     "ns1.example.com",
+		NameServerAddr: net.IPv4(192, 0, 2, 1),
+		Status:         SecurityBogus,
+		RRSets:         []RRSetStatus{{Name: "example.com", Type: RecordTypeA, Status: SecurityBogus, Err: errors.New("signature expired")}},
+	}
+	data, err := json.Marshal(result)
+	be.NilErr(t, err)
+	be.Equal(t, `{"addrs":[{"ip":"1.2.3.4","ttl":59}],"canonical_name":"example.com","source":"name server",`+
+		`"name_server":"ns1.example.com","name_server_addr":"192.0.2.1","status":"bogus",`+
+		`"rrsets":[{"name":"example.com","type":"A","status":"bogus","error":"signature expired"}]}`, string(data))
+}
+
+func TestParseRecordType(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]RecordType{
+		"A":       RecordTypeA,
+		"aaaa":    RecordTypeAAAA,
+		"ZONEMD":  RecordTypeZONEMD,
+		"TYPE99":  RecordType(99),
+		"type257": RecordType(257),
+	} {
+		got, err := ParseRecordType(input)
+		be.NilErr(t, err)
+		be.Equal(t, want, got)
+	}
+	for _, input := range []string{"", "BOGUS", "TYPE", "TYPE70000"} {
+		_, err := ParseRecordType(input)
+		be.True(t, err != nil)
+	}
+}
@@ -0,0 +1,114 @@
+package dnstoy
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// lifecycle tracks the lookups and background work in progress, so that a
+// Resolver can be shut down cleanly.
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool
+	nextID   int
+	inflight map[int]inflightWork
+	wg       sync.WaitGroup
+}
+
+type inflightWork struct {
+	cancel     context.CancelFunc
+	background bool
+}
+
+// begin registers a lookup, returning a context that is canceled if the
+// resolver is shut down before the lookup completes, and a function to call
+// when it does. It fails with ErrClosed once the resolver is shut down.
+func (r *Resolver) begin(ctx context.Context) (context.Context, func(), error) {
+	return r.life.begin(ctx, false)
+}
+
+// beginBackground is like begin, for work that no caller waits on, e.g.
+// prefetching, which is canceled as soon as the resolver is shut down.
+func (r *Resolver) beginBackground() (context.Context, func(), error) {
+	return r.life.begin(context.Background(), true)
+}
+
+func (l *lifecycle) begin(ctx context.Context, background bool) (context.Context, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ctx, nil, ErrClosed
+	}
+	if l.inflight == nil {
+		l.inflight = make(map[int]inflightWork)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := l.nextID
+	l.nextID++
+	l.inflight[id] = inflightWork{cancel: cancel, background: background}
+	l.wg.Add(1)
+	return ctx, func() {
+		l.mu.Lock()
+		delete(l.inflight, id)
+		l.mu.Unlock()
+		cancel()
+		l.wg.Done()
+	}, nil
+}
+
+// cancel cancels the work in progress, or only the background work if all
+// is false.
+func (l *lifecycle) cancel(all bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, work := range l.inflight {
+		if all || work.background {
+			work.cancel()
+		}
+	}
+}
+
+// Shutdown stops the resolver: new lookups fail with ErrClosed, background
+// work such as prefetching is canceled, and lookups already in progress are
+// allowed to finish. If ctx is done first, the remaining lookups are
+// canceled and ctx's error is returned. Either way, the connections held by
+// the resolver's transport are closed once no lookups remain. Transports and
+// HTTP clients given in Opts or QueryOpts are left open for their owners to
+// close.
+func (r *Resolver) Shutdown(ctx context.Context) error {
+	r.life.mu.Lock()
+	r.life.closed = true
+	r.life.mu.Unlock()
+	r.life.cancel(false)
+
+	done := make(chan struct{})
+	go func() {
+		r.life.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		r.life.cancel(true)
+		<-done
+	}
+
+	if closer, ok := r.transport.(io.Closer); ok && r.ownsTransport {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if r.ownsHTTPClient {
+		r.httpClient.CloseIdleConnections()
+	}
+	return err
+}
+
+// Close shuts down the resolver like Shutdown, waiting for any lookups in
+// progress to finish.
+func (r *Resolver) Close() error {
+	return r.Shutdown(context.Background())
+}
@@ -0,0 +1,110 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	r := New(nil)
+	be.NilErr(t, r.Close())
+
+	_, err := r.LookupIP(context.Background(), "ip4", "example.com")
+	be.True(t, errors.Is(err, ErrClosed))
+	_, err = r.LookupAddr(context.Background(), "192.0.2.1")
+	be.True(t, errors.Is(err, ErrClosed))
+	_, err = r.LookupRecords(context.Background(), "example.com", QueryOpts{})
+	be.True(t, errors.Is(err, ErrClosed))
+	_, err = r.Exchange(context.Background(), NewQuery("example.com", RecordTypeA), netip.MustParseAddrPort("127.0.0.1:53"))
+	be.True(t, errors.Is(err, ErrClosed))
+
+	// closing again is harmless
+	be.NilErr(t, r.Close())
+}
+
+func TestCloseDrainsLookups(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan struct{}, 1)
+	port := startTestServer(t, func(q Message) Message {
+		received <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.LookupIP(context.Background(), "ip4", "example.test")
+		errs <- err
+	}()
+	<-received
+	be.NilErr(t, r.Close())
+	select {
+	case err := <-errs:
+		be.NilErr(t, err)
+	default:
+		t.Fatal("Close returned before the lookup in progress finished")
+	}
+}
+
+func TestShutdownCancelsLookups(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan struct{}, 1)
+	port := startTestServer(t, func(q Message) Message {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		return noResponse
+	})
+	r := newTestResolver(port, &Opts{QueryTimeout: time.Minute})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.LookupIP(context.Background(), "ip4", "example.test")
+		errs <- err
+	}()
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	be.True(t, errors.Is(r.Shutdown(ctx), context.DeadlineExceeded))
+	be.True(t, time.Since(start) < time.Second)
+	be.True(t, <-errs != nil)
+}
+
+func TestStreamTransportClose(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	server := startStreamTestServer(t, ln, func(q Message) Message { return Message{} })
+
+	transport := &TCPTransport{}
+	be.NilErr(t, transport.Close())
+	_, err = transport.RoundTrip(context.Background(), NewQuery("example.test", RecordTypeA), server)
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(transport.pool.conns))
+
+	be.NilErr(t, transport.Close())
+	transport.pool.mu.Lock()
+	be.Equal(t, 0, len(transport.pool.conns))
+	transport.pool.mu.Unlock()
+
+	// the transport can still be used
+	_, err = transport.RoundTrip(context.Background(), NewQuery("example.test", RecordTypeA), server)
+	be.NilErr(t, err)
+}
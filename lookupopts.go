@@ -0,0 +1,112 @@
+package dnstoy
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// LookupOption overrides the resolver's configuration for a single lookup.
+type LookupOption func(*lookupOpts)
+
+// WithTimeout overrides Opts.ResolutionTimeout for a single lookup.
+func WithTimeout(timeout time.Duration) LookupOption {
+	return func(o *lookupOpts) { o.timeout = timeout }
+}
+
+// WithLogger logs a single lookup with the given logger instead of
+// Opts.Logger.
+func WithLogger(logger *slog.Logger) LookupOption {
+	return func(o *lookupOpts) { o.logger = logger }
+}
+
+// WithServer sends a single lookup's queries to the given recursive
+// resolver, in the same form as Opts.Upstreams, instead of iterating from
+// the root or forwarding to the configured upstreams. Its answers are not
+// cached, so they are never served to other lookups.
+func WithServer(server string) LookupOption {
+	return func(o *lookupOpts) {
+		ns, err := parseUpstream(server)
+		o.server, o.err = &ns, err
+	}
+}
+
+// WithNoCache resolves a single lookup without consulting the cache, and
+// without caching the answers found.
+func WithNoCache() LookupOption {
+	return func(o *lookupOpts) { o.noCache = true }
+}
+
+// WithTransport sends a single lookup's queries with the given transport
+// instead of Opts.Transport, e.g. to force TCP or DNS over TLS. Queries to
+// DNS-over-HTTPS servers are unaffected, and QueryOpts.Transport takes
+// precedence. The transport is not closed by the resolver.
+func WithTransport(transport Transport) LookupOption {
+	return func(o *lookupOpts) { o.transport = transport }
+}
+
+type lookupOpts struct {
+	timeout   time.Duration  // zero to use the resolver's
+	logger    *slog.Logger   // nil to use the resolver's
+	server    *nameServerDef // nil to use the resolver's starting name servers
+	transport Transport      // nil to use the resolver's
+	noCache   bool
+
+	// err is the error from applying an invalid option, which fails the
+	// lookup.
+	err error
+}
+
+// useCache returns true if a lookup may read from and write to the cache.
+func (o lookupOpts) useCache() bool {
+	return !o.noCache && o.server == nil
+}
+
+// lookupOptsKey is the context key used to carry the LookupOptions of a
+// single lookup.
+type lookupOptsKey struct{}
+
+// withLookupOpts returns a context carrying the given options, applied on
+// top of any already carried by ctx.
+func withLookupOpts(ctx context.Context, opts []LookupOption) context.Context {
+	if len(opts) == 0 {
+		return ctx
+	}
+	o := lookupOptsFrom(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, lookupOptsKey{}, o)
+}
+
+func lookupOptsFrom(ctx context.Context) lookupOpts {
+	o, _ := ctx.Value(lookupOptsKey{}).(lookupOpts)
+	return o
+}
+
+// log returns the logger for the lookup carried by ctx.
+func (r *Resolver) log(ctx context.Context) *slog.Logger {
+	if logger := lookupOptsFrom(ctx).logger; logger != nil {
+		return logger
+	}
+	return r.logger
+}
+
+// resolutionTimeoutFor returns the resolution timeout for the lookup carried
+// by ctx.
+func (r *Resolver) resolutionTimeoutFor(ctx context.Context) time.Duration {
+	if timeout := lookupOptsFrom(ctx).timeout; timeout > 0 {
+		return timeout
+	}
+	return r.resolutionTimeout
+}
+
+// checkConfig returns the error, if any, that prevents the lookup carried by
+// ctx from being resolved due to invalid configuration.
+func (r *Resolver) checkConfig(ctx context.Context) error {
+	if r.configErr != nil {
+		return r.configErr
+	}
+	return lookupOptsFrom(ctx).err
+}
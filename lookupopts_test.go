@@ -0,0 +1,117 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+)
+
+// answerWith returns a handler answering every A query with the given
+// address, counting the queries it receives.
+func answerWith(ip net.IP, count *int, mu *sync.Mutex) testHandler {
+	return func(q Message) Message {
+		mu.Lock()
+		*count++
+		mu.Unlock()
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: ip.To4()}},
+		}
+	}
+}
+
+func TestWithServer(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var rootQueries, serverQueries int
+	port := startTestServer(t, answerWith(net.IPv4(1, 1, 1, 1), &rootQueries, &mu))
+	serverPort := startTestServer(t, answerWith(net.IPv4(2, 2, 2, 2), &serverQueries, &mu))
+	r := newTestResolver(port, nil)
+
+	ips, err := r.LookupIP(context.Background(), "ip4", "example.test", WithServer(net.JoinHostPort("127.0.0.1", serverPort)))
+	be.NilErr(t, err)
+	be.Equal(t, "2.2.2.2", ips[0].String())
+
+	// the answer from the overriding server must not be cached
+	ips, err = r.LookupIP(context.Background(), "ip4", "example.test")
+	be.NilErr(t, err)
+	be.Equal(t, "1.1.1.1", ips[0].String())
+
+	mu.Lock()
+	defer mu.Unlock()
+	be.Equal(t, 1, rootQueries)
+	be.Equal(t, 1, serverQueries)
+}
+
+func TestWithServerInvalid(t *testing.T) {
+	t.Parallel()
+
+	r := New(nil)
+	_, err := r.LookupIP(context.Background(), "ip4", "example.com", WithServer("not-an-address"))
+	be.True(t, err != nil)
+	_, err = r.LookupAddr(context.Background(), "192.0.2.1", WithServer("not-an-address"))
+	be.True(t, err != nil)
+}
+
+func TestWithNoCache(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var queries int
+	port := startTestServer(t, answerWith(net.IPv4(1, 1, 1, 1), &queries, &mu))
+	r := newTestResolver(port, nil)
+
+	for _, opts := range [][]LookupOption{nil, nil, {WithNoCache()}} {
+		_, err := r.LookupIP(context.Background(), "ip4", "example.test", opts...)
+		be.NilErr(t, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	be.Equal(t, 2, queries)
+}
+
+func TestWithTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := echoTransport{servers: make(chan netip.AddrPort, 1)}
+	r := newTestResolver("5353", nil)
+	ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test", WithTransport(transport))
+	be.NilErr(t, err)
+	be.Equal(t, "1.2.3.4", ips[0].String())
+	be.Equal(t, "127.0.0.1:5353", (<-transport.servers).String())
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message { return noResponse })
+	r := newTestResolver(port, nil)
+
+	start := time.Now()
+	_, err := r.LookupIP(context.Background(), "ip4", "example.test", WithTimeout(50*time.Millisecond))
+	be.True(t, errors.Is(err, ErrResolutionTimeout))
+	be.True(t, time.Since(start) < time.Second)
+}
+
+func TestWithLogger(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var queries int
+	port := startTestServer(t, answerWith(net.IPv4(1, 1, 1, 1), &queries, &mu))
+	r := newTestResolver(port, nil)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, err := r.LookupIP(context.Background(), "ip4", "example.test", WithLogger(logger))
+	be.NilErr(t, err)
+	be.In(t, "sending DNS query", buf.String())
+}
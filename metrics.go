@@ -0,0 +1,51 @@
+package dnstoy
+
+import (
+	"net"
+	"time"
+)
+
+// Metrics receives measurements from a Resolver, so that they can be exported
+// to a metrics system. Implementations must be safe for concurrent use, and
+// should return quickly, since they are called while resolving.
+type Metrics interface {
+	// ObserveQuery is called once for each query sent to a name server,
+	// including queries that failed without a response.
+	ObserveQuery(q QueryMetric)
+
+	// ObserveCacheLookup is called each time the cache is consulted for a
+	// name and type, reporting whether an answer was found.
+	ObserveCacheLookup(recordType RecordType, hit bool)
+
+	// ObserveRetry is called each time a query to the given name server is
+	// retried after timing out.
+	ObserveRetry(nameServer string)
+
+	// ObserveUpstreamHealth is called after each health check of an
+	// upstream, when Opts.HealthCheckInterval is set, reporting whether it
+	// is healthy.
+	ObserveUpstreamHealth(upstream string, healthy bool)
+}
+
+// QueryMetric describes a single query sent to a name server.
+type QueryMetric struct {
+	NameServer string
+	Addr       net.IP // nil for DNS-over-HTTPS upstreams
+	Type       RecordType
+
+	// RCode is the response's RCODE, or -1 if there was no usable
+	// response, in which case Err explains why.
+	RCode int
+	Err   error
+
+	// RTT is the round trip time of the query, if it was answered.
+	RTT time.Duration
+}
+
+// NopMetrics is a Metrics implementation that discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveQuery(QueryMetric)            {}
+func (NopMetrics) ObserveCacheLookup(RecordType, bool) {}
+func (NopMetrics) ObserveRetry(string)                 {}
+func (NopMetrics) ObserveUpstreamHealth(string, bool)  {}
@@ -0,0 +1,96 @@
+package dnstoy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// recordingMetrics records every measurement it receives.
+type recordingMetrics struct {
+	mu          sync.Mutex
+	queries     []QueryMetric
+	cacheHits   int
+	cacheMisses int
+	retries     map[string]int
+	health      map[string][]bool
+}
+
+func (m *recordingMetrics) ObserveQuery(q QueryMetric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, q)
+}
+
+func (m *recordingMetrics) ObserveCacheLookup(recordType RecordType, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+func (m *recordingMetrics) ObserveRetry(nameServer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.retries == nil {
+		m.retries = make(map[string]int)
+	}
+	m.retries[nameServer]++
+}
+
+func (m *recordingMetrics) ObserveUpstreamHealth(upstream string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.health == nil {
+		m.health = make(map[string][]bool)
+	}
+	m.health[upstream] = append(m.health[upstream], healthy)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	// the first query is lost, and missing.test does not exist
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		if n++; n == 1 {
+			return noResponse
+		}
+		if canonicalName(string(q.Questions[0].Name)) == "missing.test" {
+			return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+		}
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	metrics := &recordingMetrics{}
+	r := newTestResolver(port, &Opts{Metrics: metrics, QueryTimeout: 50 * time.Millisecond, RetryBackoff: time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+	}
+	_, err := r.LookupIP(context.Background(), "ip4", "missing.test")
+	be.Nonzero(t, err)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	be.Equal(t, 2, len(metrics.queries))
+	be.Equal(t, "root.test", metrics.queries[0].NameServer)
+	be.Equal(t, RecordTypeA, metrics.queries[0].Type)
+	be.Equal(t, int(RCodeNoError), metrics.queries[0].RCode)
+	be.True(t, metrics.queries[0].RTT > 0)
+	be.Equal(t, int(RCodeNXDomain), metrics.queries[1].RCode)
+	be.Equal(t, 1, metrics.cacheHits)
+	be.Equal(t, 2, metrics.cacheMisses)
+	be.Equal(t, 1, metrics.retries["root.test"])
+}
@@ -0,0 +1,138 @@
+package dnstoy
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// headerFlagZ is the reserved header bit, which must be zero:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.1
+const headerFlagZ = 1 << 6
+
+// Finding describes a problem found in a message by Message.Validate.
+type Finding struct {
+	// Section is "header", "question", "answer", "authority" or
+	// "additional".
+	Section string
+
+	// Index is the index of the question or record within its section, or
+	// -1 for problems with the header or a section as a whole.
+	Index int
+
+	Problem string
+}
+
+func (f Finding) String() string {
+	if f.Index < 0 {
+		return fmt.Sprintf("%s: %s", f.Section, f.Problem)
+	}
+	return fmt.Sprintf("%s %d: %s", f.Section, f.Index, f.Problem)
+}
+
+// Validate checks the message for problems that parsing alone does not
+// catch, returning a finding for each one, or nil if there are none. It
+// checks that:
+//
+//   - the header's section counts match the sections. Messages built in code
+//     need not set the counts, since Encode sets them, but parsed messages
+//     whose counts disagree were truncated or parsed leniently.
+//   - the opcode is known, and the flags and rcode are consistent with
+//     whether the message is a query or a response
+//   - there is at most one OPT record, in the additional section, owned by
+//     the root
+//   - owner names, and names in the data of NS, CNAME and PTR records, can
+//     be encoded
+func (m Message) Validate() []Finding {
+	var findings []Finding
+	add := func(section string, index int, format string, args ...any) {
+		findings = append(findings, Finding{Section: section, Index: index, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	h := m.Header
+	for _, c := range []struct {
+		section string
+		count   uint16
+		len     int
+	}{
+		{"question", h.QuestionCount, len(m.Questions)},
+		{"answer", h.AnswerCount, len(m.Answers)},
+		{"authority", h.AuthorityCount, len(m.Authorities)},
+		{"additional", h.AdditionalCount, len(m.Additionals)},
+	} {
+		if int(c.count) != c.len {
+			add("header", -1, "%s count is %d, but the section has %d entries", c.section, c.count, c.len)
+		}
+	}
+
+	switch h.Opcode() {
+	case OpcodeQuery, OpcodeNotify, OpcodeUpdate, OpcodeStatus:
+	case OpcodeIQuery:
+		add("header", -1, "opcode IQUERY is obsolete")
+	default:
+		add("header", -1, "unknown opcode %s", h.Opcode())
+	}
+	if h.Flags&headerFlagZ != 0 {
+		add("header", -1, "reserved Z flag is set")
+	}
+	if !h.QR() {
+		// flags that only have meaning in responses
+		for _, flag := range []struct {
+			name string
+			set  bool
+		}{{"AA", h.AA()}, {"TC", h.TC()}, {"RA", h.RA()}} {
+			if flag.set {
+				add("header", -1, "%s flag is set in a query", flag.name)
+			}
+		}
+		if h.RCode() != RCodeNoError {
+			add("header", -1, "query has rcode %s", h.RCode())
+		}
+	}
+	// https://datatracker.ietf.org/doc/html/rfc9619
+	if h.Opcode() == OpcodeQuery && len(m.Questions) != 1 && (!h.QR() || h.RCode() != RCodeFormErr) {
+		add("question", -1, "standard query has %d questions, not 1", len(m.Questions))
+	}
+
+	for i, q := range m.Questions {
+		if err := validateName(string(q.Name)); err != nil {
+			add("question", i, "%s", err)
+		}
+		if q.Type == RecordTypeOPT {
+			add("question", i, "OPT is not a valid question type")
+		}
+	}
+
+	opts := 0
+	for _, section := range []struct {
+		name    string
+		records []Record
+	}{
+		{"answer", m.Answers},
+		{"authority", m.Authorities},
+		{"additional", m.Additionals},
+	} {
+		for i, rec := range section.records {
+			if err := validateName(string(rec.Name)); err != nil {
+				add(section.name, i, "%s", err)
+			}
+			switch rec.Type {
+			case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+				if err := validateName(string(rec.Data)); err != nil {
+					add(section.name, i, "%s record data: %s", rec.Type, err)
+				}
+			case RecordTypeOPT:
+				// https://datatracker.ietf.org/doc/html/rfc6891#section-6.1.1
+				if opts++; opts == 2 {
+					add(section.name, i, "more than one OPT record")
+				}
+				if section.name != "additional" {
+					add(section.name, i, "OPT record outside the additional section")
+				}
+				if len(rec.Name) != 0 && string(rec.Name) != "." {
+					add(section.name, i, "OPT record owned by %s, not the root", strconv.Quote(string(rec.Name)))
+				}
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,100 @@
+package dnstoy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestMessageValidate(t *testing.T) {
+	t.Parallel()
+
+	question := Question{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN}
+	answer := Record{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}
+	opt := newOPTRecord(1232)
+	query, err := ParseMessage(NewQuery("example.com", RecordTypeA).Encode(), ParseStrict)
+	be.NilErr(t, err)
+	response := func(m Message) Message {
+		m.Header.SetQR(true)
+		return m
+	}
+
+	testCases := map[string]struct {
+		msg  Message
+		want []string
+	}{
+		"valid query": {
+			msg: query,
+		},
+		"valid response": {
+			msg: response(Message{
+				Header:      Header{QuestionCount: 1, AnswerCount: 1, AdditionalCount: 1},
+				Questions:   []Question{question},
+				Answers:     []Record{answer},
+				Additionals: []Record{opt},
+			}),
+		},
+		"FORMERR response without a question": {
+			msg: response(Message{Header: Header{Flags: uint16(RCodeFormErr)}}),
+		},
+		"mismatched counts": {
+			msg:  response(Message{Header: Header{QuestionCount: 1, AnswerCount: 2}, Questions: []Question{question}}),
+			want: []string{"header: answer count is 2, but the section has 0 entries"},
+		},
+		"bad query flags": {
+			msg: Message{
+				Header:    Header{QuestionCount: 1, Flags: headerFlagAA | headerFlagZ | uint16(RCodeNXDomain)},
+				Questions: []Question{question},
+			},
+			want: []string{
+				"header: reserved Z flag is set",
+				"header: AA flag is set in a query",
+				"header: query has rcode NXDOMAIN",
+			},
+		},
+		"unknown opcode": {
+			msg:  Message{Header: Header{QuestionCount: 1, Flags: 9 << 11}, Questions: []Question{question}},
+			want: []string{"header: unknown opcode OPCODE9"},
+		},
+		"too many questions": {
+			msg:  Message{Header: Header{QuestionCount: 2}, Questions: []Question{question, question}},
+			want: []string{"question: standard query has 2 questions, not 1"},
+		},
+		"OPT records": {
+			msg: response(Message{
+				Header:      Header{QuestionCount: 1, AnswerCount: 1, AdditionalCount: 1},
+				Questions:   []Question{question},
+				Answers:     []Record{opt},
+				Additionals: []Record{{Name: []byte("example.com"), Type: RecordTypeOPT, Class: 512}},
+			}),
+			want: []string{
+				"answer 0: OPT record outside the additional section",
+				"additional 0: more than one OPT record",
+				`additional 0: OPT record owned by "example.com", not the root`,
+			},
+		},
+		"bad names": {
+			msg: response(Message{
+				Header:    Header{QuestionCount: 1, AnswerCount: 1},
+				Questions: []Question{{Name: []byte("bad..name"), Type: RecordTypeA, Class: ResourceClassIN}},
+				Answers:   []Record{{Name: []byte("example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, Data: []byte(strings.Repeat("a", 64))}},
+			}),
+			want: []string{
+				`question 0: invalid domain name "bad..name": empty label`,
+				`answer 0: CNAME record data: invalid domain name "` + strings.Repeat("a", 64) + `": label "` + strings.Repeat("a", 64) + `" is longer than 63 bytes`,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for _, finding := range tc.msg.Validate() {
+				got = append(got, finding.String())
+			}
+			be.AllEqual(t, tc.want, got)
+		})
+	}
+}
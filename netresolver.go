@@ -0,0 +1,120 @@
+package dnstoy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+	"golang.org/x/exp/slog"
+)
+
+// NetResolver returns a *net.Resolver that sends every lookup through r, so
+// that code built on the standard library, e.g. an http.Transport's dialer,
+// can use it without changes. The returned resolver uses Go's built-in DNS
+// client, whose connections are served in-process by r rather than by the
+// name servers in /etc/resolv.conf. The standard library still handles the
+// hosts file and search list itself.
+func (r *Resolver) NetResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go r.serveConn(ctx, server)
+			return client, nil
+		},
+	}
+}
+
+// serveConn answers the length-prefixed queries sent over conn until it is
+// closed. Connections that are not net.PacketConns are treated as streams by
+// the standard library, so the same framing is used for both networks.
+func (r *Resolver) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	for {
+		buf, err := readStreamMessage(conn)
+		if err != nil {
+			return
+		}
+		query, err := parseMessage(byteview.New(buf))
+		if err != nil {
+			r.log(ctx).Debug("failed to parse query from net.Resolver", slog.String("err", err.Error()))
+			return
+		}
+		resp := r.answerQuery(ctx, query).Encode()
+		out := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(resp)), uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// answerQuery resolves the question in the given query and builds the
+// response that a recursive resolver would send.
+func (r *Resolver) answerQuery(ctx context.Context, query Message) Message {
+	resp := Message{
+		Header:    Header{ID: query.Header.ID},
+		Questions: query.Questions,
+	}
+	resp.Header.SetQR(true)
+	resp.Header.SetRA(true)
+	resp.Header.SetRD(query.Header.RD())
+	if len(query.Questions) != 1 || query.Questions[0].Class != ResourceClassIN {
+		resp.Header.SetRCode(RCodeFormErr)
+		return resp
+	}
+	q := query.Questions[0]
+	if !isSupportedQueryType(q.Type) {
+		resp.Header.SetRCode(RCodeNotImp)
+		return resp
+	}
+	records, err := r.lookupRecords(ctx, string(q.Name), q.Type)
+	switch {
+	case err == nil:
+		resp.Answers = records
+	case errors.Is(err, ErrNXDomain):
+		resp.Header.SetRCode(RCodeNXDomain)
+	case errors.Is(err, ErrNoData):
+	default:
+		r.log(ctx).Debug("lookup for net.Resolver failed", slog.String("query_name", string(q.Name)), slog.String("err", err.Error()))
+		resp.Header.SetRCode(RCodeServFail)
+	}
+	return resp
+}
+
+// lookupRecords resolves the records of the given type for a single name,
+// without using the search list or hosts file.
+func (r *Resolver) lookupRecords(ctx context.Context, domainName string, recordType RecordType) ([]Record, error) {
+	if err := r.checkConfig(ctx); err != nil {
+		return nil, err
+	}
+	ctx, done, err := r.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", domainName, err)
+	}
+	defer done()
+	if err := validateName(domainName); err != nil {
+		return nil, err
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, r.resolutionTimeoutFor(ctx))
+	defer cancel()
+	r.primeRootNameServers(lookupCtx)
+	records, _, err := r.doLookup(lookupCtx, newLookupState(), r.startingNameServers(ctx, domainName), domainName, recordType, 0)
+	if err != nil {
+		return nil, r.resolutionTimeoutError(ctx, domainName, err)
+	}
+	return records, nil
+}
+
+// isSupportedQueryType returns true if the resolver can look up records of
+// the given type on behalf of a net.Resolver.
+func isSupportedQueryType(t RecordType) bool {
+	switch t {
+	case RecordTypeA, RecordTypeAAAA, RecordTypeCNAME, RecordTypeNS, RecordTypePTR, RecordTypeSOA, RecordTypeTXT:
+		return true
+	default:
+		return false
+	}
+}
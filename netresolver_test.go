@@ -0,0 +1,53 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestNetResolver(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		name, typ := canonicalName(string(q.Questions[0].Name)), q.Questions[0].Type
+		switch {
+		case name == "alias.example.test" && typ != RecordTypeCNAME:
+			return cnameAnswer("alias.example.test", "www.example.test")
+		case name == "www.example.test" && typ == RecordTypeA:
+			return Message{Answers: []Record{{Name: []byte(name), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}}
+		case name == "www.example.test":
+			return Message{Header: Header{Flags: headerFlagAA}} // with no records of this type
+		}
+		return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+	})
+	nr := newTestResolver(port, nil).NetResolver()
+
+	testCases := map[string]struct {
+		name         string
+		wantAddrs    []string
+		wantNotFound bool
+	}{
+		"A only":  {name: "www.example.test.", wantAddrs: []string{"1.2.3.4"}},
+		"CNAME":   {name: "alias.example.test.", wantAddrs: []string{"1.2.3.4"}},
+		"missing": {name: "missing.example.test.", wantNotFound: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			addrs, err := nr.LookupHost(context.Background(), tc.name)
+			if tc.wantNotFound {
+				var dnsErr *net.DNSError
+				be.True(t, errors.As(err, &dnsErr))
+				be.True(t, dnsErr.IsNotFound)
+				return
+			}
+			be.NilErr(t, err)
+			be.DeepEqual(t, tc.wantAddrs, addrs)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package dnstoy
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+)
+
+// NewNotify creates a NOTIFY message telling a secondary name server that
+// the given zone has changed, so that it should check the zone's SOA serial
+// and transfer the zone if needed:
+// https://datatracker.ietf.org/doc/html/rfc1996
+func NewNotify(zone string) Query {
+	query := NewQuery(zone, RecordTypeSOA)
+	query.Header.SetOpcode(OpcodeNotify)
+	query.Header.SetAA(true)
+	return query
+}
+
+// Notify sends a NOTIFY message for the given zone to the secondary name
+// server at the given address, returning an error unless the server
+// acknowledges it. As with Exchange, the message is retried if it times
+// out.
+func (r *Resolver) Notify(ctx context.Context, zone string, server netip.AddrPort) error {
+	resp, err := r.Exchange(ctx, NewNotify(zone), server)
+	if err != nil {
+		return fmt.Errorf("notify %s: %w", zone, err)
+	}
+	if opcode := resp.Header.Opcode(); opcode != OpcodeNotify {
+		return fmt.Errorf("notify %s: %w: got opcode %s, expected %s", zone, ErrMismatchedResponse, opcode, OpcodeNotify)
+	}
+	if err := rcodeError(resp.Header.RCode()); err != nil {
+		return fmt.Errorf("notify %s: %w", zone, err)
+	}
+	return nil
+}
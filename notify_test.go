@@ -0,0 +1,64 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestNewNotify(t *testing.T) {
+	t.Parallel()
+
+	query := NewNotify("example.test")
+	be.Equal(t, OpcodeNotify, query.Header.Opcode())
+	be.True(t, query.Header.AA())
+	be.False(t, query.Header.RD())
+	be.Equal(t, RecordTypeSOA, query.Question.Type)
+	be.Equal(t, ResourceClassIN, query.Question.Class)
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		rcode   RCode
+		opcode  Opcode
+		wantErr error
+	}{
+		"acknowledged":  {opcode: OpcodeNotify},
+		"refused":       {opcode: OpcodeNotify, rcode: RCodeRefused, wantErr: ErrRefused},
+		"wrong opcode":  {opcode: OpcodeQuery, wantErr: ErrMismatchedResponse},
+		"not supported": {opcode: OpcodeNotify, rcode: RCodeNotImp, wantErr: ErrNotImp},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			notifications := make(chan Message, 1)
+			port := startTestServer(t, func(q Message) Message {
+				notifications <- q
+				var resp Message
+				resp.Header.SetOpcode(tc.opcode)
+				resp.Header.SetRCode(tc.rcode)
+				return resp
+			})
+			portNum, err := strconv.Atoi(port)
+			be.NilErr(t, err)
+			r := newTestResolver(port, nil)
+
+			err = r.Notify(context.Background(), "example.test", netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(portNum)))
+			if tc.wantErr == nil {
+				be.NilErr(t, err)
+			} else {
+				be.True(t, errors.Is(err, tc.wantErr))
+			}
+			q := <-notifications
+			be.Equal(t, OpcodeNotify, q.Header.Opcode())
+			be.Equal(t, "example.test", string(q.Questions[0].Name))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package dnstoy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// NSEC holds the data of an NSEC record, which asserts that no names exist
+// between the record's owner name and NextName in canonical order, and lists
+// the types that exist at the owner name:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-4.1
+type NSEC struct {
+	NextName string
+	Types    []RecordType
+}
+
+// parseNSEC parses the data of an NSEC record.
+func parseNSEC(data []byte) (NSEC, error) {
+	v := byteview.New(data)
+	next, err := decodeName(v)
+	if err != nil {
+		return NSEC{}, fmt.Errorf("parseNSEC: error decoding next domain name: %w", err)
+	}
+	bitmap, err := v.Next(uint16(v.Remaining()))
+	if err != nil {
+		return NSEC{}, fmt.Errorf("parseNSEC: %w", err)
+	}
+	types, err := parseTypeBitmap(bitmap)
+	if err != nil {
+		return NSEC{}, fmt.Errorf("parseNSEC: %w", err)
+	}
+	return NSEC{NextName: string(next), Types: types}, nil
+}
+
+// parseTypeBitmap parses the type bit maps field shared by NSEC and NSEC3
+// records:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-4.1.2
+func parseTypeBitmap(data []byte) ([]RecordType, error) {
+	var types []RecordType
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated type bitmap window header")
+		}
+		window, size := int(data[0]), int(data[1])
+		if size == 0 || size > 32 || len(data) < 2+size {
+			return nil, fmt.Errorf("invalid type bitmap window %d of size %d", window, size)
+		}
+		for i, b := range data[2 : 2+size] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, RecordType(window*256+i*8+bit))
+				}
+			}
+		}
+		data = data[2+size:]
+	}
+	return types, nil
+}
+
+// appendTypeBitmap appends the type bit maps field encoding the given
+// types, which must be sorted, to out.
+func appendTypeBitmap(out []byte, types []RecordType) []byte {
+	for i := 0; i < len(types); {
+		window := types[i] >> 8
+		var bitmap [32]byte
+		size := 0
+		for ; i < len(types) && types[i]>>8 == window; i++ {
+			low := types[i] & 0xff
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			size = int(low/8) + 1
+		}
+		out = append(out, byte(window), byte(size))
+		out = append(out, bitmap[:size]...)
+	}
+	return out
+}
+
+// canonicalLabels returns the lowercased labels of a name, ordered from the
+// rightmost (most significant) label to the leftmost.
+func canonicalLabels(name string) [][]byte {
+	name = canonicalName(name)
+	if name == "" {
+		return nil
+	}
+	parts := strings.Split(name, ".")
+	labels := make([][]byte, len(parts))
+	for i, part := range parts {
+		labels[len(parts)-1-i] = []byte(part)
+	}
+	return labels
+}
+
+// compareCanonical compares two names in canonical DNS name order, returning
+// -1, 0 or 1:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-6.1
+func compareCanonical(a, b string) int {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		if c := bytes.Compare(la[i], lb[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// canonicalName returns the canonical form of a domain name for comparisons
+// and map keys: lowercased, since DNS names are case-insensitive, and
+// without a trailing dot.
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// isSubdomain returns true if name is equal to or below zone.
+func isSubdomain(name, zone string) bool {
+	name, zone = canonicalName(name), canonicalName(zone)
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// commonAncestor returns the longest name that both a and b are subdomains
+// of.
+func commonAncestor(a, b string) string {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	var common []string
+	for i := 0; i < len(la) && i < len(lb) && bytes.Equal(la[i], lb[i]); i++ {
+		common = append([]string{string(la[i])}, common...)
+	}
+	return strings.Join(common, ".")
+}
+
+// maxNSECEntries bounds the number of NSEC records held for aggressive
+// negative caching.
+const maxNSECEntries = 10000
+
+type nsecEntry struct {
+	zone    string
+	owner   string
+	next    string
+	expires time.Time
+}
+
+// covers returns true if the NSEC record proves that no name exists between
+// its owner and next names, exclusive.
+func (e nsecEntry) covers(name string) bool {
+	if !isSubdomain(name, e.zone) || compareCanonical(name, e.owner) <= 0 {
+		return false
+	}
+	// the last NSEC in a zone wraps around to point at the zone apex
+	if compareCanonical(e.next, e.owner) <= 0 {
+		return true
+	}
+	return compareCanonical(name, e.next) < 0
+}
+
+// nsecCache holds NSEC records seen in responses so that they may be used to
+// synthesize negative answers without querying, per RFC 8198.
+// https://datatracker.ietf.org/doc/html/rfc8198
+type nsecCache struct {
+	mu      sync.Mutex
+	entries map[string]nsecEntry // keyed by lowercased owner name
+	now     func() time.Time     // may be overridden in tests
+}
+
+func newNSECCache() *nsecCache {
+	return &nsecCache{
+		entries: make(map[string]nsecEntry),
+		now:     time.Now,
+	}
+}
+
+// add stores an NSEC record from the given zone for the given TTL.
+func (c *nsecCache) add(zone string, owner string, nsec NSEC, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	key := canonicalName(owner)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.entries[key]; !found && len(c.entries) >= maxNSECEntries {
+		// evict an arbitrary entry to make room
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = nsecEntry{
+		zone:    zone,
+		owner:   owner,
+		next:    nsec.NextName,
+		expires: c.now().Add(ttl),
+	}
+}
+
+// covering returns an unexpired NSEC record covering the given name.
+func (c *nsecCache) covering(name string) (nsecEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, e := range c.entries {
+		if !e.expires.After(now) {
+			delete(c.entries, key)
+			continue
+		}
+		if e.covers(name) {
+			return e, true
+		}
+	}
+	return nsecEntry{}, false
+}
+
+// provesNXDomain returns true if cached NSEC records prove that the given
+// name does not exist, which requires one record covering the name itself
+// and another covering the wildcard at its closest encloser.
+// https://datatracker.ietf.org/doc/html/rfc8198#section-5.1
+func (c *nsecCache) provesNXDomain(name string) bool {
+	nameProof, found := c.covering(name)
+	if !found {
+		return false
+	}
+
+	closestEncloser := commonAncestor(name, nameProof.owner)
+	if ce := commonAncestor(name, nameProof.next); len(ce) > len(closestEncloser) {
+		closestEncloser = ce
+	}
+	if !isSubdomain(closestEncloser, nameProof.zone) {
+		closestEncloser = nameProof.zone
+	}
+
+	wildcard := "*"
+	if closestEncloser != "" {
+		wildcard += "." + closestEncloser
+	}
+	_, found = c.covering(wildcard)
+	return found
+}
@@ -0,0 +1,101 @@
+package dnstoy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestCompareCanonical(t *testing.T) {
+	t.Parallel()
+
+	// example from the RFC, in canonical order
+	// https://datatracker.ietf.org/doc/html/rfc4034#section-6.1
+	ordered := []string{
+		"example",
+		"a.example",
+		"yljkjljk.a.example",
+		"Z.a.example",
+		"zABC.a.EXAMPLE",
+		"z.example",
+		"\x01.z.example",
+		"*.z.example",
+		"\xc8.z.example",
+	}
+	for i := range ordered {
+		be.Equal(t, 0, compareCanonical(ordered[i], ordered[i]))
+		for j := i + 1; j < len(ordered); j++ {
+			be.Equal(t, -1, compareCanonical(ordered[i], ordered[j]))
+			be.Equal(t, 1, compareCanonical(ordered[j], ordered[i]))
+		}
+	}
+	be.Equal(t, 0, compareCanonical("Example.COM.", "example.com"))
+}
+
+func TestParseNSEC(t *testing.T) {
+	t.Parallel()
+
+	// example from the RFC: "host.example.com. A MX RRSIG NSEC TYPE1234"
+	// https://datatracker.ietf.org/doc/html/rfc4034#section-4.3
+	data := "\x04host\x07example\x03com\x00" +
+		"\x00\x06\x40\x01\x00\x00\x00\x03" +
+		"\x04\x1b" + strings.Repeat("\x00", 26) + "\x20"
+	got, err := parseNSEC([]byte(data))
+	be.NilErr(t, err)
+	be.Equal(t, "host.example.com", got.NextName)
+	be.DeepEqual(t, []RecordType{RecordTypeA, 15, RecordTypeRRSIG, RecordTypeNSEC, 1234}, got.Types)
+
+	_, err = parseNSEC([]byte("\x00\x00\x21"))
+	be.Nonzero(t, err)
+}
+
+func TestNSECCacheProvesNXDomain(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := newNSECCache()
+	cache.now = func() time.Time { return now }
+
+	// zone example.com contains example.com, b.example.com and
+	// d.example.com, so the chain is example.com -> b -> d -> example.com
+	cache.add("example.com", "example.com", NSEC{NextName: "b.example.com"}, time.Minute)
+	cache.add("example.com", "b.example.com", NSEC{NextName: "d.example.com"}, time.Minute)
+
+	// c.example.com is covered by b -> d, and *.example.com is covered by
+	// example.com -> b
+	be.True(t, cache.provesNXDomain("c.example.com"))
+	be.True(t, cache.provesNXDomain("C.Example.Com"))
+
+	// names that exist are never covered
+	be.False(t, cache.provesNXDomain("b.example.com"))
+
+	// e.example.com would be covered by d -> example.com, which we have not
+	// seen
+	be.False(t, cache.provesNXDomain("e.example.com"))
+	cache.add("example.com", "d.example.com", NSEC{NextName: "example.com"}, time.Minute)
+	be.True(t, cache.provesNXDomain("e.example.com"))
+
+	// the wrap-around record must not cover names outside its zone
+	be.False(t, cache.provesNXDomain("foo.org"))
+
+	// records expire
+	now = now.Add(time.Minute)
+	be.False(t, cache.provesNXDomain("c.example.com"))
+}
+
+func TestParseRRSIG(t *testing.T) {
+	t.Parallel()
+
+	data := "\x00\x2f\x08\x02\x00\x00\x0e\x10\x64\x9a\x2a\x00\x64\x88\xb5\x00\x12\x34\x07example\x03com\x00\xde\xad\xbe\xef"
+	got, err := parseRRSIG([]byte(data))
+	be.NilErr(t, err)
+	be.Equal(t, RecordTypeNSEC, got.TypeCovered)
+	be.Equal(t, uint8(8), got.Algorithm)
+	be.Equal(t, uint8(2), got.Labels)
+	be.Equal(t, uint32(3600), got.OriginalTTL)
+	be.Equal(t, uint16(0x1234), got.KeyTag)
+	be.Equal(t, "example.com", got.SignerName)
+	be.Equal(t, "\xde\xad\xbe\xef", string(got.Signature))
+}
@@ -3,10 +3,12 @@ package dnstoy
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/mccutchen/dnstoy/internal/byteview"
@@ -18,12 +20,28 @@ type RecordType uint16
 // Query types:
 // https://datatracker.ietf.org/doc/html/rfc1035#section-3.2.2
 const (
-	RecordTypeA     RecordType = 1
-	RecordTypeNS    RecordType = 2
-	RecordTypeCNAME RecordType = 5
-	RecordTypeSOA   RecordType = 6
-	RecordTypeTXT   RecordType = 16
-	RecordTypeAAAA  RecordType = 28
+	RecordTypeA          RecordType = 1
+	RecordTypeNS         RecordType = 2
+	RecordTypeCNAME      RecordType = 5
+	RecordTypeSOA        RecordType = 6
+	RecordTypePTR        RecordType = 12
+	RecordTypeMX         RecordType = 15
+	RecordTypeTXT        RecordType = 16
+	RecordTypeAAAA       RecordType = 28
+	RecordTypeSRV        RecordType = 33
+	RecordTypeOPT        RecordType = 41
+	RecordTypeDS         RecordType = 43
+	RecordTypeRRSIG      RecordType = 46
+	RecordTypeNSEC       RecordType = 47
+	RecordTypeDNSKEY     RecordType = 48
+	RecordTypeNSEC3      RecordType = 50
+	RecordTypeNSEC3PARAM RecordType = 51
+	RecordTypeCDS        RecordType = 59
+	RecordTypeCDNSKEY    RecordType = 60
+	RecordTypeZONEMD     RecordType = 63
+	RecordTypeTSIG       RecordType = 250
+	RecordTypeAXFR       RecordType = 252
+	RecordTypeCAA        RecordType = 257
 )
 
 func (t RecordType) String() string {
@@ -36,22 +54,84 @@ func (t RecordType) String() string {
 		return "SOA"
 	case RecordTypeCNAME:
 		return "CNAME"
+	case RecordTypePTR:
+		return "PTR"
+	case RecordTypeMX:
+		return "MX"
 	case RecordTypeTXT:
 		return "TXT"
 	case RecordTypeAAAA:
 		return "AAAA"
+	case RecordTypeSRV:
+		return "SRV"
+	case RecordTypeOPT:
+		return "OPT"
+	case RecordTypeDS:
+		return "DS"
+	case RecordTypeRRSIG:
+		return "RRSIG"
+	case RecordTypeNSEC:
+		return "NSEC"
+	case RecordTypeDNSKEY:
+		return "DNSKEY"
+	case RecordTypeNSEC3:
+		return "NSEC3"
+	case RecordTypeNSEC3PARAM:
+		return "NSEC3PARAM"
+	case RecordTypeCDS:
+		return "CDS"
+	case RecordTypeCDNSKEY:
+		return "CDNSKEY"
+	case RecordTypeZONEMD:
+		return "ZONEMD"
+	case RecordTypeTSIG:
+		return "TSIG"
+	case RecordTypeAXFR:
+		return "AXFR"
+	case RecordTypeCAA:
+		return "CAA"
 	default:
-		panic(fmt.Errorf("unknown resource type: %d (%x)", uint16(t), uint16(t)))
+		// types without a mnemonic are written generically:
+		// https://datatracker.ietf.org/doc/html/rfc3597#section-5
+		return fmt.Sprintf("TYPE%d", uint16(t))
 	}
 }
 
+// knownRecordTypes are the record types with mnemonics.
+var knownRecordTypes = []RecordType{
+	RecordTypeA, RecordTypeNS, RecordTypeCNAME, RecordTypeSOA, RecordTypePTR,
+	RecordTypeMX, RecordTypeTXT, RecordTypeAAAA, RecordTypeSRV, RecordTypeOPT,
+	RecordTypeDS, RecordTypeRRSIG, RecordTypeNSEC, RecordTypeDNSKEY,
+	RecordTypeNSEC3, RecordTypeNSEC3PARAM, RecordTypeCDS, RecordTypeCDNSKEY,
+	RecordTypeZONEMD, RecordTypeTSIG, RecordTypeAXFR, RecordTypeCAA,
+}
+
+// ParseRecordType parses a record type from its mnemonic, e.g. "AAAA", or
+// from the generic TYPEnn form. Mnemonics are case-insensitive.
+func ParseRecordType(s string) (RecordType, error) {
+	for _, t := range knownRecordTypes {
+		if strings.EqualFold(s, t.String()) {
+			return t, nil
+		}
+	}
+	if len(s) > 4 && strings.EqualFold(s[:4], "TYPE") {
+		if n, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return RecordType(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
 // ResourceClass represents the CLASS field in a resource record:
 type ResourceClass uint16
 
 // Query classes:
 // https://datatracker.ietf.org/doc/html/rfc1035#section-3.2.4
 const (
-	ResourceClassIN ResourceClass = 1
+	ResourceClassIN  ResourceClass = 1
+	ResourceClassCH  ResourceClass = 3
+	ResourceClassHS  ResourceClass = 4
+	ResourceClassANY ResourceClass = 255
 )
 
 // "Messages carried by UDP are restricted to 512 bytes (not counting the IP or
@@ -82,6 +162,131 @@ func (h Header) Encode() []byte {
 	return out
 }
 
+// Header flag bits:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.1
+const (
+	headerFlagQR = 1 << 15 // response
+	headerFlagAA = 1 << 10 // authoritative answer
+	headerFlagTC = 1 << 9  // truncated
+	headerFlagRD = 1 << 8  // recursion desired
+	headerFlagRA = 1 << 7  // recursion available
+)
+
+// DNSSEC header flag bits:
+// https://datatracker.ietf.org/doc/html/rfc4035#section-3.2
+const (
+	headerFlagAD = 1 << 5 // authentic data
+	headerFlagCD = 1 << 4 // checking disabled
+)
+
+// QR returns the Query/Response flag, which is set in responses.
+func (h Header) QR() bool {
+	return h.Flags&headerFlagQR != 0
+}
+
+// SetQR sets or clears the Query/Response flag.
+func (h *Header) SetQR(on bool) {
+	h.setFlag(headerFlagQR, on)
+}
+
+// AA returns the Authoritative Answer flag, which a name server sets in a
+// response when it is an authority for the name in the question.
+func (h Header) AA() bool {
+	return h.Flags&headerFlagAA != 0
+}
+
+// SetAA sets or clears the Authoritative Answer flag.
+func (h *Header) SetAA(on bool) {
+	h.setFlag(headerFlagAA, on)
+}
+
+// TC returns the Truncation flag, which is set in responses that were too
+// large for the transport they were sent over.
+func (h Header) TC() bool {
+	return h.Flags&headerFlagTC != 0
+}
+
+// SetTC sets or clears the Truncation flag.
+func (h *Header) SetTC(on bool) {
+	h.setFlag(headerFlagTC, on)
+}
+
+// RD returns the Recursion Desired flag, which asks a name server to resolve
+// the query recursively.
+func (h Header) RD() bool {
+	return h.Flags&headerFlagRD != 0
+}
+
+// SetRD sets or clears the Recursion Desired flag.
+func (h *Header) SetRD(on bool) {
+	h.setFlag(headerFlagRD, on)
+}
+
+// RA returns the Recursion Available flag, which a name server sets in a
+// response if it supports recursive queries.
+func (h Header) RA() bool {
+	return h.Flags&headerFlagRA != 0
+}
+
+// SetRA sets or clears the Recursion Available flag.
+func (h *Header) SetRA(on bool) {
+	h.setFlag(headerFlagRA, on)
+}
+
+// Opcode returns the kind of query, e.g. OpcodeQuery for a standard query.
+func (h Header) Opcode() Opcode {
+	return Opcode(h.Flags >> 11 & 0b1111)
+}
+
+// SetOpcode sets the kind of query. Only the low 4 bits of opcode are used.
+func (h *Header) SetOpcode(opcode Opcode) {
+	h.Flags = h.Flags&^(0b1111<<11) | uint16(opcode&0b1111)<<11
+}
+
+// RCode returns the response code, e.g. RCodeNXDomain. Extended response
+// codes, which are carried in part by an OPT record, are not included.
+func (h Header) RCode() RCode {
+	return RCode(h.Flags & 0b1111)
+}
+
+// SetRCode sets the response code. Only the low 4 bits of rcode are used.
+func (h *Header) SetRCode(rcode RCode) {
+	h.Flags = h.Flags&^0b1111 | uint16(rcode&0b1111)
+}
+
+// AD returns the Authentic Data flag, which a validating resolver sets in a
+// response to assert that every record in it has been validated.
+func (h Header) AD() bool {
+	return h.Flags&headerFlagAD != 0
+}
+
+// SetAD sets or clears the Authentic Data flag. In a query, it asks a
+// validating resolver to report whether the response was validated.
+// https://datatracker.ietf.org/doc/html/rfc6840#section-5.7
+func (h *Header) SetAD(on bool) {
+	h.setFlag(headerFlagAD, on)
+}
+
+// CD returns the Checking Disabled flag.
+func (h Header) CD() bool {
+	return h.Flags&headerFlagCD != 0
+}
+
+// SetCD sets or clears the Checking Disabled flag, which asks a validating
+// resolver to return data without validating it, so that the requestor can
+// validate it itself.
+func (h *Header) SetCD(on bool) {
+	h.setFlag(headerFlagCD, on)
+}
+
+func (h *Header) setFlag(flag uint16, on bool) {
+	if on {
+		h.Flags |= flag
+	} else {
+		h.Flags &^= flag
+	}
+}
+
 // parseHeader parses a Header section from a slice of bytes.
 func parseHeader(v *byteview.View) (Header, error) {
 	bs, err := v.Next(12) // 12 == 2 bytes for each of the 6 header fields
@@ -162,35 +367,127 @@ func parseRecord(v *byteview.View) (Record, error) {
 	}
 
 	dataLen := binary.BigEndian.Uint16(bs[8:10])
+	dataStart := v.Offset()
+	data, err := v.Next(dataLen)
+	if err != nil {
+		return record, fmt.Errorf("parseRecord: error reading data field: %w", err)
+	}
+	record.Data = data
 
 	switch record.Type {
-	case RecordTypeNS, RecordTypeCNAME:
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
 		// https://datatracker.ietf.org/doc/html/rfc1035#section-3.3.11
-		data, err := decodeName(v)
+		dv, err := v.WithOffset(uint16(dataStart))
 		if err != nil {
-			return record, fmt.Errorf("parseRecord: error decoding data for NS record: %w", err)
+			return record, fmt.Errorf("parseRecord: %w", err)
 		}
-		record.Data = data
-	default:
-		data, err := v.Next(dataLen)
+		name, err := decodeName(dv)
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w: error decoding data for %s record: %w", errMalformedRecordData, record.Type, err)
+		}
+		if dv.Offset() != dataStart+int(dataLen) {
+			return record, fmt.Errorf("parseRecord: %w: %s record name does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		record.Data = name
+	case RecordTypeSOA:
+		// the names in SOA records may be compressed, so they are expanded
+		// to keep the record data meaningful outside of this message
+		// https://datatracker.ietf.org/doc/html/rfc1035#section-3.3.13
+		dv, err := v.WithOffset(uint16(dataStart))
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w", err)
+		}
+		var expanded []byte
+		for i := 0; i < 2; i++ { // the primary name server and responsible mailbox
+			name, err := decodeName(dv)
+			if err != nil {
+				return record, fmt.Errorf("parseRecord: %w: error decoding data for %s record: %w", errMalformedRecordData, record.Type, err)
+			}
+			expanded = append(expanded, encodeName(string(name))...)
+		}
+		fixed, err := dv.Next(20) // serial, refresh, retry, expire and minimum
+		if err != nil || dv.Offset() != dataStart+int(dataLen) {
+			return record, fmt.Errorf("parseRecord: %w: %s record does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		record.Data = append(expanded, fixed...)
+	case RecordTypeMX:
+		// likewise for the exchange in MX records
+		// https://datatracker.ietf.org/doc/html/rfc1035#section-3.3.9
+		dv, err := v.WithOffset(uint16(dataStart))
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w", err)
+		}
+		preference, err := dv.Next(2)
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w: %s record does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		exchange, err := decodeName(dv)
 		if err != nil {
-			return record, fmt.Errorf("parseRecord: error reading data field: %w", err)
+			return record, fmt.Errorf("parseRecord: %w: error decoding data for %s record: %w", errMalformedRecordData, record.Type, err)
 		}
-		record.Data = data
+		if dv.Offset() != dataStart+int(dataLen) {
+			return record, fmt.Errorf("parseRecord: %w: %s record does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		record.Data = append([]byte{preference[0], preference[1]}, encodeName(string(exchange))...)
+	case RecordTypeSRV:
+		// the target of SRV records must not be compressed, but is by some
+		// implementations, notably mDNS responders
+		// https://datatracker.ietf.org/doc/html/rfc6762#section-18.14
+		dv, err := v.WithOffset(uint16(dataStart))
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w", err)
+		}
+		fixed, err := dv.Next(6) // priority, weight and port
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w: %s record does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		target, err := decodeName(dv)
+		if err != nil {
+			return record, fmt.Errorf("parseRecord: %w: error decoding data for %s record: %w", errMalformedRecordData, record.Type, err)
+		}
+		if dv.Offset() != dataStart+int(dataLen) {
+			return record, fmt.Errorf("parseRecord: %w: %s record does not match data length %d", errMalformedRecordData, record.Type, dataLen)
+		}
+		record.Data = append(append([]byte(nil), fixed...), encodeName(string(target))...)
 	}
 
 	return record, nil
 }
 
+// Encode encodes a Record as bytes in network order, without name
+// compression. Names in the data of NS, CNAME and PTR records are stored in
+// decoded form and encoded here, while the data of other records, including
+// the names in SOA records, is already in wire format.
+func (r Record) Encode() []byte {
+	data := r.Data
+	if r.Type == RecordTypeNS || r.Type == RecordTypeCNAME || r.Type == RecordTypePTR {
+		data = encodeName(string(r.Data))
+	}
+	name := encodeName(string(r.Name))
+	out := make([]byte, 0, len(name)+10+len(data)) // 10 == 2 bytes each for type, class, data length and 4 bytes for TTL
+	out = append(out, name...)
+	out = binary.BigEndian.AppendUint16(out, uint16(r.Type))
+	out = binary.BigEndian.AppendUint16(out, uint16(r.Class))
+	out = binary.BigEndian.AppendUint32(out, r.TTL)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)))
+	out = append(out, data...)
+	return out
+}
+
 // Query defines a DNS query message.
 type Query struct {
-	Header   Header
-	Question Question
+	Header      Header
+	Question    Question
+	Additionals []Record
 }
 
 // NewQuery creates a new DNS query message for the given domain name and
-// record type.
+// record type. Unicode labels in the name are sent as A-labels (see
+// ToASCII).
 func NewQuery(domainName string, recordType RecordType) Query {
+	if ascii, err := ToASCII(domainName); err == nil {
+		domainName = ascii
+	}
 	return newQueryHelper(domainName, recordType, uint16(rand.Intn(math.MaxUint16+1)))
 }
 
@@ -212,11 +509,22 @@ func newQueryHelper(domainName string, recordType RecordType, id uint16) Query {
 
 // Encode encodes a DNS query as bytes in network order.
 func (q Query) Encode() []byte {
-	headerBytes := q.Header.Encode()
+	header := q.Header
+	header.AdditionalCount = uint16(len(q.Additionals))
+	headerBytes := header.Encode()
 	questionBytes := q.Question.Encode()
-	out := make([]byte, 0, len(headerBytes)+len(questionBytes))
+	size := len(headerBytes) + len(questionBytes)
+	additionalBytes := make([][]byte, len(q.Additionals))
+	for i, r := range q.Additionals {
+		additionalBytes[i] = r.Encode()
+		size += len(additionalBytes[i])
+	}
+	out := make([]byte, 0, size)
 	out = append(out, headerBytes...)
 	out = append(out, questionBytes...)
+	for _, bs := range additionalBytes {
+		out = append(out, bs...)
+	}
 	return out
 }
 
@@ -230,61 +538,370 @@ type Message struct {
 	Additionals []Record
 }
 
+// Encode encodes a DNS message as bytes in network order, with any number of
+// questions and records in each section. The header's section counts are set
+// from the message's contents. Unlike the Question in a Query, the names of
+// the message's questions are given in decoded form, as in parsed messages.
+// Owner names, and names in the data of NS, CNAME and PTR records, are
+// compressed using pointers to earlier occurrences of the same suffix:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.4
+//
+// Questions and records with names that cannot be encoded, such as names
+// with labels longer than 63 bytes, are left out of the encoded message and
+// its section counts. Validate reports them.
+func (m Message) Encode() []byte {
+	questions := encodableQuestions(m.Questions)
+	answers := encodableRecords(m.Answers)
+	authorities := encodableRecords(m.Authorities)
+	additionals := encodableRecords(m.Additionals)
+
+	header := m.Header
+	header.QuestionCount = uint16(len(questions))
+	header.AnswerCount = uint16(len(answers))
+	header.AuthorityCount = uint16(len(authorities))
+	header.AdditionalCount = uint16(len(additionals))
+	c := nameCompressor{out: header.Encode(), offsets: make(map[string]int)}
+	for _, q := range questions {
+		c.appendName(string(q.Name))
+		c.out = binary.BigEndian.AppendUint16(c.out, uint16(q.Type))
+		c.out = binary.BigEndian.AppendUint16(c.out, uint16(q.Class))
+	}
+	for _, section := range [][]Record{answers, authorities, additionals} {
+		for _, r := range section {
+			c.appendRecord(r)
+		}
+	}
+	return c.out
+}
+
+// encodableQuestions returns the questions whose names can be encoded,
+// which is usually all of them.
+func encodableQuestions(questions []Question) []Question {
+	for i, q := range questions {
+		if validateName(string(q.Name)) != nil {
+			valid := append([]Question(nil), questions[:i]...)
+			for _, q := range questions[i+1:] {
+				if validateName(string(q.Name)) == nil {
+					valid = append(valid, q)
+				}
+			}
+			return valid
+		}
+	}
+	return questions
+}
+
+// encodableRecords returns the records whose names can be encoded, which is
+// usually all of them.
+func encodableRecords(records []Record) []Record {
+	for i, r := range records {
+		if !recordNamesValid(r) {
+			valid := append([]Record(nil), records[:i]...)
+			for _, r := range records[i+1:] {
+				if recordNamesValid(r) {
+					valid = append(valid, r)
+				}
+			}
+			return valid
+		}
+	}
+	return records
+}
+
+// recordNamesValid reports whether the record's owner name, and any name
+// stored in decoded form in its data, can be encoded.
+func recordNamesValid(r Record) bool {
+	if validateName(string(r.Name)) != nil {
+		return false
+	}
+	switch r.Type {
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		return validateName(string(r.Data)) == nil
+	}
+	return true
+}
+
+// maxCompressionOffset is the largest message offset that a compression
+// pointer can refer to, since pointers hold 14 bit offsets.
+const maxCompressionOffset = 0x3fff
+
+// nameCompressor encodes names into a message, replacing each suffix that
+// has already been written with a pointer to its earlier occurrence.
+type nameCompressor struct {
+	out     []byte
+	offsets map[string]int // the offset of each encoded suffix written so far
+}
+
+// appendName appends the given decoded name to the message, compressing it
+// if possible. Suffixes are matched case sensitively, so that names decode
+// with the same case they were encoded with. The name must already have been
+// checked with validateName.
+func (c *nameCompressor) appendName(name string) {
+	wire := encodeName(name)
+	for i := 0; wire[i] != 0; i += 1 + int(wire[i]) {
+		suffix := string(wire[i:])
+		if offset, ok := c.offsets[suffix]; ok {
+			c.out = binary.BigEndian.AppendUint16(c.out, 0b1100_0000<<8|uint16(offset))
+			return
+		}
+		if len(c.out) <= maxCompressionOffset {
+			c.offsets[suffix] = len(c.out)
+		}
+		c.out = append(c.out, wire[i:i+1+int(wire[i])]...)
+	}
+	c.out = append(c.out, 0x0)
+}
+
+// appendRecord appends the given record to the message. The data length is
+// only known once the data has been written, since names in the data of NS,
+// CNAME and PTR records may be compressed.
+func (c *nameCompressor) appendRecord(r Record) {
+	c.appendName(string(r.Name))
+	c.out = binary.BigEndian.AppendUint16(c.out, uint16(r.Type))
+	c.out = binary.BigEndian.AppendUint16(c.out, uint16(r.Class))
+	c.out = binary.BigEndian.AppendUint32(c.out, r.TTL)
+	lengthOffset := len(c.out)
+	c.out = append(c.out, 0, 0)
+	switch r.Type {
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		// names in these records' data are stored in decoded form
+		c.appendName(string(r.Data))
+	default:
+		c.out = append(c.out, r.Data...)
+	}
+	binary.BigEndian.PutUint16(c.out[lengthOffset:], uint16(len(c.out)-lengthOffset-2))
+}
+
+// ParseMode controls how malformed messages are handled when parsing.
+type ParseMode int
+
+// Supported parse modes
+const (
+	// ParseStrict rejects a message entirely if any part of it is
+	// malformed.
+	ParseStrict ParseMode = iota
+
+	// ParseLenient salvages as much of a malformed message as possible,
+	// returning the questions and records that were successfully parsed
+	// along with a *PartialMessageError describing the rest.
+	ParseLenient
+)
+
+// RecordParseError describes a question or record that could not be parsed.
+type RecordParseError struct {
+	Section string // "question", "answer", "authority" or "additional"
+	Index   int    // index of the question or record within its section
+	Offset  int    // offset of the question or record within the message
+	Err     error
+}
+
+func (e RecordParseError) Error() string {
+	return fmt.Sprintf("%s %d at offset %d: %s", e.Section, e.Index, e.Offset, e.Err)
+}
+
+func (e RecordParseError) Unwrap() error {
+	return e.Err
+}
+
+// PartialMessageError is returned when parsing in ParseLenient mode if any
+// part of a message could not be parsed. Records whose data is malformed are
+// skipped, but if a record's boundaries cannot be determined, the rest of
+// the message is unreadable and parsing stops there.
+type PartialMessageError struct {
+	Errors []RecordParseError
+}
+
+func (e *PartialMessageError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "partially parsed message: " + strings.Join(msgs, "; ")
+}
+
+// errMalformedRecordData indicates that a record's data could not be parsed,
+// although the record's boundaries are known and parsing may continue with
+// the next record.
+var errMalformedRecordData = errors.New("malformed record data")
+
+// ParseMessage parses a DNS message from its wire format, in the given mode.
+func ParseMessage(data []byte, mode ParseMode) (Message, error) {
+	return parseMessageMode(byteview.New(data), mode)
+}
+
+// ParseHeader parses the header at the start of a DNS message.
+func ParseHeader(msg []byte) (Header, error) {
+	return parseHeader(byteview.New(msg))
+}
+
+// ParseQuestion parses the question at the given offset in a DNS message,
+// returning it along with the offset just past it. The whole message is
+// needed to expand compressed names.
+func ParseQuestion(msg []byte, offset int) (Question, int, error) {
+	v, err := viewAt(msg, offset)
+	if err != nil {
+		return Question{}, offset, err
+	}
+	q, err := parseQuestion(v)
+	return q, v.Offset(), err
+}
+
+// ParseRecord parses the resource record at the given offset in a DNS
+// message, returning it along with the offset just past it. The whole
+// message is needed to expand compressed names.
+func ParseRecord(msg []byte, offset int) (Record, int, error) {
+	v, err := viewAt(msg, offset)
+	if err != nil {
+		return Record{}, offset, err
+	}
+	rec, err := parseRecord(v)
+	return rec, v.Offset(), err
+}
+
+// ParseName decodes the possibly compressed name at the given offset in a
+// DNS message, returning it without a trailing dot along with the offset
+// just past it.
+func ParseName(msg []byte, offset int) (string, int, error) {
+	v, err := viewAt(msg, offset)
+	if err != nil {
+		return "", offset, err
+	}
+	name, err := decodeName(v)
+	return string(name), v.Offset(), err
+}
+
+// EncodeName encodes a name in wire format, without compression.
+func EncodeName(name string) ([]byte, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	return encodeName(name), nil
+}
+
+// viewAt returns a view of a DNS message starting at the given offset.
+func viewAt(msg []byte, offset int) (*byteview.View, error) {
+	if offset < 0 || offset > len(msg) || len(msg) > 0xffff {
+		return nil, fmt.Errorf("invalid offset %d into message of %d bytes", offset, len(msg))
+	}
+	return byteview.New(msg).WithOffset(uint16(offset))
+}
+
 func parseMessage(v *byteview.View) (Message, error) {
+	return parseMessageMode(v, ParseStrict)
+}
+
+func parseMessageMode(v *byteview.View, mode ParseMode) (Message, error) {
 	header, err := parseHeader(v)
 	if err != nil {
 		return Message{}, err
 	}
 
-	questions := make([]Question, header.QuestionCount)
+	var (
+		msg     = Message{Header: header}
+		errs    []RecordParseError
+		stopped bool
+	)
+	// fail handles an error parsing part of the message, returning true if
+	// parsing should continue with the next question or record
+	fail := func(section string, index, offset int, err error) bool {
+		if mode == ParseStrict {
+			return false
+		}
+		errs = append(errs, RecordParseError{Section: section, Index: index, Offset: offset, Err: err})
+		stopped = !errors.Is(err, errMalformedRecordData)
+		return !stopped
+	}
+
+	msg.Questions = make([]Question, 0, header.QuestionCount)
 	for i := 0; i < int(header.QuestionCount); i++ {
+		offset := v.Offset()
 		question, err := parseQuestion(v)
 		if err != nil {
-			return Message{}, err
+			if fail("question", i, offset, err) {
+				continue
+			}
+			if mode == ParseStrict {
+				return Message{}, err
+			}
+			break
 		}
-		questions[i] = question
+		msg.Questions = append(msg.Questions, question)
 	}
 
-	answers := make([]Record, header.AnswerCount)
-	for i := 0; i < int(header.AnswerCount); i++ {
-		rec, err := parseRecord(v)
-		if err != nil {
-			return Message{}, err
+	sections := []struct {
+		name    string
+		count   uint16
+		records *[]Record
+	}{
+		{"answer", header.AnswerCount, &msg.Answers},
+		{"authority", header.AuthorityCount, &msg.Authorities},
+		{"additional", header.AdditionalCount, &msg.Additionals},
+	}
+	for _, section := range sections {
+		*section.records = make([]Record, 0, section.count)
+		for i := 0; i < int(section.count) && !stopped; i++ {
+			offset := v.Offset()
+			rec, err := parseRecord(v)
+			if err != nil {
+				if fail(section.name, i, offset, err) {
+					continue
+				}
+				if mode == ParseStrict {
+					return Message{}, err
+				}
+				break
+			}
+			*section.records = append(*section.records, rec)
 		}
-		answers[i] = rec
 	}
 
-	authorities := make([]Record, header.AuthorityCount)
-	for i := 0; i < int(header.AuthorityCount); i++ {
-		rec, err := parseRecord(v)
-		if err != nil {
-			return Message{}, err
-		}
-		authorities[i] = rec
+	if len(errs) > 0 {
+		return msg, &PartialMessageError{Errors: errs}
 	}
+	return msg, nil
+}
 
-	additionals := make([]Record, header.AdditionalCount)
-	for i := 0; i < int(header.AdditionalCount); i++ {
-		rec, err := parseRecord(v)
-		if err != nil {
-			return Message{}, err
+// Name length limits:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-2.3.4
+const (
+	maxLabelLength = 63
+	maxNameLength  = 255 // in wire format, including length octets
+)
+
+// validateName ensures that a domain name can be encoded, with labels of 1
+// to 63 bytes and an encoded length of at most 255 bytes. The root name (""
+// or ".") is valid.
+func validateName(name string) error {
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" {
+		return nil
+	}
+	wireLen := 1 // the terminating root label
+	for _, label := range strings.Split(trimmed, ".") {
+		if label == "" {
+			return fmt.Errorf("%w %q: empty label", ErrInvalidName, name)
 		}
-		additionals[i] = rec
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("%w %q: label %q is longer than %d bytes", ErrInvalidName, name, label, maxLabelLength)
+		}
+		wireLen += 1 + len(label)
 	}
-
-	return Message{
-		Header:      header,
-		Questions:   questions,
-		Answers:     answers,
-		Authorities: authorities,
-		Additionals: additionals,
-	}, nil
+	if wireLen > maxNameLength {
+		return fmt.Errorf("%w %q: encoded length %d exceeds %d bytes", ErrInvalidName, name, wireLen, maxNameLength)
+	}
+	return nil
 }
 
 // encodeName encodes a DNS name by splitting it into parts and prefixing each
 // part with its length and appending a nul byte, so "google.com" is encoded as
-// "6 google 3 com 0".
+// "6 google 3 com 0". The root name ("" or ".") is encoded as a single nul
+// byte. Names should first be checked with validateName, since overlong
+// labels cannot be encoded correctly.
 func encodeName(name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0x0}
+	}
 	parts := strings.Split(name, ".")
 	result := make([]byte, 0, len(name)+len(parts))
 	for _, part := range parts {
@@ -295,9 +912,23 @@ func encodeName(name string) []byte {
 	return result
 }
 
-// decodeName decodes a DNS name, optionally handling compression.
+// maxNamePointers bounds the number of compression pointers followed while
+// decoding a single name.
+const maxNamePointers = 16
+
+// decodeName decodes a DNS name, optionally handling compression. To guard
+// against malicious messages, each compression pointer must refer to an
+// offset before the one it was found in, at most maxNamePointers pointers
+// are followed, and the decoded name may be at most maxNameLength bytes in
+// wire format.
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.4
 func decodeName(v *byteview.View) ([]byte, error) {
-	var parts [][]byte
+	var (
+		parts    [][]byte
+		wireLen  = 1 // the terminating root label
+		pointers int
+		start    = v.Offset() // where the current run of labels began
+	)
 	for {
 		length, err := v.NextByte()
 		if err != nil {
@@ -309,48 +940,43 @@ func decodeName(v *byteview.View) ([]byte, error) {
 			break
 		}
 
-		// for compressed names, we need to decode the pointer to an earlier
-		// offset in the same message where the name can be found.
-		if isCompressed, pointerOffset, err := checkNameCompression(length, v); err != nil {
-			return nil, fmt.Errorf("decodeName: error checking for name compression: %w", err)
-		} else if isCompressed {
-			v2, err := v.WithOffset(pointerOffset)
+		switch length & 0b1100_0000 {
+		case 0b0000_0000:
+			part, err := v.Next(uint16(length))
 			if err != nil {
-				return nil, fmt.Errorf("decodeName: invalid pointer offset %v: %w", pointerOffset, err)
+				return nil, fmt.Errorf("decodeName: error reading name part: %w", err)
 			}
-			part, err := decodeName(v2)
-			if err != nil {
-				return nil, fmt.Errorf("decodeName: error decoding compressed name at offset %v: %w", pointerOffset, err)
+			if wireLen += 1 + len(part); wireLen > maxNameLength {
+				return nil, fmt.Errorf("decodeName: name exceeds %d bytes", maxNameLength)
 			}
 			parts = append(parts, part)
-			break
-		} else {
-			part, err := v.Next(uint16(length))
+		case 0b1100_0000:
+			// for compressed names, we need to decode the pointer to an
+			// earlier offset in the same message where the rest of the name
+			// can be found.
+			b, err := v.NextByte()
 			if err != nil {
-				return nil, fmt.Errorf("decodeName: error reading name part: %w", err)
+				return nil, fmt.Errorf("decodeName: error reading pointer: %w", err)
 			}
-			parts = append(parts, part)
+			pointerOffset := binary.BigEndian.Uint16([]byte{length & 0b0011_1111, b})
+			if pointers++; pointers > maxNamePointers {
+				return nil, fmt.Errorf("decodeName: too many compression pointers (%d)", maxNamePointers)
+			}
+			if int(pointerOffset) >= start {
+				return nil, fmt.Errorf("decodeName: compression pointer to offset %d does not point backwards from offset %d", pointerOffset, start)
+			}
+			v, err = v.WithOffset(pointerOffset)
+			if err != nil {
+				return nil, fmt.Errorf("decodeName: invalid pointer offset %v: %w", pointerOffset, err)
+			}
+			start = int(pointerOffset)
+		default:
+			return nil, fmt.Errorf("decodeName: unsupported label type %#b", length>>6)
 		}
 	}
 	return bytes.Join(parts, []byte(".")), nil
 }
 
-// checkNameCompression checks whether the given length indicates that name
-// compression is being used. If so, another byte is read from the view in
-// order to compute the offset where the referenced name can be found.
-func checkNameCompression(length byte, v *byteview.View) (isCompressed bool, pointerOffset uint16, err error) {
-	if length&0b1100_0000 != 0 {
-		// https://datatracker.ietf.org/doc/html/rfc1035#section-4.1.4
-		b, err := v.NextByte()
-		if err != nil {
-			return false, 0, err
-		}
-		pointerOffset = binary.BigEndian.Uint16([]byte{byte(length & 0b0011_1111), b})
-		return true, pointerOffset, nil
-	}
-	return false, 0, nil
-}
-
 // parseIPAddrs parses one or more net.IP addresses from a slice of bytes,
 // which should encode IPv4 or IPv6 addresses depending on the given record
 // type.
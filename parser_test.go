@@ -1,9 +1,11 @@
 package dnstoy
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/carlmjohnson/be"
@@ -86,6 +88,76 @@ func TestDecodeName(t *testing.T) {
 	be.Equal(t, want, string(got))
 }
 
+func TestDecodeNameCompression(t *testing.T) {
+	t.Parallel()
+
+	// a chain of pointers, each pointing to the one before it
+	chain, last := []byte{0}, 0
+	for i := 0; i <= maxNamePointers; i++ {
+		chain, last = append(chain, 0xc0, byte(last)), len(chain)
+	}
+
+	testCases := map[string]struct {
+		data    string
+		offset  uint16
+		want    string
+		wantErr string
+	}{
+		"compressed": {
+			data:   "\x07example\x03com\x00\x03www\xc0\x00",
+			offset: 13,
+			want:   "www.example.com",
+		},
+		"pointer to pointer": {
+			data:   "\x03com\x00\x07example\xc0\x00\x03www\xc0\x05",
+			offset: 15,
+			want:   "www.example.com",
+		},
+		"self pointer": {
+			data:    "\xc0\x00",
+			wantErr: "compression pointer to offset 0 does not point backwards from offset 0",
+		},
+		"forward pointer": {
+			data:    "\x03www\xc0\x06\x03com\x00",
+			wantErr: "compression pointer to offset 6 does not point backwards from offset 0",
+		},
+		"pointer loop": {
+			data:    "\x03www\xc0\x06\x03com\xc0\x00",
+			offset:  6,
+			wantErr: "compression pointer to offset 6 does not point backwards from offset 0",
+		},
+		"too many pointers": {
+			data:    string(chain),
+			offset:  uint16(last),
+			wantErr: "too many compression pointers",
+		},
+		"name too long": {
+			data:    strings.Repeat("\x3f"+strings.Repeat("a", 63), 4) + "\x00",
+			wantErr: "name exceeds 255 bytes",
+		},
+		"reserved label type": {
+			data:    "\x40abc\x00",
+			wantErr: "unsupported label type",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			v, err := byteview.FromString(tc.data).WithOffset(tc.offset)
+			be.NilErr(t, err)
+			got, err := decodeName(v)
+			if tc.wantErr != "" {
+				be.Nonzero(t, err)
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
 func TestParseRecord(t *testing.T) {
 	resp := byteview.FromString("`V\x81\x80\x00\x01\x00\x01\x00\x00\x00\x00\x03www\x07example\x03com\x00\x00\x01\x00\x01\xc0\x0c\x00\x01\x00\x01\x00\x00R\x9b\x00\x04]\xb8\xd8\"")
 
@@ -107,6 +179,60 @@ func TestParseRecord(t *testing.T) {
 	be.DeepEqual(t, want, got)
 }
 
+func TestRecordEncode(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]Record{
+		"A":     {Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+		"NS":    {Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 300, Data: []byte("ns1.example.com")},
+		"CNAME": {Name: []byte("www.example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("example.com")},
+		"SOA":   soaRecord("example.com", 2023010101),
+		"root":  {Name: []byte(""), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("a.root-servers.net")},
+	}
+	for name, rec := range testCases {
+		rec := rec
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseRecord(byteview.New(rec.Encode()))
+			be.NilErr(t, err)
+			be.DeepEqual(t, rec, got)
+		})
+	}
+
+	// names in the data of NS records are encoded, rather than copied
+	encoded := testCases["NS"].Encode()
+	be.Equal(t, "\x03ns1\x07example\x03com\x00", string(encoded[len(encoded)-17:]))
+}
+
+func TestParseRecordExpandsSOANames(t *testing.T) {
+	t.Parallel()
+
+	// an SOA record whose owner and names are compressed against the
+	// question name
+	resp := []byte("\x00\x01\x81\x80\x00\x01\x00\x00\x00\x01\x00\x00\x07example\x03com\x00\x00\x06\x00\x01")
+	resp = append(resp, 0xc0, 0x0c, 0x00, 0x06, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 6+13+20)
+	resp = append(resp, "\x03ns1\xc0\x0c\x0ahostmaster\xc0\x0c"...)
+	resp = binary.BigEndian.AppendUint32(resp, 2023010101)
+	for _, v := range []uint32{3600, 600, 86400, 300} {
+		resp = binary.BigEndian.AppendUint32(resp, v)
+	}
+
+	msg, err := parseMessage(byteview.New(resp))
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(msg.Authorities))
+	want := soaRecord("example.com", 2023010101)
+	be.DeepEqual(t, want.Data, msg.Authorities[0].Data)
+	serial, err := soaSerial(msg.Authorities[0].Data)
+	be.NilErr(t, err)
+	be.Equal(t, uint32(2023010101), serial)
+
+	// data that does not match its length is rejected
+	resp[len(resp)-20-13-6-1]++
+	resp = append(resp, 0)
+	_, err = parseMessage(byteview.New(resp))
+	be.Nonzero(t, err)
+}
+
 func TestParseMessage(t *testing.T) {
 	t.Parallel()
 
@@ -246,3 +372,351 @@ func TestParseIPAddrs(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeQueryWithEDNS(t *testing.T) {
+	query := newQueryHelper("google.com", RecordTypeA, 1)
+	query.AddEDNS(1232, EDNSOption{Code: EDNSOptionNSID})
+
+	got := query.Encode()
+	want := "\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x01\x06google\x03com\x00\x00\x01\x00\x01" +
+		"\x00\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x04\x00\x03\x00\x00"
+	be.Equal(t, want, string(got))
+	be.Equal(t, len(got), cap(got)) // ensure we compute correct output size
+	be.Equal(t, 1232, query.maxResponseSize())
+}
+
+func TestEncodeMessage(t *testing.T) {
+	msg := Message{
+		Header: Header{ID: 1, Flags: headerFlagQR},
+		Questions: []Question{
+			{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN},
+			{Name: []byte("example.com"), Type: RecordTypeAAAA, Class: ResourceClassIN},
+		},
+		Answers: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("www.example.com")},
+			{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+		},
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 300, Data: []byte("ns1.example.com")},
+		},
+		Additionals: []Record{
+			{Name: []byte("ns1.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{5, 6, 7, 8}},
+		},
+	}
+
+	got, err := parseMessage(byteview.New(msg.Encode()))
+	be.NilErr(t, err)
+	want := msg
+	want.Header.QuestionCount = 2
+	want.Header.AnswerCount = 2
+	want.Header.AuthorityCount = 1
+	want.Header.AdditionalCount = 1
+	be.Equal(t, fmt.Sprintf("%+v", want), fmt.Sprintf("%+v", got))
+}
+
+func TestEncodeMessageCompression(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Header:    Header{ID: 1, Flags: headerFlagQR},
+		Questions: []Question{{Name: []byte("www.example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN}},
+		Answers: []Record{
+			{Name: []byte("www.example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("web.example.com")},
+		},
+		Authorities: []Record{
+			{Name: []byte("EXAMPLE.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns1.example.com")},
+		},
+	}
+	encoded := msg.Encode()
+	const headerSize = 12
+
+	// the answer's owner name is a single pointer to the question's name,
+	// and the CNAME's target is a new label followed by a pointer
+	answerStart := headerSize + len(encodeName("www.example.com")) + 4
+	be.DeepEqual(t, []byte{0xc0, headerSize}, encoded[answerStart:answerStart+2])
+	dataStart := answerStart + 2 + 10
+	be.DeepEqual(t, []byte{3, 'w', 'e', 'b', 0xc0, headerSize + 4}, encoded[dataStart:dataStart+6])
+	be.Equal(t, 6, int(binary.BigEndian.Uint16(encoded[dataStart-2:dataStart])))
+
+	// names are only compressed against suffixes with the same case
+	be.In(t, "\x07EXAMPLE", string(encoded))
+
+	got, err := parseMessage(byteview.New(encoded))
+	be.NilErr(t, err)
+	be.Equal(t, "www.example.com", string(got.Answers[0].Name))
+	be.Equal(t, "web.example.com", string(got.Answers[0].Data))
+	be.Equal(t, "EXAMPLE.com", string(got.Authorities[0].Name))
+	be.Equal(t, "ns1.example.com", string(got.Authorities[0].Data))
+}
+
+func TestEncodeMessageCompressionLimit(t *testing.T) {
+	t.Parallel()
+
+	// suffixes first written beyond the offsets that pointers can refer to
+	// are not used as pointer targets
+	msg := Message{Header: Header{ID: 1, Flags: headerFlagQR}}
+	for i := 0; i < 1000; i++ {
+		msg.Answers = append(msg.Answers, Record{Name: []byte(fmt.Sprintf("host%d.example.com", i)), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}})
+	}
+	encoded := msg.Encode()
+	be.True(t, len(encoded) > maxCompressionOffset)
+	got, err := parseMessage(byteview.New(encoded))
+	be.NilErr(t, err)
+	be.Equal(t, 1000, len(got.Answers))
+	for i, rec := range got.Answers {
+		be.Equal(t, fmt.Sprintf("host%d.example.com", i), string(rec.Name))
+	}
+}
+
+func TestEncodeMessageInvalidNames(t *testing.T) {
+	t.Parallel()
+
+	label64 := strings.Repeat("a", 64)
+	label300 := strings.Repeat("b", 300)
+	testCases := map[string]Message{
+		"64 byte question label": {
+			Questions: []Question{{Name: []byte(label64 + ".example.com"), Type: RecordTypeA, Class: ResourceClassIN}},
+		},
+		"300 byte owner label": {
+			Answers: []Record{{Name: []byte(label300 + ".example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{5, 6, 7, 8}}},
+		},
+		"64 byte CNAME target label": {
+			Answers: []Record{{Name: []byte("example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte(label64 + ".example.com")}},
+		},
+		"300 byte NS label": {
+			Authorities: []Record{{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte(label300)}},
+		},
+	}
+	for name, invalid := range testCases {
+		invalid := invalid
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// the invalid entries are left out, and the rest of the message
+			// still parses
+			msg := Message{
+				Header:      Header{ID: 1, Flags: headerFlagQR},
+				Questions:   append([]Question{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN}}, invalid.Questions...),
+				Answers:     append([]Record{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}, invalid.Answers...),
+				Authorities: invalid.Authorities,
+			}
+			be.Nonzero(t, len(msg.Validate()))
+			got, err := parseMessage(byteview.New(msg.Encode()))
+			be.NilErr(t, err)
+			be.Equal(t, 1, len(got.Questions))
+			be.Equal(t, "example.com", string(got.Questions[0].Name))
+			be.Equal(t, 1, len(got.Answers))
+			be.DeepEqual(t, []byte{1, 2, 3, 4}, got.Answers[0].Data)
+			be.Equal(t, 0, len(got.Authorities))
+			be.Equal(t, 0, len(got.Validate()))
+		})
+	}
+}
+
+func TestParseMessageLenient(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Header:    Header{ID: 1, Flags: headerFlagQR},
+		Questions: []Question{{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN}},
+		Answers: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+		},
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns1.example.com")},
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns2.example.com")},
+		},
+	}
+	encoded := msg.Encode()
+	// corrupt the first NS record's data with a forward compression pointer
+	i := strings.Index(string(encoded), "\x03ns1")
+	encoded[i], encoded[i+1] = 0xc0, 0xff
+
+	t.Run("strict", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseMessage(encoded, ParseStrict)
+		be.In(t, "compression pointer", err.Error())
+		var partialErr *PartialMessageError
+		be.False(t, errors.As(err, &partialErr))
+	})
+
+	t.Run("lenient skips malformed record data", func(t *testing.T) {
+		t.Parallel()
+		got, err := ParseMessage(encoded, ParseLenient)
+		var partialErr *PartialMessageError
+		be.True(t, errors.As(err, &partialErr))
+		be.Equal(t, 1, len(partialErr.Errors))
+		be.Equal(t, "authority", partialErr.Errors[0].Section)
+		be.Equal(t, 0, partialErr.Errors[0].Index)
+		be.Equal(t, 1, len(got.Questions))
+		be.Equal(t, 1, len(got.Answers))
+		be.Equal(t, 1, len(got.Authorities))
+		be.Equal(t, "ns2.example.com", string(got.Authorities[0].Data))
+	})
+
+	t.Run("lenient stops at truncation", func(t *testing.T) {
+		t.Parallel()
+		got, err := ParseMessage(encoded[:i-4], ParseLenient)
+		var partialErr *PartialMessageError
+		be.True(t, errors.As(err, &partialErr))
+		be.Equal(t, 1, len(partialErr.Errors))
+		be.Equal(t, 1, len(got.Answers))
+		be.Equal(t, 0, len(got.Authorities))
+	})
+
+	t.Run("lenient without errors", func(t *testing.T) {
+		t.Parallel()
+		got, err := ParseMessage(msg.Encode(), ParseLenient)
+		be.NilErr(t, err)
+		be.Equal(t, 2, len(got.Authorities))
+	})
+}
+
+func TestHeaderDNSSECFlags(t *testing.T) {
+	t.Parallel()
+
+	h := Header{Flags: headerFlagRD}
+	be.False(t, h.AD())
+	be.False(t, h.CD())
+	h.SetAD(true)
+	h.SetCD(true)
+	be.True(t, h.AD())
+	be.True(t, h.CD())
+	be.Equal(t, headerFlagRD|headerFlagAD|headerFlagCD, h.Flags)
+	h.SetAD(false)
+	be.False(t, h.AD())
+	be.True(t, h.CD())
+}
+
+func TestHeaderFlags(t *testing.T) {
+	t.Parallel()
+
+	flags := map[string]struct {
+		get func(Header) bool
+		set func(*Header, bool)
+		bit uint16
+	}{
+		"QR": {Header.QR, (*Header).SetQR, headerFlagQR},
+		"AA": {Header.AA, (*Header).SetAA, headerFlagAA},
+		"TC": {Header.TC, (*Header).SetTC, headerFlagTC},
+		"RD": {Header.RD, (*Header).SetRD, headerFlagRD},
+		"RA": {Header.RA, (*Header).SetRA, headerFlagRA},
+		"AD": {Header.AD, (*Header).SetAD, headerFlagAD},
+		"CD": {Header.CD, (*Header).SetCD, headerFlagCD},
+	}
+	for name, flag := range flags {
+		flag := flag
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var h Header
+			h.SetOpcode(OpcodeUpdate)
+			h.SetRCode(RCodeRefused)
+			flag.set(&h, true)
+			be.True(t, flag.get(h))
+			be.Equal(t, uint16(OpcodeUpdate)<<11|uint16(RCodeRefused)|flag.bit, h.Flags)
+			flag.set(&h, false)
+			be.True(t, !flag.get(h))
+
+			// setting a flag leaves the opcode and rcode alone
+			be.Equal(t, OpcodeUpdate, h.Opcode())
+			be.Equal(t, RCodeRefused, h.RCode())
+		})
+	}
+
+	// only the low 4 bits of the opcode and rcode are used
+	var h Header
+	h.SetQR(true)
+	h.SetOpcode(0xff)
+	h.SetRCode(0xff)
+	be.Equal(t, uint16(0xffff&^0b0000_0111_1111_0000), h.Flags)
+	h.SetOpcode(0)
+	h.SetRCode(RCodeNXDomain)
+	be.Equal(t, headerFlagQR|uint16(RCodeNXDomain), h.Flags)
+}
+
+func TestQuerySetDO(t *testing.T) {
+	t.Parallel()
+
+	query := newQueryHelper("example.com", RecordTypeA, 1)
+	be.False(t, query.DO())
+
+	// clearing the flag does not add an OPT record
+	query.SetDO(false)
+	be.Equal(t, 0, len(query.Additionals))
+
+	// setting it does, if necessary
+	query.SetDO(true)
+	be.True(t, query.DO())
+	be.Equal(t, 1, len(query.Additionals))
+	be.Equal(t, ednsUDPSize, query.maxResponseSize())
+
+	query.SetDO(false)
+	be.False(t, query.DO())
+	be.Equal(t, 1, len(query.Additionals))
+}
+
+func TestMessageNSID(t *testing.T) {
+	testCases := map[string]struct {
+		additionals []Record
+		wantNSID    string
+		wantFound   bool
+	}{
+		"printable nsid": {
+			additionals: []Record{newOPTRecord(1232, EDNSOption{Code: EDNSOptionNSID, Data: []byte("b4.lax")})},
+			wantNSID:    "b4.lax",
+			wantFound:   true,
+		},
+		"binary nsid": {
+			additionals: []Record{newOPTRecord(1232, EDNSOption{Code: EDNSOptionNSID, Data: []byte{0xde, 0xad}})},
+			wantNSID:    "dead",
+			wantFound:   true,
+		},
+		"no nsid option": {
+			additionals: []Record{newOPTRecord(1232)},
+		},
+		"no opt record": {},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			msg := Message{Additionals: tc.additionals}
+			nsid, found := msg.NSID()
+			be.Equal(t, tc.wantFound, found)
+			be.Equal(t, tc.wantNSID, nsid)
+		})
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	t.Parallel()
+
+	label63 := strings.Repeat("a", 63)
+	testCases := map[string]struct {
+		name    string
+		wantErr string
+	}{
+		"simple":       {name: "www.example.com"},
+		"trailing dot": {name: "www.example.com."},
+		"root":         {name: "."},
+		"empty":        {name: ""},
+		"max label":    {name: label63 + ".com"},
+		"max length":   {name: strings.Join([]string{label63, label63, label63, strings.Repeat("a", 61)}, ".")},
+		"long label":   {name: label63 + "a.com", wantErr: `invalid domain name "` + label63 + `a.com": label "` + label63 + `a" is longer than 63 bytes`},
+		"empty label":  {name: "www..example.com", wantErr: `invalid domain name "www..example.com": empty label`},
+		"leading dot":  {name: ".example.com", wantErr: `invalid domain name ".example.com": empty label`},
+		"too long":     {name: strings.Join([]string{label63, label63, label63, strings.Repeat("a", 62)}, "."), wantErr: "encoded length 256 exceeds 255 bytes"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validateName(tc.name)
+			if tc.wantErr == "" {
+				be.NilErr(t, err)
+				return
+			}
+			be.True(t, errors.Is(err, ErrInvalidName))
+			be.In(t, tc.wantErr, err.Error())
+		})
+	}
+}
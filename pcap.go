@@ -0,0 +1,196 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// pcap file format constants:
+// https://datatracker.ietf.org/doc/html/draft-ietf-opsawg-pcap
+const (
+	pcapMagic       = 0xa1b2c3d4 // microsecond timestamps
+	pcapSnapLen     = 65535 + 60 // the largest message over TCP, with headers
+	pcapLinkTypeRaw = 101        // packets begin with an IPv4 or IPv6 header
+)
+
+const (
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+
+	// the first of the ephemeral ports given to the local end of captured
+	// exchanges
+	pcapFirstPort = 49152
+)
+
+// PcapWriter writes DNS messages to a packet capture file in the pcap
+// format, framed as UDP or TCP packets, e.g. for inspecting the queries a
+// resolver sent with Wireshark or tcpdump. It is safe for concurrent use.
+// See Opts.Capture.
+type PcapWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	nextPort uint16
+}
+
+// NewPcapWriter writes the file header of a packet capture to w, returning
+// a PcapWriter that writes packets to it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := binary.LittleEndian.AppendUint32(nil, pcapMagic)
+	header = binary.LittleEndian.AppendUint16(header, 2) // version 2.4
+	header = binary.LittleEndian.AppendUint16(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, 0) // time zone offset, always zero
+	header = binary.LittleEndian.AppendUint32(header, 0) // timestamp accuracy, always zero
+	header = binary.LittleEndian.AppendUint32(header, pcapSnapLen)
+	header = binary.LittleEndian.AppendUint32(header, pcapLinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w, nextPort: pcapFirstPort}, nil
+}
+
+// WriteExchange writes a query sent to a server at the given time, over
+// "udp" or "tcp", and its response, if resp is not nil, received rtt later.
+// Both are given in wire format. Transports do not report the address the
+// query was sent from, so the local end of the exchange is recorded as the
+// unspecified address, with an ephemeral port that tells the exchange apart
+// from others. Exchanges over TCP are recorded without the connection's
+// handshake, as a single segment in each direction.
+func (p *PcapWriter) WriteExchange(network string, server netip.AddrPort, sent time.Time, query []byte, rtt time.Duration, resp []byte) error {
+	if network != "udp" && network != "tcp" {
+		return fmt.Errorf("cannot capture %s exchanges", network)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	local := netip.IPv4Unspecified()
+	if server.Addr().Is6() {
+		local = netip.IPv6Unspecified()
+	}
+	client := netip.AddrPortFrom(local, p.nextPort)
+	if p.nextPort++; p.nextPort == 0 {
+		p.nextPort = pcapFirstPort
+	}
+
+	queryPacket, err := framePacket(network, client, server, query, 0)
+	if err != nil {
+		return err
+	}
+	if err := p.writePacket(sent, queryPacket); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	// the response acknowledges the query, with its length prefix
+	respPacket, err := framePacket(network, server, client, resp, uint32(len(query)+2))
+	if err != nil {
+		return err
+	}
+	return p.writePacket(sent.Add(rtt), respPacket)
+}
+
+func (p *PcapWriter) writePacket(t time.Time, packet []byte) error {
+	record := binary.LittleEndian.AppendUint32(nil, uint32(t.Unix()))
+	record = binary.LittleEndian.AppendUint32(record, uint32(t.Nanosecond()/1000))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(packet))) // captured length
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(packet))) // original length
+	_, err := p.w.Write(append(record, packet...))
+	return err
+}
+
+// framePacket returns a DNS message framed as an IP packet carrying a UDP
+// datagram or a TCP segment, from src to dst. TCP segments begin at the
+// first sequence number of the stream, acknowledging the given number of
+// bytes of the peer's.
+func framePacket(network string, src, dst netip.AddrPort, msg []byte, acked uint32) ([]byte, error) {
+	var (
+		proto   uint8
+		segment []byte
+	)
+	switch network {
+	case "udp":
+		proto = ipProtoUDP
+		segment = binary.BigEndian.AppendUint16(nil, src.Port())
+		segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+		segment = binary.BigEndian.AppendUint16(segment, uint16(8+len(msg)))
+		segment = binary.BigEndian.AppendUint16(segment, 0) // checksum
+		segment = append(segment, msg...)
+	case "tcp":
+		proto = ipProtoTCP
+		segment = binary.BigEndian.AppendUint16(nil, src.Port())
+		segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+		segment = binary.BigEndian.AppendUint32(segment, 1)       // sequence number
+		segment = binary.BigEndian.AppendUint32(segment, 1+acked) // acknowledgment number
+		segment = append(segment, 5<<4, tcpFlagPSH|tcpFlagACK)    // header length in words, flags
+		segment = binary.BigEndian.AppendUint16(segment, 65535)   // window
+		segment = binary.BigEndian.AppendUint16(segment, 0)       // checksum
+		segment = binary.BigEndian.AppendUint16(segment, 0)       // urgent pointer
+		segment = binary.BigEndian.AppendUint16(segment, uint16(len(msg)))
+		segment = append(segment, msg...)
+	}
+	checksumOffset := 6
+	if proto == ipProtoTCP {
+		checksumOffset = 16
+	}
+
+	src16, dst16 := src.Addr().As16(), dst.Addr().As16()
+	var packet, pseudoHeader []byte
+	if src.Addr().Is4() {
+		if 20+len(segment) > 65535 {
+			return nil, errors.New("message too large to capture")
+		}
+		packet = []byte{0x45, 0} // version and header length, DSCP
+		packet = binary.BigEndian.AppendUint16(packet, uint16(20+len(segment)))
+		packet = binary.BigEndian.AppendUint16(packet, 0)      // identification
+		packet = binary.BigEndian.AppendUint16(packet, 0x4000) // don't fragment
+		packet = append(packet, 64, proto)                     // TTL, protocol
+		packet = binary.BigEndian.AppendUint16(packet, 0)      // checksum
+		packet = append(packet, src16[12:]...)
+		packet = append(packet, dst16[12:]...)
+		binary.BigEndian.PutUint16(packet[10:], internetChecksum(packet))
+		pseudoHeader = append(append(append([]byte(nil), src16[12:]...), dst16[12:]...), 0, proto)
+		pseudoHeader = binary.BigEndian.AppendUint16(pseudoHeader, uint16(len(segment)))
+	} else {
+		if len(segment) > 65535 {
+			return nil, errors.New("message too large to capture")
+		}
+		packet = binary.BigEndian.AppendUint32(nil, 6<<28) // version
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(segment)))
+		packet = append(packet, proto, 64) // next header, hop limit
+		packet = append(packet, src16[:]...)
+		packet = append(packet, dst16[:]...)
+		pseudoHeader = append(append([]byte(nil), src16[:]...), dst16[:]...)
+		pseudoHeader = binary.BigEndian.AppendUint32(pseudoHeader, uint32(len(segment)))
+		pseudoHeader = append(pseudoHeader, 0, 0, 0, proto)
+	}
+	checksum := internetChecksum(append(pseudoHeader, segment...))
+	if checksum == 0 && proto == ipProtoUDP {
+		checksum = 0xffff // zero means no checksum
+	}
+	binary.BigEndian.PutUint16(segment[checksumOffset:], checksum)
+	return append(packet, segment...), nil
+}
+
+// internetChecksum returns the checksum used by IP, UDP and TCP headers:
+// https://datatracker.ietf.org/doc/html/rfc1071
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,187 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// capturedPacket is a packet read back from a capture file.
+type capturedPacket struct {
+	time   time.Time
+	packet []byte
+}
+
+// readCapture parses a capture file written by a PcapWriter.
+func readCapture(t *testing.T, data []byte) []capturedPacket {
+	t.Helper()
+	be.True(t, len(data) >= 24)
+	be.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(data))
+	be.Equal(t, uint32(pcapLinkTypeRaw), binary.LittleEndian.Uint32(data[20:]))
+	var packets []capturedPacket
+	for data = data[24:]; len(data) > 0; {
+		be.True(t, len(data) >= 16)
+		sec, usec := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		n := binary.LittleEndian.Uint32(data[8:])
+		be.Equal(t, n, binary.LittleEndian.Uint32(data[12:]))
+		packets = append(packets, capturedPacket{time.Unix(int64(sec), int64(usec)*1000), data[16 : 16+n]})
+		data = data[16+n:]
+	}
+	return packets
+}
+
+// packetPayload checks the headers and checksums of a captured packet,
+// returning its addresses and the DNS message it carries.
+func packetPayload(t *testing.T, packet []byte) (netip.AddrPort, netip.AddrPort, []byte) {
+	t.Helper()
+	var (
+		src, dst     netip.Addr
+		proto        uint8
+		segment      []byte
+		pseudoHeader []byte
+	)
+	switch packet[0] >> 4 {
+	case 4:
+		be.Equal(t, uint16(0), internetChecksum(packet[:20]))
+		be.Equal(t, len(packet), int(binary.BigEndian.Uint16(packet[2:])))
+		src, dst = netip.AddrFrom4([4]byte(packet[12:16])), netip.AddrFrom4([4]byte(packet[16:20]))
+		proto, segment = packet[9], packet[20:]
+		pseudoHeader = append(append(append([]byte(nil), packet[12:20]...), 0, proto), binary.BigEndian.AppendUint16(nil, uint16(len(segment)))...)
+	case 6:
+		be.Equal(t, len(packet)-40, int(binary.BigEndian.Uint16(packet[4:])))
+		src, dst = netip.AddrFrom16([16]byte(packet[8:24])), netip.AddrFrom16([16]byte(packet[24:40]))
+		proto, segment = packet[6], packet[40:]
+		pseudoHeader = append(append([]byte(nil), packet[8:40]...), binary.BigEndian.AppendUint32(nil, uint32(len(segment)))...)
+		pseudoHeader = append(pseudoHeader, 0, 0, 0, proto)
+	default:
+		t.Fatalf("unexpected IP version %d", packet[0]>>4)
+	}
+	be.Equal(t, uint16(0), internetChecksum(append(pseudoHeader, segment...)))
+	srcPort, dstPort := binary.BigEndian.Uint16(segment), binary.BigEndian.Uint16(segment[2:])
+	var msg []byte
+	switch proto {
+	case ipProtoUDP:
+		be.Equal(t, len(segment), int(binary.BigEndian.Uint16(segment[4:])))
+		msg = segment[8:]
+	case ipProtoTCP:
+		be.Equal(t, uint8(tcpFlagPSH|tcpFlagACK), segment[13])
+		msg = segment[20:]
+		be.Equal(t, len(msg)-2, int(binary.BigEndian.Uint16(msg)))
+		msg = msg[2:]
+	default:
+		t.Fatalf("unexpected protocol %d", proto)
+	}
+	return netip.AddrPortFrom(src, srcPort), netip.AddrPortFrom(dst, dstPort), msg
+}
+
+func TestPcapWriter(t *testing.T) {
+	t.Parallel()
+
+	query := NewQuery("www.example.test", RecordTypeA).Encode()
+	resp := Message{Header: Header{ID: 1, Flags: headerFlagQR}, Answers: []Record{{Name: []byte("www.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{192, 0, 2, 1}}}}.Encode()
+	sent := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	testCases := map[string]struct {
+		network string
+		server  netip.AddrPort
+		resp    []byte
+		want    int
+	}{
+		"udp": {
+			network: "udp",
+			server:  netip.MustParseAddrPort("192.0.2.53:53"),
+			resp:    resp,
+			want:    2,
+		},
+		"tcp": {
+			network: "tcp",
+			server:  netip.MustParseAddrPort("192.0.2.53:53"),
+			resp:    resp,
+			want:    2,
+		},
+		"udp over IPv6": {
+			network: "udp",
+			server:  netip.MustParseAddrPort("[2001:db8::53]:53"),
+			resp:    resp,
+			want:    2,
+		},
+		"tcp over IPv6": {
+			network: "tcp",
+			server:  netip.MustParseAddrPort("[2001:db8::53]:853"),
+			resp:    resp,
+			want:    2,
+		},
+		"no response": {
+			network: "udp",
+			server:  netip.MustParseAddrPort("192.0.2.53:53"),
+			want:    1,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			p, err := NewPcapWriter(&buf)
+			be.NilErr(t, err)
+			be.NilErr(t, p.WriteExchange(tc.network, tc.server, sent, query, 5*time.Millisecond, tc.resp))
+			be.NilErr(t, p.WriteExchange(tc.network, tc.server, sent, query, 5*time.Millisecond, tc.resp))
+
+			packets := readCapture(t, buf.Bytes())
+			be.Equal(t, 2*tc.want, len(packets))
+			be.True(t, packets[0].time.Equal(sent))
+			src, dst, msg := packetPayload(t, packets[0].packet)
+			be.Equal(t, tc.server, dst)
+			be.True(t, src.Addr().IsUnspecified())
+			be.Equal(t, string(query), string(msg))
+			if tc.resp != nil {
+				be.True(t, packets[1].time.Equal(sent.Add(5*time.Millisecond)))
+				respSrc, respDst, msg := packetPayload(t, packets[1].packet)
+				be.Equal(t, tc.server, respSrc)
+				be.Equal(t, src, respDst)
+				be.Equal(t, string(tc.resp), string(msg))
+			}
+			// each exchange is given its own port
+			nextSrc, _, _ := packetPayload(t, packets[tc.want].packet)
+			be.True(t, nextSrc.Port() != src.Port())
+		})
+	}
+}
+
+func TestPcapWriterUnsupportedNetwork(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewPcapWriter(&bytes.Buffer{})
+	be.NilErr(t, err)
+	err = p.WriteExchange("https", netip.MustParseAddrPort("192.0.2.53:443"), time.Now(), nil, 0, nil)
+	be.True(t, err != nil)
+}
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	var buf bytes.Buffer
+	capture, err := NewPcapWriter(&buf)
+	be.NilErr(t, err)
+	r := newTestResolver(port, &Opts{Capture: capture})
+	_, err = r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+
+	packets := readCapture(t, buf.Bytes())
+	be.Equal(t, 2, len(packets))
+	_, dst, query := packetPayload(t, packets[0].packet)
+	be.Equal(t, "127.0.0.1:"+port, dst.String())
+	src, _, resp := packetPayload(t, packets[1].packet)
+	be.Equal(t, dst, src)
+	be.Equal(t, binary.BigEndian.Uint16(query), binary.BigEndian.Uint16(resp))
+}
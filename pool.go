@@ -0,0 +1,298 @@
+package dnstoy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultConnIdleTimeout = 10 * time.Second
+
+// errConnClosed is returned for queries that were outstanding when a pooled
+// connection was closed.
+var errConnClosed = errors.New("pooled connection closed")
+
+// errTransportClosed is returned for queries that were outstanding when
+// their transport was closed.
+var errTransportClosed = errors.New("transport closed")
+
+// errResponseTimeout is returned for queries whose response did not arrive
+// in time. It is a net.Error whose Timeout method returns true, like the
+// errors returned when reads from a connection time out.
+var errResponseTimeout net.Error = responseTimeoutError{}
+
+type responseTimeoutError struct{}
+
+func (responseTimeoutError) Error() string   { return "timeout waiting for response" }
+func (responseTimeoutError) Timeout() bool   { return true }
+func (responseTimeoutError) Temporary() bool { return true }
+
+// connPool maintains persistent stream (TCP) connections to name servers,
+// keyed by network and address. Multiple queries may be outstanding on a
+// single connection at once, as allowed by RFC 7766, with responses matched
+// to queries by message ID.
+// https://datatracker.ietf.org/doc/html/rfc7766#section-6.2.1.1
+type connPool struct {
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*pooledConn
+	dials map[string]*poolDial // connections being dialed
+}
+
+// poolDial is a connection being dialed, which other queries to the same
+// address wait for rather than dialing their own.
+type poolDial struct {
+	done chan struct{}
+	pc   *pooledConn
+	err  error
+}
+
+func newConnPool(dial func(ctx context.Context, network, addr string) (net.Conn, error), idleTimeout time.Duration) *connPool {
+	return &connPool{
+		dial:        dial,
+		idleTimeout: idleTimeout,
+		conns:       make(map[string]*pooledConn),
+		dials:       make(map[string]*poolDial),
+	}
+}
+
+// exchange sends an encoded query to the given address over a pooled
+// connection and waits up to timeout for the matching response.
+func (p *connPool) exchange(ctx context.Context, network, addr string, query []byte, timeout time.Duration) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, fmt.Errorf("query too short: %d bytes", len(query))
+	}
+	resp, err := p.doExchange(ctx, network, addr, query, timeout)
+	// the server may have closed an idle connection just before we used it,
+	// in which case we retry exactly once on a fresh connection
+	if errors.Is(err, errConnClosed) {
+		resp, err = p.doExchange(ctx, network, addr, query, timeout)
+	}
+	return resp, err
+}
+
+func (p *connPool) doExchange(ctx context.Context, network, addr string, query []byte, timeout time.Duration) ([]byte, error) {
+	pc, err := p.get(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return pc.exchange(ctx, query, timeout)
+}
+
+// get returns the existing live connection for the given address, or dials
+// a new one. Dialing happens outside of the pool's lock, so that a slow
+// server does not hold up queries to others, and concurrent queries to the
+// same address share a single dial.
+func (p *connPool) get(ctx context.Context, network, addr string) (*pooledConn, error) {
+	key := network + "/" + addr
+	for {
+		p.mu.Lock()
+		if pc, found := p.conns[key]; found {
+			p.mu.Unlock()
+			return pc, nil
+		}
+		d, found := p.dials[key]
+		if !found {
+			break
+		}
+		p.mu.Unlock()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// a dial abandoned by the query that started it is retried with
+		// this query's context
+		if d.err == nil || !errors.Is(d.err, context.Canceled) && !errors.Is(d.err, context.DeadlineExceeded) {
+			return d.pc, d.err
+		}
+	}
+	d := &poolDial{done: make(chan struct{})}
+	p.dials[key] = d
+	p.mu.Unlock()
+
+	conn, err := p.dial(ctx, network, addr)
+
+	p.mu.Lock()
+	delete(p.dials, key)
+	if err == nil {
+		d.pc = p.newConn(key, conn)
+		p.conns[key] = d.pc
+	}
+	d.err = err
+	p.mu.Unlock()
+	close(d.done)
+	return d.pc, d.err
+}
+
+// newConn starts managing a newly dialed connection for the given key.
+func (p *connPool) newConn(key string, conn net.Conn) *pooledConn {
+	pc := &pooledConn{
+		conn:    conn,
+		pending: make(map[uint16]chan []byte),
+		done:    make(chan struct{}),
+	}
+	pc.idleTimeout = p.idleTimeout
+	pc.onClose = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.conns[key] == pc {
+			delete(p.conns, key)
+		}
+	}
+	pc.idleTimer = time.AfterFunc(p.idleTimeout, func() { pc.close(errConnClosed) })
+	go pc.readLoop()
+	return pc
+}
+
+// closeAll closes every connection in the pool, failing the queries
+// outstanding on them. Later queries open new connections.
+func (p *connPool) closeAll() {
+	p.mu.Lock()
+	conns := make([]*pooledConn, 0, len(p.conns))
+	for _, pc := range p.conns {
+		conns = append(conns, pc)
+	}
+	p.mu.Unlock()
+	for _, pc := range conns {
+		pc.close(errTransportClosed)
+	}
+}
+
+// pooledConn is a single persistent connection that may have many queries
+// in flight.
+type pooledConn struct {
+	conn        net.Conn
+	idleTimer   *time.Timer
+	idleTimeout time.Duration
+	onClose     func()
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte
+	nextID  uint16
+	err     error
+	done    chan struct{}
+}
+
+// exchange sends a query on the connection and waits for its response. The
+// query's message ID is rewritten to one that is unique among the queries
+// outstanding on this connection, and restored in the response.
+func (pc *pooledConn) exchange(ctx context.Context, query []byte, timeout time.Duration) ([]byte, error) {
+	origID := binary.BigEndian.Uint16(query[0:2])
+	id, ch, err := pc.register()
+	if err != nil {
+		return nil, err
+	}
+	defer pc.unregister(id)
+
+	out := make([]byte, 0, len(query)+2)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(query)))
+	out = append(out, query...)
+	binary.BigEndian.PutUint16(out[2:4], id)
+
+	pc.writeMu.Lock()
+	pc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = pc.conn.Write(out)
+	pc.writeMu.Unlock()
+	if err != nil {
+		pc.close(errConnClosed)
+		return nil, fmt.Errorf("%w: %s", errConnClosed, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		binary.BigEndian.PutUint16(resp[0:2], origID)
+		return resp, nil
+	case <-pc.done:
+		return nil, pc.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w after %s", errResponseTimeout, timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// register allocates an unused message ID and a channel on which the
+// matching response will be delivered.
+func (pc *pooledConn) register() (uint16, chan []byte, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil {
+		return 0, nil, pc.err
+	}
+	if len(pc.pending) > 0xffff {
+		return 0, nil, errors.New("too many outstanding queries on connection")
+	}
+	for {
+		pc.nextID++
+		if _, found := pc.pending[pc.nextID]; !found {
+			break
+		}
+	}
+	ch := make(chan []byte, 1)
+	pc.pending[pc.nextID] = ch
+	pc.idleTimer.Stop()
+	return pc.nextID, ch, nil
+}
+
+func (pc *pooledConn) unregister(id uint16) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.pending, id)
+	if len(pc.pending) == 0 && pc.err == nil {
+		pc.idleTimer.Reset(pc.idleTimeout)
+	}
+}
+
+// readLoop reads responses from the connection and dispatches them to the
+// waiting queries until the connection fails or is closed.
+func (pc *pooledConn) readLoop() {
+	for {
+		resp, err := readStreamMessage(pc.conn)
+		if err != nil {
+			pc.close(fmt.Errorf("%w: %s", errConnClosed, err))
+			return
+		}
+		if len(resp) < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(resp[0:2])
+		pc.mu.Lock()
+		ch, found := pc.pending[id]
+		pc.mu.Unlock()
+		if found {
+			// drop duplicate responses rather than blocking the read loop
+			select {
+			case ch <- resp:
+			default:
+			}
+		}
+	}
+}
+
+// close shuts down the connection, failing any outstanding queries with the
+// given error. It is safe to call multiple times.
+func (pc *pooledConn) close(err error) {
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		return
+	}
+	pc.err = err
+	pc.idleTimer.Stop()
+	close(pc.done)
+	pc.mu.Unlock()
+
+	pc.conn.Close()
+	pc.onClose()
+}
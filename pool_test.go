@@ -0,0 +1,152 @@
+package dnstoy
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// startPipeliningServer starts a TCP server that waits for n queries on each
+// connection and then answers them in reverse order, echoing each query back
+// as its own response.
+func startPipeliningServer(t *testing.T, n int) (addr string, dials *int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	dials = new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(dials, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					queries := make([][]byte, 0, n)
+					for i := 0; i < n; i++ {
+						q, err := readStreamMessage(conn)
+						if err != nil {
+							return
+						}
+						queries = append(queries, q)
+					}
+					for i := len(queries) - 1; i >= 0; i-- {
+						out := binary.BigEndian.AppendUint16(nil, uint16(len(queries[i])))
+						conn.Write(append(out, queries[i]...))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), dials
+}
+
+func TestConnPoolPipelining(t *testing.T) {
+	t.Parallel()
+
+	addr, dials := startPipeliningServer(t, 2)
+	pool := newConnPool((&net.Dialer{}).DialContext, time.Minute)
+
+	// both queries use the same message ID, which the pool must rewrite so
+	// that the responses can be told apart
+	queries := [][]byte{
+		newQueryHelper("a.example.com", RecordTypeA, 1).Encode(),
+		newQueryHelper("b.example.com", RecordTypeA, 1).Encode(),
+	}
+	results := make([][]byte, len(queries))
+	errs := make([]error, len(queries))
+
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		i, q := i, q
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = pool.exchange(context.Background(), "tcp", addr, q, time.Second)
+		}()
+	}
+	wg.Wait()
+
+	for i := range queries {
+		be.NilErr(t, errs[i])
+		be.Equal(t, string(queries[i]), string(results[i]))
+	}
+	be.Equal(t, int32(1), atomic.LoadInt32(dials))
+}
+
+func TestConnPoolIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	addr, dials := startPipeliningServer(t, 1)
+	pool := newConnPool((&net.Dialer{}).DialContext, 10*time.Millisecond)
+	query := newQueryHelper("example.com", RecordTypeA, 1).Encode()
+
+	_, err := pool.exchange(context.Background(), "tcp", addr, query, time.Second)
+	be.NilErr(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = pool.exchange(context.Background(), "tcp", addr, query, time.Second)
+	be.NilErr(t, err)
+
+	be.Equal(t, int32(2), atomic.LoadInt32(dials))
+}
+
+func TestConnPoolDialOutsideLock(t *testing.T) {
+	t.Parallel()
+
+	// dials to slow.test block until released, and are counted
+	var slowDials int32
+	dialing, release := make(chan struct{}), make(chan struct{})
+	pool := newConnPool(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "slow.test:53" {
+			if atomic.AddInt32(&slowDials, 1) == 1 {
+				close(dialing)
+			}
+			<-release
+		}
+		client, server := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		return client, nil
+	}, time.Minute)
+	t.Cleanup(pool.closeAll)
+
+	conns := make([]*pooledConn, 3)
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i := range conns {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conns[i], errs[i] = pool.get(context.Background(), "tcp", "slow.test:53")
+		}()
+	}
+	<-dialing
+
+	// other addresses are not held up by the slow dial
+	_, err := pool.get(context.Background(), "tcp", "fast.test:53")
+	be.NilErr(t, err)
+
+	// and neither is a query that gives up waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.get(ctx, "tcp", "slow.test:53")
+	be.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		be.NilErr(t, err)
+	}
+	be.Equal(t, int32(1), atomic.LoadInt32(&slowDials))
+	be.True(t, conns[0] != nil && conns[0] == conns[1] && conns[1] == conns[2])
+}
@@ -0,0 +1,63 @@
+package dnstoy
+
+import (
+	"context"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	defaultPrefetchMinHits = 2
+
+	// maxPrefetchQueries bounds how long a background prefetch may take, in
+	// multiples of the per-query timeout.
+	maxPrefetchQueries = 10
+)
+
+// cacheBypassKey is the context key used to mark a domain name whose cached
+// answers must be ignored, so that a prefetch actually hits the network.
+type cacheBypassKey struct{}
+
+func withCacheBypass(ctx context.Context, domainName string) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, canonicalName(domainName))
+}
+
+func isCacheBypassed(ctx context.Context, domainName string) bool {
+	bypassed, _ := ctx.Value(cacheBypassKey{}).(string)
+	return bypassed != "" && bypassed == canonicalName(domainName)
+}
+
+// maybePrefetch kicks off a background refresh of the given cache entry if
+// prefetching is enabled and the entry is popular and close to expiring.
+func (r *Resolver) maybePrefetch(key CacheKey, domainName string, recordType RecordType) {
+	if r.prefetchPct <= 0 {
+		return
+	}
+	// hit counts are only tracked by the built-in cache
+	cache, ok := r.cache.(*MemoryCache)
+	if !ok || !cache.claimPrefetch(key, r.prefetchPct, r.prefetchHits) {
+		return
+	}
+	ctx, done, err := r.beginBackground()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer done()
+		ctx, cancel := context.WithTimeout(ctx, r.queryTimeout*maxPrefetchQueries)
+		defer cancel()
+		ctx = withQueryOpts(ctx, QueryOpts{Class: key.Class})
+		r.log(ctx).Debug(
+			"prefetching cache entry",
+			slog.String("query_name", domainName),
+			slog.String("resource_type", recordType.String()),
+		)
+		if _, _, err := r.doLookup(withCacheBypass(ctx, domainName), newLookupState(), r.startingNameServers(ctx, domainName), domainName, recordType, 0); err != nil {
+			r.log(ctx).Debug(
+				"prefetch failed",
+				slog.String("query_name", domainName),
+				slog.String("err", err.Error()),
+			)
+		}
+	}()
+}
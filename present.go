@@ -0,0 +1,334 @@
+package dnstoy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// String renders the message in the presentation format used by dig, with
+// a summary of the header followed by each non-empty section.
+func (m Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n", m.Header.Opcode(), m.Header.RCode(), m.Header.ID)
+	fmt.Fprintf(&b, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		m.Header.flagNames(), len(m.Questions), len(m.Answers), len(m.Authorities), len(m.Additionals))
+
+	var additionals []Record
+	for _, rec := range m.Additionals {
+		if rec.Type == RecordTypeOPT {
+			b.WriteString("\n;; OPT PSEUDOSECTION:\n")
+			writeOPT(&b, rec)
+			continue
+		}
+		additionals = append(additionals, rec)
+	}
+	if len(m.Questions) > 0 {
+		b.WriteString("\n;; QUESTION SECTION:\n")
+		for _, q := range m.Questions {
+			b.WriteString(q.String())
+			b.WriteByte('\n')
+		}
+	}
+	for _, section := range []struct {
+		name    string
+		records []Record
+	}{
+		{"ANSWER", m.Answers},
+		{"AUTHORITY", m.Authorities},
+		{"ADDITIONAL", additionals},
+	} {
+		if len(section.records) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n;; %s SECTION:\n", section.name)
+		for _, rec := range section.records {
+			b.WriteString(rec.String())
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// writeOPT describes an OPT pseudo-record the way dig does.
+func writeOPT(b *strings.Builder, opt Record) {
+	version := opt.TTL >> 16 & 0xff
+	var flags string
+	if opt.TTL&ednsFlagDO != 0 {
+		flags = " do"
+	}
+	fmt.Fprintf(b, "; EDNS: version: %d, flags:%s; udp: %d\n", version, flags, uint16(opt.Class))
+	options, err := parseEDNSOptions(opt.Data)
+	if err != nil {
+		fmt.Fprintf(b, "; invalid options: %s\n", err)
+		return
+	}
+	for _, option := range options {
+		if option.Code == EDNSOptionNSID {
+			fmt.Fprintf(b, "; NSID: %X (%q)\n", option.Data, option.Data)
+			continue
+		}
+		fmt.Fprintf(b, "; OPT=%d: %X\n", option.Code, option.Data)
+	}
+}
+
+// String renders the question as a line of a dig question section. Names
+// must be decoded, as they are in a parsed Message.
+func (q Question) String() string {
+	return fmt.Sprintf(";%s\t\t%s\t%s", presentName(string(q.Name)), q.Class, q.Type)
+}
+
+// String renders the record in presentation format, as a line of a zone file
+// or of dig's output: "name TTL class type data".
+func (r Record) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", presentName(string(r.Name)), r.TTL, r.Class, r.Type, r.presentData())
+}
+
+// presentData renders the record's data in presentation format. Data that
+// is malformed, or belongs to a type without a known presentation format, is
+// rendered in the generic format:
+// https://datatracker.ietf.org/doc/html/rfc3597#section-5
+func (r Record) presentData() string {
+	if s, err := r.formatData(); err == nil {
+		return s
+	}
+	return fmt.Sprintf("\\# %d %X", len(r.Data), r.Data)
+}
+
+func (r Record) formatData() (string, error) {
+	switch r.Type {
+	case RecordTypeA, RecordTypeAAAA:
+		ips, err := parseIPAddrs(r.Type, r.Data)
+		if err != nil || len(ips) != 1 {
+			return "", fmt.Errorf("invalid %s record", r.Type)
+		}
+		return ips[0].String(), nil
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		return presentName(string(r.Data)), nil
+	case RecordTypeSOA:
+		return formatSOA(r.Data)
+	case RecordTypeMX:
+		mx, err := parseMX(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s", mx.Preference, presentName(mx.Exchange)), nil
+	case RecordTypeTXT:
+		return formatTXT(r.Data)
+	case RecordTypeSRV:
+		srv, err := parseSRV(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, presentName(srv.Target)), nil
+	case RecordTypeCAA:
+		caa, err := parseCAA(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s %s", caa.Flags, caa.Tag, quoteString(caa.Value)), nil
+	case RecordTypeDS, RecordTypeCDS:
+		ds, err := parseDS(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %X", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest), nil
+	case RecordTypeDNSKEY, RecordTypeCDNSKEY:
+		key, err := parseDNSKEY(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s", key.Flags, key.Protocol, key.Algorithm, base64.StdEncoding.EncodeToString(key.PublicKey)), nil
+	case RecordTypeRRSIG:
+		sig, err := parseRRSIG(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+			sig.TypeCovered, sig.Algorithm, sig.Labels, sig.OriginalTTL,
+			formatSigTime(sig.Expiration), formatSigTime(sig.Inception), sig.KeyTag,
+			presentName(sig.SignerName), base64.StdEncoding.EncodeToString(sig.Signature),
+		), nil
+	case RecordTypeNSEC:
+		nsec, err := parseNSEC(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(presentName(nsec.NextName) + " " + formatTypes(nsec.Types)), nil
+	case RecordTypeNSEC3:
+		nsec3, err := parseNSEC3(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(fmt.Sprintf("%d %d %d %s %s %s",
+			nsec3.HashAlgorithm, nsec3.Flags, nsec3.Iterations, formatSalt(nsec3.Salt),
+			nsec3Encoding.EncodeToString(nsec3.NextHashedOwner), formatTypes(nsec3.Types),
+		)), nil
+	case RecordTypeNSEC3PARAM:
+		if len(r.Data) < 5 || len(r.Data) != 5+int(r.Data[4]) {
+			return "", fmt.Errorf("invalid NSEC3PARAM record")
+		}
+		return fmt.Sprintf("%d %d %d %s", r.Data[0], r.Data[1], binary.BigEndian.Uint16(r.Data[2:4]), formatSalt(r.Data[5:])), nil
+	case RecordTypeZONEMD:
+		zonemd, err := parseZONEMD(r.Data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %X", zonemd.Serial, zonemd.Scheme, zonemd.HashAlgorithm, zonemd.Digest), nil
+	default:
+		return "", fmt.Errorf("no presentation format for %s records", r.Type)
+	}
+}
+
+// formatSOA renders the data of an SOA record, whose names are stored
+// uncompressed in wire format.
+func formatSOA(data []byte) (string, error) {
+	v := byteview.New(data)
+	mname, err := decodeName(v)
+	if err != nil {
+		return "", err
+	}
+	rname, err := decodeName(v)
+	if err != nil {
+		return "", err
+	}
+	bs, err := v.Next(20) // 20 == 5 32-bit fields
+	if err != nil {
+		return "", err
+	}
+	fields := make([]string, 0, 7)
+	fields = append(fields, presentName(string(mname)), presentName(string(rname)))
+	for i := 0; i < 20; i += 4 {
+		fields = append(fields, strconv.FormatUint(uint64(binary.BigEndian.Uint32(bs[i:i+4])), 10))
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// formatTXT renders the character-strings in the data of a TXT record as
+// quoted strings, escaping quotes, backslashes and unprintable bytes:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-5.1
+func formatTXT(data []byte) (string, error) {
+	strs, err := ParseTXT(data)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(strs))
+	for i, s := range strs {
+		parts[i] = quoteString(s)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// quoteString renders a character string in presentation format, quoted,
+// with quotes and backslashes escaped and non-printable bytes written in
+// decimal, e.g. "\009" for a tab.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatSigTime renders an RRSIG inception or expiration time as
+// YYYYMMDDHHmmSS in UTC:
+// https://datatracker.ietf.org/doc/html/rfc4034#section-3.2
+func formatSigTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+func formatSalt(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return strings.ToUpper(hex.EncodeToString(salt))
+}
+
+func formatTypes(types []RecordType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, " ")
+}
+
+// presentName renders a decoded domain name as a fully qualified name, with
+// a trailing dot.
+func presentName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// headerFlagNames are the names of the header flags, in the order dig lists
+// them.
+var headerFlagNames = []struct {
+	bit  uint16
+	name string
+}{
+	{headerFlagQR, "qr"},
+	{headerFlagAA, "aa"},
+	{headerFlagTC, "tc"},
+	{headerFlagRD, "rd"},
+	{headerFlagRA, "ra"},
+	{headerFlagAD, "ad"},
+	{headerFlagCD, "cd"},
+}
+
+// flagNames returns the names of the flags set in the header.
+func (h Header) flagNames() string {
+	var names []string
+	for _, flag := range headerFlagNames {
+		if h.Flags&flag.bit != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// String returns the mnemonic for the class, or its number in the generic
+// format for classes without one.
+func (c ResourceClass) String() string {
+	switch c {
+	case ResourceClassIN:
+		return "IN"
+	case ResourceClassCH:
+		return "CH"
+	case ResourceClassHS:
+		return "HS"
+	case ResourceClassANY:
+		return "ANY"
+	default:
+		return "CLASS" + strconv.Itoa(int(c))
+	}
+}
+
+// ParseResourceClass parses a class from its mnemonic, e.g. "CH", or from
+// the generic CLASSnn form. Mnemonics are case-insensitive.
+func ParseResourceClass(s string) (ResourceClass, error) {
+	for _, c := range []ResourceClass{ResourceClassIN, ResourceClassCH, ResourceClassHS, ResourceClassANY} {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	if len(s) > 5 && strings.EqualFold(s[:5], "CLASS") {
+		if n, err := strconv.ParseUint(s[5:], 10, 16); err == nil {
+			return ResourceClass(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown class %q", s)
+}
@@ -0,0 +1,110 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestRecordString(t *testing.T) {
+	t.Parallel()
+
+	soa := append(encodeName("ns1.example.com"), encodeName("hostmaster.example.com")...)
+	for _, v := range []uint32{2023010101, 7200, 3600, 1209600, 300} {
+		soa = binary.BigEndian.AppendUint32(soa, v)
+	}
+	sig := RRSIG{
+		TypeCovered: RecordTypeA,
+		Algorithm:   AlgorithmECDSAP256SHA256,
+		Labels:      2,
+		OriginalTTL: 300,
+		Expiration:  1700000000,
+		Inception:   1690000000,
+		KeyTag:      12345,
+		SignerName:  "example.com",
+		Signature:   []byte{1, 2, 3},
+	}
+
+	testCases := map[string]struct {
+		record Record
+		want   string
+	}{
+		"A": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{93, 184, 216, 34}},
+			want:   "example.com.\t300\tIN\tA\t93.184.216.34",
+		},
+		"AAAA": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeAAAA, Class: ResourceClassIN, TTL: 300, Data: []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+			want:   "example.com.\t300\tIN\tAAAA\t2001:db8::1",
+		},
+		"CNAME": {
+			record: Record{Name: []byte("www.example.com"), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte("example.com")},
+			want:   "www.example.com.\t60\tIN\tCNAME\texample.com.",
+		},
+		"SOA": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeSOA, Class: ResourceClassIN, TTL: 3600, Data: soa},
+			want:   "example.com.\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 2023010101 7200 3600 1209600 300",
+		},
+		"TXT": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeTXT, Class: ResourceClassCH, TTL: 0, Data: []byte("\x0asay \"hi\"\\\x01\x03abc")},
+			want:   "example.com.\t0\tCH\tTXT\t\"say \\\"hi\\\"\\\\\\001\" \"abc\"",
+		},
+		"RRSIG": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeRRSIG, Class: ResourceClassIN, TTL: 300, Data: sig.Encode()},
+			want:   "example.com.\t300\tIN\tRRSIG\tA 13 2 300 20231114221320 20230722042640 12345 example.com. AQID",
+		},
+		"root name": {
+			record: Record{Name: []byte(""), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 518400, Data: []byte("a.root-servers.net")},
+			want:   ".\t518400\tIN\tNS\ta.root-servers.net.",
+		},
+		"unknown type": {
+			record: Record{Name: []byte("example.com"), Type: RecordType(99), Class: ResourceClass(42), TTL: 1, Data: []byte{0xab, 0xcd}},
+			want:   "example.com.\t1\tCLASS42\tTYPE99\t\\# 2 ABCD",
+		},
+		"malformed data": {
+			record: Record{Name: []byte("example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 1, Data: []byte{1, 2}},
+			want:   "example.com.\t1\tIN\tA\t\\# 2 0102",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, tc.want, tc.record.String())
+		})
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Header:    Header{ID: 4660, Flags: headerFlagQR | headerFlagRD | headerFlagRA | uint16(RCodeNXDomain)},
+		Questions: []Question{{Name: []byte("missing.example.com"), Type: RecordTypeA, Class: ResourceClassIN}},
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns1.example.com")},
+		},
+		Additionals: []Record{
+			newOPTRecord(1232, EDNSOption{Code: EDNSOptionNSID, Data: []byte("ns1")}),
+			{Name: []byte("ns1.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{192, 0, 2, 1}},
+		},
+	}
+	want := `;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 4660
+;; flags: qr rd ra; QUERY: 1, ANSWER: 0, AUTHORITY: 1, ADDITIONAL: 2
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 1232
+; NSID: 6E7331 ("ns1")
+
+;; QUESTION SECTION:
+;missing.example.com.		IN	A
+
+;; AUTHORITY SECTION:
+example.com.	60	IN	NS	ns1.example.com.
+
+;; ADDITIONAL SECTION:
+ns1.example.com.	60	IN	A	192.0.2.1
+`
+	be.Equal(t, want, msg.String())
+}
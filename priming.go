@@ -0,0 +1,86 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// rootPrimingRetry is how long to wait before priming again after a priming
+// query fails, during which the configured root hints are used.
+const rootPrimingRetry = time.Minute
+
+// primeRootNameServers refreshes the set of root name servers by sending a
+// priming query for the root zone's NS records to the configured root
+// hints, if the current set has expired.
+// https://datatracker.ietf.org/doc/html/rfc8109
+func (r *Resolver) primeRootNameServers(ctx context.Context) {
+	if !r.rootPriming || len(r.upstreams) > 0 || lookupOptsFrom(ctx).server != nil {
+		return
+	}
+	r.primeMu.Lock()
+	defer r.primeMu.Unlock()
+
+	r.rootsMu.Lock()
+	expired := !time.Now().Before(r.rootsExpire)
+	r.rootsMu.Unlock()
+	if !expired {
+		return
+	}
+
+	roots, ttl, err := r.sendPrimingQuery(ctx)
+	if err != nil || len(roots) == 0 {
+		r.log(ctx).Warn("root priming failed, using root hints", slog.Any("err", err))
+		r.rootsMu.Lock()
+		r.rootsExpire = time.Now().Add(rootPrimingRetry)
+		r.rootsMu.Unlock()
+		return
+	}
+	r.log(ctx).Debug("primed root name servers", slog.Int("count", len(roots)), slog.Duration("ttl", ttl))
+
+	r.rootsMu.Lock()
+	defer r.rootsMu.Unlock()
+	r.rootNameServers = roots
+	r.rootsExpire = time.Now().Add(ttl)
+}
+
+// sendPrimingQuery asks the root hints for the root zone's NS records,
+// returning the root name servers with addresses given in the response's
+// additional section, along with the TTL of the NS RRset.
+func (r *Resolver) sendPrimingQuery(ctx context.Context) ([]nameServerDef, time.Duration, error) {
+	hints := shuffled(r.usableNameServers(r.rootHints))
+	msg, _, _, err := r.queryNameServers(ctx, newLookupState(), hints, ".", RecordTypeNS, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	addrs := make(map[string][]net.IP)
+	for _, rec := range msg.Additionals {
+		if rec.Type != RecordTypeA && rec.Type != RecordTypeAAAA {
+			continue
+		}
+		ips, err := parseIPAddrs(rec.Type, rec.Data)
+		if err != nil {
+			return nil, 0, err
+		}
+		name := canonicalName(string(rec.Name))
+		addrs[name] = append(addrs[name], ips...)
+	}
+
+	var nsRecords []Record
+	var roots []nameServerDef
+	for _, rec := range msg.Answers {
+		if rec.Type != RecordTypeNS || canonicalName(string(rec.Name)) != "" {
+			continue
+		}
+		nsRecords = append(nsRecords, rec)
+		// name servers without addresses are skipped rather than resolved,
+		// since every root name server should be accompanied by glue
+		if nsAddrs := addrs[canonicalName(string(rec.Data))]; len(nsAddrs) > 0 {
+			roots = append(roots, newNameServerDef(string(rec.Data), ".", nsAddrs...))
+		}
+	}
+	return roots, rrsetTTL(nsRecords), nil
+}
@@ -0,0 +1,56 @@
+package dnstoy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestPrimeRootNameServers(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var primingQueries int
+	port := startTestServer(t, func(q Message) Message {
+		if q.Questions[0].Type != RecordTypeNS {
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		primingQueries++
+		return Message{
+			Answers: []Record{
+				{Name: []byte(""), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 3600, Data: []byte("a.root.test")},
+				{Name: []byte(""), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 3600, Data: []byte("b.root.test")},
+			},
+			Additionals: []Record{
+				{Name: []byte("a.root.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{127, 0, 0, 1}},
+				{Name: []byte("a.root.test"), Type: RecordTypeAAAA, Class: ResourceClassIN, TTL: 3600, Data: []byte{15: 1}},
+			},
+		}
+	})
+	r := newTestResolver(port, &Opts{DisableCache: true})
+	r.rootPriming = true
+
+	for i := 0; i < 2; i++ {
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, "1.2.3.4", ips[0].String())
+	}
+
+	// the primed root name servers are used until their TTL expires, and
+	// those without glue are ignored
+	mu.Lock()
+	be.Equal(t, 1, primingQueries)
+	mu.Unlock()
+	r.rootsMu.Lock()
+	roots := r.rootNameServers
+	r.rootsMu.Unlock()
+	be.Equal(t, 1, len(roots))
+	be.Equal(t, "a.root.test", roots[0].name)
+	be.Equal(t, 2, len(roots[0].addrs))
+}
@@ -0,0 +1,131 @@
+package dnstoy
+
+import (
+	"context"
+	"fmt"
+)
+
+// QueryOpts holds the parameters of the queries sent to look up records with
+// LookupRecords. The zero value looks up A records in the IN class, with the
+// same queries that LookupIP would send.
+type QueryOpts struct {
+	// Type is the type of records to look up. Defaults to A.
+	Type RecordType
+
+	// Class is the class of records to look up. Defaults to IN.
+	Class ResourceClass
+
+	// RD sets the Recursion Desired bit on every query, rather than only on
+	// queries to forwarders. Name servers that offer recursion will then
+	// answer in full instead of referring the resolver elsewhere.
+	RD bool
+
+	// DO sets the DNSSEC OK bit, asking name servers to include DNSSEC
+	// records in their responses.
+	DO bool
+
+	// CD sets the Checking Disabled bit, asking name servers that validate
+	// DNSSEC to answer even if validation fails.
+	CD bool
+
+	// UDPSize is the UDP payload size advertised in the queries' OPT
+	// records. EDNSOptions are additional options carried in them. An OPT
+	// record is only added if either is set, or one is needed for other
+	// reasons, e.g. by DO.
+	UDPSize     uint16
+	EDNSOptions []EDNSOption
+
+	// Transport overrides the resolver's transport for these queries.
+	// Queries to DNS-over-HTTPS forwarders are unaffected.
+	Transport Transport
+}
+
+func (o QueryOpts) recordType() RecordType {
+	if o.Type == 0 {
+		return RecordTypeA
+	}
+	return o.Type
+}
+
+func (o QueryOpts) class() ResourceClass {
+	if o.Class == 0 {
+		return ResourceClassIN
+	}
+	return o.Class
+}
+
+// inClass returns the options for the queries sent on behalf of a lookup
+// to resolve the addresses of name servers, which are in the IN class
+// whatever the class of the lookup.
+func (o QueryOpts) inClass() QueryOpts {
+	o.Class = ResourceClassIN
+	return o
+}
+
+func (o QueryOpts) udpSize() uint16 {
+	if o.UDPSize == 0 {
+		return ednsUDPSize
+	}
+	return o.UDPSize
+}
+
+// queryOptsKey is the context key used to carry the QueryOpts of a lookup
+// down to the queries sent while resolving it.
+type queryOptsKey struct{}
+
+func withQueryOpts(ctx context.Context, opts QueryOpts) context.Context {
+	return context.WithValue(ctx, queryOptsKey{}, opts)
+}
+
+func queryOptsFrom(ctx context.Context) QueryOpts {
+	opts, _ := ctx.Value(queryOptsKey{}).(QueryOpts)
+	return opts
+}
+
+// LookupRecords looks up the records of the type and class given in opts for
+// the given name, sending queries with the given parameters. Unlike
+// LookupIP, the name is looked up as given, without using the search list or
+// hosts file. The records are returned as they appear in the answer, with
+// any CNAMEs followed.
+func (r *Resolver) LookupRecords(ctx context.Context, domainName string, opts QueryOpts, options ...LookupOption) (records []Record, err error) {
+	ctx = withLookupOpts(ctx, options)
+	ctx, span := r.startSpan(ctx, spanLookup,
+		SpanAttribute{"dns.question.name", domainName},
+		SpanAttribute{"dns.question.type", opts.recordType().String()},
+	)
+	defer func() { endSpan(span, err) }()
+
+	if opts.recordType() == RecordTypeOPT {
+		return nil, fmt.Errorf("lookup %s: cannot look up %s records", domainName, opts.recordType())
+	}
+	domainName, err = ToASCII(domainName)
+	if err != nil {
+		return nil, err
+	}
+	return r.lookupRecords(withQueryOpts(ctx, opts), domainName, opts.recordType())
+}
+
+// LookupChaos asks the given name server, in the same form as
+// Opts.Upstreams, for the TXT records of the given name in the CHAOS class,
+// returning their strings. Many name servers answer "version.bind" with
+// their software version and "hostname.bind" or "id.server" with their
+// identity, which are useful when diagnosing a specific server.
+// https://datatracker.ietf.org/doc/html/rfc4892
+func (r *Resolver) LookupChaos(ctx context.Context, server, name string) ([]string, error) {
+	records, err := r.LookupRecords(ctx, name, QueryOpts{Type: RecordTypeTXT, Class: ResourceClassCH}, WithServer(server))
+	if err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, rec := range records {
+		if rec.Type != RecordTypeTXT {
+			continue
+		}
+		recStrs, err := ParseTXT(rec.Data)
+		if err != nil {
+			return nil, fmt.Errorf("lookup %s: %w", name, err)
+		}
+		strs = append(strs, recStrs...)
+	}
+	return strs, nil
+}
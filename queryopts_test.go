@@ -0,0 +1,164 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestLookupRecords(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan Message, 1)
+	port := startTestServer(t, func(q Message) Message {
+		queries <- q
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeTXT, Class: ResourceClassCH, TTL: 60, Data: []byte("\x05hello")}},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	records, err := r.LookupRecords(context.Background(), "version.test", QueryOpts{
+		Type:        RecordTypeTXT,
+		Class:       ResourceClassCH,
+		RD:          true,
+		DO:          true,
+		CD:          true,
+		UDPSize:     4096,
+		EDNSOptions: []EDNSOption{{Code: 10, Data: []byte("cookie!!")}},
+	})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+	be.Equal(t, "\x05hello", string(records[0].Data))
+
+	q := <-queries
+	be.Equal(t, RecordTypeTXT, q.Questions[0].Type)
+	be.Equal(t, ResourceClassCH, q.Questions[0].Class)
+	be.True(t, q.Header.Flags&headerFlagRD != 0)
+	be.True(t, q.Header.CD())
+	be.True(t, q.DO())
+	opt, found := matchRecord(q.Additionals, RecordTypeOPT)
+	be.True(t, found)
+	be.Equal(t, ResourceClass(4096), opt.Class)
+	options, err := q.EDNSOptions()
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(options))
+	be.Equal(t, uint16(10), options[0].Code)
+
+	// the answer is cached under its class
+	records, err = r.LookupRecords(context.Background(), "version.test", QueryOpts{Type: RecordTypeTXT, Class: ResourceClassCH})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+	be.Equal(t, 0, len(queries))
+}
+
+func TestLookupRecordsDefaults(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan Message, 1)
+	port := startTestServer(t, func(q Message) Message {
+		queries <- q
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	records, err := r.LookupRecords(context.Background(), "www.example.test", QueryOpts{})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+
+	q := <-queries
+	be.Equal(t, RecordTypeA, q.Questions[0].Type)
+	be.Equal(t, ResourceClassIN, q.Questions[0].Class)
+	be.Equal(t, uint16(0), q.Header.Flags&headerFlagRD)
+	be.False(t, q.Header.CD())
+	_, found := matchRecord(q.Additionals, RecordTypeOPT)
+	be.False(t, found)
+}
+
+func TestLookupRecordsTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := echoTransport{servers: make(chan netip.AddrPort, 1)}
+	r := newTestResolver("5353", nil)
+	records, err := r.LookupRecords(context.Background(), "www.example.test", QueryOpts{Transport: transport})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+	be.Equal(t, "127.0.0.1:5353", (<-transport.servers).String())
+
+	_, err = r.LookupRecords(context.Background(), "www.example.test", QueryOpts{Type: RecordTypeOPT})
+	be.True(t, err != nil)
+}
+
+func TestLookupChaos(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan Message, 1)
+	port := startTestServer(t, func(q Message) Message {
+		queries <- q
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeTXT, Class: ResourceClassCH, TTL: 0, Data: []byte("\x07dnstoy!")}},
+		}
+	})
+	r := New(nil)
+
+	strs, err := r.LookupChaos(context.Background(), net.JoinHostPort("127.0.0.1", port), "version.bind")
+	be.NilErr(t, err)
+	be.AllEqual(t, []string{"dnstoy!"}, strs)
+
+	q := <-queries
+	be.True(t, strings.EqualFold("version.bind", string(q.Questions[0].Name)))
+	be.Equal(t, RecordTypeTXT, q.Questions[0].Type)
+	be.Equal(t, ResourceClassCH, q.Questions[0].Class)
+}
+
+func TestLookupRecordsNameServerClass(t *testing.T) {
+	t.Parallel()
+
+	// a CH lookup referred to a name server without glue must still look up
+	// the name server's address in IN
+	var mu sync.Mutex
+	var nsClasses []ResourceClass
+	var referred bool
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		question := q.Questions[0]
+		if strings.EqualFold(string(question.Name), "ns1.other.test") {
+			nsClasses = append(nsClasses, question.Class)
+			if question.Type != RecordTypeA || question.Class != ResourceClassIN {
+				return Message{}
+			}
+			return Message{
+				Answers: []Record{{Name: question.Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{127, 0, 0, 1}}},
+			}
+		}
+		if !referred {
+			referred = true
+			return Message{
+				Authorities: []Record{{Name: []byte("example.test"), Type: RecordTypeNS, Class: ResourceClassCH, TTL: 60, Data: []byte("ns1.other.test")}},
+			}
+		}
+		return Message{
+			Answers: []Record{{Name: question.Name, Type: RecordTypeTXT, Class: ResourceClassCH, TTL: 60, Data: []byte("\x05hello")}},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	records, err := r.LookupRecords(context.Background(), "version.example.test", QueryOpts{Type: RecordTypeTXT, Class: ResourceClassCH})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+
+	mu.Lock()
+	defer mu.Unlock()
+	be.True(t, len(nsClasses) > 0)
+	for _, class := range nsClasses {
+		be.Equal(t, ResourceClassIN, class)
+	}
+}
@@ -0,0 +1,254 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// MX holds the data of an MX record:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-3.3.9
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+// parseMX parses the data of an MX record.
+func parseMX(data []byte) (MX, error) {
+	v := byteview.New(data)
+	bs, err := v.Next(2)
+	if err != nil {
+		return MX{}, fmt.Errorf("parseMX: %w", err)
+	}
+	exchange, err := decodeName(v)
+	if err != nil {
+		return MX{}, fmt.Errorf("parseMX: error decoding exchange: %w", err)
+	}
+	if v.Remaining() != 0 {
+		return MX{}, fmt.Errorf("parseMX: %d trailing bytes", v.Remaining())
+	}
+	return MX{Preference: binary.BigEndian.Uint16(bs), Exchange: string(exchange)}, nil
+}
+
+// Encode encodes the MX as record data in network order.
+func (mx MX) Encode() []byte {
+	return append(binary.BigEndian.AppendUint16(nil, mx.Preference), encodeName(mx.Exchange)...)
+}
+
+// SRV holds the data of an SRV record, which locates the servers for a
+// service:
+// https://datatracker.ietf.org/doc/html/rfc2782
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// parseSRV parses the data of an SRV record.
+func parseSRV(data []byte) (SRV, error) {
+	v := byteview.New(data)
+	bs, err := v.Next(6)
+	if err != nil {
+		return SRV{}, fmt.Errorf("parseSRV: %w", err)
+	}
+	target, err := decodeName(v)
+	if err != nil {
+		return SRV{}, fmt.Errorf("parseSRV: error decoding target: %w", err)
+	}
+	if v.Remaining() != 0 {
+		return SRV{}, fmt.Errorf("parseSRV: %d trailing bytes", v.Remaining())
+	}
+	return SRV{
+		Priority: binary.BigEndian.Uint16(bs[0:2]),
+		Weight:   binary.BigEndian.Uint16(bs[2:4]),
+		Port:     binary.BigEndian.Uint16(bs[4:6]),
+		Target:   string(target),
+	}, nil
+}
+
+// Encode encodes the SRV as record data in network order.
+func (srv SRV) Encode() []byte {
+	out := binary.BigEndian.AppendUint16(nil, srv.Priority)
+	out = binary.BigEndian.AppendUint16(out, srv.Weight)
+	out = binary.BigEndian.AppendUint16(out, srv.Port)
+	return append(out, encodeName(srv.Target)...)
+}
+
+// CAA holds the data of a CAA record, which names the certificate
+// authorities allowed to issue certificates for a domain:
+// https://datatracker.ietf.org/doc/html/rfc8659
+type CAA struct {
+	Flags uint8
+	Tag   string // e.g. "issue", "issuewild" or "iodef"
+	Value string
+}
+
+// parseCAA parses the data of a CAA record.
+func parseCAA(data []byte) (CAA, error) {
+	v := byteview.New(data)
+	bs, err := v.Next(2)
+	if err != nil {
+		return CAA{}, fmt.Errorf("parseCAA: %w", err)
+	}
+	tag, err := v.Next(uint16(bs[1]))
+	if err != nil {
+		return CAA{}, fmt.Errorf("parseCAA: error decoding tag: %w", err)
+	}
+	if err := validateCAATag(string(tag)); err != nil {
+		return CAA{}, fmt.Errorf("parseCAA: %w", err)
+	}
+	value, _ := v.Next(uint16(v.Remaining()))
+	return CAA{Flags: bs[0], Tag: string(tag), Value: string(value)}, nil
+}
+
+// validateCAATag checks that a CAA tag is between 1 and 15 ASCII letters and
+// digits.
+func validateCAATag(tag string) error {
+	if len(tag) == 0 || len(tag) > 15 {
+		return fmt.Errorf("invalid CAA tag %q: must be 1 to 15 characters", tag)
+	}
+	for _, c := range []byte(tag) {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return fmt.Errorf("invalid CAA tag %q: must be letters and digits", tag)
+		}
+	}
+	return nil
+}
+
+// Encode encodes the CAA as record data in network order.
+func (caa CAA) Encode() []byte {
+	out := []byte{caa.Flags, byte(len(caa.Tag))}
+	out = append(out, caa.Tag...)
+	return append(out, caa.Value...)
+}
+
+// NewA returns an A record for the given name and IPv4 address.
+func NewA(name string, ttl uint32, ip net.IP) (Record, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return Record{}, fmt.Errorf("invalid A record for %s: %v is not an IPv4 address", name, ip)
+	}
+	return newRecord(name, RecordTypeA, ttl, append([]byte(nil), ip4...))
+}
+
+// NewAAAA returns an AAAA record for the given name and IPv6 address.
+func NewAAAA(name string, ttl uint32, ip net.IP) (Record, error) {
+	if len(ip) != net.IPv6len || ip.To4() != nil {
+		return Record{}, fmt.Errorf("invalid AAAA record for %s: %v is not an IPv6 address", name, ip)
+	}
+	return newRecord(name, RecordTypeAAAA, ttl, append([]byte(nil), ip...))
+}
+
+// NewCNAME returns a CNAME record making name an alias for target.
+func NewCNAME(name string, ttl uint32, target string) (Record, error) {
+	return newNameRecord(name, RecordTypeCNAME, ttl, target)
+}
+
+// NewNS returns an NS record delegating name to the given name server.
+func NewNS(name string, ttl uint32, nameServer string) (Record, error) {
+	return newNameRecord(name, RecordTypeNS, ttl, nameServer)
+}
+
+// NewPTR returns a PTR record pointing name, usually a reverse lookup name,
+// at target.
+func NewPTR(name string, ttl uint32, target string) (Record, error) {
+	return newNameRecord(name, RecordTypePTR, ttl, target)
+}
+
+// NewMX returns an MX record naming a mail exchange for name, with the given
+// preference. Lower preferences are tried first.
+func NewMX(name string, ttl uint32, preference uint16, exchange string) (Record, error) {
+	if err := validateName(exchange); err != nil {
+		return Record{}, fmt.Errorf("invalid MX record for %s: %w", name, err)
+	}
+	return newRecord(name, RecordTypeMX, ttl, MX{Preference: preference, Exchange: exchange}.Encode())
+}
+
+// NewSRV returns an SRV record locating a server for the service named by
+// name, e.g. "_http._tcp.example.com".
+func NewSRV(name string, ttl uint32, srv SRV) (Record, error) {
+	if err := validateName(srv.Target); err != nil {
+		return Record{}, fmt.Errorf("invalid SRV record for %s: %w", name, err)
+	}
+	srv.Target = strings.TrimSuffix(srv.Target, ".")
+	return newRecord(name, RecordTypeSRV, ttl, srv.Encode())
+}
+
+// NewCAA returns a CAA record restricting the certificate authorities that
+// may issue certificates for name.
+func NewCAA(name string, ttl uint32, caa CAA) (Record, error) {
+	if err := validateCAATag(caa.Tag); err != nil {
+		return Record{}, fmt.Errorf("invalid CAA record for %s: %w", name, err)
+	}
+	return newRecord(name, RecordTypeCAA, ttl, caa.Encode())
+}
+
+// NewTXT returns a TXT record holding the given strings, each of which is
+// limited to 255 bytes. Longer text must be split across several strings.
+func NewTXT(name string, ttl uint32, strs ...string) (Record, error) {
+	if len(strs) == 0 {
+		return Record{}, fmt.Errorf("invalid TXT record for %s: no strings", name)
+	}
+	var data []byte
+	for _, s := range strs {
+		if len(s) > 255 {
+			return Record{}, fmt.Errorf("invalid TXT record for %s: string of %d bytes exceeds 255 bytes", name, len(s))
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	if len(data) > 0xffff {
+		return Record{}, fmt.Errorf("invalid TXT record for %s: data of %d bytes exceeds 65535 bytes", name, len(data))
+	}
+	return newRecord(name, RecordTypeTXT, ttl, data)
+}
+
+// ParseTXT returns the character-strings in the data of a TXT record.
+// https://datatracker.ietf.org/doc/html/rfc1035#section-3.3.14
+func ParseTXT(data []byte) ([]string, error) {
+	var strs []string
+	for len(data) > 0 {
+		size := int(data[0])
+		if len(data) < 1+size {
+			return nil, fmt.Errorf("truncated TXT character-string")
+		}
+		strs = append(strs, string(data[1:1+size]))
+		data = data[1+size:]
+	}
+	return strs, nil
+}
+
+// newNameRecord returns a record whose data is a single name, which is
+// stored decoded.
+func newNameRecord(name string, recordType RecordType, ttl uint32, target string) (Record, error) {
+	if err := validateName(target); err != nil {
+		return Record{}, fmt.Errorf("invalid %s record for %s: %w", recordType, name, err)
+	}
+	return newRecord(name, recordType, ttl, []byte(strings.TrimSuffix(target, ".")))
+}
+
+// maxTTL is the largest TTL allowed:
+// https://datatracker.ietf.org/doc/html/rfc2181#section-8
+const maxTTL = 1<<31 - 1
+
+// newRecord returns an IN record with the given owner name and data, with
+// the name stored without a trailing dot, as it is in parsed records.
+func newRecord(name string, recordType RecordType, ttl uint32, data []byte) (Record, error) {
+	if err := validateName(name); err != nil {
+		return Record{}, fmt.Errorf("invalid %s record: %w", recordType, err)
+	}
+	if ttl > maxTTL {
+		return Record{}, fmt.Errorf("invalid %s record for %s: TTL %d exceeds %d", recordType, name, ttl, maxTTL)
+	}
+	return Record{
+		Name:  []byte(strings.TrimSuffix(name, ".")),
+		Type:  recordType,
+		Class: ResourceClassIN,
+		TTL:   ttl,
+		Data:  data,
+	}, nil
+}
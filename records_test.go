@@ -0,0 +1,143 @@
+package dnstoy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+func TestRecordConstructors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		build func() (Record, error)
+		want  string
+	}{
+		"A": {
+			build: func() (Record, error) { return NewA("example.com.", 300, net.ParseIP("192.0.2.1")) },
+			want:  "example.com.\t300\tIN\tA\t192.0.2.1",
+		},
+		"AAAA": {
+			build: func() (Record, error) { return NewAAAA("example.com", 300, net.ParseIP("2001:db8::1")) },
+			want:  "example.com.\t300\tIN\tAAAA\t2001:db8::1",
+		},
+		"CNAME": {
+			build: func() (Record, error) { return NewCNAME("www.example.com", 60, "example.com.") },
+			want:  "www.example.com.\t60\tIN\tCNAME\texample.com.",
+		},
+		"NS": {
+			build: func() (Record, error) { return NewNS("example.com", 3600, "ns1.example.com") },
+			want:  "example.com.\t3600\tIN\tNS\tns1.example.com.",
+		},
+		"PTR": {
+			build: func() (Record, error) { return NewPTR("1.2.0.192.in-addr.arpa", 3600, "example.com") },
+			want:  "1.2.0.192.in-addr.arpa.\t3600\tIN\tPTR\texample.com.",
+		},
+		"MX": {
+			build: func() (Record, error) { return NewMX("example.com", 3600, 10, "mail.example.com") },
+			want:  "example.com.\t3600\tIN\tMX\t10 mail.example.com.",
+		},
+		"SRV": {
+			build: func() (Record, error) {
+				return NewSRV("_http._tcp.example.com", 120, SRV{Priority: 0, Weight: 5, Port: 8080, Target: "web.example.com."})
+			},
+			want: "_http._tcp.example.com.\t120\tIN\tSRV\t0 5 8080 web.example.com.",
+		},
+		"CAA": {
+			build: func() (Record, error) {
+				return NewCAA("example.com", 3600, CAA{Flags: 128, Tag: "issue", Value: "ca.example; account=\"1\""})
+			},
+			want: "example.com.\t3600\tIN\tCAA\t128 issue \"ca.example; account=\\\"1\\\"\"",
+		},
+		"TXT": {
+			build: func() (Record, error) { return NewTXT("example.com", 60, "v=spf1 -all", "") },
+			want:  "example.com.\t60\tIN\tTXT\t\"v=spf1 -all\" \"\"",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rec, err := tc.build()
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, rec.String())
+
+			// the record survives a trip through the wire format, with name
+			// compression
+			msg := Message{Answers: []Record{rec, rec}}
+			parsed, err := parseMessage(byteview.New(msg.Encode()))
+			be.NilErr(t, err)
+			be.Equal(t, tc.want, parsed.Answers[1].String())
+			be.Equal(t, string(rec.Data), string(parsed.Answers[1].Data))
+		})
+	}
+}
+
+func TestRecordConstructorErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]func() (Record, error){
+		"A with IPv6 address":    func() (Record, error) { return NewA("example.com", 60, net.ParseIP("2001:db8::1")) },
+		"AAAA with IPv4 address": func() (Record, error) { return NewAAAA("example.com", 60, net.ParseIP("192.0.2.1")) },
+		"invalid name":           func() (Record, error) { return NewA("bad..name", 60, net.ParseIP("192.0.2.1")) },
+		"invalid target":         func() (Record, error) { return NewCNAME("example.com", 60, strings.Repeat("a", 64)) },
+		"invalid exchange":       func() (Record, error) { return NewMX("example.com", 60, 10, "mail..example.com") },
+		"invalid SRV target":     func() (Record, error) { return NewSRV("_x._tcp.example.com", 60, SRV{Target: "a..b"}) },
+		"invalid CAA tag":        func() (Record, error) { return NewCAA("example.com", 60, CAA{Tag: "is sue"}) },
+		"TTL too large":          func() (Record, error) { return NewNS("example.com", 1<<31, "ns1.example.com") },
+		"no TXT strings":         func() (Record, error) { return NewTXT("example.com", 60) },
+		"TXT string too long":    func() (Record, error) { return NewTXT("example.com", 60, strings.Repeat("a", 256)) },
+	}
+	for name, build := range testCases {
+		build := build
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := build()
+			be.True(t, err != nil)
+		})
+	}
+}
+
+func TestParseCompressedMX(t *testing.T) {
+	t.Parallel()
+
+	// an MX record whose exchange is a pointer to the question name
+	buf := Header{ID: 1, Flags: headerFlagQR, QuestionCount: 1, AnswerCount: 1}.Encode()
+	buf = append(buf, encodeName("example.com")...)
+	buf = append(buf, 0, byte(RecordTypeMX), 0, 1)
+	buf = append(buf, 0xc0, 12, 0, byte(RecordTypeMX), 0, 1, 0, 0, 0, 60, 0, 4, 0, 10, 0xc0, 12)
+
+	msg, err := parseMessage(byteview.New(buf))
+	be.NilErr(t, err)
+	be.Equal(t, "example.com.\t60\tIN\tMX\t10 example.com.", msg.Answers[0].String())
+}
+
+func TestParseCompressedSRV(t *testing.T) {
+	t.Parallel()
+
+	// an SRV record whose target is a pointer to the question name, as sent
+	// by mDNS responders
+	buf := Header{ID: 1, Flags: headerFlagQR, QuestionCount: 1, AnswerCount: 1}.Encode()
+	buf = append(buf, encodeName("example.com")...)
+	buf = append(buf, 0, byte(RecordTypeSRV), 0, 1)
+	buf = append(buf, 0xc0, 12, 0, byte(RecordTypeSRV), 0, 1, 0, 0, 0, 60, 0, 8, 0, 1, 0, 2, 0x1f, 0x90, 0xc0, 12)
+
+	msg, err := parseMessage(byteview.New(buf))
+	be.NilErr(t, err)
+	be.Equal(t, "example.com.\t60\tIN\tSRV\t1 2 8080 example.com.", msg.Answers[0].String())
+}
+
+func TestParseTXT(t *testing.T) {
+	t.Parallel()
+
+	strs, err := ParseTXT([]byte("\x05hello\x00\x05world"))
+	be.NilErr(t, err)
+	be.AllEqual(t, []string{"hello", "", "world"}, strs)
+
+	_, err = ParseTXT([]byte("\x05hell"))
+	be.True(t, err != nil)
+}
@@ -0,0 +1,141 @@
+package dnstoy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultResolvConfFile is the conventional location of the system stub
+// resolver configuration.
+const DefaultResolvConfFile = "/etc/resolv.conf"
+
+// Limits applied to resolv.conf options, matching glibc.
+const (
+	maxResolvConfNdots    = 15
+	maxResolvConfTimeout  = 30
+	maxResolvConfAttempts = 5
+)
+
+// ResolvConf holds stub resolver configuration parsed from a resolv.conf
+// file, in the format described by resolv.conf(5).
+type ResolvConf struct {
+	// Nameservers are the addresses of the recursive resolvers to query.
+	Nameservers []net.IP
+
+	// Search is the list of domains appended to names with fewer than Ndots
+	// dots when resolving them.
+	Search []string
+	Ndots  int
+
+	// Timeout and Attempts control how long to wait for each query and how
+	// many times to send it.
+	Timeout  time.Duration
+	Attempts int
+}
+
+// LoadResolvConf loads and parses the resolv.conf file at the given path.
+func LoadResolvConf(path string) (*ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conf, err := ParseResolvConf(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return conf, nil
+}
+
+// ParseResolvConf parses resolv.conf entries from r. Unknown keywords and
+// options are ignored, as are nameserver lines with invalid addresses.
+func ParseResolvConf(r io.Reader) (*ResolvConf, error) {
+	conf := &ResolvConf{
+		Ndots:    1,
+		Timeout:  5 * time.Second,
+		Attempts: 2,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			// strip any IPv6 zone, e.g. "fe80::1%eth0"
+			if addr := net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]); addr != nil {
+				conf.Nameservers = append(conf.Nameservers, addr)
+			}
+		case "domain":
+			// the domain and search keywords are mutually exclusive, and the
+			// last one wins
+			conf.Search = []string{strings.TrimSuffix(fields[1], ".")}
+		case "search":
+			conf.Search = conf.Search[:0]
+			for _, domain := range fields[1:] {
+				conf.Search = append(conf.Search, strings.TrimSuffix(domain, "."))
+			}
+		case "options":
+			for _, opt := range fields[1:] {
+				name, value, found := strings.Cut(opt, ":")
+				if !found {
+					continue
+				}
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					continue
+				}
+				switch name {
+				case "ndots":
+					conf.Ndots = clamp(n, 0, maxResolvConfNdots)
+				case "timeout":
+					conf.Timeout = time.Duration(clamp(n, 1, maxResolvConfTimeout)) * time.Second
+				case "attempts":
+					conf.Attempts = clamp(n, 1, maxResolvConfAttempts)
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Opts returns resolver options that forward queries to the configured name
+// servers, like the system stub resolver, with its search list, ndots,
+// timeout and attempts.
+func (c *ResolvConf) Opts() *Opts {
+	upstreams := make([]string, len(c.Nameservers))
+	for i, addr := range c.Nameservers {
+		upstreams[i] = addr.String()
+	}
+	return &Opts{
+		Upstreams:     upstreams,
+		QueryTimeout:  c.Timeout,
+		QueryAttempts: c.Attempts,
+		Search:        c.Search,
+		Ndots:         c.Ndots,
+	}
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package dnstoy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	t.Parallel()
+
+	conf, err := ParseResolvConf(strings.NewReader(`
+# generated by NetworkManager
+domain corp.example.com
+search example.com. lab.example.com ; overrides domain
+nameserver 192.0.2.53
+nameserver fe80::1%eth0
+nameserver not-an-ip
+options ndots:2 timeout:3 attempts:9 rotate edns0
+`))
+	be.NilErr(t, err)
+	be.Equal(t, "[192.0.2.53 fe80::1]", fmt.Sprint(conf.Nameservers))
+	be.Equal(t, "[example.com lab.example.com]", fmt.Sprint(conf.Search))
+	be.Equal(t, 2, conf.Ndots)
+	be.Equal(t, 3*time.Second, conf.Timeout)
+	be.Equal(t, maxResolvConfAttempts, conf.Attempts)
+
+	opts := conf.Opts()
+	be.Equal(t, 3*time.Second, opts.QueryTimeout)
+	be.Equal(t, 2, len(opts.Search))
+
+	// defaults
+	conf, err = ParseResolvConf(strings.NewReader(""))
+	be.NilErr(t, err)
+	be.Equal(t, 1, conf.Ndots)
+	be.Equal(t, 5*time.Second, conf.Timeout)
+	be.Equal(t, 2, conf.Attempts)
+}
+
+func TestSearchNames(t *testing.T) {
+	t.Parallel()
+
+	r := New(&Opts{Search: []string{"example.com", "example.org"}, Ndots: 1})
+	be.Equal(t, "[www.example.com www.example.org www]", fmt.Sprint(r.SearchNames("www")))
+	be.Equal(t, "[www.example.net www.example.net.example.com www.example.net.example.org]", fmt.Sprint(r.SearchNames("www.example.net")))
+	be.Equal(t, "[www.]", fmt.Sprint(r.SearchNames("www.")))
+
+	r = New(nil)
+	be.Equal(t, "[www]", fmt.Sprint(r.SearchNames("www")))
+}
+
+func TestLookupIPSearch(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		if !strings.EqualFold(string(q.Questions[0].Name), "www.lab.example.test") {
+			return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+		}
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	r := newTestResolver(port, &Opts{Search: []string{"example.test", "lab.example.test"}, Ndots: 1})
+	ips, err := r.LookupIP(context.Background(), "ip4", "www")
+	be.NilErr(t, err)
+	be.Equal(t, "1.2.3.4", ips[0].String())
+}
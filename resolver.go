@@ -1,10 +1,16 @@
 package dnstoy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mccutchen/dnstoy/internal/byteview"
@@ -16,28 +22,41 @@ import (
 //
 // may be overridden in tests
 var defaultRootNameServers = []nameServerDef{
-	newNameServerDef("a.root-servers.net", ".", net.ParseIP("198.41.0.4")),     // Verisign, Inc.
-	newNameServerDef("b.root-servers.net", ".", net.ParseIP("199.9.14.201")),   // University of Southern California, Information Sciences Institute
-	newNameServerDef("c.root-servers.net", ".", net.ParseIP("192.33.4.12")),    // Cogent Communications
-	newNameServerDef("d.root-servers.net", ".", net.ParseIP("199.7.91.13")),    // University of Maryland
-	newNameServerDef("e.root-servers.net", ".", net.ParseIP("192.203.230.10")), // NASA (Ames Research Center)
-	newNameServerDef("f.root-servers.net", ".", net.ParseIP("192.5.5.241")),    // Internet Systems Consortium, Inc.
-	newNameServerDef("g.root-servers.net", ".", net.ParseIP("192.112.36.4")),   // US Department of Defense (NIC)
-	newNameServerDef("h.root-servers.net", ".", net.ParseIP("198.97.190.53")),  // US Army (Research Lab)
-	newNameServerDef("i.root-servers.net", ".", net.ParseIP("192.36.148.17")),  // Netnod
-	newNameServerDef("j.root-servers.net", ".", net.ParseIP("192.58.128.30")),  // Verisign, Inc.
-	newNameServerDef("k.root-servers.net", ".", net.ParseIP("193.0.14.129")),   // RIPE NCC
-	newNameServerDef("l.root-servers.net", ".", net.ParseIP("199.7.83.42")),    // ICANN
-	newNameServerDef("m.root-servers.net", ".", net.ParseIP("202.12.27.33")),   // WIDE Project
-}
-
-const defaultQueryTimeout = 1 * time.Second
+	newNameServerDef("a.root-servers.net", ".", net.ParseIP("198.41.0.4"), net.ParseIP("2001:503:ba3e::2:30")),   // Verisign, Inc.
+	newNameServerDef("b.root-servers.net", ".", net.ParseIP("199.9.14.201"), net.ParseIP("2001:500:200::b")),     // University of Southern California, Information Sciences Institute
+	newNameServerDef("c.root-servers.net", ".", net.ParseIP("192.33.4.12"), net.ParseIP("2001:500:2::c")),        // Cogent Communications
+	newNameServerDef("d.root-servers.net", ".", net.ParseIP("199.7.91.13"), net.ParseIP("2001:500:2d::d")),       // University of Maryland
+	newNameServerDef("e.root-servers.net", ".", net.ParseIP("192.203.230.10"), net.ParseIP("2001:500:a8::e")),    // NASA (Ames Research Center)
+	newNameServerDef("f.root-servers.net", ".", net.ParseIP("192.5.5.241"), net.ParseIP("2001:500:2f::f")),       // Internet Systems Consortium, Inc.
+	newNameServerDef("g.root-servers.net", ".", net.ParseIP("192.112.36.4"), net.ParseIP("2001:500:12::d0d")),    // US Department of Defense (NIC)
+	newNameServerDef("h.root-servers.net", ".", net.ParseIP("198.97.190.53"), net.ParseIP("2001:500:1::53")),     // US Army (Research Lab)
+	newNameServerDef("i.root-servers.net", ".", net.ParseIP("192.36.148.17"), net.ParseIP("2001:7fe::53")),       // Netnod
+	newNameServerDef("j.root-servers.net", ".", net.ParseIP("192.58.128.30"), net.ParseIP("2001:503:c27::2:30")), // Verisign, Inc.
+	newNameServerDef("k.root-servers.net", ".", net.ParseIP("193.0.14.129"), net.ParseIP("2001:7fd::1")),         // RIPE NCC
+	newNameServerDef("l.root-servers.net", ".", net.ParseIP("199.7.83.42"), net.ParseIP("2001:500:9f::42")),      // ICANN
+	newNameServerDef("m.root-servers.net", ".", net.ParseIP("202.12.27.33"), net.ParseIP("2001:dc3::35")),        // WIDE Project
+}
+
+const (
+	defaultQueryTimeout      = 1 * time.Second
+	defaultNetwork           = "udp"
+	defaultMaxDepth          = 30
+	defaultMaxCNAMEChain     = 10
+	defaultPort              = "53"
+	defaultQueryAttempts     = 3
+	defaultResolutionTimeout = 30 * time.Second
+	defaultRetryBackoff      = 100 * time.Millisecond
+	maxRaceNameServers       = 3
+)
 
 // New returns a new Resolver.
 func New(opts *Opts) *Resolver {
 	if opts == nil {
 		opts = &Opts{}
 	}
+	if len(opts.RootNameServers) == 0 && opts.RootHints != nil {
+		opts.RootNameServers = opts.RootHints.nameServers
+	}
 	if len(opts.RootNameServers) == 0 {
 		opts.RootNameServers = defaultRootNameServers
 	}
@@ -50,12 +69,144 @@ func New(opts *Opts) *Resolver {
 	if opts.QueryTimeout == 0 {
 		opts.QueryTimeout = defaultQueryTimeout
 	}
-	return &Resolver{
-		rootNameServers: opts.RootNameServers,
-		queryTimeout:    opts.QueryTimeout,
-		dialer:          opts.Dialer,
-		logger:          opts.Logger,
+	if opts.Network == "" {
+		opts.Network = defaultNetwork
+	}
+	if opts.ConnIdleTimeout == 0 {
+		opts.ConnIdleTimeout = defaultConnIdleTimeout
+	}
+	if opts.ResolutionTimeout == 0 {
+		opts.ResolutionTimeout = defaultResolutionTimeout
+	}
+	if opts.QueryAttempts == 0 {
+		opts.QueryAttempts = defaultQueryAttempts
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	if opts.RaceNameServers < 1 {
+		opts.RaceNameServers = 1
+	} else if opts.RaceNameServers > maxRaceNameServers {
+		opts.RaceNameServers = maxRaceNameServers
+	}
+	if opts.MaxDepth == 0 {
+		opts.MaxDepth = defaultMaxDepth
+	}
+	if opts.MaxCNAMEChain == 0 {
+		opts.MaxCNAMEChain = defaultMaxCNAMEChain
+	}
+	if opts.PrefetchMinHits == 0 {
+		opts.PrefetchMinHits = defaultPrefetchMinHits
+	}
+	if opts.Cache == nil && !opts.DisableCache {
+		opts.Cache = NewMemoryCache(opts.CacheMaxEntries, opts.CacheMaxBytes)
+	}
+	if opts.DNSSEC && opts.TrustAnchors == nil {
+		opts.TrustAnchors = RootTrustAnchors()
+	}
+	if opts.Algorithms == nil {
+		opts.Algorithms = DefaultAlgorithms()
+	}
+	if opts.AddressFilter == nil {
+		opts.AddressFilter = DefaultAddressFilter
+	}
+	ownsHTTPClient := opts.HTTPClient == nil
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: opts.QueryTimeout}
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = NopMetrics{}
+	}
+	ownsTransport := opts.Transport == nil
+	if opts.Transport == nil {
+		if isStreamNetwork(opts.Network) {
+			opts.Transport = &TCPTransport{
+				Network:     opts.Network,
+				Dialer:      opts.Dialer,
+				Timeout:     opts.QueryTimeout,
+				IdleTimeout: opts.ConnIdleTimeout,
+				ParseMode:   opts.ParseMode,
+			}
+		} else {
+			opts.Transport = &UDPTransport{
+				Network:   opts.Network,
+				Dialer:    opts.Dialer,
+				Timeout:   opts.QueryTimeout,
+				ParseMode: opts.ParseMode,
+			}
+		}
+	}
+	var (
+		upstreams []nameServerDef
+		configErr error
+	)
+	for _, upstream := range opts.Upstreams {
+		ns, err := parseUpstream(upstream)
+		if err != nil {
+			configErr = err
+			break
+		}
+		upstreams = append(upstreams, ns)
+	}
+	forwardZones, err := parseForwardZones(opts.ForwardZones)
+	if err != nil && configErr == nil {
+		configErr = err
+	}
+	var nsec *nsecCache
+	if opts.AggressiveNSEC {
+		nsec = newNSECCache()
+	}
+	r := &Resolver{
+		rootHints:         opts.RootNameServers,
+		rootNameServers:   opts.RootNameServers,
+		rootPriming:       !opts.DisableRootPriming,
+		addrFilter:        opts.AddressFilter,
+		parseMode:         opts.ParseMode,
+		requestDNSSEC:     opts.RequestDNSSEC || opts.DNSSEC,
+		dnssec:            opts.DNSSEC,
+		trustAnchors:      opts.TrustAnchors,
+		anchorTracker:     opts.TrustAnchorTracker,
+		anchorStatePath:   opts.TrustAnchorStatePath,
+		algorithms:        opts.Algorithms,
+		checkingDisabled:  opts.CheckingDisabled,
+		search:            opts.Search,
+		upstreams:         upstreams,
+		forwardZones:      forwardZones,
+		httpClient:        opts.HTTPClient,
+		ownsHTTPClient:    ownsHTTPClient,
+		configErr:         configErr,
+		ndots:             opts.Ndots,
+		queryTimeout:      opts.QueryTimeout,
+		queryAttempts:     opts.QueryAttempts,
+		resolutionTimeout: opts.ResolutionTimeout,
+		retryBackoff:      opts.RetryBackoff,
+		raceSize:          opts.RaceNameServers,
+		rtt:               newRTTTracker(),
+		network:           opts.Network,
+		dialer:            opts.Dialer,
+		requestNSID:       opts.RequestNSID,
+		transport:         opts.Transport,
+		ownsTransport:     ownsTransport,
+		metrics:           opts.Metrics,
+		capture:           opts.Capture,
+		tracer:            opts.Tracer,
+		logger:            opts.Logger,
+		cache:             opts.Cache,
+		prefetchPct:       opts.PrefetchThreshold,
+		prefetchHits:      opts.PrefetchMinHits,
+		nsec:              nsec,
+		hosts:             opts.Hosts,
+		maxDepth:          opts.MaxDepth,
+		maxCNAMEChain:     opts.MaxCNAMEChain,
+		randomizeCase:     !opts.DisableCaseRandomization,
+		answerOrder:       opts.AnswerOrder,
+		sortAddresses:     opts.SortAddresses,
+		port:              defaultPort,
 	}
+	if opts.HealthCheckInterval > 0 && configErr == nil {
+		r.startHealthChecks(opts.HealthCheckInterval)
+	}
+	return r
 }
 
 // Opts defines the options used to configure a Resolver.
@@ -64,189 +215,1451 @@ type Opts struct {
 	QueryTimeout    time.Duration
 	Dialer          *net.Dialer
 	Logger          *slog.Logger
+
+	// ResolutionTimeout bounds the total time taken by a single lookup,
+	// including every query sent while following referrals, resolving name
+	// servers and chasing CNAMEs, independently of QueryTimeout. Defaults to
+	// 30s.
+	ResolutionTimeout time.Duration
+
+	// QueryAttempts is the number of times a query is sent to each of a name
+	// server's addresses before moving on to the next address, when queries
+	// time out. Defaults to 3.
+	QueryAttempts int
+
+	// RetryBackoff is the delay before retrying a query that timed out,
+	// doubled for each subsequent attempt, with random jitter applied.
+	// Defaults to 100ms.
+	RetryBackoff time.Duration
+
+	// Network is the network used to send queries to name servers, one of
+	// "udp", "udp4", "udp6", "tcp", "tcp4" or "tcp6". Use "udp6" or "tcp6"
+	// on IPv6-only hosts. Defaults to "udp". If Transport is set, Network
+	// only restricts the address family of the name servers queried.
+	Network string
+
+	// ConnIdleTimeout controls how long idle TCP connections to name servers
+	// are kept open for reuse. Defaults to 10s.
+	ConnIdleTimeout time.Duration
+
+	// Transport sends queries to name servers, other than DNS-over-HTTPS
+	// upstreams. Defaults to a UDPTransport or TCPTransport, depending on
+	// Network, using Dialer, QueryTimeout, ConnIdleTimeout and ParseMode.
+	// See TLSTransport for DNS over TLS.
+	Transport Transport
+
+	// Metrics receives measurements of the queries sent, cache lookups and
+	// retries. Defaults to NopMetrics.
+	Metrics Metrics
+
+	// Capture, if set, records each query sent to a name server, and its
+	// response, as packets in a capture file. Queries sent over DNS over
+	// TLS are recorded as if sent over TCP, and those sent over HTTPS are
+	// not recorded. See PcapWriter.
+	Capture *PcapWriter
+
+	// Tracer, if set, is used to start a span for each lookup, with a
+	// child span for each query sent to a name server. See Tracer.
+	Tracer Tracer
+
+	// RequestNSID adds the EDNS NSID option to outgoing queries, asking name
+	// servers to identify themselves. Any identifiers received are logged.
+	RequestNSID bool
+
+	// RaceNameServers is the number of name servers, up to 3, that each
+	// query is sent to concurrently, with the first response used and the
+	// remaining queries cancelled. This reduces latency when a name server
+	// is slow or unresponsive, at the cost of additional queries. Defaults
+	// to 1, which disables racing.
+	RaceNameServers int
+
+	// MaxDepth bounds the number of recursive steps (referrals, name server
+	// lookups and CNAMEs followed) taken to resolve a single name. Defaults
+	// to 30.
+	MaxDepth int
+
+	// MaxCNAMEChain bounds the number of CNAMEs followed when resolving a
+	// single name. Defaults to 10.
+	MaxCNAMEChain int
+
+	// Cache stores answers between lookups. Defaults to a MemoryCache
+	// bounded by CacheMaxEntries and CacheMaxBytes.
+	Cache Cache
+
+	// DisableCache disables caching of answers when no Cache is given, so
+	// that every lookup is resolved from the root.
+	DisableCache bool
+
+	// PrefetchThreshold enables proactive refreshing of popular cache
+	// entries when they are within this percentage of their original TTL of
+	// expiring, e.g. 10 to refresh entries during the last 10% of their
+	// lifetime. Zero disables prefetching. Prefetching requires the default
+	// MemoryCache, which tracks cache hits.
+	PrefetchThreshold float64
+
+	// PrefetchMinHits is the number of cache hits an entry must receive
+	// before it is eligible for prefetching. Defaults to 2.
+	PrefetchMinHits int
+
+	// CacheMaxEntries and CacheMaxBytes bound the size of the default cache,
+	// by number of RRsets and approximate memory use respectively, evicting
+	// the least recently used entries when either limit is reached. Zero
+	// means unlimited.
+	CacheMaxEntries int
+	CacheMaxBytes   int
+
+	// Hosts, if set, is consulted before any queries are sent, like a system
+	// resolver's hosts file. See LoadHosts.
+	Hosts *Hosts
+
+	// AggressiveNSEC enables aggressive use of cached NSEC records (RFC
+	// 8198) to answer queries for names proven not to exist without sending
+	// any queries. This requests DNSSEC records from name servers, but
+	// because dnstoy does not validate signatures the NSEC records are
+	// trusted as-is.
+	AggressiveNSEC bool
+
+	// DisableCaseRandomization disables "DNS 0x20" randomization of the
+	// case of query names, which adds entropy to outgoing queries that a
+	// forged response would have to guess. Some name servers do not echo
+	// the query name exactly and cannot be used with it enabled.
+	// https://datatracker.ietf.org/doc/html/draft-vixie-dnsext-dns0x20-00
+	DisableCaseRandomization bool
+
+	// Search is a list of domains to append to relative names when looking
+	// them up, tried in order until one resolves. Names with at least Ndots
+	// dots are first tried as-is, before the search list; others are tried
+	// as-is last. Names ending in a "." are never searched. See
+	// ResolvConf.
+	Search []string
+	Ndots  int
+
+	// RootHints, if set, lists the root name servers to start resolving
+	// names from, instead of the built-in root hints. See LoadRootHints.
+	RootHints *RootHints
+
+	// DisableRootPriming disables the priming query used to discover the
+	// current set of root name servers, so that RootNameServers (or the
+	// root hints) are used as-is.
+	// https://datatracker.ietf.org/doc/html/rfc8109
+	DisableRootPriming bool
+
+	// AddressFilter decides which name server addresses, learned from glue
+	// records or by resolving name servers' names, may be queried.
+	// Defaults to DefaultAddressFilter, which skips private addresses. Use
+	// AllowAllAddresses to resolve names served by internal name servers,
+	// or StrictAddressFilter for protection against delegations that point
+	// at loopback, link-local or other special-purpose addresses. The
+	// filter does not apply to root name servers or upstreams.
+	AddressFilter AddressFilter
+
+	// DNSSEC enables DNSSEC-aware resolution. Queries are sent with the
+	// DNSSEC OK bit set, as with RequestDNSSEC, and whenever a referral
+	// is followed, the parent zone's DS records for the child zone are
+	// also fetched and cached, establishing the chain of trust. Negative
+	// responses from signed zones must prove the nonexistence of the name,
+	// and LookupIPResult validates answers.
+	DNSSEC bool
+
+	// TrustAnchors are the DNSSEC trust anchors from which chains of trust
+	// are validated when DNSSEC is enabled. Defaults to RootTrustAnchors.
+	// See LoadTrustAnchors.
+	TrustAnchors []TrustAnchor
+
+	// TrustAnchorTracker, if set, keeps the trust anchors for its zone up
+	// to date across key rollovers, as described in RFC 5011. Its trusted
+	// keys replace any TrustAnchors for the same zone, and it is updated
+	// with each DNSKEY RRset for the zone that validates.
+	TrustAnchorTracker *TrustAnchorTracker
+
+	// TrustAnchorStatePath is the file to which the state of
+	// TrustAnchorTracker is saved whenever it changes, so that its
+	// hold-down timers survive restarts. See LoadTrustAnchorTracker.
+	TrustAnchorStatePath string
+
+	// Algorithms are the DNSSEC signature algorithms that can be validated.
+	// Zones signed only with other algorithms are treated as insecure.
+	// Defaults to DefaultAlgorithms.
+	Algorithms Algorithms
+
+	// RequestDNSSEC sets the DNSSEC OK (DO) bit on queries, asking name
+	// servers to include DNSSEC records (RRSIG, NSEC, etc) in responses.
+	RequestDNSSEC bool
+
+	// CheckingDisabled sets the Checking Disabled (CD) bit on queries sent
+	// to Upstreams, asking them to return data without validating it. The
+	// Authenticated Data (AD) bit asserted by upstreams in responses may be
+	// inspected via LookupIPWithTrace.
+	CheckingDisabled bool
+
+	// ParseMode controls how malformed responses are handled. In
+	// ParseLenient mode, whatever can be salvaged from a malformed response
+	// is used and the parse errors are logged, which may help when
+	// debugging broken name servers. Defaults to ParseStrict.
+	ParseMode ParseMode
+
+	// Upstreams configures the resolver to forward queries to the given
+	// recursive resolvers, rather than iterating from the root. Each
+	// upstream is an IP address with an optional port (e.g. "8.8.8.8" or
+	// "[2001:4860:4860::8888]:53") or a DNS-over-HTTPS URL (e.g.
+	// "https://dns.google/dns-query"). If any upstream is invalid, every
+	// lookup fails with a descriptive error.
+	Upstreams []string
+
+	// ForwardZones configures the resolver to forward queries for the names
+	// in certain zones to the given recursive resolvers, in the same form
+	// as Upstreams, e.g. to resolve the names in an internal zone with an
+	// internal resolver. Queries for other names are resolved as usual. The
+	// most specific zone containing a name applies.
+	ForwardZones map[string][]string
+
+	// HealthCheckInterval, if set, is how often upstreams, including those
+	// of ForwardZones, are probed in the background. Upstreams that fail
+	// several consecutive probes are marked down, and tried only after
+	// healthy ones until a probe succeeds again. Each upstream's health is
+	// reported to Metrics. Shut the resolver down to stop probing.
+	HealthCheckInterval time.Duration
+
+	// HTTPClient is used for DNS-over-HTTPS upstreams. Defaults to a client
+	// with a timeout of QueryTimeout.
+	HTTPClient *http.Client
+
+	// AnswerOrder controls the order of the addresses returned by LookupIP
+	// and friends, e.g. to rotate them between lookups for crude client-side
+	// load balancing. Defaults to AnswerOrderPreserve.
+	AnswerOrder AnswerOrder
+
+	// SortAddresses sorts the addresses returned by LookupIP and friends in
+	// the order in which they should be tried according to RFC 6724's
+	// destination address selection rules, e.g. preferring IPv6 addresses
+	// only when this host has IPv6 connectivity. The sort is stable, so
+	// addresses that are equally preferable stay in the order given by
+	// AnswerOrder.
+	// https://datatracker.ietf.org/doc/html/rfc6724
+	SortAddresses bool
 }
 
 // Resolver makes DNS queries.
 type Resolver struct {
-	rootNameServers []nameServerDef
-	queryTimeout    time.Duration
-	dialer          *net.Dialer
-	logger          *slog.Logger
+	rootHints         []nameServerDef
+	rootPriming       bool
+	addrFilter        AddressFilter
+	parseMode         ParseMode
+	requestDNSSEC     bool
+	dnssec            bool
+	trustAnchors      []TrustAnchor
+	anchorTracker     *TrustAnchorTracker
+	anchorStatePath   string
+	anchorSaveMu      sync.Mutex // serializes saving anchorTracker's state
+	algorithms        Algorithms
+	checkingDisabled  bool
+	primeMu           sync.Mutex // serializes priming queries
+	rootsMu           sync.Mutex // guards rootNameServers and rootsExpire
+	rootNameServers   []nameServerDef
+	rootsExpire       time.Time
+	search            []string
+	upstreams         []nameServerDef // if set, queries are forwarded to these
+	forwardZones      []forwardZone   // most specific first
+	httpClient        *http.Client
+	ownsHTTPClient    bool  // closed on shutdown if set
+	configErr         error // returned by every lookup if set
+	ndots             int
+	queryTimeout      time.Duration
+	queryAttempts     int
+	resolutionTimeout time.Duration
+	retryBackoff      time.Duration
+	raceSize          int
+	rtt               *rttTracker
+	network           string
+	dialer            *net.Dialer // for zone transfers
+	requestNSID       bool
+	transport         Transport
+	ownsTransport     bool // closed on shutdown if set
+	metrics           Metrics
+	capture           *PcapWriter // nil if capture is disabled
+	tracer            Tracer      // nil if tracing is disabled
+	logger            *slog.Logger
+	cache             Cache // nil if caching is disabled
+	prefetchPct       float64
+	prefetchHits      int
+	nsec              *nsecCache // nil if aggressive NSEC caching is disabled
+	hosts             *Hosts     // may be nil
+	maxDepth          int
+	maxCNAMEChain     int
+	randomizeCase     bool
+	answerOrder       AnswerOrder
+	sortAddresses     bool
+	roundRobin        roundRobin
+	port              string // may be overridden in tests
+	life              lifecycle
 }
 
-// LookupIP recursively resolves the given domain name, returning the resolved
-// IP addresses.
-func (r *Resolver) LookupIP(ctx context.Context, domainName string) ([]net.IP, error) {
-	result, _, err := r.doLookupIP(ctx, r.chooseRootNameServer(), domainName, 0)
-	return result, err
+// LookupIP recursively resolves the given host, returning its IP addresses,
+// like net.Resolver.LookupIP. The network must be "ip4" to look up IPv4
+// addresses only, "ip6" for IPv6 addresses only, or "ip" for both, in which
+// case the lookup only fails if neither address family could be resolved.
+// Relative names are resolved using the search list, if any, and IP address
+// literals of the requested family are returned as-is. Otherwise, IPv4
+// addresses are returned in their 4-byte form, without duplicates, e.g.
+// where IPv4-mapped IPv6 addresses are found alongside the same IPv4
+// addresses. Any options override the resolver's configuration for this
+// lookup only.
+func (r *Resolver) LookupIP(ctx context.Context, network, host string, opts ...LookupOption) ([]net.IP, error) {
+	ctx = withLookupOpts(ctx, opts)
+	var recordTypes []RecordType
+	switch network {
+	case "ip":
+		recordTypes = []RecordType{RecordTypeA, RecordTypeAAAA}
+	case "ip4":
+		recordTypes = []RecordType{RecordTypeA}
+	case "ip6":
+		recordTypes = []RecordType{RecordTypeAAAA}
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if (network == "ip4" && ip.To4() == nil) || (network == "ip6" && ip.To4() != nil) {
+			return nil, fmt.Errorf("lookup %s: %w: address is not %s", host, ErrNoData, network)
+		}
+		return []net.IP{ip}, nil
+	}
+	if len(recordTypes) == 1 {
+		result, err := r.lookupIPResult(ctx, host, recordTypes[0], false)
+		if err != nil {
+			return nil, err
+		}
+		return r.arrangeAnswers(network, host, result.IPs), nil
+	}
+
+	results := make([]LookupResult, len(recordTypes))
+	errs := make([]error, len(recordTypes))
+	var wg sync.WaitGroup
+	for i, recordType := range recordTypes {
+		i, recordType := i, recordType
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = r.lookupIPResult(ctx, host, recordType, false)
+		}()
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	for _, result := range results {
+		ips = append(ips, result.IPs...)
+	}
+	if len(ips) == 0 {
+		// the IPv4 lookup's error is preferred, since it is the one that
+		// an "ip4" lookup would have returned
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r.arrangeAnswers(network, host, ips), nil
+}
+
+// arrangeAnswers normalizes, dedupes and orders the addresses found for the
+// given host.
+func (r *Resolver) arrangeAnswers(network, host string, ips []net.IP) []net.IP {
+	ips = normalizeIPs(ips)
+	r.orderAnswers(network, host, ips)
+	if r.sortAddresses {
+		sortRFC6724(ips)
+	}
+	return ips
 }
 
-func (r *Resolver) doLookupIP(ctx context.Context, nameServer nameServerDef, domainName string, depth int) ([]net.IP, int, error) {
-	msg, err := r.sendQuery(ctx, nameServer, domainName, RecordTypeA, depth)
+// LookupIPAddr resolves both the IPv4 and IPv6 addresses of the given host,
+// like net.Resolver.LookupIPAddr. IP address literals are returned as-is.
+// The lookup only fails if neither address family could be resolved.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string, opts ...LookupOption) ([]net.IPAddr, error) {
+	ips, err := r.LookupIP(ctx, "ip", host, opts...)
 	if err != nil {
-		return nil, depth, err
+		return nil, err
+	}
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: ip}
+	}
+	return addrs, nil
+}
+
+// LookupHost resolves the given host, returning its IPv4 and IPv6 addresses
+// as strings, like net.Resolver.LookupHost.
+func (r *Resolver) LookupHost(ctx context.Context, host string, opts ...LookupOption) ([]string, error) {
+	addrs, err := r.LookupIPAddr(ctx, host, opts...)
+	if err != nil {
+		return nil, err
 	}
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		hosts = append(hosts, addr.String())
+	}
+	return hosts, nil
+}
 
-	r.logRecords("answer", msg.Answers)
-	r.logRecords("authority", msg.Authorities)
-	r.logRecords("additional", msg.Additionals)
+// lookupIPResult resolves the addresses of the given type for the given
+// domain name, using the search list, and optionally validates the answer.
+func (r *Resolver) lookupIPResult(ctx context.Context, domainName string, recordType RecordType, validate bool) (result LookupResult, err error) {
+	ctx, span := r.startSpan(ctx, spanLookup,
+		SpanAttribute{"dns.question.name", domainName},
+		SpanAttribute{"dns.question.type", recordType.String()},
+	)
+	defer func() { endSpan(span, err) }()
 
-	// if we successfully resolved IP address(es), we're done
-	ips, err := ipAddrsFromRecords(msg.Answers)
+	ctx, done, err := r.begin(ctx)
 	if err != nil {
-		return nil, depth, err
+		return LookupResult{}, fmt.Errorf("lookup %s: %w", domainName, err)
+	}
+	defer done()
+
+	domainName, err = ToASCII(domainName)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, r.resolutionTimeoutFor(ctx))
+	defer cancel()
+	for _, name := range r.SearchNames(domainName) {
+		if result, err = r.lookupIP(lookupCtx, name, recordType, validate); err == nil || lookupCtx.Err() != nil || errors.Is(err, ErrBogus) {
+			return result, r.resolutionTimeoutError(ctx, domainName, err)
+		}
+		r.log(ctx).Debug("search name failed to resolve", slog.String("query_name", name), slog.String("err", err.Error()))
+	}
+	return LookupResult{}, err
+}
+
+func (r *Resolver) lookupIP(ctx context.Context, domainName string, recordType RecordType, validate bool) (LookupResult, error) {
+	if err := r.checkConfig(ctx); err != nil {
+		return LookupResult{}, err
+	}
+	if err := validateName(domainName); err != nil {
+		return LookupResult{}, err
+	}
+	if ips := r.hosts.lookupIP(domainName, recordType); len(ips) > 0 {
+		r.log(ctx).Debug("resolved from hosts file", slog.String("query_name", domainName))
+		result := LookupResult{IPs: ips, CanonicalName: domainName, Source: SourceHosts}
+		for _, ip := range ips {
+			result.Addrs = append(result.Addrs, AddrTTL{IP: ip})
+		}
+		return result, nil
+	}
+	r.primeRootNameServers(ctx)
+	state := newLookupState()
+	records, _, err := r.doLookup(ctx, state, r.startingNameServers(ctx, domainName), domainName, recordType, 0)
+	if err != nil {
+		if validate && errors.Is(err, ErrBogus) {
+			return LookupResult{Status: SecurityBogus}, err
+		}
+		return LookupResult{}, err
+	}
+	result, err := newLookupResult(state, domainName, recordType, records)
+	if err != nil || !validate {
+		return result, err
+	}
+	result.Status, result.RRSets, result.Chain = r.validate(ctx, state)
+	if result.Status == SecurityBogus {
+		result.IPs, result.Addrs = nil, nil
+		for _, rrset := range result.RRSets {
+			if rrset.Status == SecurityBogus {
+				return result, fmt.Errorf("lookup %s: %w: %s %s: %s", domainName, ErrBogus, rrset.Name, rrset.Type, rrset.Err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// newLookupResult describes the answer to a lookup, made up of the given
+// address records.
+func newLookupResult(state *lookupState, domainName string, recordType RecordType, records []Record) (LookupResult, error) {
+	var result LookupResult
+	for _, rec := range records {
+		ips, err := parseIPAddrs(rec.Type, rec.Data)
+		if err != nil {
+			return LookupResult{}, err
+		}
+		for _, ip := range ips {
+			result.IPs = append(result.IPs, ip)
+			result.Addrs = append(result.Addrs, AddrTTL{IP: ip, TTL: time.Duration(rec.TTL) * time.Second})
+		}
+	}
+	result.CNAMEs, result.CanonicalName = state.cnamesFrom(domainName)
+	result.Source = SourceCache
+	if from := state.sources[NewCacheKey(result.CanonicalName, recordType, ResourceClassIN)]; from.name != "" {
+		result.Source = SourceNameServer
+		result.NameServer, result.NameServerAddr = from.name, from.addr
+	}
+	return result, nil
+}
+
+// LookupAddr performs a reverse lookup for the given IP address, returning
+// the names mapped to it.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string, opts ...LookupOption) (names []string, err error) {
+	ctx = withLookupOpts(ctx, opts)
+	ctx, span := r.startSpan(ctx, spanLookup,
+		SpanAttribute{"dns.question.name", addr},
+		SpanAttribute{"dns.question.type", RecordTypePTR.String()},
+	)
+	defer func() { endSpan(span, err) }()
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %q", addr)
 	}
-	if len(ips) > 0 {
-		return ips, depth, nil
+	if err := r.checkConfig(ctx); err != nil {
+		return nil, err
 	}
+	ctx, done, err := r.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", addr, err)
+	}
+	defer done()
+	if names := r.hosts.lookupAddr(ip); len(names) > 0 {
+		r.log(ctx).Debug("resolved from hosts file", slog.String("query_addr", addr))
+		return names, nil
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, r.resolutionTimeoutFor(ctx))
+	defer cancel()
+	r.primeRootNameServers(lookupCtx)
+	records, _, err := r.doLookup(lookupCtx, newLookupState(), r.startingNameServers(ctx, reverseAddrName(ip)), reverseAddrName(ip), RecordTypePTR, 0)
+	if err != nil {
+		return nil, r.resolutionTimeoutError(ctx, addr, err)
+	}
+	names = make([]string, 0, len(records))
+	for _, rec := range records {
+		names = append(names, string(rec.Data))
+	}
+	return names, nil
+}
+
+// resolutionTimeoutError reports a lookup that failed because it exceeded
+// the resolution timeout, rather than the caller's own deadline, as
+// ErrResolutionTimeout. Other errors are returned as-is.
+func (r *Resolver) resolutionTimeoutError(ctx context.Context, name string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("lookup %s: %w after %s", name, ErrResolutionTimeout, r.resolutionTimeoutFor(ctx))
+	}
+	return err
+}
 
-	// if we find glue NS records, re-resolve again with a new name server
-	if glue, err := getGlueNameServers(msg); err != nil {
-		return nil, depth, fmt.Errorf("failed to get glue nameservers: %w", err)
-	} else if len(glue) > 0 {
-		nameServer = randomChoice(glue)
-		r.logger.Debug(
-			"recursively resolving with new name server from glue records",
+// SearchNames returns the names to try, in order, when looking up the given
+// name using the search list, as LookupIP does. It returns only the name
+// itself if there is no search list or the name ends in a ".".
+func (r *Resolver) SearchNames(name string) []string {
+	if len(r.search) == 0 || strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	names := make([]string, 0, len(r.search)+1)
+	for _, domain := range r.search {
+		names = append(names, name+"."+domain)
+	}
+	if strings.Count(name, ".") >= r.ndots {
+		return append([]string{name}, names...)
+	}
+	return append(names, name)
+}
+
+// doLookup iteratively resolves the given domain name, returning the answer
+// records of the requested type. The given name servers are tried in order
+// until one of them responds.
+func (r *Resolver) doLookup(ctx context.Context, state *lookupState, nameServers []nameServerDef, domainName string, recordType RecordType, depth int) ([]Record, int, error) {
+	if depth > r.maxDepth {
+		return nil, depth, fmt.Errorf("lookup %s %s: %w (%d)", domainName, recordType, ErrMaxDepth, r.maxDepth)
+	}
+
+	// consult the cache before sending any queries, following cached CNAMEs
+	// where necessary
+	useCache := lookupOptsFrom(ctx).useCache()
+	if r.cache != nil && useCache && !isCacheBypassed(ctx, domainName) {
+		class := queryOptsFrom(ctx).class()
+		key := NewCacheKey(domainName, recordType, class)
+		if records, found := r.cache.Get(key); found {
+			r.metrics.ObserveCacheLookup(recordType, true)
+			r.log(ctx).Debug(
+				"resolved from cache",
+				slog.String("query_name", domainName),
+				slog.String("resource_type", recordType.String()),
+				slog.Int("depth", depth),
+			)
+			r.maybePrefetch(key, domainName, recordType)
+			traceStep(ctx, TraceStep{Name: domainName, Type: recordType, Cached: true, Response: Message{Answers: records}, Depth: depth})
+			if r.dnssec {
+				state.addCachedAnswer(domainName, recordType, records, r.cachedSignatures(domainName, recordType))
+			}
+			state.answeredBy(domainName, recordType, respondent{})
+			return records, depth, nil
+		}
+		// a cached CNAME also answers the query, by pointing elsewhere
+		key = NewCacheKey(domainName, RecordTypeCNAME, class)
+		records, found := r.cache.Get(key)
+		r.metrics.ObserveCacheLookup(recordType, found)
+		if found {
+			cnameDomain := string(records[0].Data)
+			if err := state.followCNAME(domainName, records[0], r.maxCNAMEChain); err != nil {
+				return nil, depth, err
+			}
+			r.log(ctx).Debug(
+				"recursively resolving cached CNAME",
+				slog.String("cname", cnameDomain),
+				slog.String("query_name", domainName),
+				slog.Int("depth", depth),
+			)
+			r.maybePrefetch(key, domainName, recordType)
+			traceStep(ctx, TraceStep{Name: domainName, Type: RecordTypeCNAME, Cached: true, Response: Message{Answers: records}, Depth: depth})
+			if r.dnssec {
+				state.addCachedAnswer(domainName, RecordTypeCNAME, records, r.cachedSignatures(domainName, RecordTypeCNAME))
+			}
+			return r.doLookup(ctx, state, r.startingNameServers(ctx, cnameDomain), cnameDomain, recordType, depth+1)
+		}
+	}
+
+	if r.nsec != nil && useCache && r.nsec.provesNXDomain(domainName) {
+		r.log(ctx).Debug(
+			"synthesized NXDOMAIN from cached NSEC records",
 			slog.String("query_name", domainName),
-			slog.String("ns_name", nameServer.name),
-			slog.String("ns_addr", nameServer.addr.String()),
-			slog.String("ns_authority", nameServer.authority),
 			slog.Int("depth", depth),
 		)
-		return r.doLookupIP(ctx, nameServer, domainName, depth+1)
+		return nil, depth, fmt.Errorf("lookup %s %s (synthesized from cached NSEC records): %w", domainName, recordType, ErrNXDomain)
 	}
 
-	// if we find NS records but no glue records, we must first resolve
-	// nameserver domain to nameserver IP, then recurse with new nameserver IP
-	if ns, found := matchRecord(msg.Authorities, RecordTypeNS); found {
-		nsDomain := string(ns.Data)
-		r.logger.Debug(
-			"resolving NS domain",
-			slog.String("ns_domain", nsDomain),
-			slog.Int("depth", depth),
-		)
-		nextNSAddrs, newDepth, err := r.doLookupIP(ctx, r.chooseRootNameServer(), nsDomain, depth+1)
-		if err != nil {
-			return nil, newDepth, fmt.Errorf("error resolving nameserver: %w", err)
+	msg, nameServer, depth, err := r.queryNameServers(ctx, state, nameServers, domainName, recordType, depth)
+	if errors.Is(err, ErrNXDomain) && r.dnssec {
+		if err := r.verifyDenial(ctx, state, nameServer.nameServerDef, msg, domainName, recordType, true); err != nil {
+			return nil, depth, err
 		}
-		if len(nextNSAddrs) == 0 {
-			return nil, newDepth, fmt.Errorf("no IP addresses found for nameserver %q", nsDomain)
+	}
+	if err != nil {
+		return nil, depth, err
+	}
+
+	r.logRecords(ctx, "answer", msg.Answers)
+	r.logRecords(ctx, "authority", msg.Authorities)
+	r.logRecords(ctx, "additional", msg.Additionals)
+	if r.dnssec {
+		state.addAnswers(msg.Answers, msg.Authorities)
+	}
+
+	// if we found answers of the requested type, we're done
+	if answers := filterRecords(msg.Answers, recordType); len(answers) > 0 {
+		state.answeredBy(domainName, recordType, nameServer)
+		return answers, depth, nil
+	}
+
+	// if we were referred to the name servers for a child zone, re-resolve
+	// with them
+	if len(msg.Answers) == 0 {
+		delegation, err := r.delegationNameServers(ctx, msg)
+		if err != nil {
+			return nil, depth, fmt.Errorf("failed to get delegated nameservers: %w", err)
 		}
-		for _, nsAddr := range nextNSAddrs {
-			if nsAddr.IsPrivate() {
-				r.logger.Debug("skipping private name server", slog.String("ns_addr", nsAddr.String()))
-				continue
+		if len(delegation) > 0 {
+			if r.dnssec && !nameServer.recursive {
+				depth = r.fetchDelegationDS(ctx, state, nameServers, nameServer.nameServerDef, msg, delegation[0].authority, depth)
 			}
-			nameServer = newNameServerDef(nsDomain, string(ns.Name), nsAddr)
-			r.logger.Debug(
-				"recursively resolving with new name server",
-				slog.String("query_domain", domainName),
-				slog.String("ns_name", nameServer.name),
-				slog.String("ns_addr", nameServer.addr.String()),
-				slog.String("ns_authority", nameServer.authority),
+			r.log(ctx).Debug(
+				"recursively resolving with delegated name servers",
+				slog.String("query_name", domainName),
+				slog.String("ns_authority", delegation[0].authority),
+				slog.Int("ns_count", len(delegation)),
 				slog.Int("depth", depth),
 			)
-			return r.doLookupIP(ctx, nameServer, domainName, newDepth+1)
+			return r.doLookup(ctx, state, delegation, domainName, recordType, depth+1)
 		}
 	}
 
 	// finally, if we find a CNAME, recursively resolve it instead of our
 	// current query
-	if cname, found := matchRecord(msg.Answers, RecordTypeCNAME); found {
+	if cname, found := matchNamedRecord(msg.Answers, domainName, RecordTypeCNAME); found {
 		cnameDomain := string(cname.Data)
-		r.logger.Debug(
+		if err := state.followCNAME(domainName, cname, r.maxCNAMEChain); err != nil {
+			return nil, depth, err
+		}
+		r.log(ctx).Debug(
 			"recursively resolving CNAME",
 			slog.String("cname", cnameDomain),
 			slog.String("query_name", domainName),
 			slog.Int("depth", depth),
 		)
-		return r.doLookupIP(ctx, nameServer, cnameDomain, depth+1)
+		// the current name servers can only answer for the CNAME target if
+		// it falls within their authority; otherwise start again from the
+		// root
+		if !isSubdomain(cnameDomain, nameServer.authority) {
+			nameServers = r.startingNameServers(ctx, cnameDomain)
+		}
+		return r.doLookup(ctx, state, nameServers, cnameDomain, recordType, depth+1)
 	}
 
-	r.logger.Debug(
-		"no IP addresses found",
+	if len(msg.Answers) == 0 && r.dnssec {
+		if err := r.verifyDenial(ctx, state, nameServer.nameServerDef, msg, domainName, recordType, false); err != nil {
+			return nil, depth, err
+		}
+	}
+	r.log(ctx).Debug(
+		"no answers found",
 		slog.String("query_name", domainName),
-		slog.String("msg", fmt.Sprintf("%#v", msg)),
+		slog.String("resource_type", recordType.String()),
+		slog.String("msg", msg.String()),
 	)
-	return nil, depth, fmt.Errorf("failed to resolve %s to an IP", domainName)
+	return nil, depth, fmt.Errorf("failed to resolve %s %s record: %w", domainName, recordType, ErrNoData)
+}
+
+// queryNameServers sends a query to each of the given name servers in turn
+// until one of them responds, moving on to the next if the query fails or
+// the server responds with SERVFAIL or REFUSED. If racing is enabled, the
+// query is sent to several name servers at once and the first response wins.
+// Name servers without addresses are resolved before being queried. It
+// returns the response along with the name server that sent it.
+func (r *Resolver) queryNameServers(ctx context.Context, state *lookupState, nameServers []nameServerDef, domainName string, recordType RecordType, depth int) (Message, respondent, int, error) {
+	ctx, attempts := withAttemptLog(ctx)
+	var lastErr error
+	for len(nameServers) > 0 {
+		batch := make([]nameServerDef, 0, r.raceSize)
+		for len(nameServers) > 0 && len(batch) < r.raceSize {
+			nameServer := nameServers[0]
+			nameServers = nameServers[1:]
+			if err := ctx.Err(); err != nil {
+				return Message{}, respondent{nameServerDef: nameServer}, depth, err
+			}
+
+			if len(nameServer.addrs) == 0 && nameServer.url == "" {
+				resolved, newDepth, err := r.resolveNameServer(ctx, state, nameServer, depth)
+				if errors.Is(err, ErrMaxDepth) {
+					return Message{}, respondent{nameServerDef: nameServer}, newDepth, err
+				}
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				nameServer, depth = resolved, newDepth
+			}
+
+			if !state.visit(nameServer, domainName, recordType) {
+				lastErr = fmt.Errorf("lookup %s %s: %w: already asked %s", domainName, recordType, ErrLookupLoop, nameServer.name)
+				continue
+			}
+			batch = append(batch, nameServer)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		msg, from, retry, err := r.raceNameServers(ctx, batch, domainName, recordType, depth)
+		if err != nil && ctx.Err() != nil {
+			err = attempts.wrap(domainName, recordType, err)
+		}
+		if err == nil || !retry {
+			return msg, from, depth, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("lookup %s %s: no name servers to query", domainName, recordType)
+	}
+	return Message{}, respondent{}, depth, attempts.wrap(domainName, recordType, lastErr)
 }
 
-// sendQuery sends a query to a name server and parses the response.
-func (r *Resolver) sendQuery(ctx context.Context, nameServer nameServerDef, targetDomain string, recordType RecordType, depth int) (Message, error) {
-	conn, err := r.dialer.DialContext(ctx, "udp", net.JoinHostPort(nameServer.addr.String(), "53"))
+// raceNameServers sends a query to each of the given name servers
+// concurrently, returning the first successful response and cancelling the
+// remaining queries. If every query fails, the last error is returned, along
+// with whether the next name server should be tried.
+func (r *Resolver) raceNameServers(ctx context.Context, nameServers []nameServerDef, domainName string, recordType RecordType, depth int) (Message, respondent, bool, error) {
+	if len(nameServers) == 1 {
+		msg, addr, retry, err := r.queryNameServer(ctx, nameServers[0], domainName, recordType, depth)
+		return msg, respondent{nameServers[0], addr}, retry, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		msg   Message
+		from  respondent
+		retry bool
+		err   error
+	}
+	results := make(chan result, len(nameServers))
+	for _, nameServer := range nameServers {
+		nameServer := nameServer
+		go func() {
+			msg, addr, retry, err := r.queryNameServer(ctx, nameServer, domainName, recordType, depth)
+			results <- result{msg, respondent{nameServer, addr}, retry, err}
+		}()
+	}
+
+	var last result
+	for range nameServers {
+		last = <-results
+		if last.err == nil || !last.retry {
+			return last.msg, last.from, last.retry, last.err
+		}
+	}
+	return Message{}, last.from, true, last.err
+}
+
+// queryNameServer sends a query to a single name server, returning its
+// response with any out-of-bailiwick records removed, and the address it was
+// sent to. If the query fails, retry reports whether another name server
+// should be tried.
+func (r *Resolver) queryNameServer(ctx context.Context, nameServer nameServerDef, domainName string, recordType RecordType, depth int) (Message, net.IP, bool, error) {
+	transport := r.transportFor(ctx)
+	if nameServer.url != "" {
+		transport = &HTTPSTransport{}
+	}
+	ctx, span := r.startSpan(ctx, spanQuery,
+		SpanAttribute{"dns.question.name", domainName},
+		SpanAttribute{"dns.question.type", recordType.String()},
+		SpanAttribute{"dns.zone", nameServer.authority},
+		SpanAttribute{"dns.nameserver", nameServer.name},
+		SpanAttribute{"dns.transport", transportName(transport)},
+		SpanAttribute{"dns.depth", depth},
+	)
+	msg, addr, rtt, err := r.sendQuery(ctx, nameServer, domainName, recordType, depth)
+	if addr != nil {
+		span.SetAttributes(SpanAttribute{"net.peer.addr", addr.String()})
+	}
+	if err == nil && r.tracer != nil {
+		// responses are parsed by the transport, so their size is measured
+		// by re-encoding them
+		span.SetAttributes(
+			SpanAttribute{"dns.rcode", int(msg.Header.RCode())},
+			SpanAttribute{"dns.response.bytes", len(msg.Encode())},
+		)
+	}
+	endSpan(span, err)
+	traceStep(ctx, TraceStep{
+		Name:       domainName,
+		Type:       recordType,
+		Zone:       nameServer.authority,
+		NameServer: nameServer.name,
+		Addr:       addr,
+		Response:   msg,
+		RTT:        rtt,
+		Err:        err,
+		Depth:      depth,
+	})
+	metric := QueryMetric{NameServer: nameServer.name, Addr: addr, Type: recordType, RCode: -1, Err: err, RTT: rtt}
+	if err == nil {
+		metric.RCode = int(msg.Header.RCode())
+	}
+	r.metrics.ObserveQuery(metric)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to dial nameserver %s: %w", nameServer.name, err)
+		r.log(ctx).Debug(
+			"query failed, trying next name server",
+			slog.String("query_name", domainName),
+			slog.String("ns_name", nameServer.name),
+			slog.String("err", err.Error()),
+		)
+		return Message{}, addr, ctx.Err() == nil, err
+	}
+	msg = r.enforceBailiwick(ctx, nameServer, msg)
+	if lookupOptsFrom(ctx).useCache() {
+		r.cacheAnswers(msg)
+		r.cacheNSEC(ctx, msg)
+	}
+	if err := rcodeError(msg.Header.RCode()); err != nil {
+		err = fmt.Errorf("lookup %s %s from %s: %w", domainName, recordType, nameServer.name, err)
+		recordAttempt(ctx, QueryAttempt{
+			NameServer: nameServer.name,
+			Addr:       addr,
+			Transport:  transportName(transport),
+			RCode:      int(msg.Header.RCode()),
+			Err:        err,
+			Duration:   rtt,
+		})
+		if errors.Is(err, ErrServFail) || errors.Is(err, ErrRefused) {
+			r.log(ctx).Debug(
+				"name server failed to answer, trying next name server",
+				slog.String("query_name", domainName),
+				slog.String("ns_name", nameServer.name),
+				slog.String("rcode", msg.Header.RCode().String()),
+				slog.String("err", err.Error()),
+			)
+			return Message{}, addr, true, err
+		}
+		// the response is returned with the error, since it may prove the
+		// error, e.g. with NSEC records for NXDOMAIN
+		return msg, addr, false, err
 	}
-	conn.SetDeadline(time.Now().Add(r.queryTimeout))
+	return msg, addr, false, nil
+}
 
-	r.logger.Debug(
-		"sending DNS query",
-		slog.String("query_name", targetDomain),
-		slog.String("ns_name", nameServer.name),
-		slog.String("ns_addr", nameServer.addr.String()),
-		slog.String("ns_authority", nameServer.authority),
-		slog.String("resource_type", recordType.String()),
+// resolveNameServer resolves the address of a name server that was
+// delegated to without glue records.
+func (r *Resolver) resolveNameServer(ctx context.Context, state *lookupState, nameServer nameServerDef, depth int) (nameServerDef, int, error) {
+	r.log(ctx).Debug(
+		"resolving NS domain",
+		slog.String("ns_domain", nameServer.name),
 		slog.Int("depth", depth),
 	)
-
-	query := NewQuery(targetDomain, recordType)
-	if _, err := conn.Write(query.Encode()); err != nil {
-		return Message{}, err
+	ctx = withQueryOpts(ctx, queryOptsFrom(ctx).inClass())
+	nsRecords, newDepth, err := r.doLookup(ctx, state, r.startingNameServers(ctx, nameServer.name), nameServer.name, r.nameServerAddrType(), depth+1)
+	if err != nil {
+		return nameServer, newDepth, fmt.Errorf("error resolving nameserver: %w", err)
 	}
-
-	buf := make([]byte, maxMessageSize)
-	n, err := conn.Read(buf)
+	nextNSAddrs, err := ipAddrsFromRecords(nsRecords)
 	if err != nil {
-		return Message{}, err
+		return nameServer, newDepth, fmt.Errorf("error resolving nameserver: %w", err)
 	}
+	allowedAddrs := r.filterNameServerAddrs(ctx, nameServer.name, nextNSAddrs)
+	if len(allowedAddrs) == 0 {
+		return nameServer, newDepth, fmt.Errorf("no IP addresses found for nameserver %q", nameServer.name)
+	}
+	return newNameServerDef(nameServer.name, nameServer.authority, allowedAddrs...), newDepth, nil
+}
 
-	resp := buf[:n]
-	// r.logger.Debug("raw DNS response bytes", slog.String("resp_bytes", string(resp)))
+// sendQuery sends a query to a name server and parses the response. It also
+// returns the address the query was sent to, if any, and the round trip time.
+func (r *Resolver) sendQuery(ctx context.Context, nameServer nameServerDef, targetDomain string, recordType RecordType, depth int) (Message, net.IP, time.Duration, error) {
+	if err := validateName(targetDomain); err != nil {
+		return Message{}, nil, 0, err
+	}
+	addrs := nameServer.addrsFor(r.network)
+	if len(addrs) == 0 && nameServer.url == "" {
+		err := fmt.Errorf("nameserver %s has no address usable over %s", nameServer.name, r.network)
+		recordAttempt(ctx, QueryAttempt{NameServer: nameServer.name, Transport: transportName(r.transportFor(ctx)), RCode: -1, Err: err})
+		return Message{}, nil, 0, err
+	}
+	r.rtt.sortAddrs(addrs)
 
-	msg, err := parseMessage(byteview.New(resp))
+	queryName := targetDomain
+	if r.randomizeCase {
+		queryName = randomizeCase(targetDomain)
+	}
+	opts := queryOptsFrom(ctx)
+	query := NewQuery(queryName, recordType)
+	query.Question.Class = opts.class()
+	if nameServer.recursive || opts.RD {
+		query.Header.SetRD(true)
+	}
+	ednsOptions := opts.EDNSOptions
+	if r.requestNSID {
+		ednsOptions = append([]EDNSOption{{Code: EDNSOptionNSID}}, ednsOptions...)
+	}
+	if len(ednsOptions) > 0 || opts.UDPSize != 0 {
+		query.AddEDNS(opts.udpSize(), ednsOptions...)
+	}
+	if r.requestDNSSEC || r.nsec != nil || opts.DO {
+		// DNSSEC records, including the NSEC records needed for aggressive
+		// NSEC caching, are only included in responses to queries with the
+		// DNSSEC OK bit set
+		query.SetDO(true)
+	}
+	if (nameServer.recursive && r.checkingDisabled) || opts.CD {
+		query.Header.SetCD(true)
+	}
+	var (
+		msg  Message
+		addr net.IP
+		rtt  time.Duration
+		err  error
+	)
+	if nameServer.url != "" {
+		start := time.Now()
+		msg, err = r.exchangeDoH(ctx, nameServer, query)
+		rtt = time.Since(start)
+		var partialErr *PartialMessageError
+		if err != nil && !errors.As(err, &partialErr) {
+			recordAttempt(ctx, QueryAttempt{NameServer: nameServer.name, Transport: "https", RCode: -1, Err: err, Duration: rtt})
+		}
+	}
+	for _, addr = range addrs {
+		msg, rtt, err = r.exchangeWithRetry(ctx, nameServer, addr, query, targetDomain, recordType, depth)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	var partialErr *PartialMessageError
+	if err != nil && !errors.As(err, &partialErr) {
+		return Message{}, addr, rtt, err
+	}
+	if errors.As(err, &partialErr) {
+		r.log(ctx).Warn(
+			"partially parsed DNS response",
+			slog.String("err", err.Error()),
+			slog.String("query_name", targetDomain),
+			slog.String("ns_name", nameServer.name),
+			slog.String("ns_addr", addr.String()),
+		)
+		err = nil
+	}
+	if err == nil {
+		err = validateResponse(query, msg, r.randomizeCase)
+	}
 	if err != nil {
-		r.logger.Debug(
+		r.log(ctx).Debug(
 			"failed to parse DNS response",
 			slog.String("err", err.Error()),
 			slog.String("query_name", targetDomain),
 			slog.String("ns_name", nameServer.name),
-			slog.String("ns_addr", nameServer.addr.String()),
+			slog.String("ns_addr", addr.String()),
 			slog.String("ns_authority", nameServer.authority),
 			slog.String("resource_type", recordType.String()),
 			slog.Int("depth", depth),
 		)
-		return Message{}, err
+		transport := transportName(r.transportFor(ctx))
+		if nameServer.url != "" {
+			transport = "https"
+		}
+		recordAttempt(ctx, QueryAttempt{NameServer: nameServer.name, Addr: addr, Transport: transport, RCode: -1, Err: err, Duration: rtt})
+		if addr != nil {
+			r.rtt.failure(addr, r.queryTimeout)
+		}
+		return Message{}, addr, rtt, err
 	}
 
-	return msg, nil
+	// name servers that fail to answer are penalized so that other name
+	// servers for the same zone are preferred in future
+	if addr != nil {
+		if rcode := msg.Header.RCode(); rcode == RCodeServFail || rcode == RCodeRefused {
+			r.rtt.failure(addr, r.queryTimeout)
+		} else {
+			r.rtt.success(addr, rtt)
+		}
+	}
+
+	if nsid, found := msg.NSID(); found {
+		r.log(ctx).Info(
+			"name server identity",
+			slog.String("nsid", nsid),
+			slog.String("ns_name", nameServer.name),
+			slog.String("ns_addr", addr.String()),
+		)
+	}
+
+	return msg, addr, rtt, nil
 }
 
-// chooseRootNameServer chooses an authoritative root name server in round-robin
-// fashion.
-func (r *Resolver) chooseRootNameServer() nameServerDef {
-	return randomChoice(r.rootNameServers)
+// exchangeWithRetry sends a query to the given name server address, retrying
+// with exponential backoff if it times out. It returns the response along
+// with the round trip time of the successful attempt.
+func (r *Resolver) exchangeWithRetry(ctx context.Context, nameServer nameServerDef, addr net.IP, query Query, targetDomain string, recordType RecordType, depth int) (Message, time.Duration, error) {
+	var (
+		resp Message
+		err  error
+	)
+	for attempt := 0; attempt < r.queryAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := r.retryBackoff << (attempt - 1)
+			// wait between half and all of the backoff, so that concurrent
+			// lookups don't retry in lockstep
+			backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			r.metrics.ObserveRetry(nameServer.name)
+			r.log(ctx).Debug(
+				"retrying DNS query after timeout",
+				slog.String("query_name", targetDomain),
+				slog.String("ns_name", nameServer.name),
+				slog.String("ns_addr", addr.String()),
+				slog.Int("attempt", attempt+1),
+				slog.Duration("backoff", backoff),
+			)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return Message{}, 0, ctx.Err()
+			}
+		}
+		r.log(ctx).Debug(
+			"sending DNS query",
+			slog.String("query_name", targetDomain),
+			slog.String("ns_name", nameServer.name),
+			slog.String("ns_addr", addr.String()),
+			slog.String("ns_authority", nameServer.authority),
+			slog.String("resource_type", recordType.String()),
+			slog.Int("depth", depth),
+		)
+		start := time.Now()
+		resp, err = r.exchange(ctx, nameServer, addr, query)
+		var partialErr *PartialMessageError
+		if err == nil || errors.As(err, &partialErr) {
+			return resp, time.Since(start), err
+		}
+		recordAttempt(ctx, QueryAttempt{
+			NameServer: nameServer.name,
+			Addr:       addr,
+			Transport:  transportName(r.transportFor(ctx)),
+			RCode:      -1,
+			Err:        err,
+			Duration:   time.Since(start),
+		})
+		// queries cancelled by the caller, e.g. when racing name servers,
+		// say nothing about the name server's health
+		if ctx.Err() != nil {
+			return Message{}, 0, err
+		}
+		r.rtt.failure(addr, r.queryTimeout)
+		if !isTimeout(err) {
+			return Message{}, 0, err
+		}
+	}
+	return Message{}, 0, err
 }
 
-func (r *Resolver) logRecords(section string, records []Record) {
-	for _, a := range records {
-		// try to log human-readable data instead of raw bytes where we know
-		// what to expect for a given record type
-		val := string(a.Data)
-		if a.Type == RecordTypeA || a.Type == RecordTypeAAAA {
-			ips, err := parseIPAddrs(a.Type, a.Data)
-			if err == nil {
-				val = fmt.Sprintf("%v", ips)
+// exchangeDoH sends a query to a DNS-over-HTTPS upstream.
+func (r *Resolver) exchangeDoH(ctx context.Context, nameServer nameServerDef, query Query) (Message, error) {
+	r.log(ctx).Debug("sending DNS query over HTTPS", slog.String("ns_url", nameServer.url))
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout)
+	defer cancel()
+	transport := &HTTPSTransport{URL: nameServer.url, Client: r.httpClient, ParseMode: r.parseMode}
+	return r.roundTrip(ctx, transport, nameServer, netip.AddrPort{}, query)
+}
+
+// exchange sends a query to the given name server address using the
+// resolver's transport.
+func (r *Resolver) exchange(ctx context.Context, nameServer nameServerDef, addr net.IP, query Query) (Message, error) {
+	port := r.port
+	if nameServer.port != "" {
+		port = nameServer.port
+	}
+	server, err := netip.ParseAddrPort(net.JoinHostPort(addr.String(), port))
+	if err != nil {
+		return Message{}, fmt.Errorf("invalid address for nameserver %s: %w", nameServer.name, err)
+	}
+	return r.roundTrip(ctx, r.transportFor(ctx), nameServer, netip.AddrPortFrom(server.Addr().Unmap(), server.Port()), query)
+}
+
+// transportFor returns the transport to send a lookup's queries with, which
+// is the resolver's unless the lookup's QueryOpts or LookupOptions override
+// it.
+func (r *Resolver) transportFor(ctx context.Context) Transport {
+	if transport := queryOptsFrom(ctx).Transport; transport != nil {
+		return transport
+	}
+	if transport := lookupOptsFrom(ctx).transport; transport != nil {
+		return transport
+	}
+	return r.transport
+}
+
+// roundTrip sends a query to a name server with the given transport.
+// Partially parsed responses are returned along with their errors.
+func (r *Resolver) roundTrip(ctx context.Context, transport Transport, nameServer nameServerDef, server netip.AddrPort, query Query) (Message, error) {
+	sent := time.Now()
+	msg, err := transport.RoundTrip(ctx, query, server)
+	if r.capture != nil {
+		r.captureExchange(ctx, transport, server, query, sent, msg, err)
+	}
+	var partialErr *PartialMessageError
+	if err != nil && !errors.As(err, &partialErr) {
+		return Message{}, fmt.Errorf("query to nameserver %s failed: %w", nameServer.name, err)
+	}
+	return msg, err
+}
+
+// captureExchange records a query sent with the given transport, and its
+// response, if one was received, to the capture file.
+func (r *Resolver) captureExchange(ctx context.Context, transport Transport, server netip.AddrPort, query Query, sent time.Time, msg Message, err error) {
+	var network string
+	switch transportName(transport) {
+	case "https":
+		return
+	case "tcp", "tls":
+		network = "tcp"
+	default:
+		network = "udp"
+	}
+	var resp []byte
+	var partialErr *PartialMessageError
+	if err == nil || errors.As(err, &partialErr) {
+		resp = msg.Encode()
+	}
+	if err := r.capture.WriteExchange(network, server, sent, query.Encode(), time.Since(sent), resp); err != nil {
+		r.log(ctx).Warn("failed to capture query", slog.String("nameserver", server.String()), slog.String("err", err.Error()))
+	}
+}
+
+// cacheAnswers stores each RRset in the message's answer section in the
+// cache.
+func (r *Resolver) cacheAnswers(msg Message) {
+	if r.cache == nil {
+		return
+	}
+	for key, rrset := range groupRRsets(msg.Answers) {
+		if key.Type == RecordTypeRRSIG {
+			rrset = r.mergeSignatures(key, rrset)
+		}
+		r.cache.Set(key, rrset, rrsetTTL(rrset))
+	}
+}
+
+// cacheNSEC stores the signed NSEC records in the message's authority
+// section for aggressive negative caching. Only NSEC records accompanied by
+// an RRSIG are used, because the signer name identifies the zone whose NSEC
+// chain the record belongs to.
+func (r *Resolver) cacheNSEC(ctx context.Context, msg Message) {
+	if r.nsec == nil {
+		return
+	}
+	signers := make(map[string]string)
+	for _, rec := range msg.Authorities {
+		if rec.Type != RecordTypeRRSIG {
+			continue
+		}
+		if sig, err := parseRRSIG(rec.Data); err == nil && sig.TypeCovered == RecordTypeNSEC {
+			signers[canonicalName(string(rec.Name))] = sig.SignerName
+		}
+	}
+	for _, rec := range msg.Authorities {
+		if rec.Type != RecordTypeNSEC {
+			continue
+		}
+		zone, found := signers[canonicalName(string(rec.Name))]
+		if !found {
+			continue
+		}
+		nsec, err := parseNSEC(rec.Data)
+		if err != nil {
+			r.log(ctx).Debug("failed to parse NSEC record", slog.String("name", string(rec.Name)), slog.String("err", err.Error()))
+			continue
+		}
+		r.nsec.add(zone, string(rec.Name), nsec, time.Duration(rec.TTL)*time.Second)
+	}
+}
+
+// enforceBailiwick removes records from a response whose owner names fall
+// outside the responding name server's authority, so that a malicious or
+// misconfigured server cannot inject answers or glue for names it is not
+// authoritative for.
+// https://datatracker.ietf.org/doc/html/rfc2181#section-5.4.1
+func (r *Resolver) enforceBailiwick(ctx context.Context, nameServer nameServerDef, msg Message) Message {
+	filter := func(section string, records []Record) []Record {
+		results := make([]Record, 0, len(records))
+		for _, rec := range records {
+			// the OPT pseudo-record is always owned by the root
+			if rec.Type == RecordTypeOPT || isSubdomain(string(rec.Name), nameServer.authority) {
+				results = append(results, rec)
+				continue
 			}
+			r.log(ctx).Debug(
+				"ignoring out-of-bailiwick record",
+				slog.String("section", section),
+				slog.String("name", string(rec.Name)),
+				slog.String("type", rec.Type.String()),
+				slog.String("ns_name", nameServer.name),
+				slog.String("ns_authority", nameServer.authority),
+			)
 		}
+		return results
+	}
+	msg.Answers = filter("answer", msg.Answers)
+	msg.Authorities = filter("authority", msg.Authorities)
+	msg.Additionals = filter("additional", msg.Additionals)
+	return msg
+}
+
+// startingNameServers returns the name servers that lookups for the given
+// name start from, in order of preference, which is random until their round
+// trip times are known. These are the server given by WithServer, if any,
+// the upstream resolvers of the forward zone containing the name, the
+// upstream resolvers when forwarding, or otherwise the root name servers.
+func (r *Resolver) startingNameServers(ctx context.Context, name string) []nameServerDef {
+	if server := lookupOptsFrom(ctx).server; server != nil {
+		return []nameServerDef{*server}
+	}
+	nameServers := r.forwardZoneFor(name)
+	if len(nameServers) == 0 {
+		nameServers = r.upstreams
+	}
+	if len(nameServers) == 0 {
+		r.rootsMu.Lock()
+		nameServers = r.rootNameServers
+		r.rootsMu.Unlock()
+	}
+	results := shuffled(r.usableNameServers(nameServers))
+	r.rtt.sortNameServers(results, r.network)
+	return results
+}
 
-		r.logger.Debug(
+// delegationNameServers returns the name servers that a referral delegates
+// to, in the order they should be tried: those with glue records usable over
+// the resolver's network first, fastest first, then those that must be
+// resolved in a random order.
+func (r *Resolver) delegationNameServers(ctx context.Context, msg Message) ([]nameServerDef, error) {
+	glue, err := getGlueNameServers(msg)
+	if err != nil {
+		return nil, err
+	}
+	var withAddrs, withoutAddrs []nameServerDef
+	seen := make(map[string]bool)
+	for _, ns := range glue {
+		seen[canonicalName(ns.name)] = true
+		ns.addrs = r.filterNameServerAddrs(ctx, ns.name, ns.addrs)
+		if _, found := ns.addrFor(r.network); found {
+			withAddrs = append(withAddrs, ns)
+		} else {
+			withoutAddrs = append(withoutAddrs, newNameServerDef(ns.name, ns.authority))
+		}
+	}
+	for _, rec := range msg.Authorities {
+		if rec.Type != RecordTypeNS || seen[canonicalName(string(rec.Data))] {
+			continue
+		}
+		seen[canonicalName(string(rec.Data))] = true
+		withoutAddrs = append(withoutAddrs, newNameServerDef(string(rec.Data), string(rec.Name)))
+	}
+	withAddrs = shuffled(withAddrs)
+	r.rtt.sortNameServers(withAddrs, r.network)
+	return append(withAddrs, shuffled(withoutAddrs)...), nil
+}
+
+// usableNameServers filters the given name servers down to those that have at
+// least one address reachable over the resolver's network. If none are
+// usable, the original slice is returned so that the eventual query fails
+// with a descriptive error.
+func (r *Resolver) usableNameServers(nameServers []nameServerDef) []nameServerDef {
+	results := make([]nameServerDef, 0, len(nameServers))
+	for _, ns := range nameServers {
+		if _, found := ns.addrFor(r.network); found {
+			results = append(results, ns)
+		}
+	}
+	if len(results) == 0 {
+		return nameServers
+	}
+	return results
+}
+
+// nameServerAddrType returns the record type to use when resolving a name
+// server's domain name to an address we can actually reach.
+func (r *Resolver) nameServerAddrType() RecordType {
+	if networkFamily(r.network) == "6" {
+		return RecordTypeAAAA
+	}
+	return RecordTypeA
+}
+
+func (r *Resolver) logRecords(ctx context.Context, section string, records []Record) {
+	for _, a := range records {
+		r.log(ctx).Debug(
 			"resource record",
 			slog.String("section", section),
 			slog.String("name", string(a.Name)),
 			slog.String("type", a.Type.String()),
-			slog.String("value", val),
+			slog.String("value", a.presentData()),
+		)
+	}
+}
+
+// lookupState tracks state across the recursive steps of a single lookup.
+type lookupState struct {
+	visited     map[visitKey]bool
+	cnames      map[string]bool         // names seen while following CNAMEs
+	cnameChain  map[string]Record       // the CNAME followed from each name
+	sources     map[CacheKey]respondent // the name server that answered each query, or zero if cached
+	delegations []delegation            // zone cuts crossed, if DNSSEC is enabled
+	answers     []signedRRset           // answers found, if DNSSEC is enabled
+}
+
+type visitKey struct {
+	nameServer string
+	name       string
+	recordType RecordType
+}
+
+func newLookupState() *lookupState {
+	return &lookupState{
+		visited:    make(map[visitKey]bool),
+		cnames:     make(map[string]bool),
+		cnameChain: make(map[string]Record),
+		sources:    make(map[CacheKey]respondent),
+	}
+}
+
+// followCNAME records that the lookup is following the given CNAME from one
+// name to another, returning an error if doing so would loop or exceed the
+// maximum chain length.
+func (s *lookupState) followCNAME(from string, cname Record, maxChain int) error {
+	from, to := canonicalName(from), canonicalName(string(cname.Data))
+	s.cnameChain[from] = cname
+	s.cnames[from] = true
+	if s.cnames[to] {
+		return fmt.Errorf("lookup %s: %w: %s has already been visited", from, ErrCNAMELoop, to)
+	}
+	// the chain includes the original name, which is not itself a CNAME
+	if len(s.cnames) > maxChain {
+		return fmt.Errorf("lookup %s: %w (%d)", from, ErrCNAMEChainTooLong, maxChain)
+	}
+	s.cnames[to] = true
+	return nil
+}
+
+// answeredBy records which name server answered a query for the given name
+// and type. Cached answers are recorded with a zero respondent.
+func (s *lookupState) answeredBy(name string, recordType RecordType, from respondent) {
+	s.sources[NewCacheKey(name, recordType, ResourceClassIN)] = from
+}
+
+// cnamesFrom returns the chain of CNAMEs followed from the given name, in
+// order, along with the canonical name at the end of the chain.
+func (s *lookupState) cnamesFrom(name string) ([]Record, string) {
+	var chain []Record
+	for {
+		cname, found := s.cnameChain[canonicalName(name)]
+		if !found || len(chain) > len(s.cnameChain) {
+			return chain, name
+		}
+		chain = append(chain, cname)
+		name = string(cname.Data)
+	}
+}
+
+// visit records that the given name server is being asked about the given
+// name, returning false if it has already been asked during this lookup,
+// which indicates a delegation loop.
+func (s *lookupState) visit(nameServer nameServerDef, name string, recordType RecordType) bool {
+	key := visitKey{
+		nameServer: canonicalName(nameServer.name),
+		name:       canonicalName(name),
+		recordType: recordType,
+	}
+	if s.visited[key] {
+		return false
+	}
+	s.visited[key] = true
+	return true
+}
+
+// validateResponse ensures that a response message matches the query it is
+// answering, with the same ID and an identical question section. The case of
+// the question name is ignored unless exactCase is set, as it must be when
+// the query name's case was randomized.
+func validateResponse(query Query, msg Message, exactCase bool) error {
+	if msg.Header.ID != query.Header.ID {
+		return fmt.Errorf("%w: got ID %d, expected %d", ErrMismatchedResponse, msg.Header.ID, query.Header.ID)
+	}
+	if len(msg.Questions) != 1 {
+		return fmt.Errorf("%w: got %d questions, expected 1", ErrMismatchedResponse, len(msg.Questions))
+	}
+	// parsed question names are decoded, while the query's is still encoded
+	got, want := msg.Questions[0], query.Question
+	wantName, err := decodeName(byteview.New(want.Name))
+	if err != nil {
+		return err
+	}
+	nameMatches := bytes.EqualFold(got.Name, wantName)
+	if exactCase {
+		nameMatches = bytes.Equal(got.Name, wantName)
+	}
+	if !nameMatches || got.Type != want.Type || got.Class != want.Class {
+		return fmt.Errorf(
+			"%w: got question %s %s %d, expected %s %s %d",
+			ErrMismatchedResponse, got.Name, got.Type, got.Class, wantName, want.Type, want.Class,
 		)
 	}
+	return nil
+}
+
+// randomizeCase randomly changes the case of each letter in a domain name.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rand.Intn(2) == 0 {
+			b[i] = c ^ 0x20
+		}
+	}
+	return string(b)
 }
 
 // getGlueNameServers joins the glue records in the additional section with the
@@ -258,16 +1671,26 @@ func getGlueNameServers(msg Message) ([]nameServerDef, error) {
 
 	authorityIdx := make(map[string]int)
 	for i, a := range msg.Authorities {
-		authorityIdx[string(a.Data)] = i
+		if a.Type == RecordTypeNS {
+			authorityIdx[canonicalName(string(a.Data))] = i
+		}
 	}
 
+	// a name server may have multiple glue records (e.g. both A and AAAA),
+	// which are collected into a single nameServerDef
+	resultIdx := make(map[string]int)
 	results := make([]nameServerDef, 0, len(msg.Additionals))
 	for _, a := range msg.Additionals {
+		// skip pseudo-records and signatures that may accompany glue when
+		// EDNS is in use
+		if a.Type == RecordTypeOPT || a.Type == RecordTypeRRSIG {
+			continue
+		}
 		if a.Type != RecordTypeA && a.Type != RecordTypeAAAA {
 			return nil, fmt.Errorf("unexpected record type %s (%v) in additional section", a.Type, a.Type)
 		}
 
-		idx, found := authorityIdx[string(a.Name)]
+		idx, found := authorityIdx[canonicalName(string(a.Name))]
 		if !found {
 			return nil, fmt.Errorf("no authority found for %q", a.Name)
 		}
@@ -277,9 +1700,13 @@ func getGlueNameServers(msg Message) ([]nameServerDef, error) {
 			return nil, fmt.Errorf("failed to parse glue IP address: %w", err)
 		}
 
+		if i, found := resultIdx[canonicalName(string(a.Name))]; found {
+			results[i].addrs = append(results[i].addrs, addrs...)
+			continue
+		}
 		authority := msg.Authorities[idx]
-		ns := newNameServerDef(string(authority.Data), string(authority.Name), addrs[0])
-		results = append(results, ns)
+		resultIdx[canonicalName(string(a.Name))] = len(results)
+		results = append(results, newNameServerDef(string(authority.Data), string(authority.Name), addrs...))
 	}
 	return results, nil
 }
@@ -294,6 +1721,28 @@ func matchRecord(records []Record, recordType RecordType) (Record, bool) {
 	return Record{}, false
 }
 
+// matchNamedRecord returns the first record of the given type owned by the
+// given name, compared case-insensitively.
+func matchNamedRecord(records []Record, name string, recordType RecordType) (Record, bool) {
+	for _, r := range records {
+		if r.Type == recordType && canonicalName(string(r.Name)) == canonicalName(name) {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// filterRecords returns the records of the given type.
+func filterRecords(records []Record, recordType RecordType) []Record {
+	var results []Record
+	for _, r := range records {
+		if r.Type == recordType {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
 func ipAddrsFromRecords(records []Record) ([]net.IP, error) {
 	results := make([]net.IP, 0, len(records))
 	for _, r := range records {
@@ -308,17 +1757,59 @@ func ipAddrsFromRecords(records []Record) ([]net.IP, error) {
 	return results, nil
 }
 
-// randomChoice returns a random element from the given slice.
-func randomChoice[T any](choices []T) T {
-	return choices[rand.Intn(len(choices))]
+// shuffled returns a copy of the given slice in a random order.
+func shuffled[T any](items []T) []T {
+	results := append([]T(nil), items...)
+	rand.Shuffle(len(results), func(i, j int) { results[i], results[j] = results[j], results[i] })
+	return results
 }
 
 type nameServerDef struct {
 	name      string
-	addr      net.IP
+	addrs     []net.IP
 	authority string
+
+	// for upstream recursive resolvers
+	recursive bool   // queries are sent with recursion desired
+	port      string // overrides the resolver's port if set
+	url       string // DNS-over-HTTPS endpoint, used instead of addrs
+}
+
+// respondent is a name server that responded to a query, along with the
+// address that the response came from.
+type respondent struct {
+	nameServerDef
+	addr net.IP
+}
+
+func newNameServerDef(name string, authority string, addrs ...net.IP) nameServerDef {
+	return nameServerDef{addrs: addrs, name: name, authority: authority}
+}
+
+// addrFor returns the first of the name server's addresses that may be used
+// with the given network, preferring IPv4 addresses when the network does not
+// specify an address family.
+func (ns nameServerDef) addrFor(network string) (net.IP, bool) {
+	addrs := ns.addrsFor(network)
+	if len(addrs) == 0 {
+		return nil, false
+	}
+	return addrs[0], true
 }
 
-func newNameServerDef(name string, authority string, addr net.IP) nameServerDef {
-	return nameServerDef{addr: addr, name: name, authority: authority}
+// addrsFor returns all of the name server's addresses that may be used with
+// the given network, in order of preference.
+func (ns nameServerDef) addrsFor(network string) []net.IP {
+	family := networkFamily(network)
+	var results, fallbacks []net.IP
+	for _, addr := range ns.addrs {
+		isV4 := addr.To4() != nil
+		switch {
+		case family == "4" && isV4, family == "6" && !isV4, family == "" && isV4:
+			results = append(results, addr)
+		case family == "":
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return append(results, fallbacks...)
 }
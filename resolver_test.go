@@ -0,0 +1,702 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+func TestNameServerAddrFor(t *testing.T) {
+	t.Parallel()
+
+	v4 := net.ParseIP("198.41.0.4")
+	v6 := net.ParseIP("2001:503:ba3e::2:30")
+
+	testCases := map[string]struct {
+		addrs     []net.IP
+		network   string
+		wantAddr  net.IP
+		wantFound bool
+	}{
+		"udp prefers ipv4":       {addrs: []net.IP{v6, v4}, network: "udp", wantAddr: v4, wantFound: true},
+		"udp falls back to ipv6": {addrs: []net.IP{v6}, network: "udp", wantAddr: v6, wantFound: true},
+		"udp6 requires ipv6":     {addrs: []net.IP{v4, v6}, network: "udp6", wantAddr: v6, wantFound: true},
+		"tcp4 requires ipv4":     {addrs: []net.IP{v6, v4}, network: "tcp4", wantAddr: v4, wantFound: true},
+		"udp6 without ipv6 addr": {addrs: []net.IP{v4}, network: "udp6", wantFound: false},
+		"tcp4 without ipv4 addr": {addrs: []net.IP{v6}, network: "tcp4", wantFound: false},
+		"no addresses at all":    {addrs: nil, network: "udp", wantFound: false},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ns := newNameServerDef("ns.example.com", "example.com", tc.addrs...)
+			addr, found := ns.addrFor(tc.network)
+			be.Equal(t, tc.wantFound, found)
+			be.Equal(t, tc.wantAddr.String(), addr.String())
+		})
+	}
+}
+
+func TestGetGlueNameServersGroupsAddrs(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, Data: []byte("a.iana-servers.net")},
+		},
+		Additionals: []Record{
+			{Name: []byte("a.iana-servers.net"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{199, 43, 135, 53}},
+			{Name: []byte("a.iana-servers.net"), Type: RecordTypeAAAA, Class: ResourceClassIN, Data: net.ParseIP("2001:500:8f::53")},
+		},
+	}
+	got, err := getGlueNameServers(msg)
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(got))
+	be.Equal(t, "a.iana-servers.net", got[0].name)
+	be.Equal(t, "example.com", got[0].authority)
+	be.Equal(t, 2, len(got[0].addrs))
+	be.Equal(t, "199.43.135.53", got[0].addrs[0].String())
+	be.Equal(t, "2001:500:8f::53", got[0].addrs[1].String())
+}
+
+func TestGetGlueNameServersIgnoresCase(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, Data: []byte("A.IANA-Servers.net")},
+		},
+		Additionals: []Record{
+			{Name: []byte("a.iana-servers.net."), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{199, 43, 135, 53}},
+			{Name: []byte("A.iana-SERVERS.net"), Type: RecordTypeAAAA, Class: ResourceClassIN, Data: net.ParseIP("2001:500:8f::53")},
+		},
+	}
+	got, err := getGlueNameServers(msg)
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(got))
+	be.Equal(t, "A.IANA-Servers.net", got[0].name)
+	be.Equal(t, 2, len(got[0].addrs))
+}
+
+func TestMatchNamedRecord(t *testing.T) {
+	t.Parallel()
+
+	records := []Record{
+		{Name: []byte("other.example.com"), Type: RecordTypeCNAME, Data: []byte("a.example.com")},
+		{Name: []byte("WWW.Example.com."), Type: RecordTypeCNAME, Data: []byte("b.example.com")},
+	}
+	got, found := matchNamedRecord(records, "www.example.COM", RecordTypeCNAME)
+	be.True(t, found)
+	be.Equal(t, "b.example.com", string(got.Data))
+
+	_, found = matchNamedRecord(records, "www.example.com", RecordTypeA)
+	be.False(t, found)
+}
+
+func TestValidateResponse(t *testing.T) {
+	t.Parallel()
+
+	query := newQueryHelper("www.example.com", RecordTypeA, 1234)
+	question := Question{Name: []byte("WWW.Example.COM"), Type: RecordTypeA, Class: ResourceClassIN}
+
+	testCases := map[string]struct {
+		msg       Message
+		exactCase bool
+		wantErr   string
+	}{
+		"matching response, name case ignored": {
+			msg: Message{Header: Header{ID: 1234}, Questions: []Question{question}},
+		},
+		"matching response, exact case": {
+			msg:       Message{Header: Header{ID: 1234}, Questions: []Question{{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN}}},
+			exactCase: true,
+		},
+		"mismatched name case": {
+			msg:       Message{Header: Header{ID: 1234}, Questions: []Question{question}},
+			exactCase: true,
+			wantErr:   "response does not match query: got question WWW.Example.COM A 1, expected www.example.com A 1",
+		},
+		"mismatched id": {
+			msg:     Message{Header: Header{ID: 4321}, Questions: []Question{question}},
+			wantErr: "response does not match query: got ID 4321, expected 1234",
+		},
+		"missing question": {
+			msg:     Message{Header: Header{ID: 1234}},
+			wantErr: "response does not match query: got 0 questions, expected 1",
+		},
+		"mismatched name": {
+			msg:     Message{Header: Header{ID: 1234}, Questions: []Question{{Name: []byte("evil.example.com"), Type: RecordTypeA, Class: ResourceClassIN}}},
+			wantErr: "response does not match query: got question evil.example.com A 1, expected www.example.com A 1",
+		},
+		"mismatched type": {
+			msg:     Message{Header: Header{ID: 1234}, Questions: []Question{{Name: []byte("www.example.com"), Type: RecordTypeAAAA, Class: ResourceClassIN}}},
+			wantErr: "response does not match query: got question www.example.com AAAA 1, expected www.example.com A 1",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := validateResponse(query, tc.msg, tc.exactCase)
+			if tc.wantErr == "" {
+				be.NilErr(t, err)
+				return
+			}
+			be.Nonzero(t, err)
+			be.True(t, errors.Is(err, ErrMismatchedResponse))
+			be.Equal(t, tc.wantErr, err.Error())
+		})
+	}
+}
+
+// testHandler answers a single parsed query with a response message.
+type testHandler func(q Message) Message
+
+// noResponse may be returned by a testHandler to simulate a lost response.
+// Handlers otherwise never set the message ID, which is copied from the
+// query.
+var noResponse = Message{Header: Header{ID: 0xffff}}
+
+// startTestServer starts a UDP DNS server on localhost that answers queries
+// with the given handler, returning its port.
+func startTestServer(t *testing.T, handler testHandler) string {
+	t.Helper()
+	return startTestServerOn(t, "127.0.0.1", handler)
+}
+
+// startTestServerOn is like startTestServer, listening on the given loopback
+// address, e.g. to tell apart name servers whose health is tracked by IP.
+func startTestServerOn(t *testing.T, ip string, handler testHandler) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+	be.NilErr(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := parseMessage(byteview.New(buf[:n]))
+			if err != nil {
+				continue
+			}
+			resp := handler(query)
+			if resp.Header.ID == noResponse.Header.ID {
+				continue
+			}
+			resp.Header.ID = query.Header.ID
+			resp.Header.Flags |= headerFlagQR
+			if resp.Questions == nil {
+				resp.Questions = query.Questions
+			}
+			conn.WriteTo(resp.Encode(), addr)
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	be.NilErr(t, err)
+	return port
+}
+
+// newTestResolver creates a resolver that sends every query to the test
+// server listening on localhost at the given port.
+func newTestResolver(port string, opts *Opts) *Resolver {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	opts.RootNameServers = []nameServerDef{newNameServerDef("root.test", ".", net.ParseIP("127.0.0.1"))}
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts.DisableRootPriming = true
+	r := New(opts)
+	r.port = port
+	return r
+}
+
+// referral returns a response delegating to the given name server, with a
+// glue record pointing back at localhost.
+func referral(zone, nsName string) Message {
+	return Message{
+		Authorities: []Record{{Name: []byte(zone), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte(nsName)}},
+		Additionals: []Record{{Name: []byte(nsName), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{127, 0, 0, 1}}},
+	}
+}
+
+func TestLookupIPDelegationLoop(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		return referral("loop.test", "ns.loop.test")
+	})
+	r := newTestResolver(port, nil)
+	_, err := r.LookupIP(context.Background(), "ip4", "www.loop.test")
+	be.True(t, errors.Is(err, ErrLookupLoop))
+}
+
+func TestLookupIPMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return referral("deep.test", fmt.Sprintf("ns%d.deep.test", n))
+	})
+	r := newTestResolver(port, &Opts{MaxDepth: 5})
+	_, err := r.LookupIP(context.Background(), "ip4", "www.deep.test")
+	be.True(t, errors.Is(err, ErrMaxDepth))
+}
+
+func cnameAnswer(from, to string) Message {
+	return Message{
+		Answers: []Record{{Name: []byte(from), Type: RecordTypeCNAME, Class: ResourceClassIN, TTL: 60, Data: []byte(to)}},
+	}
+}
+
+func TestLookupIPCNAMELoop(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		if strings.EqualFold(string(q.Questions[0].Name), "a.cname.test") {
+			return cnameAnswer("a.cname.test", "b.cname.test")
+		}
+		return cnameAnswer("b.cname.test", "a.cname.test")
+	})
+	r := newTestResolver(port, nil)
+	_, err := r.LookupIP(context.Background(), "ip4", "a.cname.test")
+	be.True(t, errors.Is(err, ErrCNAMELoop))
+}
+
+func TestLookupIPCNAMEChainTooLong(t *testing.T) {
+	t.Parallel()
+
+	// n.cname.test -> n+1.cname.test -> ...
+	port := startTestServer(t, func(q Message) Message {
+		var n int
+		fmt.Sscanf(strings.ToLower(string(q.Questions[0].Name)), "%d.cname.test", &n)
+		return cnameAnswer(string(q.Questions[0].Name), fmt.Sprintf("%d.cname.test", n+1))
+	})
+
+	// following 0 -> 1 -> 2 -> 3 is allowed, but following a fourth CNAME
+	// exceeds the limit
+	r := newTestResolver(port, &Opts{MaxCNAMEChain: 3})
+	_, err := r.LookupIP(context.Background(), "ip4", "0.cname.test")
+	be.True(t, errors.Is(err, ErrCNAMEChainTooLong))
+	be.Equal(t, "lookup 3.cname.test: maximum CNAME chain length exceeded (3)", err.Error())
+}
+
+func TestEnforceBailiwick(t *testing.T) {
+	t.Parallel()
+
+	r := newTestResolver("53", nil)
+	nameServer := newNameServerDef("a.gtld-servers.net", "com", net.ParseIP("192.5.6.30"))
+	msg := Message{
+		Answers: []Record{
+			{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{1, 2, 3, 4}},
+			{Name: []byte("www.example.org"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{6, 6, 6, 6}},
+		},
+		Authorities: []Record{
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, Data: []byte("ns1.example.com")},
+			{Name: []byte("example.com"), Type: RecordTypeNS, Class: ResourceClassIN, Data: []byte("ns.example.net")},
+		},
+		Additionals: []Record{
+			{Name: []byte("NS1.Example.COM"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{1, 1, 1, 1}},
+			{Name: []byte("ns.example.net"), Type: RecordTypeA, Class: ResourceClassIN, Data: []byte{6, 6, 6, 6}},
+			{Name: []byte(""), Type: RecordTypeOPT, Class: ResourceClass(ednsUDPSize)},
+		},
+	}
+
+	got := r.enforceBailiwick(context.Background(), nameServer, msg)
+	be.Equal(t, 1, len(got.Answers))
+	be.Equal(t, "www.example.com", string(got.Answers[0].Name))
+	be.Equal(t, 2, len(got.Authorities))
+	be.Equal(t, 2, len(got.Additionals))
+	be.Equal(t, "NS1.Example.COM", string(got.Additionals[0].Name))
+	be.Equal(t, RecordTypeOPT, got.Additionals[1].Type)
+
+	// the root is authoritative for everything
+	got = r.enforceBailiwick(context.Background(), r.rootNameServers[0], msg)
+	be.Equal(t, 2, len(got.Answers))
+	be.Equal(t, 3, len(got.Additionals))
+}
+
+func TestLookupIPIgnoresOutOfBailiwickAnswers(t *testing.T) {
+	t.Parallel()
+
+	// the root delegates example.test to ns.example.test, which answers with
+	// an extra record for a name outside of its zone
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n == 1 {
+			return referral("example.test", "ns.example.test")
+		}
+		return Message{
+			Answers: []Record{
+				{Name: []byte("www.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+				{Name: []byte("www.bank.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{6, 6, 6, 6}},
+			},
+		}
+	})
+	r := newTestResolver(port, nil)
+	ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(ips))
+	be.Equal(t, "1.2.3.4", ips[0].String())
+
+	_, found := r.cache.Get(NewCacheKey("www.example.test", RecordTypeA, ResourceClassIN))
+	be.True(t, found)
+	_, found = r.cache.Get(NewCacheKey("www.bank.test", RecordTypeA, ResourceClassIN))
+	be.False(t, found)
+}
+
+func TestRandomizeCase(t *testing.T) {
+	t.Parallel()
+
+	name := "www.example-123.com"
+	var changed bool
+	for i := 0; i < 10; i++ {
+		got := randomizeCase(name)
+		be.True(t, strings.EqualFold(name, got))
+		changed = changed || got != name
+	}
+	be.True(t, changed)
+}
+
+func TestLookupIPCaseRandomization(t *testing.T) {
+	t.Parallel()
+
+	answer := func(q Message) Message {
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	}
+
+	// a server that echoes the query name exactly
+	port := startTestServer(t, answer)
+	r := newTestResolver(port, &Opts{DisableCache: true})
+	_, err := r.LookupIP(context.Background(), "ip4", "www.example-with-a-long-name.test")
+	be.NilErr(t, err)
+
+	// a server that lowercases the query name only works with case
+	// randomization disabled
+	port = startTestServer(t, func(q Message) Message {
+		resp := answer(q)
+		resp.Questions = []Question{q.Questions[0]}
+		resp.Questions[0].Name = bytes.ToLower(q.Questions[0].Name)
+		return resp
+	})
+	r = newTestResolver(port, &Opts{DisableCache: true})
+	_, err = r.LookupIP(context.Background(), "ip4", "www.example-with-a-long-name.test")
+	be.True(t, errors.Is(err, ErrMismatchedResponse))
+
+	r = newTestResolver(port, &Opts{DisableCache: true, DisableCaseRandomization: true})
+	_, err = r.LookupIP(context.Background(), "ip4", "www.example-with-a-long-name.test")
+	be.NilErr(t, err)
+}
+
+func TestLookupIPRetriesTimeouts(t *testing.T) {
+	t.Parallel()
+
+	// the first two queries are lost
+	newServer := func() string {
+		var mu sync.Mutex
+		var n int
+		return startTestServer(t, func(q Message) Message {
+			mu.Lock()
+			defer mu.Unlock()
+			n++
+			if n <= 2 {
+				return noResponse
+			}
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+			}
+		})
+	}
+
+	t.Run("succeeds within attempts", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(), &Opts{QueryTimeout: 50 * time.Millisecond, RetryBackoff: time.Millisecond})
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, "1.2.3.4", ips[0].String())
+	})
+
+	t.Run("fails after exhausting attempts", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(), &Opts{QueryTimeout: 50 * time.Millisecond, QueryAttempts: 2, RetryBackoff: time.Millisecond})
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.True(t, isTimeout(err))
+	})
+}
+
+func TestNameServerAddrsFor(t *testing.T) {
+	t.Parallel()
+
+	ns := newNameServerDef("ns.example.com", "example.com", net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"))
+	be.Equal(t, "[192.0.2.1 192.0.2.2 2001:db8::1]", fmt.Sprint(ns.addrsFor("udp")))
+	be.Equal(t, "[192.0.2.1 192.0.2.2]", fmt.Sprint(ns.addrsFor("udp4")))
+	be.Equal(t, "[2001:db8::1]", fmt.Sprint(ns.addrsFor("tcp6")))
+}
+
+func TestLookupIPTriesAllDelegatedNameServers(t *testing.T) {
+	t.Parallel()
+
+	// the root delegates to two name servers, the first of which to be
+	// asked responds with SERVFAIL
+	newServer := func(failures int) string {
+		var mu sync.Mutex
+		var n int
+		return startTestServer(t, func(q Message) Message {
+			mu.Lock()
+			defer mu.Unlock()
+			n++
+			if n == 1 {
+				resp := referral("example.test", "ns1.example.test")
+				resp.Authorities = append(resp.Authorities, Record{Name: []byte("example.test"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns2.example.test")})
+				resp.Additionals = append(resp.Additionals, Record{Name: []byte("ns2.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{127, 0, 0, 1}})
+				return resp
+			}
+			if n <= 1+failures {
+				return Message{Header: Header{Flags: uint16(RCodeServFail)}}
+			}
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+			}
+		})
+	}
+
+	t.Run("falls through to next name server", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(1), nil)
+		ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.NilErr(t, err)
+		be.Equal(t, "1.2.3.4", ips[0].String())
+	})
+
+	t.Run("fails when every name server fails", func(t *testing.T) {
+		t.Parallel()
+		r := newTestResolver(newServer(2), nil)
+		_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+		be.True(t, errors.Is(err, ErrServFail))
+
+		// the failures are recorded against the name servers' address
+		be.Equal(t, 2, r.rtt.entries["127.0.0.1"].failures)
+	})
+}
+
+func TestLookupIPRacesNameServers(t *testing.T) {
+	t.Parallel()
+
+	// the root delegates to two name servers, one of which never responds
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		switch n {
+		case 1:
+			resp := referral("example.test", "ns1.example.test")
+			resp.Authorities = append(resp.Authorities, Record{Name: []byte("example.test"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 60, Data: []byte("ns2.example.test")})
+			resp.Additionals = append(resp.Additionals, Record{Name: []byte("ns2.example.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{127, 0, 0, 1}})
+			return resp
+		case 2:
+			return noResponse
+		default:
+			return Message{
+				Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+			}
+		}
+	})
+
+	// without racing, the unresponsive name server would exhaust the
+	// context's deadline before the other is tried
+	r := newTestResolver(port, &Opts{RaceNameServers: 2, QueryTimeout: 10 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ips, err := r.LookupIP(ctx, "ip4", "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, "1.2.3.4", ips[0].String())
+}
+
+func TestLookupIPHonorsContext(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		return noResponse
+	})
+	r := newTestResolver(port, &Opts{QueryTimeout: 10 * time.Second})
+
+	t.Run("deadline", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := r.LookupIP(ctx, "ip4", "www.example.test")
+		be.True(t, errors.Is(err, context.DeadlineExceeded))
+		be.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := r.LookupIP(ctx, "ip4", "www.example.test")
+		be.True(t, errors.Is(err, context.Canceled))
+		be.True(t, time.Since(start) < time.Second)
+	})
+}
+
+func TestLookupIPResolutionTimeout(t *testing.T) {
+	t.Parallel()
+
+	// every query times out, but would be retried for far longer than the
+	// resolution timeout allows
+	port := startTestServer(t, func(q Message) Message {
+		return noResponse
+	})
+	r := newTestResolver(port, &Opts{
+		QueryTimeout:      time.Second,
+		QueryAttempts:     5,
+		ResolutionTimeout: 100 * time.Millisecond,
+	})
+	start := time.Now()
+	_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.True(t, errors.Is(err, ErrResolutionTimeout))
+	be.Equal(t, "lookup www.example.test: resolution timeout exceeded after 100ms", err.Error())
+	be.True(t, time.Since(start) < time.Second)
+}
+
+func TestLookupIPAddr(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		name, typ := canonicalName(string(q.Questions[0].Name)), q.Questions[0].Type
+		switch {
+		case name == "dual.test" && typ == RecordTypeA, name == "v4.test" && typ == RecordTypeA:
+			return Message{Answers: []Record{{Name: []byte(name), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}}
+		case name == "dual.test" && typ == RecordTypeAAAA:
+			return Message{Answers: []Record{{Name: []byte(name), Type: RecordTypeAAAA, Class: ResourceClassIN, TTL: 60, Data: net.ParseIP("2001:db8::1")}}}
+		case name == "v4.test":
+			return Message{}
+		}
+		return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+	})
+	r := newTestResolver(port, nil)
+
+	testCases := map[string]struct {
+		host    string
+		want    []string
+		wantErr error
+	}{
+		"both families": {host: "dual.test", want: []string{"1.2.3.4", "2001:db8::1"}},
+		"IPv4 only":     {host: "v4.test", want: []string{"1.2.3.4"}},
+		"IP literal":    {host: "2001:db8::2", want: []string{"2001:db8::2"}},
+		"missing":       {host: "missing.test", wantErr: ErrNXDomain},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := r.LookupHost(context.Background(), tc.host)
+			if tc.wantErr != nil {
+				be.True(t, errors.Is(err, tc.wantErr))
+				return
+			}
+			be.NilErr(t, err)
+			be.DeepEqual(t, tc.want, got)
+
+			addrs, err := r.LookupIPAddr(context.Background(), tc.host)
+			be.NilErr(t, err)
+			be.Equal(t, len(tc.want), len(addrs))
+		})
+	}
+}
+
+func TestLookupIPNetwork(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		network   string
+		host      string
+		want      []string
+		wantTypes []RecordType
+		wantErr   bool
+	}{
+		"ip":               {network: "ip", host: "dual.test", want: []string{"1.2.3.4", "2001:db8::1"}, wantTypes: []RecordType{RecordTypeA, RecordTypeAAAA}},
+		"ip4":              {network: "ip4", host: "dual.test", want: []string{"1.2.3.4"}, wantTypes: []RecordType{RecordTypeA}},
+		"ip6":              {network: "ip6", host: "dual.test", want: []string{"2001:db8::1"}, wantTypes: []RecordType{RecordTypeAAAA}},
+		"matching literal": {network: "ip6", host: "2001:db8::2", want: []string{"2001:db8::2"}},
+		"other literal":    {network: "ip4", host: "2001:db8::2", wantErr: true},
+		"unknown network":  {network: "tcp", host: "dual.test", wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// a separate server per case, so the query types sent can be
+			// counted
+			var mu sync.Mutex
+			queried := map[RecordType]int{}
+			port := startTestServer(t, func(q Message) Message {
+				mu.Lock()
+				queried[q.Questions[0].Type]++
+				mu.Unlock()
+				if q.Questions[0].Type == RecordTypeAAAA {
+					return Message{Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeAAAA, Class: ResourceClassIN, TTL: 60, Data: net.ParseIP("2001:db8::1")}}}
+				}
+				return Message{Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}}
+			})
+			r := newTestResolver(port, nil)
+
+			ips, err := r.LookupIP(context.Background(), tc.network, tc.host)
+			if tc.wantErr {
+				be.True(t, err != nil)
+				return
+			}
+			be.NilErr(t, err)
+			got := make([]string, len(ips))
+			for i, ip := range ips {
+				got[i] = ip.String()
+			}
+			be.AllEqual(t, tc.want, got)
+
+			mu.Lock()
+			defer mu.Unlock()
+			be.Equal(t, len(tc.wantTypes), len(queried))
+			for _, recordType := range tc.wantTypes {
+				be.Equal(t, 1, queried[recordType])
+			}
+		})
+	}
+
+	r := New(nil)
+	_, err := r.LookupIP(context.Background(), "tcp", "example.com")
+	var netErr net.UnknownNetworkError
+	be.True(t, errors.As(err, &netErr))
+	_, err = r.LookupIP(context.Background(), "ip4", "2001:db8::2")
+	be.True(t, errors.Is(err, ErrNoData))
+}
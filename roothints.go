@@ -0,0 +1,79 @@
+package dnstoy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// RootHints lists the root name servers that a resolver sends its first
+// queries to, replacing the built-in list of the root servers of the
+// internet, e.g. to resolve names in a lab with its own root servers, or
+// using a local copy of the root zone.
+type RootHints struct {
+	nameServers []nameServerDef
+}
+
+// LoadRootHints loads and parses the root hints file at the given path. See
+// ParseRootHints.
+func LoadRootHints(path string) (*RootHints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hints, err := ParseRootHints(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return hints, nil
+}
+
+// ParseRootHints parses a root hints file, in master file format like
+// https://www.internic.net/domain/named.root: the root zone's NS records,
+// and the A and AAAA records of the name servers they name. Name servers
+// without addresses are ignored.
+func ParseRootHints(r io.Reader) (*RootHints, error) {
+	records, err := ParseZone(r, ".")
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string][]net.IP)
+	for _, rec := range records {
+		if rec.Type != RecordTypeA && rec.Type != RecordTypeAAAA {
+			continue
+		}
+		ips, err := parseIPAddrs(rec.Type, rec.Data)
+		if err != nil {
+			return nil, err
+		}
+		name := canonicalName(string(rec.Name))
+		addrs[name] = append(addrs[name], ips...)
+	}
+	hints := &RootHints{}
+	for _, rec := range records {
+		if rec.Type != RecordTypeNS || canonicalName(string(rec.Name)) != "" {
+			continue
+		}
+		name := canonicalName(string(rec.Data))
+		if len(addrs[name]) > 0 {
+			hints.nameServers = append(hints.nameServers, newNameServerDef(name, ".", addrs[name]...))
+		}
+	}
+	if len(hints.nameServers) == 0 {
+		return nil, errors.New("no root name servers with addresses")
+	}
+	return hints, nil
+}
+
+// RootHintsFromAddrs returns root hints listing a root name server at each
+// of the given addresses, named by its address.
+func RootHintsFromAddrs(addrs ...net.IP) *RootHints {
+	hints := &RootHints{}
+	for _, addr := range addrs {
+		hints.nameServers = append(hints.nameServers, newNameServerDef(addr.String(), ".", addr))
+	}
+	return hints
+}
@@ -0,0 +1,76 @@
+package dnstoy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestParseRootHints(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		hints   string
+		want    []string
+		wantErr bool
+	}{
+		"named.root": {
+			hints: `; formerly NS.INTERNIC.NET
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+;
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+`,
+			want: []string{"a.root-servers.net [198.41.0.4 2001:503:ba3e::2:30]", "b.root-servers.net [170.247.170.2]"},
+		},
+		"name server without addresses": {
+			hints: ". 60 NS a.root.test.\n. 60 NS b.root.test.\nb.root.test. 60 A 192.0.2.2\n",
+			want:  []string{"b.root.test [192.0.2.2]"},
+		},
+		"addresses of other names": {
+			hints:   ". 60 NS a.root.test.\nb.root.test. 60 A 192.0.2.2\n",
+			wantErr: true,
+		},
+		"empty": {
+			hints:   "",
+			wantErr: true,
+		},
+		"syntax error": {
+			hints:   ". 60 NS\n",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			hints, err := ParseRootHints(strings.NewReader(tc.hints))
+			if tc.wantErr {
+				be.True(t, err != nil)
+				return
+			}
+			be.NilErr(t, err)
+			var got []string
+			for _, ns := range hints.nameServers {
+				got = append(got, fmt.Sprintf("%s %s", ns.name, ns.addrs))
+			}
+			be.AllEqual(t, tc.want, got)
+		})
+	}
+}
+
+func TestRootHintsFromAddrs(t *testing.T) {
+	t.Parallel()
+
+	hints := RootHintsFromAddrs(net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1"))
+	r := New(&Opts{RootHints: hints})
+	be.Equal(t, 2, len(r.rootHints))
+	be.Equal(t, "192.0.2.1", r.rootHints[0].name)
+	be.Equal(t, ".", r.rootHints[1].authority)
+	be.Equal(t, "2001:db8::1", r.rootHints[1].addrs[0].String())
+}
@@ -0,0 +1,176 @@
+package dnstoy
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// unknownRTT is the initial estimate for name server addresses that have
+	// not yet been queried. It is low enough that new addresses are tried
+	// before known slow ones, with jitter added so that unknown addresses
+	// are explored in a random order.
+	unknownRTT       = 10 * time.Millisecond
+	unknownRTTJitter = 10 * time.Millisecond
+
+	// maxRTTFailures is the number of consecutive failures after which an
+	// address is considered unhealthy, and tried only after healthy ones,
+	// until failureExpiry has passed since its last failure.
+	maxRTTFailures = 3
+	failureExpiry  = time.Minute
+
+	// maxRTTEntries bounds the number of addresses tracked.
+	maxRTTEntries = 10000
+)
+
+type rttEntry struct {
+	srtt        time.Duration
+	failures    int
+	lastFailure time.Time
+}
+
+// rttTracker tracks the smoothed round trip time and recent failures of each
+// name server address queried, so that the fastest healthy name servers can
+// be preferred, in the manner of BIND and Unbound.
+type rttTracker struct {
+	mu      sync.Mutex
+	entries map[string]*rttEntry // keyed by IP address
+	now     func() time.Time     // may be overridden in tests
+}
+
+func newRTTTracker() *rttTracker {
+	return &rttTracker{
+		entries: make(map[string]*rttEntry),
+		now:     time.Now,
+	}
+}
+
+// success records a response received from the given address after rtt.
+func (t *rttTracker) success(addr net.IP, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(addr)
+	e.failures = 0
+	e.update(rtt)
+}
+
+// failure records a failed query to the given address, which is penalized as
+// if it had responded after the given timeout.
+func (t *rttTracker) failure(addr net.IP, timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(addr)
+	e.failures++
+	e.lastFailure = t.now()
+	e.update(timeout)
+}
+
+// entry returns the entry for the given address, creating it if necessary.
+// The caller must hold t.mu.
+func (t *rttTracker) entry(addr net.IP) *rttEntry {
+	key := addr.String()
+	if e, found := t.entries[key]; found {
+		return e
+	}
+	if len(t.entries) >= maxRTTEntries {
+		// evict an arbitrary entry to make room
+		for k := range t.entries {
+			delete(t.entries, k)
+			break
+		}
+	}
+	e := &rttEntry{}
+	t.entries[key] = e
+	return e
+}
+
+// update folds a new sample into the smoothed RTT, weighting the previous
+// estimate by 7/8 as in RFC 6298.
+func (e *rttEntry) update(rtt time.Duration) {
+	if e.srtt == 0 {
+		e.srtt = rtt
+		return
+	}
+	e.srtt = (7*e.srtt + rtt) / 8
+}
+
+// rttScore orders addresses or name servers by preference: healthy before
+// unhealthy, then by ascending smoothed RTT.
+type rttScore struct {
+	rtt     time.Duration
+	healthy bool
+}
+
+func (s rttScore) less(other rttScore) bool {
+	if s.healthy != other.healthy {
+		return s.healthy
+	}
+	return s.rtt < other.rtt
+}
+
+// score returns the estimated cost of querying the given address. The caller
+// must hold t.mu.
+func (t *rttTracker) score(addr net.IP) rttScore {
+	e, found := t.entries[addr.String()]
+	if !found {
+		return rttScore{rtt: unknownRTT + time.Duration(rand.Int63n(int64(unknownRTTJitter))), healthy: true}
+	}
+	return rttScore{
+		rtt:     e.srtt,
+		healthy: e.failures < maxRTTFailures || t.now().Sub(e.lastFailure) > failureExpiry,
+	}
+}
+
+// healthy reports whether the given address is healthy.
+func (t *rttTracker) healthy(addr net.IP) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.score(addr).healthy
+}
+
+// sortAddrs sorts addresses in order of preference.
+func (t *rttTracker) sortAddrs(addrs []net.IP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	scores := make(map[string]rttScore, len(addrs))
+	for _, addr := range addrs {
+		scores[addr.String()] = t.score(addr)
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return scores[addrs[i].String()].less(scores[addrs[j].String()])
+	})
+}
+
+// sortNameServers sorts name servers in order of preference, according to
+// the best of each one's addresses usable over the given network. Name
+// servers without usable addresses keep their relative order at the end.
+func (t *rttTracker) sortNameServers(nameServers []nameServerDef, network string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	type scored struct {
+		nameServer nameServerDef
+		score      rttScore
+		usable     bool
+	}
+	items := make([]scored, len(nameServers))
+	for i, ns := range nameServers {
+		items[i].nameServer = ns
+		for _, addr := range ns.addrsFor(network) {
+			if score := t.score(addr); !items[i].usable || score.less(items[i].score) {
+				items[i].score, items[i].usable = score, true
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].usable != items[j].usable {
+			return items[i].usable
+		}
+		return items[i].score.less(items[j].score)
+	})
+	for i, item := range items {
+		nameServers[i] = item.nameServer
+	}
+}
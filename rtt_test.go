@@ -0,0 +1,61 @@
+package dnstoy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestRTTTracker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	tracker := newRTTTracker()
+	tracker.now = func() time.Time { return now }
+
+	fast := net.ParseIP("192.0.2.1")
+	slow := net.ParseIP("192.0.2.2")
+	failing := net.ParseIP("192.0.2.3")
+	unknown := net.ParseIP("192.0.2.4")
+
+	tracker.success(fast, 20*time.Millisecond)
+	tracker.success(slow, 200*time.Millisecond)
+	for i := 0; i < maxRTTFailures; i++ {
+		tracker.failure(failing, time.Second)
+	}
+
+	// the smoothed RTT moves gradually towards new samples
+	tracker.success(fast, 100*time.Millisecond)
+	be.Equal(t, 30*time.Millisecond, tracker.entries[fast.String()].srtt)
+
+	// unknown addresses are explored before known slow ones, and unhealthy
+	// addresses are tried last
+	addrs := []net.IP{failing, slow, unknown, fast}
+	tracker.sortAddrs(addrs)
+	be.Equal(t, "[192.0.2.4 192.0.2.1 192.0.2.2 192.0.2.3]", fmt.Sprint(addrs))
+
+	// name servers are ordered by their best usable address
+	nameServers := []nameServerDef{
+		newNameServerDef("ns1.example.com", "example.com", slow),
+		newNameServerDef("ns2.example.com", "example.com", failing, fast),
+		newNameServerDef("ns3.example.com", "example.com"),
+		newNameServerDef("ns4.example.com", "example.com", failing),
+	}
+	tracker.sortNameServers(nameServers, "udp")
+	names := make([]string, len(nameServers))
+	for i, ns := range nameServers {
+		names[i] = ns.name
+	}
+	be.Equal(t, "[ns2.example.com ns1.example.com ns4.example.com ns3.example.com]", fmt.Sprint(names))
+
+	// failures are forgiven after a while
+	now = now.Add(failureExpiry + time.Second)
+	be.True(t, tracker.score(failing).healthy)
+
+	// a success resets the failure count
+	tracker.success(failing, 10*time.Millisecond)
+	be.Equal(t, 0, tracker.entries[failing.String()].failures)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// Blocklist is a set of names that should not be resolved, such as those of
+// ad and tracking servers.
+type Blocklist struct {
+	names     map[string]bool // blocked along with their subdomains
+	wildcards map[string]bool // only their subdomains are blocked
+}
+
+// LoadBlocklist loads and parses the blocklists at the given paths,
+// returning a Blocklist that blocks the names in any of them.
+func LoadBlocklist(paths ...string) (*Blocklist, error) {
+	b := newBlocklist()
+	for _, path := range paths {
+		if err := b.load(path); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (b *Blocklist) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := b.parse(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// ParseBlocklist parses a blocklist from r, which may be in the format of a
+// hosts file, as published by many blocklist maintainers, or a list of
+// names, one per line. In either case comments are introduced by "#", and
+// invalid entries are ignored.
+//
+// Each name blocks itself and its subdomains, while a wildcard such as
+// "*.example.com" blocks only the subdomains of example.com. In hosts files,
+// the addresses are ignored, as are the names conventionally mapped to
+// loopback addresses, such as localhost.
+func ParseBlocklist(r io.Reader) (*Blocklist, error) {
+	b := newBlocklist()
+	if err := b.parse(r); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newBlocklist() *Blocklist {
+	return &Blocklist{
+		names:     make(map[string]bool),
+		wildcards: make(map[string]bool),
+	}
+}
+
+// hostsOnlyNames are the names found in hosts files that must not be
+// blocked.
+var hostsOnlyNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+func (b *Blocklist) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1:
+			b.add(fields[0])
+		case len(fields) > 1 && net.ParseIP(strings.SplitN(fields[0], "%", 2)[0]) != nil:
+			for _, name := range fields[1:] {
+				if !hostsOnlyNames[canonicalName(name)] {
+					b.add(name)
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (b *Blocklist) add(entry string) {
+	name, wildcard := strings.CutPrefix(entry, "*.")
+	name = canonicalName(name)
+	if _, err := dnstoy.EncodeName(name); err != nil || name == "" {
+		return
+	}
+	if wildcard {
+		b.wildcards[name] = true
+	} else {
+		b.names[name] = true
+	}
+}
+
+// Len returns the number of entries in the blocklist.
+func (b *Blocklist) Len() int {
+	return len(b.names) + len(b.wildcards)
+}
+
+// Blocked returns true if the given name is blocked.
+func (b *Blocklist) Blocked(name string) bool {
+	name = canonicalName(name)
+	if b.names[name] {
+		return true
+	}
+	for {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+		if b.names[name] || b.wildcards[name] {
+			return true
+		}
+	}
+}
+
+// BlockResponse controls how blocked queries are answered.
+type BlockResponse int
+
+// Supported block responses
+const (
+	// BlockNXDomain answers blocked queries with NXDOMAIN, as if the name
+	// did not exist.
+	BlockNXDomain BlockResponse = iota
+
+	// BlockNullAddress answers blocked queries for A and AAAA records with
+	// the unspecified address, 0.0.0.0 or ::, so that clients fail to
+	// connect quickly instead of falling back to other names, and queries
+	// for other types with no records.
+	BlockNullAddress
+)
+
+// blockTTL is the TTL of the records in responses to blocked queries.
+const blockTTL = 60
+
+// Blocker is a Handler that answers queries for names in its Blocklist
+// itself, passing other queries on to the next handler, and counts the
+// queries blocked.
+type Blocker struct {
+	List     *Blocklist
+	Response BlockResponse
+	Next     Handler
+
+	queries atomic.Uint64
+	blocked atomic.Uint64
+}
+
+// ServeDNS answers the query if its name is blocked, or else passes it on
+// to the next handler.
+func (b *Blocker) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	b.queries.Add(1)
+	if len(query.Questions) != 1 || !b.List.Blocked(string(query.Questions[0].Name)) {
+		return b.Next.ServeDNS(ctx, query)
+	}
+	b.blocked.Add(1)
+
+	question := query.Questions[0]
+	var resp dnstoy.Message
+	resp.Header.SetRA(true)
+	switch {
+	case b.Response == BlockNXDomain:
+		resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+	case question.Type == dnstoy.RecordTypeA:
+		resp.Answers = []dnstoy.Record{{Name: question.Name, Type: question.Type, Class: question.Class, TTL: blockTTL, Data: make([]byte, net.IPv4len)}}
+	case question.Type == dnstoy.RecordTypeAAAA:
+		resp.Answers = []dnstoy.Record{{Name: question.Name, Type: question.Type, Class: question.Class, TTL: blockTTL, Data: make([]byte, net.IPv6len)}}
+	}
+	return resp, nil
+}
+
+// Queries returns the number of queries received by the blocker.
+func (b *Blocker) Queries() uint64 {
+	return b.queries.Load()
+}
+
+// Blocked returns the number of queries blocked.
+func (b *Blocker) Blocked() uint64 {
+	return b.blocked.Load()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+const testBlocklist = `
+# hosts format
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.example.com tracker.example.net # trailing comment
+
+# domain list format
+Metrics.Example.org.
+*.wild.example.com
+bad..name
+`
+
+func TestBlocklist(t *testing.T) {
+	t.Parallel()
+
+	b, err := ParseBlocklist(strings.NewReader(testBlocklist))
+	be.NilErr(t, err)
+	be.Equal(t, 4, b.Len())
+
+	testCases := map[string]bool{
+		"ads.example.com":       true,
+		"ADS.example.com.":      true,
+		"x.y.ads.example.com":   true,
+		"tracker.example.net":   true,
+		"metrics.example.org":   true,
+		"a.metrics.example.org": true,
+		"a.wild.example.com":    true,
+		"wild.example.com":      false,
+		"example.com":           false,
+		"notads.example.com":    false,
+		"localhost":             false,
+		"ip6-localhost":         false,
+	}
+	for name, want := range testCases {
+		name, want := name, want
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			be.Equal(t, want, b.Blocked(name))
+		})
+	}
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path1, path2 := filepath.Join(dir, "hosts"), filepath.Join(dir, "domains")
+	be.NilErr(t, os.WriteFile(path1, []byte("0.0.0.0 ads.example.com\n"), 0o644))
+	be.NilErr(t, os.WriteFile(path2, []byte("tracker.example.net\n"), 0o644))
+
+	b, err := LoadBlocklist(path1, path2)
+	be.NilErr(t, err)
+	be.True(t, b.Blocked("ads.example.com"))
+	be.True(t, b.Blocked("tracker.example.net"))
+
+	_, err = LoadBlocklist(filepath.Join(dir, "missing"))
+	be.True(t, err != nil)
+}
+
+func TestBlocker(t *testing.T) {
+	t.Parallel()
+
+	list, err := ParseBlocklist(strings.NewReader("ads.example.com\n"))
+	be.NilErr(t, err)
+	next := HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		return dnstoy.Message{Answers: []dnstoy.Record{{Name: query.Questions[0].Name, Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 60, Data: []byte{192, 0, 2, 1}}}}, nil
+	})
+
+	testCases := map[string]struct {
+		response   BlockResponse
+		name       string
+		recordType dnstoy.RecordType
+		wantRCode  dnstoy.RCode
+		wantData   []byte
+	}{
+		"not blocked":  {name: "www.example.com", recordType: dnstoy.RecordTypeA, wantData: []byte{192, 0, 2, 1}},
+		"nxdomain":     {response: BlockNXDomain, name: "ads.example.com", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeNXDomain},
+		"null A":       {response: BlockNullAddress, name: "ads.example.com", recordType: dnstoy.RecordTypeA, wantData: make([]byte, 4)},
+		"null AAAA":    {response: BlockNullAddress, name: "ads.example.com", recordType: dnstoy.RecordTypeAAAA, wantData: make([]byte, 16)},
+		"null no data": {response: BlockNullAddress, name: "ads.example.com", recordType: dnstoy.RecordTypeMX},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			blocker := &Blocker{List: list, Response: tc.response, Next: next}
+			query := dnstoy.Message{Questions: []dnstoy.Question{{Name: []byte(tc.name), Type: tc.recordType, Class: dnstoy.ResourceClassIN}}}
+			resp, err := blocker.ServeDNS(context.Background(), query)
+			be.NilErr(t, err)
+			be.Equal(t, tc.wantRCode, resp.Header.RCode())
+			if tc.wantData == nil {
+				be.Equal(t, 0, len(resp.Answers))
+			} else {
+				be.Equal(t, 1, len(resp.Answers))
+				be.Equal(t, string(tc.wantData), string(resp.Answers[0].Data))
+			}
+			be.Equal(t, uint64(1), blocker.Queries())
+			be.Equal(t, tc.name == "ads.example.com", blocker.Blocked() == 1)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+const (
+	// dohContentType is the media type of DNS messages sent over HTTPS:
+	// https://datatracker.ietf.org/doc/html/rfc8484#section-6
+	dohContentType = "application/dns-message"
+
+	// jsonContentType is the media type of responses to JSON API queries.
+	jsonContentType = "application/dns-json"
+
+	maxMessageSize = 65535
+)
+
+// HTTPHandler returns an http.Handler that answers DNS-over-HTTPS queries
+// with the server's Handler, for registering with an http.ServeMux at a
+// path such as "/dns-query". Queries are read from the "dns" parameter of
+// GET requests or the body of POST requests, as described by RFC 8484.
+// Responses are never truncated, since there is no need to retry over TCP.
+//
+// Queries may also be made with the "name" and, optionally, "type"
+// parameters of GET requests, in which case the response is encoded as
+// JSON, like the JSON APIs of some public resolvers, but in the format of
+// dnstoy.Message's MarshalJSON.
+//
+// The handler does not terminate TLS, so it is served over plain HTTP
+// unless it is used with http.Server's ListenAndServeTLS or behind a proxy
+// that terminates TLS. Its requests are not tracked by Shutdown: they are
+// governed by the http.Server that serves them.
+// https://datatracker.ietf.org/doc/html/rfc8484
+func (s *Server) HTTPHandler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var packet []byte
+	var err error
+	asJSON := false
+	switch r.Method {
+	case http.MethodGet:
+		params := r.URL.Query()
+		if name := params.Get("name"); name != "" {
+			packet, err = jsonQuery(name, params.Get("type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			asJSON = true
+			break
+		}
+		packet, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(params.Get("dns"), "="))
+		if err != nil || len(packet) == 0 {
+			http.Error(w, "missing or invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dohContentType {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		packet, err = io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(packet) > maxMessageSize {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, resp, ok := s.respond(r.Context(), httpRemoteAddr(r), packet)
+	if !ok {
+		http.Error(w, "invalid DNS query", http.StatusBadRequest)
+		return
+	}
+	body := resp.Encode()
+	w.Header().Set("Content-Type", dohContentType)
+	if asJSON {
+		if body, err = json.Marshal(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+	}
+	if ttl, ok := minTTL(resp); ok {
+		// https://datatracker.ietf.org/doc/html/rfc8484#section-5.1
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+	}
+	w.Write(body)
+}
+
+// jsonQuery encodes a query for the given name and type, which defaults to
+// A.
+func jsonQuery(name, typeName string) ([]byte, error) {
+	if _, err := dnstoy.EncodeName(name); err != nil {
+		return nil, err
+	}
+	recordType := dnstoy.RecordTypeA
+	if typeName != "" {
+		var err error
+		if recordType, err = dnstoy.ParseRecordType(typeName); err != nil {
+			return nil, err
+		}
+	}
+	query := dnstoy.NewQuery(name, recordType)
+	query.Header.SetRD(true)
+	return query.Encode(), nil
+}
+
+// httpRemoteAddr returns the address of the client that made the request.
+func httpRemoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+// minTTL returns the smallest TTL of the records in the response, which is
+// how long the response may be cached, or false if there are none.
+func minTTL(resp dnstoy.Message) (uint32, bool) {
+	var ttl uint32
+	found := false
+	for _, section := range [][]dnstoy.Record{resp.Answers, resp.Authorities, resp.Additionals} {
+		for _, rec := range section {
+			if rec.Type == dnstoy.RecordTypeOPT {
+				continue
+			}
+			if !found || rec.TTL < ttl {
+				ttl, found = rec.TTL, true
+			}
+		}
+	}
+	return ttl, found
+}
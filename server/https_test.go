@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+func startHTTPServer(t *testing.T) string {
+	t.Helper()
+	s := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+			rec, err := dnstoy.NewA(string(query.Questions[0].Name), 300, net.IPv4(192, 0, 2, 1))
+			short := rec
+			short.TTL = 30
+			return dnstoy.Message{Answers: []dnstoy.Record{rec, short}}, err
+		}),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	srv := httptest.NewServer(s.HTTPHandler())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestHTTPHandler(t *testing.T) {
+	t.Parallel()
+
+	url := startHTTPServer(t)
+	query := newQuery("www.example.com")
+	query.Header.ID = 0
+	packet := query.Encode()
+
+	testCases := map[string]func() (*http.Response, error){
+		"GET": func() (*http.Response, error) {
+			return http.Get(url + "?dns=" + base64.RawURLEncoding.EncodeToString(packet))
+		},
+		"POST": func() (*http.Response, error) {
+			return http.Post(url, dohContentType, bytes.NewReader(packet))
+		},
+	}
+	for name, do := range testCases {
+		do := do
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			resp, err := do()
+			be.NilErr(t, err)
+			defer resp.Body.Close()
+			be.Equal(t, http.StatusOK, resp.StatusCode)
+			be.Equal(t, dohContentType, resp.Header.Get("Content-Type"))
+			be.Equal(t, "max-age=30", resp.Header.Get("Cache-Control"))
+			body, err := io.ReadAll(resp.Body)
+			be.NilErr(t, err)
+			msg, err := dnstoy.ParseMessage(body, dnstoy.ParseStrict)
+			be.NilErr(t, err)
+			be.Equal(t, uint16(0), msg.Header.ID)
+			be.True(t, msg.Header.QR())
+			be.Equal(t, 2, len(msg.Answers))
+		})
+	}
+}
+
+func TestHTTPHandlerJSON(t *testing.T) {
+	t.Parallel()
+
+	url := startHTTPServer(t)
+	resp, err := http.Get(url + "?name=www.example.com&type=A")
+	be.NilErr(t, err)
+	defer resp.Body.Close()
+	be.Equal(t, http.StatusOK, resp.StatusCode)
+	be.Equal(t, jsonContentType, resp.Header.Get("Content-Type"))
+	var msg dnstoy.Message
+	be.NilErr(t, json.NewDecoder(resp.Body).Decode(&msg))
+	be.Equal(t, "www.example.com", string(msg.Questions[0].Name))
+	be.Equal(t, 2, len(msg.Answers))
+}
+
+func TestHTTPHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	url := startHTTPServer(t)
+	testCases := map[string]struct {
+		method      string
+		query       string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		"missing dns parameter": {method: http.MethodGet, wantStatus: http.StatusBadRequest},
+		"invalid dns parameter": {method: http.MethodGet, query: "?dns=!!!", wantStatus: http.StatusBadRequest},
+		"truncated query":       {method: http.MethodGet, query: "?dns=AAAA", wantStatus: http.StatusBadRequest},
+		"invalid name":          {method: http.MethodGet, query: "?name=bad..name", wantStatus: http.StatusBadRequest},
+		"invalid type":          {method: http.MethodGet, query: "?name=example.com&type=BOGUS", wantStatus: http.StatusBadRequest},
+		"wrong content type":    {method: http.MethodPost, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		"wrong method":          {method: http.MethodPut, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(tc.method, url+tc.query, bytes.NewReader(tc.body))
+			be.NilErr(t, err)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			be.NilErr(t, err)
+			resp.Body.Close()
+			be.Equal(t, tc.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,726 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// mDNS parameters:
+// https://datatracker.ietf.org/doc/html/rfc6762
+const (
+	mdnsPort = 5353
+
+	// mdnsHostTTL is the TTL of records containing a host name, and
+	// mdnsTTL that of other records:
+	// https://datatracker.ietf.org/doc/html/rfc6762#section-10
+	mdnsHostTTL = 120
+	mdnsTTL     = 4500
+
+	// legacyTTL caps the TTLs in responses to queries from resolvers that
+	// are not mDNS queriers:
+	// https://datatracker.ietf.org/doc/html/rfc6762#section-6.7
+	legacyTTL = 10
+
+	// cacheFlushBit is the top bit of the class of a record, which is set in
+	// responses for records that are unique to the responder, and of the
+	// class of a question, which is set to request a unicast response.
+	// https://datatracker.ietf.org/doc/html/rfc6762#section-10.2
+	cacheFlushBit = 0x8000
+
+	// typeANY is the question type matching records of every type.
+	typeANY dnstoy.RecordType = 255
+
+	probeCount       = 3
+	announceCount    = 2
+	probeInterval    = 250 * time.Millisecond
+	announceInterval = time.Second
+
+	// tiebreakDelay is how long to wait before probing again after losing
+	// a simultaneous probe tiebreak:
+	// https://datatracker.ietf.org/doc/html/rfc6762#section-8.2
+	tiebreakDelay = time.Second
+
+	// maxConflicts bounds the number of times names are changed to resolve
+	// conflicts, after which advertising fails.
+	maxConflicts = 15
+
+	// servicesName is the name enumerating the types of the services
+	// advertised on the network:
+	// https://datatracker.ietf.org/doc/html/rfc6763#section-9
+	servicesName = "_services._dns-sd._udp.local"
+)
+
+// MDNSGroup is the IPv4 multicast group on which mDNS messages are sent.
+var MDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// ErrNameConflict is returned by a Responder that cannot find names that are
+// not already in use on the network.
+var ErrNameConflict = errors.New("mdns: too many name conflicts")
+
+// Service is a DNS-SD service instance advertised by a Responder:
+// https://datatracker.ietf.org/doc/html/rfc6763
+type Service struct {
+	// Instance is the name of the service instance shown to users, e.g.
+	// "Living Room Printer", which may not contain dots.
+	Instance string
+
+	// Type is the service type and protocol, e.g. "_ipp._tcp".
+	Type string
+
+	Port uint16
+
+	// Text holds the "key=value" pairs published in the instance's TXT
+	// record.
+	Text []string
+}
+
+// Responder advertises a host name and services on the local network with
+// multicast DNS, answering the queries for their records.
+//
+// Before answering, it probes the network to make sure that no other host
+// uses the same names. If one does, the names are changed, by appending
+// "-2" to the host name or " (2)" to a service's instance name, and so on,
+// and probing starts over. Once the names are claimed they are announced,
+// and they are defended for as long as the responder runs; when it stops,
+// it announces that its records are no longer valid.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-8
+type Responder struct {
+	// Hostname is the name of the host, without the ".local" domain.
+	// Defaults to the first label of the system's host name.
+	Hostname string
+
+	// Addrs are the addresses published for the host name. When listening
+	// on an interface, they default to the interface's addresses.
+	Addrs []netip.Addr
+
+	Services []Service
+
+	// OnRename, if set, is called when a name is changed to resolve a
+	// conflict, with the old and new names.
+	OnRename func(old, new string)
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	// probeInterval and announceInterval override the intervals between
+	// probes and announcements, for tests.
+	probeInterval    time.Duration
+	announceInterval time.Duration
+}
+
+// ListenAndAdvertise joins the IPv4 mDNS group on the given interface, or
+// on the system's default multicast interface if iface is nil, and
+// advertises the responder's names on it until ctx is done.
+func (r *Responder) ListenAndAdvertise(ctx context.Context, iface *net.Interface) error {
+	conn, err := net.ListenMulticastUDP("udp4", iface, MDNSGroup)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(r.Addrs) == 0 {
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			return err
+		}
+		rr := *r
+		rr.Addrs = addrs
+		r = &rr
+	}
+	return r.Advertise(ctx, conn, MDNSGroup)
+}
+
+// interfaceAddrs returns the unicast addresses of the given interface, or of
+// every interface if it is nil, excluding loopback addresses.
+func interfaceAddrs(iface *net.Interface) ([]netip.Addr, error) {
+	var addrs []net.Addr
+	var err error
+	if iface != nil {
+		addrs, err = iface.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var found []netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok || addr.IsLoopback() || addr.IsMulticast() {
+			continue
+		}
+		found = append(found, addr.Unmap())
+	}
+	if len(found) == 0 {
+		return nil, errors.New("mdns: no addresses to advertise")
+	}
+	return found, nil
+}
+
+// Advertise advertises the responder's names with messages sent to the
+// given multicast group over conn, which must also receive the messages
+// sent to the group, until ctx is done. It returns nil once ctx is done, or
+// ErrNameConflict if no free names could be found. The connection is not
+// closed.
+func (r *Responder) Advertise(ctx context.Context, conn net.PacketConn, group net.Addr) error {
+	a, err := r.newAdvertiser(conn)
+	if err != nil {
+		return err
+	}
+
+	type packet struct {
+		data []byte
+		addr net.Addr
+	}
+	packets := make(chan packet)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.SetReadDeadline(time.Now())
+	}()
+	go func() {
+		buf := make([]byte, 9000) // the largest mDNS message
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case packets <- packet{append([]byte(nil), buf[:n]...), addr}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// the first probe is delayed randomly, so that hosts starting at the
+	// same time do not probe at the same time
+	// https://datatracker.ietf.org/doc/html/rfc6762#section-8.1
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(r.probeWait()))))
+	defer timer.Stop()
+	state, sent := stateProbing, 0
+	for {
+		select {
+		case <-ctx.Done():
+			if state != stateProbing {
+				a.send(a.announcement(0), group)
+			}
+			return nil
+		case err := <-readErr:
+			return err
+		case p := <-packets:
+			msg, err := dnstoy.ParseMessage(p.data, dnstoy.ParseStrict)
+			if err != nil || msg.Header.Opcode() != dnstoy.OpcodeQuery || msg.Header.RCode() != dnstoy.RCodeNoError {
+				// https://datatracker.ietf.org/doc/html/rfc6762#section-18.3
+				continue
+			}
+			switch {
+			case state == stateProbing && msg.Header.QR():
+				if names := a.conflicts(msg, true); len(names) > 0 {
+					if err := a.rename(names); err != nil {
+						return err
+					}
+					sent = 0
+					resetTimer(timer, r.probeWait())
+				}
+			case state == stateProbing:
+				if a.lostTiebreak(msg) {
+					a.logger.Debug("lost simultaneous probe tiebreak", slog.String("from", p.addr.String()))
+					sent = 0
+					resetTimer(timer, tiebreakDelay)
+				}
+			case msg.Header.QR():
+				if names := a.conflicts(msg, false); len(names) > 0 {
+					// probe again to find out whether the names are
+					// still ours
+					// https://datatracker.ietf.org/doc/html/rfc6762#section-9
+					a.logger.Info("conflicting records received", slog.String("from", p.addr.String()), slog.Any("names", names))
+					state, sent = stateProbing, 0
+					resetTimer(timer, 0)
+				}
+			default:
+				if resp, to, ok := a.answer(msg, p.addr, group); ok {
+					a.send(resp, to)
+				}
+			}
+		case <-timer.C:
+			if state == stateProbing && sent == probeCount {
+				a.logger.Info("advertising mDNS names", slog.Any("names", a.uniqueNames()))
+				state, sent = stateAnnouncing, 0
+			}
+			switch state {
+			case stateProbing:
+				a.send(a.probe(sent == 0), group)
+				sent++
+				timer.Reset(r.probeWait())
+			case stateAnnouncing:
+				a.send(a.announcement(-1), group)
+				sent++
+				if sent < announceCount {
+					timer.Reset(r.announceWait())
+				} else {
+					state = stateAnnounced
+				}
+			}
+		}
+	}
+}
+
+type advertiseState int
+
+const (
+	stateProbing advertiseState = iota
+	stateAnnouncing
+	stateAnnounced
+)
+
+func (r *Responder) probeWait() time.Duration {
+	if r.probeInterval > 0 {
+		return r.probeInterval
+	}
+	return probeInterval
+}
+
+func (r *Responder) announceWait() time.Duration {
+	if r.announceInterval > 0 {
+		return r.announceInterval
+	}
+	return announceInterval
+}
+
+// resetTimer resets a timer that may have fired without being received
+// from.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// advertiser holds the state of a responder advertising its names,
+// including the names chosen to resolve conflicts.
+type advertiser struct {
+	r      *Responder
+	conn   net.PacketConn
+	logger *slog.Logger
+
+	host           string
+	hostSuffix     int
+	instanceSuffix []int
+	conflictCount  int
+	records        []dnstoy.Record
+}
+
+func (r *Responder) newAdvertiser(conn net.PacketConn) (*advertiser, error) {
+	a := &advertiser{r: r, conn: conn, logger: r.Logger, host: r.Hostname}
+	if a.logger == nil {
+		a.logger = slog.Default()
+	}
+	if a.host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		a.host, _, _ = strings.Cut(hostname, ".")
+	}
+	for _, svc := range r.Services {
+		if strings.Contains(svc.Instance, ".") {
+			return nil, fmt.Errorf("mdns: instance name %q contains a dot", svc.Instance)
+		}
+		a.instanceSuffix = append(a.instanceSuffix, 1)
+	}
+	a.hostSuffix = 1
+	if err := a.build(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// hostName returns the current host name.
+func (a *advertiser) hostName() string {
+	if a.hostSuffix > 1 {
+		return fmt.Sprintf("%s-%d.local", a.host, a.hostSuffix)
+	}
+	return a.host + ".local"
+}
+
+// instanceName returns the current name of the i'th service instance.
+func (a *advertiser) instanceName(i int) string {
+	svc := a.r.Services[i]
+	instance := svc.Instance
+	if a.instanceSuffix[i] > 1 {
+		instance = fmt.Sprintf("%s (%d)", instance, a.instanceSuffix[i])
+	}
+	return instance + "." + strings.Trim(svc.Type, ".") + ".local"
+}
+
+// build builds the records to advertise with the current names.
+func (a *advertiser) build() error {
+	var records []dnstoy.Record
+	add := func(rec dnstoy.Record, err error) error {
+		if err != nil {
+			return fmt.Errorf("mdns: %w", err)
+		}
+		records = append(records, rec)
+		return nil
+	}
+	host := a.hostName()
+	for _, addr := range a.r.Addrs {
+		var err error
+		if addr.Is4() || addr.Is4In6() {
+			err = add(dnstoy.NewA(host, mdnsHostTTL, addr.Unmap().AsSlice()))
+		} else {
+			err = add(dnstoy.NewAAAA(host, mdnsHostTTL, addr.AsSlice()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i, svc := range a.r.Services {
+		serviceName := strings.Trim(svc.Type, ".") + ".local"
+		instance := a.instanceName(i)
+		text := svc.Text
+		if len(text) == 0 {
+			// https://datatracker.ietf.org/doc/html/rfc6763#section-6.1
+			text = []string{""}
+		}
+		for _, err := range []error{
+			add(dnstoy.NewPTR(servicesName, mdnsTTL, serviceName)),
+			add(dnstoy.NewPTR(serviceName, mdnsTTL, instance)),
+			add(dnstoy.NewSRV(instance, mdnsHostTTL, dnstoy.SRV{Port: svc.Port, Target: host})),
+			add(dnstoy.NewTXT(instance, mdnsTTL, text...)),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	a.records = records
+	return nil
+}
+
+// isUnique returns true for the records that no other host may publish, as
+// opposed to the PTR records shared by the instances of a service type.
+func isUnique(rec dnstoy.Record) bool {
+	return rec.Type != dnstoy.RecordTypePTR
+}
+
+// uniqueNames returns the names of the unique records.
+func (a *advertiser) uniqueNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, rec := range a.records {
+		name := canonicalName(string(rec.Name))
+		if isUnique(rec) && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// owns returns true if the given record is one of ours.
+func (a *advertiser) owns(rec dnstoy.Record) bool {
+	for _, ours := range a.records {
+		if sameRecord(rec, ours) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameRecord(a, b dnstoy.Record) bool {
+	return a.Type == b.Type &&
+		a.Class&^cacheFlushBit == b.Class&^cacheFlushBit &&
+		canonicalName(string(a.Name)) == canonicalName(string(b.Name)) &&
+		bytes.Equal(a.Data, b.Data)
+}
+
+// conflicts returns the names of our unique records for which the response
+// has records that are not ours. While probing, records of any type
+// conflict; afterwards, only records of the same type do.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-9
+func (a *advertiser) conflicts(resp dnstoy.Message, anyType bool) []string {
+	var names []string
+	for _, rec := range append(append([]dnstoy.Record(nil), resp.Answers...), resp.Additionals...) {
+		if a.owns(rec) {
+			continue
+		}
+		name := canonicalName(string(rec.Name))
+		for _, ours := range a.records {
+			if !isUnique(ours) || canonicalName(string(ours.Name)) != name {
+				continue
+			}
+			if anyType || (ours.Type == rec.Type && ours.Class == rec.Class&^cacheFlushBit) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// rename changes the conflicting names.
+func (a *advertiser) rename(names []string) error {
+	a.conflictCount++
+	if a.conflictCount > maxConflicts {
+		return ErrNameConflict
+	}
+	conflicting := make(map[string]bool)
+	for _, name := range names {
+		conflicting[name] = true
+	}
+	if old := a.hostName(); conflicting[canonicalName(old)] {
+		a.hostSuffix++
+		a.renamed(old, a.hostName())
+	}
+	for i := range a.instanceSuffix {
+		if old := a.instanceName(i); conflicting[canonicalName(old)] {
+			a.instanceSuffix[i]++
+			a.renamed(old, a.instanceName(i))
+		}
+	}
+	return a.build()
+}
+
+func (a *advertiser) renamed(old, new string) {
+	a.logger.Info("mDNS name conflict", slog.String("old", old), slog.String("new", new))
+	if a.r.OnRename != nil {
+		a.r.OnRename(old, new)
+	}
+}
+
+// lostTiebreak returns true if the query is a probe from another host for
+// one of our names whose proposed records are lexicographically later than
+// ours, in which case that host wins the name.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-8.2
+func (a *advertiser) lostTiebreak(query dnstoy.Message) bool {
+	for _, name := range a.uniqueNames() {
+		var ours, theirs []dnstoy.Record
+		for _, rec := range a.records {
+			if canonicalName(string(rec.Name)) == name {
+				ours = append(ours, rec)
+			}
+		}
+		for _, rec := range query.Authorities {
+			if canonicalName(string(rec.Name)) == name {
+				theirs = append(theirs, rec)
+			}
+		}
+		if len(theirs) > 0 && compareRecordSets(ours, theirs) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compareRecordSets compares two sets of records lexicographically, by
+// class, type and data, after sorting them in the same order.
+func compareRecordSets(a, b []dnstoy.Record) int {
+	sortRecords(a)
+	sortRecords(b)
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareRecords(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func sortRecords(records []dnstoy.Record) {
+	sort.Slice(records, func(i, j int) bool { return compareRecords(records[i], records[j]) < 0 })
+}
+
+func compareRecords(a, b dnstoy.Record) int {
+	switch ac, bc := a.Class&^cacheFlushBit, b.Class&^cacheFlushBit; {
+	case ac != bc:
+		if ac < bc {
+			return -1
+		}
+		return 1
+	case a.Type != b.Type:
+		if a.Type < b.Type {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a.Data, b.Data)
+}
+
+// probe returns a probe for our unique names, with the records we propose
+// in its authority section. The first probe requests unicast responses.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-8.1
+func (a *advertiser) probe(first bool) dnstoy.Message {
+	var msg dnstoy.Message
+	class := dnstoy.ResourceClassIN
+	if first {
+		class |= cacheFlushBit
+	}
+	for _, name := range a.uniqueNames() {
+		msg.Questions = append(msg.Questions, dnstoy.Question{Name: []byte(name), Type: typeANY, Class: class})
+	}
+	for _, rec := range a.records {
+		if isUnique(rec) {
+			msg.Authorities = append(msg.Authorities, rec)
+		}
+	}
+	return msg
+}
+
+// announcement returns an unsolicited response with all of our records,
+// with the given TTL if it is not negative, which is 0 to announce that the
+// records are no longer valid.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-8.3
+// https://datatracker.ietf.org/doc/html/rfc6762#section-10.1
+func (a *advertiser) announcement(ttl int) dnstoy.Message {
+	var msg dnstoy.Message
+	msg.Header.SetQR(true)
+	msg.Header.SetAA(true)
+	for _, rec := range a.records {
+		if ttl >= 0 {
+			rec.TTL = uint32(ttl)
+		}
+		msg.Answers = append(msg.Answers, multicastRecord(rec))
+	}
+	return msg
+}
+
+// multicastRecord returns the record as sent in multicast responses, with
+// the cache-flush bit set if it is unique.
+func multicastRecord(rec dnstoy.Record) dnstoy.Record {
+	if isUnique(rec) {
+		rec.Class |= cacheFlushBit
+	}
+	return rec
+}
+
+// answer returns the response to a query, if we have any records that
+// answer it, along with the address to send it to.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-6
+func (a *advertiser) answer(query dnstoy.Message, from, group net.Addr) (dnstoy.Message, net.Addr, bool) {
+	udpAddr, _ := from.(*net.UDPAddr)
+	legacy := udpAddr != nil && udpAddr.Port != mdnsPort
+	unicast := legacy
+
+	var resp dnstoy.Message
+	resp.Header.SetQR(true)
+	resp.Header.SetAA(true)
+	if legacy {
+		resp.Header.ID = query.Header.ID
+		resp.Questions = query.Questions
+	}
+	included := func(rec dnstoy.Record) bool {
+		for _, section := range [][]dnstoy.Record{resp.Answers, resp.Additionals} {
+			for _, r := range section {
+				if sameRecord(r, rec) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	add := func(section *[]dnstoy.Record, rec dnstoy.Record) {
+		if included(rec) {
+			return
+		}
+		if legacy && rec.TTL > legacyTTL {
+			rec.TTL = legacyTTL
+		} else if !legacy {
+			rec = multicastRecord(rec)
+		}
+		*section = append(*section, rec)
+	}
+
+	allQU := len(query.Questions) > 0
+	for _, q := range query.Questions {
+		if q.Class&cacheFlushBit == 0 {
+			allQU = false
+		}
+		class := q.Class &^ cacheFlushBit
+		if class != dnstoy.ResourceClassIN && class != dnstoy.ResourceClass(typeANY) {
+			continue
+		}
+		name := canonicalName(string(q.Name))
+		for _, rec := range a.records {
+			if canonicalName(string(rec.Name)) != name || (q.Type != typeANY && q.Type != rec.Type) {
+				continue
+			}
+			if knownAnswer(query, rec) {
+				continue
+			}
+			add(&resp.Answers, rec)
+		}
+	}
+	if len(resp.Answers) == 0 {
+		return resp, nil, false
+	}
+
+	// include the records the querier is likely to ask for next
+	// https://datatracker.ietf.org/doc/html/rfc6763#section-12
+	for _, ans := range resp.Answers {
+		var names []string
+		switch ans.Type {
+		case dnstoy.RecordTypePTR:
+			names = []string{canonicalName(string(ans.Data)), canonicalName(a.hostName())}
+		case dnstoy.RecordTypeSRV:
+			names = []string{canonicalName(a.hostName())}
+		}
+		for _, name := range names {
+			for _, rec := range a.records {
+				if canonicalName(string(rec.Name)) == name && rec.Type != dnstoy.RecordTypePTR {
+					add(&resp.Additionals, rec)
+				}
+			}
+		}
+	}
+
+	if unicast || allQU {
+		return resp, from, true
+	}
+	return resp, group, true
+}
+
+// knownAnswer returns true if the query lists the record among the answers
+// it already knows, with at least half of its TTL remaining.
+// https://datatracker.ietf.org/doc/html/rfc6762#section-7.1
+func knownAnswer(query dnstoy.Message, rec dnstoy.Record) bool {
+	for _, known := range query.Answers {
+		if sameRecord(known, rec) && known.TTL >= rec.TTL/2 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *advertiser) send(msg dnstoy.Message, to net.Addr) {
+	if _, err := a.conn.WriteTo(msg.Encode(), to); err != nil {
+		a.logger.Warn("failed to send mDNS message", slog.String("to", to.String()), slog.String("err", err.Error()))
+	}
+}
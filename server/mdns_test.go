@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// startResponder starts advertising with the given responder on a local
+// port, with the messages it multicasts sent to the returned connection,
+// which stands in for the rest of the network. It returns the address of
+// the responder and a function that stops it, returning its error.
+func startResponder(t *testing.T, r *Responder) (net.PacketConn, net.Addr, func() error) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { conn.Close() })
+	network, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	be.NilErr(t, err)
+	t.Cleanup(func() { network.Close() })
+
+	r.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	r.probeInterval = 10 * time.Millisecond
+	r.announceInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- r.Advertise(ctx, conn, network.LocalAddr()) }()
+	var once sync.Once
+	var advertiseErr error
+	stop := func() error {
+		once.Do(func() {
+			cancel()
+			advertiseErr = <-errs
+		})
+		return advertiseErr
+	}
+	t.Cleanup(func() { stop() })
+	return network, conn.LocalAddr(), stop
+}
+
+// readMDNS reads the next message sent to the network.
+func readMDNS(t *testing.T, network net.PacketConn) dnstoy.Message {
+	t.Helper()
+	network.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 9000)
+	n, _, err := network.ReadFrom(buf)
+	be.NilErr(t, err)
+	msg, err := dnstoy.ParseMessage(buf[:n], dnstoy.ParseStrict)
+	be.NilErr(t, err)
+	return msg
+}
+
+func testResponder() *Responder {
+	return &Responder{
+		Hostname: "pi",
+		Addrs:    []netip.Addr{netip.MustParseAddr("192.168.1.10")},
+		Services: []Service{{Instance: "Home Lab", Type: "_http._tcp", Port: 8080, Text: []string{"path=/"}}},
+	}
+}
+
+func TestResponder(t *testing.T) {
+	t.Parallel()
+
+	network, addr, stop := startResponder(t, testResponder())
+
+	for i := 0; i < probeCount; i++ {
+		probe := readMDNS(t, network)
+		be.False(t, probe.Header.QR())
+		be.Equal(t, 2, len(probe.Questions)) // the host and instance names
+		be.Equal(t, typeANY, probe.Questions[0].Type)
+		be.Equal(t, i == 0, probe.Questions[0].Class&cacheFlushBit != 0)
+		be.Equal(t, 3, len(probe.Authorities)) // A, SRV and TXT
+	}
+	for i := 0; i < announceCount; i++ {
+		announcement := readMDNS(t, network)
+		be.True(t, announcement.Header.QR())
+		be.True(t, announcement.Header.AA())
+		be.Equal(t, 5, len(announcement.Answers))
+		for _, rec := range announcement.Answers {
+			be.Equal(t, rec.Type != dnstoy.RecordTypePTR, rec.Class&cacheFlushBit != 0)
+		}
+	}
+
+	// a query from a port other than 5353 is answered directly, like a
+	// conventional DNS query
+	query := dnstoy.NewQuery("_http._tcp.local", dnstoy.RecordTypePTR)
+	_, err := network.WriteTo(query.Encode(), addr)
+	be.NilErr(t, err)
+	resp := readMDNS(t, network)
+	be.Equal(t, query.Header.ID, resp.Header.ID)
+	be.Equal(t, 1, len(resp.Questions))
+	be.Equal(t, 1, len(resp.Answers))
+	be.Equal(t, "_http._tcp.local.\t10\tIN\tPTR\tHome Lab._http._tcp.local.", resp.Answers[0].String())
+	var additionals []string
+	for _, rec := range resp.Additionals {
+		additionals = append(additionals, rec.String())
+	}
+	be.AllEqual(t, []string{
+		"Home Lab._http._tcp.local.\t10\tIN\tSRV\t0 0 8080 pi.local.",
+		"Home Lab._http._tcp.local.\t10\tIN\tTXT\t\"path=/\"",
+		"pi.local.\t10\tIN\tA\t192.168.1.10",
+	}, additionals)
+
+	// stopping sends a goodbye
+	be.NilErr(t, stop())
+	goodbye := readMDNS(t, network)
+	be.Equal(t, 5, len(goodbye.Answers))
+	for _, rec := range goodbye.Answers {
+		be.Equal(t, uint32(0), rec.TTL)
+	}
+}
+
+func TestResponderConflict(t *testing.T) {
+	t.Parallel()
+
+	renames := make(chan [2]string, 2)
+	r := testResponder()
+	r.OnRename = func(old, new string) { renames <- [2]string{old, new} }
+	network, addr, _ := startResponder(t, r)
+
+	// another host answers the first probe with its own address for the
+	// host name
+	probe := readMDNS(t, network)
+	be.Equal(t, "pi.local", string(probe.Questions[0].Name))
+	var resp dnstoy.Message
+	resp.Header.SetQR(true)
+	rec, err := dnstoy.NewA("pi.local", 120, net.IPv4(192, 168, 1, 20))
+	be.NilErr(t, err)
+	resp.Answers = append(resp.Answers, rec)
+	_, err = network.WriteTo(resp.Encode(), addr)
+	be.NilErr(t, err)
+
+	be.Equal(t, [2]string{"pi.local", "pi-2.local"}, <-renames)
+	for {
+		probe := readMDNS(t, network)
+		if probe.Header.QR() {
+			t.Fatal("announced before probing the new name")
+		}
+		if string(probe.Questions[0].Name) == "pi-2.local" {
+			// the instance name did not conflict
+			be.Equal(t, "home lab._http._tcp.local", string(probe.Questions[1].Name))
+			break
+		}
+	}
+}
+
+func TestResponderTooManyConflicts(t *testing.T) {
+	t.Parallel()
+
+	network, addr, stop := startResponder(t, testResponder())
+
+	// answer every probe with conflicting records, until the responder
+	// gives up
+	for {
+		network.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 9000)
+		n, _, err := network.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		probe, err := dnstoy.ParseMessage(buf[:n], dnstoy.ParseStrict)
+		be.NilErr(t, err)
+		var resp dnstoy.Message
+		resp.Header.SetQR(true)
+		for _, rec := range probe.Authorities {
+			if rec.Type == dnstoy.RecordTypeA {
+				rec.Data = []byte{192, 168, 1, 20}
+				resp.Answers = append(resp.Answers, rec)
+			}
+		}
+		network.WriteTo(resp.Encode(), addr)
+	}
+	be.True(t, errors.Is(stop(), ErrNameConflict))
+}
+
+func TestAdvertiserAnswer(t *testing.T) {
+	t.Parallel()
+
+	r := testResponder()
+	a, err := r.newAdvertiser(nil)
+	be.NilErr(t, err)
+	group := MDNSGroup
+	querier := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 30), Port: mdnsPort}
+	host, err := dnstoy.NewA("pi.local", mdnsHostTTL, net.IPv4(192, 168, 1, 10))
+	be.NilErr(t, err)
+
+	testCases := map[string]struct {
+		questions []dnstoy.Question
+		known     []dnstoy.Record
+		wantOK    bool
+		wantTo    net.Addr
+		wantCount int
+	}{
+		"multicast": {
+			questions: []dnstoy.Question{{Name: []byte("PI.local"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN}},
+			wantOK:    true,
+			wantTo:    group,
+			wantCount: 1,
+		},
+		"unicast requested": {
+			questions: []dnstoy.Question{{Name: []byte("pi.local"), Type: typeANY, Class: dnstoy.ResourceClassIN | cacheFlushBit}},
+			wantOK:    true,
+			wantTo:    querier,
+			wantCount: 1,
+		},
+		"known answer": {
+			questions: []dnstoy.Question{{Name: []byte("pi.local"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN}},
+			known:     []dnstoy.Record{host},
+		},
+		"stale known answer": {
+			questions: []dnstoy.Question{{Name: []byte("pi.local"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN}},
+			known:     []dnstoy.Record{{Name: host.Name, Type: host.Type, Class: host.Class, TTL: 10, Data: host.Data}},
+			wantOK:    true,
+			wantTo:    group,
+			wantCount: 1,
+		},
+		"service types": {
+			questions: []dnstoy.Question{{Name: []byte(servicesName), Type: dnstoy.RecordTypePTR, Class: dnstoy.ResourceClassIN}},
+			wantOK:    true,
+			wantTo:    group,
+			wantCount: 1,
+		},
+		"other name": {
+			questions: []dnstoy.Question{{Name: []byte("other.local"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN}},
+		},
+		"other type": {
+			questions: []dnstoy.Question{{Name: []byte("pi.local"), Type: dnstoy.RecordTypeAAAA, Class: dnstoy.ResourceClassIN}},
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			query := dnstoy.Message{Questions: tc.questions, Answers: tc.known}
+			resp, to, ok := a.answer(query, querier, group)
+			be.Equal(t, tc.wantOK, ok)
+			if !ok {
+				return
+			}
+			be.Equal(t, tc.wantTo, to)
+			be.Equal(t, uint16(0), resp.Header.ID)
+			be.Equal(t, 0, len(resp.Questions))
+			be.Equal(t, tc.wantCount, len(resp.Answers))
+		})
+	}
+}
+
+func TestLostTiebreak(t *testing.T) {
+	t.Parallel()
+
+	a, err := testResponder().newAdvertiser(nil)
+	be.NilErr(t, err)
+
+	probeWith := func(ip net.IP) dnstoy.Message {
+		rec, err := dnstoy.NewA("pi.local", mdnsHostTTL, ip)
+		be.NilErr(t, err)
+		return dnstoy.Message{Authorities: []dnstoy.Record{rec}}
+	}
+	be.True(t, a.lostTiebreak(probeWith(net.IPv4(192, 168, 1, 11))))
+	be.False(t, a.lostTiebreak(probeWith(net.IPv4(192, 168, 1, 9))))
+	be.False(t, a.lostTiebreak(probeWith(net.IPv4(192, 168, 1, 10)))) // our own probe
+	be.False(t, a.lostTiebreak(a.probe(false)))
+}
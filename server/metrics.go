@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// query latency histogram.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics is a Handler that measures the queries answered by the next
+// handler, and an http.Handler that exports its measurements in the
+// Prometheus text format, for serving at /metrics. It also implements
+// dnstoy.Metrics, so that given as a resolver's Opts.Metrics it measures the
+// resolver's cache and upstreams.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+type Metrics struct {
+	Next Handler
+
+	mu              sync.Mutex
+	queries         map[queryLabels]uint64
+	latency         histogram
+	cacheHits       uint64
+	cacheMisses     uint64
+	upstreamQueries map[string]uint64 // by rcode
+	retries         uint64
+	upstreamHealth  map[string]bool
+	counters        []counterFunc
+}
+
+type queryLabels struct {
+	qtype, rcode string
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type counterFunc struct {
+	name, help string
+	value      func() uint64
+}
+
+// Counter exports a counter whose value is given by a function, such as the
+// number of queries blocked by a Blocker. It must be called before the
+// metrics are served.
+func (m *Metrics) Counter(name, help string, value func() uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters = append(m.counters, counterFunc{name: name, help: help, value: value})
+}
+
+// ServeDNS passes the query on to the next handler, counting it by type and
+// rcode and measuring the time taken to answer it.
+func (m *Metrics) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	start := time.Now()
+	resp, err := m.Next.ServeDNS(ctx, query)
+	elapsed := time.Since(start)
+
+	labels := queryLabels{qtype: "none", rcode: resp.Header.RCode().String()}
+	if len(query.Questions) > 0 {
+		labels.qtype = query.Questions[0].Type.String()
+	}
+	if err != nil {
+		labels.rcode = dnstoy.RCodeServFail.String()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queries == nil {
+		m.queries = make(map[queryLabels]uint64)
+	}
+	m.queries[labels]++
+	m.latency.observe(elapsed.Seconds())
+	return resp, err
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	if i := sort.SearchFloat64s(latencyBuckets, v); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveQuery counts the queries sent by the resolver by rcode.
+func (m *Metrics) ObserveQuery(q dnstoy.QueryMetric) {
+	rcode := "error"
+	if q.RCode >= 0 {
+		rcode = dnstoy.RCode(q.RCode).String()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstreamQueries == nil {
+		m.upstreamQueries = make(map[string]uint64)
+	}
+	m.upstreamQueries[rcode]++
+}
+
+// ObserveCacheLookup counts the resolver's cache hits and misses.
+func (m *Metrics) ObserveCacheLookup(recordType dnstoy.RecordType, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// ObserveRetry counts the resolver's retried queries.
+func (m *Metrics) ObserveRetry(nameServer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+// ObserveUpstreamHealth records the latest health of each of the resolver's
+// upstreams.
+func (m *Metrics) ObserveUpstreamHealth(upstream string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstreamHealth == nil {
+		m.upstreamHealth = make(map[string]bool)
+	}
+	m.upstreamHealth[upstream] = healthy
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+// write writes the metrics in the Prometheus text format, with the samples
+// of each metric sorted by their labels.
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "dnstoy_queries_total", "counter", "Queries answered, by query type and response code.")
+	queries := make([]queryLabels, 0, len(m.queries))
+	for labels := range m.queries {
+		queries = append(queries, labels)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].qtype != queries[j].qtype {
+			return queries[i].qtype < queries[j].qtype
+		}
+		return queries[i].rcode < queries[j].rcode
+	})
+	for _, labels := range queries {
+		fmt.Fprintf(w, "dnstoy_queries_total{qtype=%s,rcode=%s} %d\n", quoteLabel(labels.qtype), quoteLabel(labels.rcode), m.queries[labels])
+	}
+
+	writeHeader(w, "dnstoy_query_duration_seconds", "histogram", "Time taken to answer queries.")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		if m.latency.counts != nil {
+			cumulative += m.latency.counts[i]
+		}
+		fmt.Fprintf(w, "dnstoy_query_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "dnstoy_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latency.count)
+	fmt.Fprintf(w, "dnstoy_query_duration_seconds_sum %s\n", formatFloat(m.latency.sum))
+	fmt.Fprintf(w, "dnstoy_query_duration_seconds_count %d\n", m.latency.count)
+
+	writeHeader(w, "dnstoy_cache_lookups_total", "counter", "Lookups in the resolver's cache, by result.")
+	fmt.Fprintf(w, "dnstoy_cache_lookups_total{result=\"hit\"} %d\n", m.cacheHits)
+	fmt.Fprintf(w, "dnstoy_cache_lookups_total{result=\"miss\"} %d\n", m.cacheMisses)
+	writeHeader(w, "dnstoy_cache_hit_ratio", "gauge", "Fraction of the resolver's cache lookups that were hits.")
+	ratio := 0.0
+	if total := m.cacheHits + m.cacheMisses; total > 0 {
+		ratio = float64(m.cacheHits) / float64(total)
+	}
+	fmt.Fprintf(w, "dnstoy_cache_hit_ratio %s\n", formatFloat(ratio))
+
+	writeHeader(w, "dnstoy_upstream_queries_total", "counter", "Queries sent by the resolver to name servers, by response code, or \"error\" if unanswered.")
+	writeSorted(w, "dnstoy_upstream_queries_total", "rcode", m.upstreamQueries, func(n uint64) string { return strconv.FormatUint(n, 10) })
+	writeHeader(w, "dnstoy_upstream_retries_total", "counter", "Queries retried by the resolver after timing out.")
+	fmt.Fprintf(w, "dnstoy_upstream_retries_total %d\n", m.retries)
+	writeHeader(w, "dnstoy_upstream_healthy", "gauge", "Whether each upstream passed its latest health check.")
+	writeSorted(w, "dnstoy_upstream_healthy", "upstream", m.upstreamHealth, func(healthy bool) string {
+		if healthy {
+			return "1"
+		}
+		return "0"
+	})
+
+	for _, c := range m.counters {
+		writeHeader(w, c.name, "counter", c.help)
+		fmt.Fprintf(w, "%s %d\n", c.name, c.value())
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+// writeSorted writes the samples of a metric with a single label, sorted by
+// the label's value.
+func writeSorted[V any](w *bufio.Writer, name, label string, samples map[string]V, format func(V) string) {
+	keys := make([]string, 0, len(samples))
+	for k := range samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%s} %s\n", name, label, quoteLabel(k), format(samples[k]))
+	}
+}
+
+// quoteLabel quotes a label value, escaping backslashes, double quotes and
+// newlines.
+func quoteLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
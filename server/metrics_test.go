@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := &Metrics{Next: HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		var resp dnstoy.Message
+		switch canonicalName(string(query.Questions[0].Name)) {
+		case "missing.example":
+			resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+		case "broken.example":
+			return dnstoy.Message{}, errors.New("oops")
+		}
+		return resp, nil
+	})}
+	for _, q := range []struct {
+		name       string
+		recordType dnstoy.RecordType
+	}{
+		{"www.example", dnstoy.RecordTypeA},
+		{"www.example", dnstoy.RecordTypeA},
+		{"www.example", dnstoy.RecordTypeAAAA},
+		{"missing.example", dnstoy.RecordTypeA},
+		{"broken.example", dnstoy.RecordTypeMX},
+	} {
+		query := dnstoy.Message{Questions: []dnstoy.Question{{Name: []byte(q.name), Type: q.recordType, Class: dnstoy.ResourceClassIN}}}
+		m.ServeDNS(context.Background(), query)
+	}
+
+	// the resolver's measurements
+	var metrics dnstoy.Metrics = m
+	metrics.ObserveCacheLookup(dnstoy.RecordTypeA, true)
+	metrics.ObserveCacheLookup(dnstoy.RecordTypeA, false)
+	metrics.ObserveCacheLookup(dnstoy.RecordTypeA, false)
+	metrics.ObserveCacheLookup(dnstoy.RecordTypeA, false)
+	metrics.ObserveQuery(dnstoy.QueryMetric{RCode: int(dnstoy.RCodeNoError)})
+	metrics.ObserveQuery(dnstoy.QueryMetric{RCode: -1, Err: errors.New("timeout")})
+	metrics.ObserveRetry("192.0.2.53")
+	metrics.ObserveUpstreamHealth("192.0.2.53", true)
+	metrics.ObserveUpstreamHealth("192.0.2.54", true)
+	metrics.ObserveUpstreamHealth("192.0.2.54", false)
+	m.Counter("dnstoy_blocked_queries_total", "Queries blocked.", func() uint64 { return 7 })
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	be.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE dnstoy_queries_total counter",
+		`dnstoy_queries_total{qtype="A",rcode="NOERROR"} 2`,
+		`dnstoy_queries_total{qtype="A",rcode="NXDOMAIN"} 1`,
+		`dnstoy_queries_total{qtype="AAAA",rcode="NOERROR"} 1`,
+		`dnstoy_queries_total{qtype="MX",rcode="SERVFAIL"} 1`,
+		"# TYPE dnstoy_query_duration_seconds histogram",
+		`dnstoy_query_duration_seconds_bucket{le="+Inf"} 5`,
+		"dnstoy_query_duration_seconds_count 5",
+		`dnstoy_cache_lookups_total{result="hit"} 1`,
+		`dnstoy_cache_lookups_total{result="miss"} 3`,
+		"dnstoy_cache_hit_ratio 0.25",
+		`dnstoy_upstream_queries_total{rcode="NOERROR"} 1`,
+		`dnstoy_upstream_queries_total{rcode="error"} 1`,
+		"dnstoy_upstream_retries_total 1",
+		`dnstoy_upstream_healthy{upstream="192.0.2.53"} 1`,
+		`dnstoy_upstream_healthy{upstream="192.0.2.54"} 0`,
+		"# HELP dnstoy_blocked_queries_total Queries blocked.",
+		"dnstoy_blocked_queries_total 7",
+	} {
+		if !lines[want] {
+			t.Errorf("missing line %q in:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	t.Parallel()
+	be.Equal(t, `"a\\b\"c\nd"`, quoteLabel("a\\b\"c\nd"))
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// Notifications is a Handler that accepts NOTIFY messages announcing
+// changes to its zones, as sent by their primary name servers, passing
+// other messages on to the next handler. It is the receiving end of
+// dnstoy.Resolver's Notify.
+// https://datatracker.ietf.org/doc/html/rfc1996
+type Notifications struct {
+	// Zones are the zones for which notifications are accepted. Others are
+	// refused.
+	Zones []string
+
+	// Primaries are the addresses from which notifications are accepted.
+	// If empty, notifications are accepted from any address.
+	Primaries []netip.Addr
+
+	// OnNotify is called with the zone and the address of the sender for
+	// each accepted notification, to trigger a refresh of the zone, e.g. by
+	// checking its SOA serial and transferring it if it has changed. The
+	// notification is acknowledged once it returns, so it should return
+	// quickly, starting any transfer in the background.
+	OnNotify func(zone string, from netip.Addr)
+
+	Next Handler
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// ServeDNS accepts NOTIFY messages, passing other messages on to the next
+// handler.
+func (n *Notifications) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	if query.Header.Opcode() != dnstoy.OpcodeNotify {
+		return n.Next.ServeDNS(ctx, query)
+	}
+
+	var resp dnstoy.Message
+	resp.Header.SetAA(true)
+	if len(query.Questions) != 1 || query.Questions[0].Type != dnstoy.RecordTypeSOA {
+		resp.Header.SetRCode(dnstoy.RCodeFormErr)
+		return resp, nil
+	}
+	from := clientAddr(ctx)
+	zone, ok := n.zone(string(query.Questions[0].Name))
+	if !ok || !n.allowed(from) {
+		n.logger().Warn("refused notification", slog.String("zone", string(query.Questions[0].Name)), slog.String("from", from.String()))
+		resp.Header.SetRCode(dnstoy.RCodeRefused)
+		return resp, nil
+	}
+	n.logger().Info("zone change notified", slog.String("zone", zone), slog.String("from", from.String()))
+	if n.OnNotify != nil {
+		n.OnNotify(zone, from)
+	}
+	return resp, nil
+}
+
+// zone returns the configured zone matching the given name, if any.
+func (n *Notifications) zone(name string) (string, bool) {
+	for _, zone := range n.Zones {
+		if canonicalName(zone) == canonicalName(name) {
+			return zone, true
+		}
+	}
+	return "", false
+}
+
+func (n *Notifications) allowed(from netip.Addr) bool {
+	if len(n.Primaries) == 0 {
+		return true
+	}
+	for _, addr := range n.Primaries {
+		if addr.Unmap() == from {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifications) logger() *slog.Logger {
+	if n.Logger != nil {
+		return n.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestNotifications(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		from       string
+		opcode     dnstoy.Opcode
+		zone       string
+		recordType dnstoy.RecordType
+		wantRCode  dnstoy.RCode
+		wantErr    bool
+		wantNotify bool
+	}{
+		"accepted":         {from: "192.0.2.1", opcode: dnstoy.OpcodeNotify, zone: "Example.Test", recordType: dnstoy.RecordTypeSOA, wantNotify: true},
+		"unknown zone":     {from: "192.0.2.1", opcode: dnstoy.OpcodeNotify, zone: "other.test", recordType: dnstoy.RecordTypeSOA, wantRCode: dnstoy.RCodeRefused},
+		"unknown primary":  {from: "192.0.2.2", opcode: dnstoy.OpcodeNotify, zone: "example.test", recordType: dnstoy.RecordTypeSOA, wantRCode: dnstoy.RCodeRefused},
+		"wrong type":       {from: "192.0.2.1", opcode: dnstoy.OpcodeNotify, zone: "example.test", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeFormErr},
+		"not notification": {from: "192.0.2.1", opcode: dnstoy.OpcodeQuery, zone: "example.test", recordType: dnstoy.RecordTypeSOA, wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var notified []string
+			notifications := &Notifications{
+				Zones:     []string{"example.test."},
+				Primaries: []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+				OnNotify: func(zone string, from netip.Addr) {
+					notified = append(notified, zone+" from "+from.String())
+				},
+				Next: HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+					return dnstoy.Message{}, errors.New("not a notification")
+				}),
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+
+			ctx := context.WithValue(context.Background(), remoteAddrKey{}, &net.UDPAddr{IP: net.ParseIP(tc.from), Port: 53})
+			query := dnstoy.Message{Questions: []dnstoy.Question{{Name: []byte(tc.zone), Type: tc.recordType, Class: dnstoy.ResourceClassIN}}}
+			query.Header.SetOpcode(tc.opcode)
+			resp, err := notifications.ServeDNS(ctx, query)
+			if tc.wantErr {
+				be.True(t, err != nil)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.wantRCode, resp.Header.RCode())
+			be.True(t, resp.Header.AA())
+			if tc.wantNotify {
+				be.AllEqual(t, []string{"example.test. from " + tc.from}, notified)
+			} else {
+				be.Equal(t, 0, len(notified))
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	notified := make(chan string, 1)
+	_, addr := startServer(t, &Notifications{
+		Zones:    []string{"example.test"},
+		OnNotify: func(zone string, from netip.Addr) { notified <- zone },
+		Next:     HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) { return dnstoy.Message{}, nil }),
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	r := dnstoy.New(&dnstoy.Opts{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	t.Cleanup(func() { r.Close() })
+
+	be.NilErr(t, r.Notify(context.Background(), "example.test", netip.MustParseAddrPort(addr)))
+	be.Equal(t, "example.test", <-notified)
+	be.True(t, errors.Is(r.Notify(context.Background(), "other.test", netip.MustParseAddrPort(addr)), dnstoy.ErrRefused))
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// ednsUDPSize is the UDP payload size advertised in responses to queries
+// with an OPT record.
+const ednsUDPSize = 1232
+
+// Recursive returns a Handler that answers queries by resolving them with
+// the given resolver, like a recursive resolver. Only standard queries with
+// a single question are answered.
+func Recursive(r *dnstoy.Resolver) Handler {
+	return HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		var resp dnstoy.Message
+		resp.Header.SetRA(true)
+		for _, rec := range query.Additionals {
+			if rec.Type == dnstoy.RecordTypeOPT {
+				resp.Additionals = append(resp.Additionals, dnstoy.Record{Name: []byte{}, Type: dnstoy.RecordTypeOPT, Class: ednsUDPSize})
+				break
+			}
+		}
+
+		if query.Header.Opcode() != dnstoy.OpcodeQuery {
+			resp.Header.SetRCode(dnstoy.RCodeNotImp)
+			return resp, nil
+		}
+		if len(query.Questions) != 1 {
+			resp.Header.SetRCode(dnstoy.RCodeFormErr)
+			return resp, nil
+		}
+		question := query.Questions[0]
+		switch {
+		case question.Type == dnstoy.RecordTypeOPT:
+			resp.Header.SetRCode(dnstoy.RCodeFormErr)
+			return resp, nil
+		case isQType(question.Type):
+			resp.Header.SetRCode(dnstoy.RCodeNotImp)
+			return resp, nil
+		}
+
+		records, err := r.LookupRecords(ctx, string(question.Name), dnstoy.QueryOpts{Type: question.Type, Class: question.Class})
+		switch {
+		case err == nil:
+			resp.Answers = records
+		case errors.Is(err, dnstoy.ErrNXDomain):
+			resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+		case errors.Is(err, dnstoy.ErrNoData):
+		case errors.Is(err, dnstoy.ErrInvalidName):
+			resp.Header.SetRCode(dnstoy.RCodeFormErr)
+		default:
+			return dnstoy.Message{}, err
+		}
+		return resp, nil
+	})
+}
+
+// isQType returns true for the types that are only valid in questions, such
+// as AXFR and ANY, which cannot be looked up like other types:
+// https://datatracker.ietf.org/doc/html/rfc6895#section-3.1
+func isQType(t dnstoy.RecordType) bool {
+	return 128 <= t && t <= 255
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestRecursive(t *testing.T) {
+	t.Parallel()
+
+	// an upstream that knows a single name with an A record
+	_, upstreamAddr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		var resp dnstoy.Message
+		resp.Header.SetRA(true)
+		question := query.Questions[0]
+		switch {
+		case string(question.Name) != "www.example.com":
+			resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+		case question.Type == dnstoy.RecordTypeA:
+			rec, err := dnstoy.NewA("www.example.com", 60, net.IPv4(192, 0, 2, 1))
+			resp.Answers = append(resp.Answers, rec)
+			return resp, err
+		}
+		return resp, nil
+	}))
+	resolver := dnstoy.New(&dnstoy.Opts{
+		Upstreams:                []string{upstreamAddr},
+		Logger:                   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		DisableCaseRandomization: true,
+	})
+	t.Cleanup(func() { resolver.Close() })
+	_, addr := startServer(t, Recursive(resolver))
+
+	testCases := map[string]struct {
+		name        string
+		recordType  dnstoy.RecordType
+		edns        bool
+		wantRCode   dnstoy.RCode
+		wantAnswers int
+	}{
+		"answer":          {name: "www.example.com", recordType: dnstoy.RecordTypeA, wantAnswers: 1},
+		"answer with OPT": {name: "www.example.com", recordType: dnstoy.RecordTypeA, edns: true, wantAnswers: 1},
+		"no data":         {name: "www.example.com", recordType: dnstoy.RecordTypeAAAA},
+		"nxdomain":        {name: "missing.example.com", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeNXDomain},
+		"ANY":             {name: "www.example.com", recordType: 255, wantRCode: dnstoy.RCodeNotImp},
+		"OPT":             {name: "www.example.com", recordType: dnstoy.RecordTypeOPT, wantRCode: dnstoy.RCodeFormErr},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			query := newQuery(tc.name)
+			query.Question.Type = tc.recordType
+			if tc.edns {
+				query.AddEDNS(1232)
+			}
+			resp := exchange(t, addr, query.Encode())
+			be.Equal(t, tc.wantRCode, resp.Header.RCode())
+			be.True(t, resp.Header.RA())
+			be.Equal(t, tc.wantAnswers, len(resp.Answers))
+			be.Equal(t, tc.edns, len(resp.Additionals) == 1 && resp.Additionals[0].Type == dnstoy.RecordTypeOPT)
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// PolicyZone is a Response Policy Zone: a zone whose records describe how
+// to answer queries for certain names, or whose answers contain certain
+// addresses, as published by threat intelligence feeds.
+// https://datatracker.ietf.org/doc/html/draft-vixie-dnsop-dns-rpz
+type PolicyZone struct {
+	Name string
+
+	names     map[string]policy // triggered by the name and not its subdomains
+	wildcards map[string]policy // triggered by the subdomains of the name
+	addrs     []addrPolicy      // triggered by addresses in answers
+}
+
+// policyAction is what a policy does with a query that triggers it.
+type policyAction int
+
+const (
+	actionNXDomain policyAction = iota
+	actionNoData
+	actionPassthru
+	actionRewrite
+)
+
+type policy struct {
+	action  policyAction
+	records []dnstoy.Record // the local data of rewrites
+}
+
+type addrPolicy struct {
+	prefix netip.Prefix
+	policy policy
+}
+
+// LoadPolicyZone loads and parses the policy zone at the given path. See
+// ParsePolicyZone.
+func LoadPolicyZone(path string) (*PolicyZone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	z, err := ParsePolicyZone(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return z, nil
+}
+
+// ParsePolicyZone parses a policy zone in zone file format, whose name is
+// the owner of its SOA record. Names must be absolute or relative to a
+// $ORIGIN directive.
+//
+// The zone's records are rules whose owner names, relative to the zone,
+// are triggers and whose data are actions. QNAME triggers, such as
+// "bad.example" or "*.bad.example", match the names queried, and Response
+// IP triggers, such as "32.1.2.0.192.rpz-ip" for 192.0.2.1/32, match the
+// addresses in answers. Actions are given by CNAME records:
+//
+//   - "CNAME ." answers with NXDOMAIN
+//   - "CNAME *." answers with no records
+//   - "CNAME rpz-passthru." answers normally, exempting the name from the
+//     rules of later zones
+//
+// Any other records, such as A records or a CNAME to a walled garden, are
+// local data with which the query is answered instead. The other triggers
+// and actions, such as rpz-nsdname and rpz-drop, are not supported, and
+// their rules are ignored.
+func ParsePolicyZone(r io.Reader) (*PolicyZone, error) {
+	records, err := dnstoy.ParseZone(r, "")
+	if err != nil {
+		return nil, err
+	}
+	z := &PolicyZone{
+		names:     make(map[string]policy),
+		wildcards: make(map[string]policy),
+	}
+	for _, rec := range records {
+		if rec.Type == dnstoy.RecordTypeSOA {
+			z.Name = canonicalName(string(rec.Name))
+			break
+		}
+	}
+	if z.Name == "" {
+		return nil, errors.New("policy zone has no SOA record")
+	}
+
+	rules := make(map[string][]dnstoy.Record)
+	var triggers []string
+	for _, rec := range records {
+		owner := canonicalName(string(rec.Name))
+		trigger, ok := strings.CutSuffix(owner, "."+z.Name)
+		if !ok {
+			// the records at the apex, such as the SOA and NS records, and
+			// those outside the zone are not rules
+			continue
+		}
+		if _, seen := rules[trigger]; !seen {
+			triggers = append(triggers, trigger)
+		}
+		rules[trigger] = append(rules[trigger], rec)
+	}
+	for _, trigger := range triggers {
+		p, ok := parsePolicy(rules[trigger])
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(trigger, ".rpz-ip"):
+			if prefix, err := parseRPZPrefix(strings.TrimSuffix(trigger, ".rpz-ip")); err == nil {
+				z.addrs = append(z.addrs, addrPolicy{prefix: prefix, policy: p})
+			}
+		case strings.HasSuffix(trigger, ".rpz-nsdname"), strings.HasSuffix(trigger, ".rpz-nsip"), strings.HasSuffix(trigger, ".rpz-client-ip"):
+		case strings.HasPrefix(trigger, "*."):
+			z.wildcards[strings.TrimPrefix(trigger, "*.")] = p
+		default:
+			z.names[trigger] = p
+		}
+	}
+	return z, nil
+}
+
+// parsePolicy returns the policy described by a rule's records, or false if
+// its action is not supported.
+func parsePolicy(records []dnstoy.Record) (policy, bool) {
+	if len(records) == 1 && records[0].Type == dnstoy.RecordTypeCNAME {
+		switch canonicalName(string(records[0].Data)) {
+		case "":
+			return policy{action: actionNXDomain}, true
+		case "*":
+			return policy{action: actionNoData}, true
+		case "rpz-passthru":
+			return policy{action: actionPassthru}, true
+		case "rpz-drop", "rpz-tcp-only":
+			return policy{}, false
+		}
+	}
+	return policy{action: actionRewrite, records: records}, true
+}
+
+// parseRPZPrefix parses the prefix of a Response IP trigger, which is given
+// as the prefix length followed by the address with its labels reversed,
+// with "zz" standing for the longest run of zeros in IPv6 addresses, e.g.
+// "24.0.2.0.192" for 192.0.2.0/24 or "48.zz.db8.2001" for 2001:db8::/48.
+func parseRPZPrefix(s string) (netip.Prefix, error) {
+	labels := strings.Split(s, ".")
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil || len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("invalid rpz-ip trigger %q", s)
+	}
+	labels = labels[1:]
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	var addr string
+	if len(labels) == 4 && !strings.Contains(s, "zz") {
+		addr = strings.Join(labels, ".")
+	} else {
+		addr = strings.Replace(strings.Join(labels, ":"), "zz", "", 1)
+		if strings.HasPrefix(addr, ":") {
+			addr = ":" + addr
+		}
+		if strings.HasSuffix(addr, ":") {
+			addr += ":"
+		}
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid rpz-ip trigger %q: %w", s, err)
+	}
+	return ip.Prefix(bits)
+}
+
+// Len returns the number of rules in the zone.
+func (z *PolicyZone) Len() int {
+	return len(z.names) + len(z.wildcards) + len(z.addrs)
+}
+
+// nameRule returns the policy triggered by a query for the given name, if
+// any. Exact matches take precedence over wildcards, and more specific
+// wildcards over less specific ones.
+func (z *PolicyZone) nameRule(name string) (policy, bool) {
+	if p, ok := z.names[name]; ok {
+		return p, true
+	}
+	for {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return policy{}, false
+		}
+		name = name[i+1:]
+		if p, ok := z.wildcards[name]; ok {
+			return p, true
+		}
+	}
+}
+
+// addrRule returns the policy triggered by the given address, if any, with
+// longer prefixes taking precedence.
+func (z *PolicyZone) addrRule(addr netip.Addr) (policy, bool) {
+	var found *addrPolicy
+	for i, rule := range z.addrs {
+		if rule.prefix.Contains(addr) && (found == nil || rule.prefix.Bits() > found.prefix.Bits()) {
+			found = &z.addrs[i]
+		}
+	}
+	if found == nil {
+		return policy{}, false
+	}
+	return found.policy, true
+}
+
+// RPZ is a Handler that applies the rules of its policy zones to queries,
+// passing them on to the next handler to be resolved where needed. The
+// first zone with a rule triggered by a query applies; QNAME triggers are
+// checked before the query is resolved, and Response IP triggers after.
+type RPZ struct {
+	Zones []*PolicyZone
+	Next  Handler
+
+	hits atomic.Uint64
+}
+
+// ServeDNS answers the query according to the first rule it triggers, if
+// any.
+func (h *RPZ) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	if len(query.Questions) != 1 {
+		return h.Next.ServeDNS(ctx, query)
+	}
+	question := query.Questions[0]
+	name := canonicalName(string(question.Name))
+	for _, z := range h.Zones {
+		if p, ok := z.nameRule(name); ok {
+			if p.action == actionPassthru {
+				return h.Next.ServeDNS(ctx, query)
+			}
+			h.hits.Add(1)
+			return h.apply(ctx, p, question)
+		}
+	}
+
+	resp, err := h.Next.ServeDNS(ctx, query)
+	if err != nil {
+		return resp, err
+	}
+	for _, rec := range resp.Answers {
+		if rec.Type != dnstoy.RecordTypeA && rec.Type != dnstoy.RecordTypeAAAA {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(rec.Data)
+		if !ok {
+			continue
+		}
+		for _, z := range h.Zones {
+			if p, ok := z.addrRule(addr.Unmap()); ok {
+				if p.action == actionPassthru {
+					return resp, nil
+				}
+				h.hits.Add(1)
+				return h.apply(ctx, p, question)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// apply answers a question according to a policy.
+func (h *RPZ) apply(ctx context.Context, p policy, question dnstoy.Question) (dnstoy.Message, error) {
+	var resp dnstoy.Message
+	resp.Header.SetRA(true)
+	switch p.action {
+	case actionNXDomain:
+		resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+	case actionNoData:
+	case actionRewrite:
+		var cname *dnstoy.Record
+		for _, rec := range p.records {
+			rec.Name = question.Name
+			switch {
+			case rec.Type == question.Type:
+				resp.Answers = append(resp.Answers, rec)
+			case rec.Type == dnstoy.RecordTypeCNAME:
+				c := rec
+				cname = &c
+			}
+		}
+		if len(resp.Answers) > 0 || cname == nil {
+			return resp, nil
+		}
+		// a CNAME to a walled garden, which is resolved as usual; a target
+		// beginning with "*." is relative to the name queried
+		if target, ok := strings.CutPrefix(string(cname.Data), "*."); ok {
+			cname.Data = []byte(strings.TrimSuffix(string(question.Name), ".") + "." + target)
+		}
+		resp.Answers = append(resp.Answers, *cname)
+		target := dnstoy.Message{Questions: []dnstoy.Question{{Name: cname.Data, Type: question.Type, Class: question.Class}}}
+		target.Header.SetRD(true)
+		targetResp, err := h.Next.ServeDNS(ctx, target)
+		if err != nil {
+			return dnstoy.Message{}, err
+		}
+		resp.Answers = append(resp.Answers, targetResp.Answers...)
+		resp.Header.SetRCode(targetResp.Header.RCode())
+	}
+	return resp, nil
+}
+
+// Hits returns the number of queries answered by a rule of one of the
+// zones, rather than resolved normally.
+func (h *RPZ) Hits() uint64 {
+	return h.hits.Load()
+}
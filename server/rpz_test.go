@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+const testPolicyZone = `$ORIGIN rpz.test.
+$TTL 300
+@	SOA	localhost. hostmaster.localhost. 1 3600 600 86400 60
+	NS	localhost.
+bad.example	CNAME	.
+*.bad.example	CNAME	.
+nodata.example	CNAME	*.
+ok.bad.example	CNAME	rpz-passthru.
+moved.example	A	192.0.2.80
+	AAAA	2001:db8::80
+garden.example	CNAME	walled.test.
+mixed.example	CNAME	walled.test.
+	TXT	"hello"
+*.tracker.example	CNAME	*.sinkhole.test.
+dropped.example	CNAME	rpz-drop.
+32.66.113.0.203.rpz-ip	CNAME	.
+24.0.2.0.198.rpz-ip	A	192.0.2.1
+64.zz.db8.2001.rpz-ip	CNAME	*.
+`
+
+func TestParsePolicyZone(t *testing.T) {
+	t.Parallel()
+
+	z, err := ParsePolicyZone(strings.NewReader(testPolicyZone))
+	be.NilErr(t, err)
+	be.Equal(t, "rpz.test", z.Name)
+	be.Equal(t, 11, z.Len()) // the rpz-drop rule is ignored
+
+	_, err = ParsePolicyZone(strings.NewReader("$ORIGIN rpz.test.\nbad CNAME .\n"))
+	be.True(t, err != nil)
+}
+
+func TestParseRPZPrefix(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"32.1.2.0.192":      "192.0.2.1/32",
+		"24.0.2.0.192":      "192.0.2.0/24",
+		"48.zz.db8.2001":    "2001:db8::/48",
+		"128.1.zz.db8.2001": "2001:db8::1/128",
+		"128.1.zz":          "::1/128",
+		"32.1.2.0":          "", // too few labels
+		"bits.0.2.0.192":    "",
+	}
+	for trigger, want := range testCases {
+		trigger, want := trigger, want
+		t.Run(trigger, func(t *testing.T) {
+			t.Parallel()
+			prefix, err := parseRPZPrefix(trigger)
+			if want == "" {
+				be.True(t, err != nil)
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, netip.MustParsePrefix(want), prefix)
+		})
+	}
+}
+
+func TestRPZ(t *testing.T) {
+	t.Parallel()
+
+	z, err := ParsePolicyZone(strings.NewReader(testPolicyZone))
+	be.NilErr(t, err)
+	answers := map[string][]byte{
+		"ok.bad.example":                  {192, 0, 2, 10},
+		"www.example.com":                 {192, 0, 2, 20},
+		"walled.test":                     {192, 0, 2, 30},
+		"x.tracker.example.sinkhole.test": {192, 0, 2, 40},
+		"dropped.example":                 {192, 0, 2, 50},
+		"ads.example.net":                 {203, 0, 113, 66},
+		"cdn.example.net":                 {198, 0, 2, 7},
+	}
+	next := HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		name := query.Questions[0].Name
+		var resp dnstoy.Message
+		if data, ok := answers[canonicalName(string(name))]; ok && query.Questions[0].Type == dnstoy.RecordTypeA {
+			resp.Answers = []dnstoy.Record{{Name: name, Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 60, Data: data}}
+		}
+		return resp, nil
+	})
+
+	testCases := map[string]struct {
+		name       string
+		recordType dnstoy.RecordType
+		wantRCode  dnstoy.RCode
+		wantData   []string
+		wantHit    bool
+	}{
+		"no rule":         {name: "www.example.com", recordType: dnstoy.RecordTypeA, wantData: []string{"192.0.2.20"}},
+		"nxdomain":        {name: "bad.example", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeNXDomain, wantHit: true},
+		"wildcard":        {name: "a.b.BAD.example", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeNXDomain, wantHit: true},
+		"passthru":        {name: "ok.bad.example", recordType: dnstoy.RecordTypeA, wantData: []string{"192.0.2.10"}},
+		"nodata":          {name: "nodata.example", recordType: dnstoy.RecordTypeA, wantHit: true},
+		"rewrite":         {name: "moved.example", recordType: dnstoy.RecordTypeAAAA, wantData: []string{"2001:db8::80"}, wantHit: true},
+		"rewrite no data": {name: "moved.example", recordType: dnstoy.RecordTypeTXT, wantHit: true},
+		"walled garden":   {name: "garden.example", recordType: dnstoy.RecordTypeA, wantData: []string{"walled.test.", "192.0.2.30"}, wantHit: true},
+		"mixed garden":    {name: "mixed.example", recordType: dnstoy.RecordTypeA, wantData: []string{"walled.test.", "192.0.2.30"}, wantHit: true},
+		"mixed rewrite":   {name: "mixed.example", recordType: dnstoy.RecordTypeTXT, wantData: []string{`"hello"`}, wantHit: true},
+		"relative garden": {name: "x.tracker.example", recordType: dnstoy.RecordTypeA, wantData: []string{"x.tracker.example.sinkhole.test.", "192.0.2.40"}, wantHit: true},
+		"unsupported":     {name: "dropped.example", recordType: dnstoy.RecordTypeA, wantData: []string{"192.0.2.50"}},
+		"response IP":     {name: "ads.example.net", recordType: dnstoy.RecordTypeA, wantRCode: dnstoy.RCodeNXDomain, wantHit: true},
+		"response prefix": {name: "cdn.example.net", recordType: dnstoy.RecordTypeA, wantData: []string{"192.0.2.1"}, wantHit: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			h := &RPZ{Zones: []*PolicyZone{z}, Next: next}
+			query := dnstoy.Message{Questions: []dnstoy.Question{{Name: []byte(tc.name), Type: tc.recordType, Class: dnstoy.ResourceClassIN}}}
+			resp, err := h.ServeDNS(context.Background(), query)
+			be.NilErr(t, err)
+			be.Equal(t, tc.wantRCode, resp.Header.RCode())
+			var data []string
+			for _, rec := range resp.Answers {
+				data = append(data, strings.Split(rec.String(), "\t")[4])
+			}
+			be.AllEqual(t, tc.wantData, data)
+			be.Equal(t, tc.wantHit, h.Hits() == 1)
+		})
+	}
+}
@@ -0,0 +1,347 @@
+// Package server implements DNS servers that answer queries with a Handler,
+// for serving authoritative data, forwarding queries, or answering queries
+// in tests. Messages are those of package dnstoy.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// Handler answers DNS queries.
+//
+// The response's ID, QR and RD bits, opcode and questions are filled in
+// from the query, so handlers need only set the rcode, any other flags and
+// the records. If the handler returns an error or panics, the query is
+// answered with SERVFAIL.
+type Handler interface {
+	ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error)
+
+// ServeDNS calls f(ctx, query).
+func (f HandlerFunc) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	return f(ctx, query)
+}
+
+// ErrServerClosed is returned by Serve and ListenAndServe once the server
+// is shut down.
+var ErrServerClosed = errors.New("server closed")
+
+const (
+	defaultAddr    = ":53"
+	defaultTimeout = 10 * time.Second
+
+	// maxUDPSize is the largest response sent over UDP to queries without an
+	// OPT record advertising a larger size:
+	// https://datatracker.ietf.org/doc/html/rfc1035#section-4.2.1
+	maxUDPSize = 512
+)
+
+// Server answers DNS queries received over UDP with its Handler, calling it
+// in a new goroutine for each query.
+type Server struct {
+	// Addr is the UDP address to listen on. Defaults to ":53".
+	Addr string
+
+	Handler Handler
+
+	// Timeout bounds the time taken to answer a single query, after which
+	// the context passed to the handler is canceled. Defaults to 10s.
+	Timeout time.Duration
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	closed  bool
+	conns   map[net.PacketConn]bool
+	wg      sync.WaitGroup // tracks queries in progress
+	ctxOnce sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+type remoteAddrKey struct{}
+
+// RemoteAddr returns the address of the client that sent the query being
+// answered, given the context passed to a Handler.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+// ListenAndServe listens on s.Addr and answers queries until the server is
+// shut down, when it returns ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers queries received on conn until the server is shut down,
+// when it returns ErrServerClosed. The server takes ownership of conn,
+// closing it when shut down.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if !s.track(conn) {
+		conn.Close()
+		return ErrServerClosed
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			s.untrack(conn)
+			conn.Close()
+			return err
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		if !s.begin() {
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			s.answer(conn, addr, packet)
+		}()
+	}
+}
+
+// answer answers a single query, writing the response, if any, to addr.
+func (s *Server) answer(conn net.PacketConn, addr net.Addr, packet []byte) {
+	query, resp, ok := s.respond(s.baseContext(), addr, packet)
+	if !ok {
+		return
+	}
+	if _, err := conn.WriteTo(truncate(resp, maxResponseSize(query)), addr); err != nil && !s.isClosed() {
+		s.logger().Warn("failed to write response", slog.String("client", addr.String()), slog.String("err", err.Error()))
+	}
+}
+
+// respond parses the given packet and answers it with the handler,
+// returning the query and response, or false if the packet should be
+// dropped without a response.
+func (s *Server) respond(ctx context.Context, addr net.Addr, packet []byte) (query, resp dnstoy.Message, ok bool) {
+	header, err := dnstoy.ParseHeader(packet)
+	if err != nil || header.QR() {
+		// too short to answer, or not a query
+		return query, resp, false
+	}
+	query, err = dnstoy.ParseMessage(packet, dnstoy.ParseStrict)
+	if err != nil {
+		s.logger().Debug("malformed query", slog.String("client", addr.String()), slog.String("err", err.Error()))
+		query = dnstoy.Message{Header: header}
+		finishResponse(&resp, query)
+		resp.Header.SetRCode(dnstoy.RCodeFormErr)
+		return query, resp, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	ctx = context.WithValue(ctx, remoteAddrKey{}, addr)
+	resp, err = s.serve(ctx, query)
+	if err != nil {
+		s.logger().Warn("failed to answer query", slog.String("client", addr.String()), slog.String("err", err.Error()))
+		resp = dnstoy.Message{}
+		resp.Header.SetRCode(dnstoy.RCodeServFail)
+	}
+	finishResponse(&resp, query)
+	return query, resp, true
+}
+
+// serve calls the handler, recovering from any panic.
+func (s *Server) serve(ctx context.Context, query dnstoy.Message) (resp dnstoy.Message, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			s.logger().Error("handler panicked", slog.Any("panic", p), slog.String("stack", string(debug.Stack())))
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	if s.Handler == nil {
+		return dnstoy.Message{}, errors.New("no handler")
+	}
+	return s.Handler.ServeDNS(ctx, query)
+}
+
+// finishResponse fills in the parts of a response that are copied from the
+// query.
+func finishResponse(resp *dnstoy.Message, query dnstoy.Message) {
+	resp.Header.ID = query.Header.ID
+	resp.Header.SetQR(true)
+	resp.Header.SetOpcode(query.Header.Opcode())
+	resp.Header.SetRD(query.Header.RD())
+	if resp.Questions == nil {
+		resp.Questions = query.Questions
+	}
+}
+
+// maxResponseSize returns the largest response that may be sent to the
+// given query over UDP, which is the payload size advertised in its OPT
+// record, if any.
+func maxResponseSize(query dnstoy.Message) int {
+	for _, rec := range query.Additionals {
+		if rec.Type == dnstoy.RecordTypeOPT && int(rec.Class) > maxUDPSize {
+			return int(rec.Class)
+		}
+	}
+	return maxUDPSize
+}
+
+// truncate encodes the response, dropping its records and setting the TC
+// bit if it is larger than maxSize, so that the client retries over TCP. An
+// OPT record is kept.
+// https://datatracker.ietf.org/doc/html/rfc2181#section-9
+func truncate(resp dnstoy.Message, maxSize int) []byte {
+	encoded := resp.Encode()
+	if len(encoded) <= maxSize {
+		return encoded
+	}
+	truncated := dnstoy.Message{Header: resp.Header, Questions: resp.Questions}
+	truncated.Header.SetTC(true)
+	for _, rec := range resp.Additionals {
+		if rec.Type == dnstoy.RecordTypeOPT {
+			truncated.Additionals = append(truncated.Additionals, rec)
+		}
+	}
+	return truncated.Encode()
+}
+
+// Shutdown stops the server: it stops reading queries, waits for the
+// queries in progress to be answered, and then closes its connections. If
+// ctx is done first, the contexts of the remaining queries are canceled and
+// ctx's error is returned once they return.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		// unblock the read loop without closing the connection, which is
+		// still needed to send the responses to queries in progress
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.baseContext()
+		s.cancel()
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// Close stops the server immediately, canceling the queries in progress.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Shutdown(ctx); err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// track registers a connection being served, returning false if the
+// server is already shut down.
+func (s *Server) track(conn net.PacketConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.PacketConn]bool)
+	}
+	s.conns[conn] = true
+	return true
+}
+
+// begin registers a query in progress, returning false if the server is
+// shut down.
+func (s *Server) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.PacketConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// baseContext returns the context from which the context of every query is
+// derived, which is canceled when a shutdown is forced.
+func (s *Server) baseContext() context.Context {
+	s.ctxOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+	return s.ctx
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return defaultTimeout
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// canonicalName returns the form of a name used to compare it with others,
+// ignoring case and any trailing dot.
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
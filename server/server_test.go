@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"golang.org/x/exp/slog"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// startServer starts a server with the given handler on a random local
+// port, returning the server and the address it listens on.
+func startServer(t *testing.T, handler Handler) (*Server, string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	be.NilErr(t, err)
+	s := &Server{Handler: handler, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	errs := make(chan error, 1)
+	go func() { errs <- s.Serve(conn) }()
+	t.Cleanup(func() {
+		s.Close()
+		be.Equal(t, ErrServerClosed, <-errs)
+	})
+	return s, conn.LocalAddr().String()
+}
+
+// exchange sends the given packet to the server and returns its parsed
+// response.
+func exchange(t *testing.T, addr string, packet []byte) dnstoy.Message {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	be.NilErr(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(packet)
+	be.NilErr(t, err)
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	be.NilErr(t, err)
+	msg, err := dnstoy.ParseMessage(buf[:n], dnstoy.ParseStrict)
+	be.NilErr(t, err)
+	return msg
+}
+
+func newQuery(name string) dnstoy.Query {
+	query := dnstoy.NewQuery(name, dnstoy.RecordTypeA)
+	query.Header.SetRD(true)
+	return query
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	remoteAddrs := make(chan net.Addr, 1)
+	_, addr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		remoteAddrs <- RemoteAddr(ctx)
+		resp := dnstoy.Message{}
+		resp.Header.SetAA(true)
+		rec, err := dnstoy.NewA(string(query.Questions[0].Name), 60, net.IPv4(192, 0, 2, 1))
+		resp.Answers = append(resp.Answers, rec)
+		return resp, err
+	}))
+
+	query := newQuery("www.example.com")
+	resp := exchange(t, addr, query.Encode())
+	be.Equal(t, query.Header.ID, resp.Header.ID)
+	be.True(t, resp.Header.QR())
+	be.True(t, resp.Header.AA())
+	be.True(t, resp.Header.RD())
+	be.Equal(t, dnstoy.RCodeNoError, resp.Header.RCode())
+	be.Equal(t, 1, len(resp.Questions))
+	be.Equal(t, "www.example.com", string(resp.Questions[0].Name))
+	be.Equal(t, 1, len(resp.Answers))
+	be.Equal(t, "127.0.0.1", (<-remoteAddrs).(*net.UDPAddr).IP.String())
+}
+
+func TestServerFailures(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]Handler{
+		"error": HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+			return dnstoy.Message{}, errors.New("oops")
+		}),
+		"panic": HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+			panic("oops")
+		}),
+		"no handler": nil,
+	}
+	for name, handler := range testCases {
+		handler := handler
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, addr := startServer(t, handler)
+			query := newQuery("www.example.com")
+			resp := exchange(t, addr, query.Encode())
+			be.Equal(t, query.Header.ID, resp.Header.ID)
+			be.Equal(t, dnstoy.RCodeServFail, resp.Header.RCode())
+			be.Equal(t, 1, len(resp.Questions))
+		})
+	}
+}
+
+func TestServerMalformedQuery(t *testing.T) {
+	t.Parallel()
+
+	_, addr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		t.Error("handler called for malformed query")
+		return dnstoy.Message{}, nil
+	}))
+	packet := newQuery("www.example.com").Encode()
+	resp := exchange(t, addr, packet[:len(packet)-2])
+	be.Equal(t, dnstoy.RCodeFormErr, resp.Header.RCode())
+	be.Equal(t, 0, len(resp.Questions))
+}
+
+func TestServerTruncates(t *testing.T) {
+	t.Parallel()
+
+	_, addr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		rec, err := dnstoy.NewTXT(string(query.Questions[0].Name), 60, strings.Repeat("a", 255), strings.Repeat("b", 255))
+		return dnstoy.Message{Answers: []dnstoy.Record{rec}}, err
+	}))
+
+	resp := exchange(t, addr, newQuery("www.example.com").Encode())
+	be.True(t, resp.Header.TC())
+	be.Equal(t, 0, len(resp.Answers))
+	be.Equal(t, 1, len(resp.Questions))
+
+	// a larger EDNS payload size allows the whole response
+	query := newQuery("www.example.com")
+	query.AddEDNS(1232)
+	resp = exchange(t, addr, query.Encode())
+	be.False(t, resp.Header.TC())
+	be.Equal(t, 1, len(resp.Answers))
+}
+
+func TestServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, addr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		close(started)
+		<-release
+		return dnstoy.Message{}, nil
+	}))
+
+	resps := make(chan dnstoy.Message, 1)
+	go func() { resps <- exchange(t, addr, newQuery("www.example.com").Encode()) }()
+	<-started
+
+	shutdownErrs := make(chan error, 1)
+	go func() { shutdownErrs <- s.Shutdown(context.Background()) }()
+	select {
+	case <-shutdownErrs:
+		t.Fatal("shutdown returned before the query in progress was answered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	be.NilErr(t, <-shutdownErrs)
+	be.Equal(t, dnstoy.RCodeNoError, (<-resps).Header.RCode())
+}
+
+func TestServerShutdownCancels(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	canceled := make(chan error, 1)
+	s, addr := startServer(t, HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		close(started)
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return dnstoy.Message{}, ctx.Err()
+	}))
+
+	conn, err := net.Dial("udp", addr)
+	be.NilErr(t, err)
+	defer conn.Close()
+	_, err = conn.Write(newQuery("www.example.com").Encode())
+	be.NilErr(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	be.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	be.Equal(t, context.Canceled, <-canceled)
+}
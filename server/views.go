@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+// View is a set of rules that change how the queries of certain clients
+// are answered, for split-horizon DNS, where clients on a local network
+// are given different answers from everyone else.
+type View struct {
+	// Clients are the networks of the clients the view applies to. A view
+	// without any applies to every client.
+	Clients []netip.Prefix
+
+	// Zones are answered from their local records, instead of being
+	// resolved.
+	Zones []LocalZone
+
+	// Rewrites substitute addresses in answers to the queries that are
+	// resolved.
+	Rewrites []Rewrite
+
+	// MinTTL and MaxTTL, if nonzero, clamp the TTLs of the records in
+	// responses, e.g. to have clients cache answers for longer than their
+	// name servers allow, or to pick up changes more quickly.
+	MinTTL uint32
+	MaxTTL uint32
+}
+
+// LocalZone is a zone whose records are given locally, overriding those
+// published by its name servers.
+type LocalZone struct {
+	Zone    string
+	Records []dnstoy.Record
+}
+
+// Rewrite substitutes one address for another in A and AAAA records, e.g.
+// so that clients on a local network connect to a server's private address
+// rather than its public address.
+type Rewrite struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// Views is a Handler that answers queries according to the first of its
+// views that applies to the client, passing them on to the next handler to
+// be resolved where needed. Queries from clients to which no view applies
+// are passed on unchanged.
+type Views struct {
+	Views []View
+	Next  Handler
+}
+
+// ServeDNS answers the query according to the view that applies to the
+// client, if any.
+func (v *Views) ServeDNS(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+	view := v.viewFor(clientAddr(ctx))
+	if view == nil {
+		return v.Next.ServeDNS(ctx, query)
+	}
+	var resp dnstoy.Message
+	if zone := view.zoneFor(query); zone != nil {
+		resp = zone.answer(query.Questions[0])
+	} else {
+		var err error
+		if resp, err = v.Next.ServeDNS(ctx, query); err != nil {
+			return resp, err
+		}
+		resp.Answers = view.rewrite(resp.Answers)
+	}
+	resp.Answers = view.clampTTLs(resp.Answers)
+	resp.Authorities = view.clampTTLs(resp.Authorities)
+	resp.Additionals = view.clampTTLs(resp.Additionals)
+	return resp, nil
+}
+
+func (v *Views) viewFor(addr netip.Addr) *View {
+	for i, view := range v.Views {
+		if len(view.Clients) == 0 {
+			return &v.Views[i]
+		}
+		for _, prefix := range view.Clients {
+			if addr.IsValid() && prefix.Contains(addr) {
+				return &v.Views[i]
+			}
+		}
+	}
+	return nil
+}
+
+// clientAddr returns the address of the client that sent the query being
+// answered, or the zero Addr if it is unknown.
+func clientAddr(ctx context.Context) netip.Addr {
+	var ip net.IP
+	switch addr := RemoteAddr(ctx).(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// zoneFor returns the most specific local zone containing the query's
+// name, if any.
+func (v *View) zoneFor(query dnstoy.Message) *LocalZone {
+	if len(query.Questions) != 1 {
+		return nil
+	}
+	name := canonicalName(string(query.Questions[0].Name))
+	var found *LocalZone
+	for i, zone := range v.Zones {
+		zoneName := canonicalName(zone.Zone)
+		if name != zoneName && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if found == nil || len(zoneName) > len(canonicalName(found.Zone)) {
+			found = &v.Zones[i]
+		}
+	}
+	return found
+}
+
+// answer answers the given question from the zone's records, with NXDOMAIN
+// if there are none for the name or its subdomains, or a CNAME if the name
+// is an alias.
+func (z *LocalZone) answer(question dnstoy.Question) dnstoy.Message {
+	var resp dnstoy.Message
+	resp.Header.SetAA(true)
+	resp.Header.SetRA(true)
+	name := canonicalName(string(question.Name))
+	exists := false
+	var cnames []dnstoy.Record
+	for _, rec := range z.Records {
+		owner := canonicalName(string(rec.Name))
+		if strings.HasSuffix(owner, "."+name) {
+			// the name is an empty non-terminal, which exists
+			exists = true
+		}
+		if owner != name {
+			continue
+		}
+		exists = true
+		switch {
+		case rec.Type == question.Type && rec.Class == question.Class:
+			resp.Answers = append(resp.Answers, rec)
+		case rec.Type == dnstoy.RecordTypeCNAME:
+			cnames = append(cnames, rec)
+		}
+	}
+	if len(resp.Answers) == 0 {
+		resp.Answers = cnames
+	}
+	if !exists {
+		resp.Header.SetRCode(dnstoy.RCodeNXDomain)
+	}
+	return resp
+}
+
+// rewrite returns the given records with the view's address substitutions
+// applied.
+func (v *View) rewrite(records []dnstoy.Record) []dnstoy.Record {
+	if len(v.Rewrites) == 0 {
+		return records
+	}
+	rewritten := make([]dnstoy.Record, len(records))
+	for i, rec := range records {
+		rewritten[i] = rec
+		if rec.Type != dnstoy.RecordTypeA && rec.Type != dnstoy.RecordTypeAAAA {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(rec.Data)
+		if !ok {
+			continue
+		}
+		for _, rw := range v.Rewrites {
+			if rw.From == addr && rw.To.BitLen() == addr.BitLen() {
+				rewritten[i].Data = rw.To.AsSlice()
+				break
+			}
+		}
+	}
+	return rewritten
+}
+
+// clampTTLs returns the given records with their TTLs clamped to the
+// view's minimum and maximum.
+func (v *View) clampTTLs(records []dnstoy.Record) []dnstoy.Record {
+	if (v.MinTTL == 0 && v.MaxTTL == 0) || len(records) == 0 {
+		return records
+	}
+	clamped := make([]dnstoy.Record, len(records))
+	for i, rec := range records {
+		clamped[i] = rec
+		if rec.Type == dnstoy.RecordTypeOPT {
+			continue
+		}
+		if v.MinTTL > 0 && rec.TTL < v.MinTTL {
+			clamped[i].TTL = v.MinTTL
+		}
+		if v.MaxTTL > 0 && rec.TTL > v.MaxTTL {
+			clamped[i].TTL = v.MaxTTL
+		}
+	}
+	return clamped
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestViews(t *testing.T) {
+	t.Parallel()
+
+	next := HandlerFunc(func(ctx context.Context, query dnstoy.Message) (dnstoy.Message, error) {
+		name := query.Questions[0].Name
+		return dnstoy.Message{Answers: []dnstoy.Record{
+			{Name: name, Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 10, Data: []byte{203, 0, 113, 1}},
+			{Name: name, Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 86400, Data: []byte{203, 0, 113, 2}},
+		}}, nil
+	})
+	views := &Views{
+		Views: []View{
+			{
+				Clients: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+				Zones: []LocalZone{
+					{Zone: "home.test", Records: []dnstoy.Record{
+						{Name: []byte("nas.home.test"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 300, Data: []byte{192, 168, 1, 10}},
+						{Name: []byte("files.home.test"), Type: dnstoy.RecordTypeCNAME, Class: dnstoy.ResourceClassIN, TTL: 300, Data: []byte("nas.home.test")},
+						{Name: []byte("a.b.home.test"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN, TTL: 300, Data: []byte{192, 168, 1, 11}},
+					}},
+				},
+				Rewrites: []Rewrite{{From: netip.MustParseAddr("203.0.113.1"), To: netip.MustParseAddr("192.168.1.1")}},
+				MinTTL:   60,
+				MaxTTL:   3600,
+			},
+		},
+		Next: next,
+	}
+
+	testCases := map[string]struct {
+		client     string
+		name       string
+		recordType dnstoy.RecordType
+		wantRCode  dnstoy.RCode
+		wantAA     bool
+		wantData   []string
+		wantTTLs   []uint32
+	}{
+		"outside view": {
+			client: "10.0.0.1", name: "www.example.com", recordType: dnstoy.RecordTypeA,
+			wantData: []string{"203.0.113.1", "203.0.113.2"}, wantTTLs: []uint32{10, 86400},
+		},
+		"rewritten and clamped": {
+			client: "192.168.1.5", name: "www.example.com", recordType: dnstoy.RecordTypeA,
+			wantData: []string{"192.168.1.1", "203.0.113.2"}, wantTTLs: []uint32{60, 3600},
+		},
+		"local zone": {
+			client: "192.168.1.5", name: "NAS.home.test", recordType: dnstoy.RecordTypeA, wantAA: true,
+			wantData: []string{"192.168.1.10"}, wantTTLs: []uint32{300},
+		},
+		"local CNAME": {
+			client: "192.168.1.5", name: "files.home.test", recordType: dnstoy.RecordTypeA, wantAA: true,
+			wantData: []string{"nas.home.test"}, wantTTLs: []uint32{300},
+		},
+		"local no data": {
+			client: "192.168.1.5", name: "nas.home.test", recordType: dnstoy.RecordTypeAAAA, wantAA: true,
+		},
+		"local empty non-terminal": {
+			client: "192.168.1.5", name: "b.home.test", recordType: dnstoy.RecordTypeA, wantAA: true,
+		},
+		"local nxdomain": {
+			client: "192.168.1.5", name: "missing.home.test", recordType: dnstoy.RecordTypeA, wantAA: true, wantRCode: dnstoy.RCodeNXDomain,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.WithValue(context.Background(), remoteAddrKey{}, &net.UDPAddr{IP: net.ParseIP(tc.client), Port: 12345})
+			query := dnstoy.Message{Questions: []dnstoy.Question{{Name: []byte(tc.name), Type: tc.recordType, Class: dnstoy.ResourceClassIN}}}
+			resp, err := views.ServeDNS(ctx, query)
+			be.NilErr(t, err)
+			be.Equal(t, tc.wantRCode, resp.Header.RCode())
+			be.Equal(t, tc.wantAA, resp.Header.AA())
+			var data []string
+			var ttls []uint32
+			for _, rec := range resp.Answers {
+				if rec.Type == dnstoy.RecordTypeCNAME {
+					data = append(data, string(rec.Data))
+				} else {
+					data = append(data, net.IP(rec.Data).String())
+				}
+				ttls = append(ttls, rec.TTL)
+			}
+			be.AllEqual(t, tc.wantData, data)
+			be.AllEqual(t, tc.wantTTLs, ttls)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package dnstoy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// cacheSnapshotVersion identifies the format written by MemoryCache.Save, so
+// that incompatible snapshots can be rejected on load.
+const cacheSnapshotVersion = 1
+
+type cacheSnapshot struct {
+	Version int                  `json:"version"`
+	Entries []cacheSnapshotEntry `json:"entries"`
+}
+
+type cacheSnapshotEntry struct {
+	Name    string           `json:"name"`
+	Type    RecordType       `json:"type"`
+	Class   ResourceClass    `json:"class"`
+	Expires time.Time        `json:"expires"`
+	TTL     uint32           `json:"ttl"`
+	Records []snapshotRecord `json:"records"`
+}
+
+type snapshotRecord struct {
+	Name  string        `json:"name"`
+	Type  RecordType    `json:"type"`
+	Class ResourceClass `json:"class"`
+	TTL   uint32        `json:"ttl"`
+	Data  []byte        `json:"data"`
+}
+
+// Save writes every unexpired cache entry to w as JSON, recording absolute
+// expiry times so that remaining TTLs can be honored when loaded.
+func (c *MemoryCache) Save(w io.Writer) error {
+	c.mu.Lock()
+	now := c.now()
+	snapshot := cacheSnapshot{
+		Version: cacheSnapshotVersion,
+		Entries: make([]cacheSnapshotEntry, 0, c.lru.Len()),
+	}
+	// walk from least to most recently used, so that loading the snapshot
+	// restores the same LRU order
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*cacheEntry)
+		if !entry.expires.After(now) {
+			continue
+		}
+		records := make([]snapshotRecord, len(entry.records))
+		for i, r := range entry.records {
+			records[i] = snapshotRecord{Name: string(r.Name), Type: r.Type, Class: r.Class, TTL: r.TTL, Data: r.Data}
+		}
+		snapshot.Entries = append(snapshot.Entries, cacheSnapshotEntry{
+			Name:    entry.key.Name,
+			Type:    entry.key.Type,
+			Class:   entry.key.Class,
+			Expires: entry.expires,
+			TTL:     uint32(entry.ttl / time.Second),
+			Records: records,
+		})
+	}
+	c.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load restores entries from a snapshot written by Save, skipping any that
+// have expired in the meantime. Loaded entries replace existing entries with
+// the same key.
+func (c *MemoryCache) Load(r io.Reader) error {
+	var snapshot cacheSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to decode cache snapshot: %w", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return fmt.Errorf("unsupported cache snapshot version %d", snapshot.Version)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, e := range snapshot.Entries {
+		if !e.Expires.After(now) || len(e.Records) == 0 {
+			continue
+		}
+		key := CacheKey{Name: e.Name, Type: e.Type, Class: e.Class}
+		records := make([]Record, len(e.Records))
+		for i, r := range e.Records {
+			records[i] = Record{Name: []byte(r.Name), Type: r.Type, Class: r.Class, TTL: r.TTL, Data: r.Data}
+		}
+		c.insert(&cacheEntry{
+			key:     key,
+			records: records,
+			expires: e.Expires,
+			ttl:     time.Duration(e.TTL) * time.Second,
+			size:    cacheEntrySize(key, records),
+		})
+	}
+	return nil
+}
+
+// snapshotCache is implemented by caches that support persistence.
+type snapshotCache interface {
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
+
+// SaveCache writes a snapshot of the resolver's cache to w, which may later
+// be restored with LoadCache so that a restarted process does not start with
+// a cold cache. The resolver's cache must support Save and Load methods, as
+// MemoryCache does.
+func (r *Resolver) SaveCache(w io.Writer) error {
+	cache, ok := r.cache.(snapshotCache)
+	if !ok {
+		return errors.New("cache does not support snapshots")
+	}
+	return cache.Save(w)
+}
+
+// LoadCache restores a cache snapshot written by SaveCache. Entries keep
+// their original expiry times, and entries that have since expired are
+// discarded.
+func (r *Resolver) LoadCache(rd io.Reader) error {
+	cache, ok := r.cache.(snapshotCache)
+	if !ok {
+		return errors.New("cache does not support snapshots")
+	}
+	return cache.Load(rd)
+}
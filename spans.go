@@ -0,0 +1,86 @@
+package dnstoy
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tracer starts spans describing the work done by a Resolver, for
+// distributed tracing. It mirrors the subset of OpenTelemetry's trace.Tracer
+// used by dnstoy, so that an OpenTelemetry tracer can be adapted with a few
+// lines of code, without dnstoy depending on OpenTelemetry:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, dnstoy.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// Each lookup is described by a "dnstoy.lookup" span, with a child
+// "dnstoy.query" span for each query sent to a name server while resolving
+// it.
+type Tracer interface {
+	// Start starts a span with the given name, as a child of the span in
+	// ctx, if any, returning a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...SpanAttribute)
+	RecordError(err error)
+	End()
+}
+
+// SpanAttribute is a key-value pair describing a span. Values are strings,
+// ints or bools.
+type SpanAttribute struct {
+	Key   string
+	Value any
+}
+
+// Span names
+const (
+	spanLookup = "dnstoy.lookup"
+	spanQuery  = "dnstoy.query"
+)
+
+// startSpan starts a span with the configured Tracer, if any.
+func (r *Resolver) startSpan(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, Span) {
+	if r.tracer == nil {
+		return ctx, nopSpan{}
+	}
+	ctx, span := r.tracer.Start(ctx, name)
+	span.SetAttributes(attrs...)
+	return ctx, span
+}
+
+// endSpan records the outcome of the work described by the span and ends it.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// transportName returns the name of the protocol used by a transport, for
+// use in span attributes.
+func transportName(t Transport) string {
+	switch t.(type) {
+	case *UDPTransport:
+		return "udp"
+	case *TCPTransport:
+		return "tcp"
+	case *TLSTransport:
+		return "tls"
+	case *HTTPSTransport:
+		return "https"
+	default:
+		return fmt.Sprintf("%T", t)
+	}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...SpanAttribute) {}
+func (nopSpan) RecordError(error)              {}
+func (nopSpan) End()                           {}
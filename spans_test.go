@@ -0,0 +1,131 @@
+package dnstoy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]any
+	errs   []error
+	ended  bool
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordingSpanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(recordingSpanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: make(map[string]any)}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordingSpanKey{}, span), recordingSpan{t, span}
+}
+
+func (t *recordingTracer) named(name string) []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []*recordedSpan
+	for _, span := range t.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+type recordingSpan struct {
+	t    *recordingTracer
+	span *recordedSpan
+}
+
+func (s recordingSpan) SetAttributes(attrs ...SpanAttribute) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	for _, attr := range attrs {
+		s.span.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s recordingSpan) RecordError(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.span.errs = append(s.span.errs, err)
+}
+
+func (s recordingSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.span.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n == 1 {
+			return referral("example.test", "ns1.example.test")
+		}
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	tracer := &recordingTracer{}
+	r := newTestResolver(port, &Opts{Tracer: tracer})
+
+	_, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+
+	lookups := tracer.named(spanLookup)
+	be.Equal(t, 1, len(lookups))
+	be.True(t, lookups[0].ended)
+	be.Equal(t, 0, len(lookups[0].errs))
+	be.Equal(t, any("www.example.test"), lookups[0].attrs["dns.question.name"])
+
+	queries := tracer.named(spanQuery)
+	be.Equal(t, 2, len(queries))
+	for _, span := range queries {
+		be.True(t, span.ended)
+		be.True(t, span.parent == lookups[0])
+		be.Equal(t, any("udp"), span.attrs["dns.transport"])
+		be.Equal(t, any(0), span.attrs["dns.rcode"])
+		be.True(t, span.attrs["dns.response.bytes"].(int) > 0)
+	}
+	be.Equal(t, any("."), queries[0].attrs["dns.zone"])
+	be.Equal(t, any("root.test"), queries[0].attrs["dns.nameserver"])
+	be.Equal(t, any("example.test"), queries[1].attrs["dns.zone"])
+	be.Equal(t, any("ns1.example.test"), queries[1].attrs["dns.nameserver"])
+}
+
+func TestTracerRecordsErrors(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+	})
+	tracer := &recordingTracer{}
+	r := newTestResolver(port, &Opts{Tracer: tracer})
+
+	_, err := r.LookupIP(context.Background(), "ip4", "missing.test")
+	be.True(t, err != nil)
+
+	lookups := tracer.named(spanLookup)
+	be.Equal(t, 1, len(lookups))
+	be.True(t, lookups[0].ended)
+	be.Equal(t, 1, len(lookups[0].errs))
+}
@@ -0,0 +1,83 @@
+package dnstoy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// A TraceStep describes a single step taken while resolving a name: either
+// a query sent to a name server, or an answer found in the cache.
+type TraceStep struct {
+	// Name and Type are the name and record type looked up, which may
+	// differ from the name passed to LookupIPWithTrace when following
+	// CNAMEs or resolving the addresses of name servers.
+	Name string
+	Type RecordType
+
+	// Zone is the zone the name server was queried as authoritative for,
+	// e.g. "." for the root name servers and "com" for the .com name
+	// servers.
+	Zone       string
+	NameServer string
+	Addr       net.IP // nil for cached answers and DNS-over-HTTPS queries
+
+	// Cached is set if the answer was found in the cache, in which case no
+	// query was sent.
+	Cached bool
+
+	// Response is the name server's response, valid if Err is nil. Its
+	// records may include some that were discarded as out of bailiwick.
+	Response Message
+	RTT      time.Duration
+	Err      error
+
+	// Depth is the recursion depth at which the step was taken.
+	Depth int
+}
+
+// LookupIPWithTrace resolves the given domain name like LookupIP, also
+// returning the steps taken to resolve it, in order: each query sent to a
+// name server, from the root down through the zones it delegated to, along
+// with any answers found in the cache. When name servers are raced, the
+// queries sent to each are included.
+func (r *Resolver) LookupIPWithTrace(ctx context.Context, domainName string, opts ...LookupOption) ([]net.IP, []TraceStep, error) {
+	t := &lookupTrace{}
+	ips, err := r.LookupIP(context.WithValue(ctx, lookupTraceKey{}, t), "ip4", domainName, opts...)
+	return ips, t.result(), err
+}
+
+// LookupRecordsWithTrace looks up records like LookupRecords, also returning
+// the steps taken to find them, as LookupIPWithTrace does.
+func (r *Resolver) LookupRecordsWithTrace(ctx context.Context, domainName string, opts QueryOpts, options ...LookupOption) ([]Record, []TraceStep, error) {
+	t := &lookupTrace{}
+	records, err := r.LookupRecords(context.WithValue(ctx, lookupTraceKey{}, t), domainName, opts, options...)
+	return records, t.result(), err
+}
+
+// lookupTraceKey is the context key used to carry the trace of a lookup, if
+// one is being recorded.
+type lookupTraceKey struct{}
+
+type lookupTrace struct {
+	mu    sync.Mutex
+	steps []TraceStep
+}
+
+// traceStep records a step in the trace carried by ctx, if any.
+func traceStep(ctx context.Context, step TraceStep) {
+	t, ok := ctx.Value(lookupTraceKey{}).(*lookupTrace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, step)
+}
+
+func (t *lookupTrace) result() []TraceStep {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceStep(nil), t.steps...)
+}
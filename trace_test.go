@@ -0,0 +1,69 @@
+package dnstoy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestLookupIPWithTrace(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var n int
+	port := startTestServer(t, func(q Message) Message {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n == 1 {
+			return referral("example.test", "ns1.example.test")
+		}
+		return Message{
+			Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+		}
+	})
+	r := newTestResolver(port, nil)
+
+	ips, trace, err := r.LookupIPWithTrace(context.Background(), "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, "1.2.3.4", ips[0].String())
+	be.Equal(t, 2, len(trace))
+
+	be.Equal(t, ".", trace[0].Zone)
+	be.Equal(t, "root.test", trace[0].NameServer)
+	be.Equal(t, "127.0.0.1", trace[0].Addr.String())
+	be.Equal(t, "www.example.test", trace[0].Name)
+	be.NilErr(t, trace[0].Err)
+	be.Equal(t, 1, len(trace[0].Response.Authorities))
+
+	be.Equal(t, "example.test", trace[1].Zone)
+	be.Equal(t, "ns1.example.test", trace[1].NameServer)
+	be.Equal(t, 1, trace[1].Depth)
+	be.Equal(t, 1, len(trace[1].Response.Answers))
+
+	// a second lookup is answered from the cache
+	_, trace, err = r.LookupIPWithTrace(context.Background(), "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(trace))
+	be.True(t, trace[0].Cached)
+	be.Equal(t, "", trace[0].NameServer)
+}
+
+func TestLookupRecordsWithTrace(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		rec, _ := NewMX("example.test", 60, 10, "mail.example.test")
+		return Message{Answers: []Record{rec}}
+	})
+	r := newTestResolver(port, nil)
+
+	records, trace, err := r.LookupRecordsWithTrace(context.Background(), "example.test", QueryOpts{Type: RecordTypeMX})
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(records))
+	be.Equal(t, 1, len(trace))
+	be.Equal(t, RecordTypeMX, trace[0].Type)
+	be.Equal(t, "root.test", trace[0].NameServer)
+}
@@ -0,0 +1,301 @@
+package dnstoy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport sends queries to name servers and returns their responses.
+// Implementations must be safe for concurrent use. The Resolver checks that
+// each response matches its query, so transports need not do so, but they
+// may return a *PartialMessageError along with a partially parsed response.
+type Transport interface {
+	RoundTrip(ctx context.Context, query Query, server netip.AddrPort) (Message, error)
+}
+
+// UDPTransport sends each query in a single datagram from a new socket, the
+// standard transport for DNS.
+type UDPTransport struct {
+	// Network is one of "udp", "udp4" or "udp6". Defaults to "udp".
+	Network string
+
+	// Dialer supplies the local address and socket options, if any, for
+	// the sockets used to send queries.
+	Dialer *net.Dialer
+
+	// Timeout bounds the time waiting for each response. Defaults to 1s.
+	Timeout time.Duration
+
+	// ParseMode controls how malformed responses are handled.
+	ParseMode ParseMode
+}
+
+// RoundTrip sends the query to the server and waits for its response.
+func (t *UDPTransport) RoundTrip(ctx context.Context, query Query, server netip.AddrPort) (Message, error) {
+	network := t.Network
+	if network == "" {
+		network = "udp"
+	}
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = defaultQueryTimeout
+	}
+
+	// an unconnected socket is used so that the source of each response can
+	// be checked against the address queried
+	lc := net.ListenConfig{}
+	var localAddr string
+	if t.Dialer != nil {
+		lc.Control = t.Dialer.Control
+		if addr, ok := t.Dialer.LocalAddr.(*net.UDPAddr); ok {
+			localAddr = addr.String()
+		}
+	}
+	conn, err := lc.ListenPacket(ctx, network, localAddr)
+	if err != nil {
+		return Message{}, err
+	}
+	defer conn.Close()
+
+	// the query may not outlive the caller's deadline, and is aborted
+	// promptly if the caller gives up
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	resp, err := exchangeDatagram(conn, net.UDPAddrFromAddrPort(server), query.Encode(), query.maxResponseSize())
+	if err != nil {
+		if ctx.Err() != nil {
+			return Message{}, fmt.Errorf("query aborted: %w", ctx.Err())
+		}
+		return Message{}, err
+	}
+	return ParseMessage(resp, t.ParseMode)
+}
+
+// TCPTransport sends queries over persistent TCP connections, which are
+// reused for later queries to the same server and may carry several queries
+// at once.
+// https://datatracker.ietf.org/doc/html/rfc7766
+type TCPTransport struct {
+	// Network is one of "tcp", "tcp4" or "tcp6". Defaults to "tcp".
+	Network string
+
+	// Dialer is used to open connections. Defaults to a zero net.Dialer.
+	Dialer *net.Dialer
+
+	// Timeout bounds the time waiting for each response. Defaults to 1s.
+	Timeout time.Duration
+
+	// IdleTimeout controls how long idle connections are kept open for
+	// reuse. Defaults to 10s.
+	IdleTimeout time.Duration
+
+	// ParseMode controls how malformed responses are handled.
+	ParseMode ParseMode
+
+	streamTransport
+}
+
+// RoundTrip sends the query to the server and waits for its response.
+func (t *TCPTransport) RoundTrip(ctx context.Context, query Query, server netip.AddrPort) (Message, error) {
+	dialer := t.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return t.roundTrip(ctx, query, server, t.Network, dialer.DialContext, t.Timeout, t.IdleTimeout, t.ParseMode)
+}
+
+// TLSTransport sends queries over persistent TLS connections, known as DNS
+// over TLS (DoT), which are reused like TCPTransport's connections.
+// https://datatracker.ietf.org/doc/html/rfc7858
+type TLSTransport struct {
+	// Network is one of "tcp", "tcp4" or "tcp6". Defaults to "tcp".
+	Network string
+
+	// Dialer is used to open connections. Defaults to a zero net.Dialer.
+	Dialer *net.Dialer
+
+	// Config is the TLS configuration used for connections. Since servers
+	// are identified by address, it should normally set ServerName to the
+	// name the server's certificate was issued for.
+	Config *tls.Config
+
+	// Timeout bounds the time waiting for each response. Defaults to 1s.
+	Timeout time.Duration
+
+	// IdleTimeout controls how long idle connections are kept open for
+	// reuse. Defaults to 10s.
+	IdleTimeout time.Duration
+
+	// ParseMode controls how malformed responses are handled.
+	ParseMode ParseMode
+
+	streamTransport
+}
+
+// RoundTrip sends the query to the server and waits for its response.
+func (t *TLSTransport) RoundTrip(ctx context.Context, query Query, server netip.AddrPort) (Message, error) {
+	dialer := &tls.Dialer{NetDialer: t.Dialer, Config: t.Config}
+	return t.roundTrip(ctx, query, server, t.Network, dialer.DialContext, t.Timeout, t.IdleTimeout, t.ParseMode)
+}
+
+// streamTransport holds the connection pool shared by the transports over
+// stream networks, which is created on first use.
+type streamTransport struct {
+	mu   sync.Mutex
+	pool *connPool
+}
+
+// Close closes the transport's connections, failing any queries outstanding
+// on them. The transport remains usable, opening new connections as needed.
+func (t *streamTransport) Close() error {
+	t.mu.Lock()
+	pool := t.pool
+	t.mu.Unlock()
+	if pool != nil {
+		pool.closeAll()
+	}
+	return nil
+}
+
+func (t *streamTransport) roundTrip(ctx context.Context, query Query, server netip.AddrPort, network string, dial func(ctx context.Context, network, addr string) (net.Conn, error), timeout, idleTimeout time.Duration, mode ParseMode) (Message, error) {
+	if network == "" {
+		network = "tcp"
+	}
+	if timeout == 0 {
+		timeout = defaultQueryTimeout
+	}
+	if idleTimeout == 0 {
+		idleTimeout = defaultConnIdleTimeout
+	}
+	t.mu.Lock()
+	if t.pool == nil {
+		t.pool = newConnPool(dial, idleTimeout)
+	}
+	pool := t.pool
+	t.mu.Unlock()
+	resp, err := pool.exchange(ctx, network, server.String(), query.Encode(), timeout)
+	if err != nil {
+		return Message{}, err
+	}
+	return ParseMessage(resp, mode)
+}
+
+// HTTPSTransport sends queries to a DNS-over-HTTPS (DoH) endpoint. Since the
+// endpoint is identified by its URL, the server address given to RoundTrip
+// is ignored.
+// https://datatracker.ietf.org/doc/html/rfc8484
+type HTTPSTransport struct {
+	// URL is the endpoint's URL, e.g. "https://dns.google/dns-query".
+	URL string
+
+	// Client is used to send requests. Defaults to a client with a 1s
+	// timeout.
+	Client *http.Client
+
+	// ParseMode controls how malformed responses are handled.
+	ParseMode ParseMode
+}
+
+// RoundTrip sends the query to the endpoint and waits for its response.
+func (t *HTTPSTransport) RoundTrip(ctx context.Context, query Query, _ netip.AddrPort) (Message, error) {
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultQueryTimeout}
+	}
+	resp, err := exchangeHTTPS(ctx, client, t.URL, query.Encode())
+	if err != nil {
+		return Message{}, err
+	}
+	return ParseMessage(resp, t.ParseMode)
+}
+
+// isStreamNetwork returns true if the given network is connection-oriented,
+// in which case DNS messages must be framed with a 2 byte length prefix:
+// https://datatracker.ietf.org/doc/html/rfc1035#section-4.2.2
+func isStreamNetwork(network string) bool {
+	return strings.HasPrefix(network, "tcp")
+}
+
+// networkFamily returns "4" or "6" if the given network is restricted to a
+// single IP address family, or an empty string if either family may be used.
+func networkFamily(network string) string {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return "4"
+	case strings.HasSuffix(network, "6"):
+		return "6"
+	default:
+		return ""
+	}
+}
+
+// isTimeout returns true if the given error indicates that a query timed out
+// waiting for a response.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// exchangeDatagram sends a query to addr over a packet-oriented connection
+// and reads a single response message of at most maxSize bytes. Packets
+// arriving from any other address are dropped, since they cannot be a
+// legitimate response and may be an attempt at cache poisoning.
+func exchangeDatagram(conn net.PacketConn, addr *net.UDPAddr, query []byte, maxSize int) ([]byte, error) {
+	if _, err := conn.WriteTo(query, addr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if !isSameUDPAddr(from, addr) {
+			continue
+		}
+		return buf[:n], nil
+	}
+}
+
+// isSameUDPAddr returns true if the given address is the same UDP address
+// as want, treating IPv4 and IPv4-mapped IPv6 addresses as equivalent.
+func isSameUDPAddr(addr net.Addr, want *net.UDPAddr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	return ok && udpAddr.Port == want.Port && udpAddr.IP.Equal(want.IP)
+}
+
+// readStreamMessage reads a single length-prefixed message from r.
+func readStreamMessage(r io.Reader) ([]byte, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
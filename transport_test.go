@@ -0,0 +1,157 @@
+package dnstoy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+func TestExchangeDatagramDropsSpoofedResponses(t *testing.T) {
+	t.Parallel()
+
+	listen := func() net.PacketConn {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		be.NilErr(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	client, server, spoofer := listen(), listen(), listen()
+	client.SetDeadline(time.Now().Add(time.Second))
+
+	go func() {
+		buf := make([]byte, 512)
+		_, from, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// a forged response from another address arrives first
+		spoofer.WriteTo([]byte("forged"), from)
+		server.WriteTo([]byte("genuine"), from)
+	}()
+
+	resp, err := exchangeDatagram(client, server.LocalAddr().(*net.UDPAddr), []byte("query"), 512)
+	be.NilErr(t, err)
+	be.Equal(t, "genuine", string(resp))
+}
+
+// echoTransport answers every query with an A record, without any network
+// access.
+type echoTransport struct {
+	servers chan netip.AddrPort
+}
+
+func (t echoTransport) RoundTrip(ctx context.Context, query Query, server netip.AddrPort) (Message, error) {
+	t.servers <- server
+	name, err := decodeName(byteview.New(query.Question.Name))
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Header:    Header{ID: query.Header.ID, Flags: headerFlagQR},
+		Questions: []Question{{Name: name, Type: query.Question.Type, Class: query.Question.Class}},
+		Answers:   []Record{{Name: name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}},
+	}, nil
+}
+
+func TestCustomTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := echoTransport{servers: make(chan netip.AddrPort, 1)}
+	r := newTestResolver("5353", &Opts{Transport: transport})
+	ips, err := r.LookupIP(context.Background(), "ip4", "www.example.test")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(ips))
+	be.Equal(t, "1.2.3.4", ips[0].String())
+	be.Equal(t, "127.0.0.1:5353", (<-transport.servers).String())
+}
+
+// startStreamTestServer starts a DNS server on localhost that answers
+// length-prefixed queries on the given listener with the given handler.
+func startStreamTestServer(t *testing.T, ln net.Listener, handler testHandler) netip.AddrPort {
+	t.Helper()
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					buf, err := readStreamMessage(conn)
+					if err != nil {
+						return
+					}
+					query, err := parseMessage(byteview.New(buf))
+					if err != nil {
+						return
+					}
+					resp := handler(query)
+					resp.Header.ID = query.Header.ID
+					resp.Header.Flags |= headerFlagQR
+					resp.Questions = query.Questions
+					out := resp.Encode()
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(out))), out...))
+				}
+			}()
+		}
+	}()
+	return netip.MustParseAddrPort(ln.Addr().String())
+}
+
+func TestStreamTransports(t *testing.T) {
+	t.Parallel()
+
+	handler := func(q Message) Message {
+		return Message{Answers: []Record{{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}}}}
+	}
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		be.NilErr(t, err)
+		return ln
+	}
+
+	// borrow the certificate of a test HTTPS server, which is valid for
+	// 127.0.0.1
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(https.Close)
+	clientConfig := https.Client().Transport.(*http.Transport).TLSClientConfig
+
+	testCases := map[string]struct {
+		transport Transport
+		server    netip.AddrPort
+	}{
+		"TCP": {
+			transport: &TCPTransport{},
+			server:    startStreamTestServer(t, listen(), handler),
+		},
+		"TLS": {
+			transport: &TLSTransport{Config: clientConfig},
+			server:    startStreamTestServer(t, tls.NewListener(listen(), https.TLS), handler),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// connections are reused for subsequent queries
+			for i := 0; i < 2; i++ {
+				msg, err := tc.transport.RoundTrip(context.Background(), NewQuery("www.example.test", RecordTypeA), tc.server)
+				be.NilErr(t, err)
+				be.Equal(t, 1, len(msg.Answers))
+				be.Equal(t, "www.example.test", string(msg.Answers[0].Name))
+			}
+		})
+	}
+}
@@ -0,0 +1,617 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// TrustAnchor is a DS record for one of a zone's key signing keys that is
+// trusted without validation, from which DNSSEC validation of the zone and
+// its descendants can begin.
+type TrustAnchor struct {
+	Zone string
+	DS   DS
+}
+
+// RootTrustAnchors returns the built-in trust anchors for the root zone: the
+// 2017 root KSK and its successor, which was published in 2024.
+// https://data.iana.org/root-anchors/root-anchors.xml
+func RootTrustAnchors() []TrustAnchor {
+	return []TrustAnchor{
+		{Zone: ".", DS: DS{KeyTag: 20326, Algorithm: 8, DigestType: DigestTypeSHA256, Digest: mustDecodeHex("E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D")}},
+		{Zone: ".", DS: DS{KeyTag: 38696, Algorithm: 8, DigestType: DigestTypeSHA256, Digest: mustDecodeHex("683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16")}},
+	}
+}
+
+// LoadTrustAnchors loads trust anchors from the file at the given path. See
+// ParseTrustAnchors for the supported formats.
+func LoadTrustAnchors(path string) ([]TrustAnchor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	anchors, err := ParseTrustAnchors(data, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return anchors, nil
+}
+
+// ParseTrustAnchors parses trust anchors in one of the following formats,
+// which is detected automatically:
+//
+//   - IANA's root-anchors.xml format (RFC 9718), from which only the key
+//     digests valid at the given time are returned
+//   - BIND's trusted-keys and trust-anchors statements
+//   - DS and DNSKEY records in zone file format, one per line, as used by
+//     Unbound's trust-anchor-file
+//
+// DNSKEY anchors are converted to DS records using SHA-256 digests.
+func ParseTrustAnchors(data []byte, now time.Time) ([]TrustAnchor, error) {
+	var (
+		anchors []TrustAnchor
+		err     error
+	)
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		anchors, err = parseTrustAnchorXML(trimmed, now)
+	case bytes.Contains(trimmed, []byte("trusted-keys")) || bytes.Contains(trimmed, []byte("trust-anchors")):
+		anchors, err = parseBINDTrustAnchors(string(trimmed))
+	default:
+		anchors, err = parseZoneTrustAnchors(string(trimmed))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, errors.New("no trust anchors found")
+	}
+	return anchors, nil
+}
+
+// trustAnchorXML is the format of IANA's root-anchors.xml:
+// https://datatracker.ietf.org/doc/html/rfc9718
+type trustAnchorXML struct {
+	Zone       string `xml:"Zone"`
+	KeyDigests []struct {
+		ValidFrom  string `xml:"validFrom,attr"`
+		ValidUntil string `xml:"validUntil,attr"`
+		KeyTag     uint16 `xml:"KeyTag"`
+		Algorithm  uint8  `xml:"Algorithm"`
+		DigestType uint8  `xml:"DigestType"`
+		Digest     string `xml:"Digest"`
+	} `xml:"KeyDigest"`
+}
+
+func parseTrustAnchorXML(data []byte, now time.Time) ([]TrustAnchor, error) {
+	var doc trustAnchorXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid trust anchor XML: %w", err)
+	}
+	var anchors []TrustAnchor
+	for _, kd := range doc.KeyDigests {
+		if kd.ValidFrom != "" {
+			validFrom, err := time.Parse(time.RFC3339, kd.ValidFrom)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validFrom for key %d: %w", kd.KeyTag, err)
+			}
+			if now.Before(validFrom) {
+				continue
+			}
+		}
+		if kd.ValidUntil != "" {
+			validUntil, err := time.Parse(time.RFC3339, kd.ValidUntil)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validUntil for key %d: %w", kd.KeyTag, err)
+			}
+			if !now.Before(validUntil) {
+				continue
+			}
+		}
+		digest, err := hex.DecodeString(strings.TrimSpace(kd.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest for key %d: %w", kd.KeyTag, err)
+		}
+		anchors = append(anchors, TrustAnchor{
+			Zone: doc.Zone,
+			DS:   DS{KeyTag: kd.KeyTag, Algorithm: kd.Algorithm, DigestType: kd.DigestType, Digest: digest},
+		})
+	}
+	return anchors, nil
+}
+
+// parseBINDTrustAnchors parses the entries of trusted-keys and trust-anchors
+// statements in BIND's configuration format, ignoring other statements:
+//
+//	trusted-keys { "." 257 3 8 "AwEAAa...="; };
+//	trust-anchors { . initial-key 257 3 8 "AwEAAa...="; . static-ds 20326 8 2 "E06D..."; };
+func parseBINDTrustAnchors(data string) ([]TrustAnchor, error) {
+	tokens, err := tokenizeBINDConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	var anchors []TrustAnchor
+	for len(tokens) > 0 {
+		keyword := tokens[0]
+		tokens = tokens[1:]
+		if keyword != "trusted-keys" && keyword != "trust-anchors" {
+			continue
+		}
+		if len(tokens) == 0 || tokens[0] != "{" {
+			return nil, fmt.Errorf("expected { after %s", keyword)
+		}
+		tokens = tokens[1:]
+		for len(tokens) > 0 && tokens[0] != "}" {
+			end := indexOf(tokens, ";")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %s entry", keyword)
+			}
+			entry := tokens[:end]
+			tokens = tokens[end+1:]
+			if keyword == "trusted-keys" {
+				// trusted-keys entries are equivalent to static-key entries
+				if len(entry) < 5 {
+					return nil, fmt.Errorf("invalid %s entry: expected 5 fields, got %d", keyword, len(entry))
+				}
+				entry = append([]string{entry[0], "static-key"}, entry[1:]...)
+			}
+			anchor, err := parseTrustAnchorEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry: %w", keyword, err)
+			}
+			anchors = append(anchors, anchor)
+		}
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("unterminated %s statement", keyword)
+		}
+		tokens = tokens[1:]
+	}
+	return anchors, nil
+}
+
+// parseTrustAnchorEntry parses a single trust-anchors entry, consisting of
+// a zone, an anchor type and the anchor's DNSKEY or DS data.
+func parseTrustAnchorEntry(fields []string) (TrustAnchor, error) {
+	if len(fields) < 6 {
+		return TrustAnchor{}, fmt.Errorf("expected 6 fields, got %d", len(fields))
+	}
+	zone, anchorType := fields[0], fields[1]
+	data := strings.Join(fields[5:], "")
+	switch anchorType {
+	case "static-key", "initial-key":
+		return dnskeyTrustAnchor(zone, fields[2], fields[3], fields[4], data)
+	case "static-ds", "initial-ds":
+		return dsTrustAnchor(zone, fields[2], fields[3], fields[4], data)
+	default:
+		return TrustAnchor{}, fmt.Errorf("unknown anchor type %q", anchorType)
+	}
+}
+
+// tokenizeBINDConfig splits BIND configuration into words, quoted strings
+// and the punctuation characters {, } and ;, dropping comments.
+func tokenizeBINDConfig(data string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := strings.IndexByte(data[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			// whitespace within quoted keys and digests is insignificant
+			tokens = append(tokens, strings.Join(strings.Fields(data[i+1:i+1+end]), ""))
+			i += end + 2
+		default:
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n{};\"", rune(data[i])) {
+				i++
+			}
+			tokens = append(tokens, data[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// parseZoneTrustAnchors parses DS and DNSKEY records in zone file format,
+// e.g. ". IN DS 20326 8 2 E06D...", with an optional TTL and class.
+// Multi-line records are not supported.
+func parseZoneTrustAnchors(data string) ([]TrustAnchor, error) {
+	var anchors []TrustAnchor
+	for i, line := range strings.Split(data, "\n") {
+		if j := strings.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		zone := fields[0]
+		fields = fields[1:]
+		// skip the optional TTL and class, in either order
+		for len(fields) > 0 {
+			if _, err := strconv.ParseUint(fields[0], 10, 32); err == nil || strings.EqualFold(fields[0], "IN") {
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %d: expected DS or DNSKEY record", i+1)
+		}
+		var (
+			anchor TrustAnchor
+			err    error
+		)
+		switch strings.ToUpper(fields[0]) {
+		case "DS":
+			anchor, err = dsTrustAnchor(zone, fields[1], fields[2], fields[3], strings.Join(fields[4:], ""))
+		case "DNSKEY":
+			anchor, err = dnskeyTrustAnchor(zone, fields[1], fields[2], fields[3], strings.Join(fields[4:], ""))
+		default:
+			err = fmt.Errorf("unsupported record type %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, nil
+}
+
+func dsTrustAnchor(zone, keyTag, algorithm, digestType, digest string) (TrustAnchor, error) {
+	var ds DS
+	var err error
+	if ds.KeyTag, err = parseUint16(keyTag); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid key tag: %w", err)
+	}
+	if ds.Algorithm, err = parseUint8(algorithm); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid algorithm: %w", err)
+	}
+	if ds.DigestType, err = parseUint8(digestType); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid digest type: %w", err)
+	}
+	if ds.Digest, err = hex.DecodeString(digest); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid digest: %w", err)
+	}
+	return TrustAnchor{Zone: zone, DS: ds}, nil
+}
+
+func dnskeyTrustAnchor(zone, flags, protocol, algorithm, publicKey string) (TrustAnchor, error) {
+	var key DNSKEY
+	flags16, err := parseUint16(flags)
+	if err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid flags: %w", err)
+	}
+	key.Flags = flags16
+	if key.Protocol, err = parseUint8(protocol); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid protocol: %w", err)
+	}
+	if key.Algorithm, err = parseUint8(algorithm); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid algorithm: %w", err)
+	}
+	if key.PublicKey, err = base64.StdEncoding.DecodeString(publicKey); err != nil {
+		return TrustAnchor{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	ds, err := key.ToDS(zone, DigestTypeSHA256)
+	if err != nil {
+		return TrustAnchor{}, err
+	}
+	return TrustAnchor{Zone: zone, DS: ds}, nil
+}
+
+func parseUint8(s string) (uint8, error) {
+	n, err := strconv.ParseUint(s, 10, 8)
+	return uint8(n), err
+}
+
+func parseUint16(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint16(n), err
+}
+
+func indexOf(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Hold-down times for automated trust anchor updates:
+// https://datatracker.ietf.org/doc/html/rfc5011#section-2.4.1
+const (
+	addHoldDown    = 30 * 24 * time.Hour
+	removeHoldDown = 30 * 24 * time.Hour
+)
+
+// KeyState is the state of a key tracked by a TrustAnchorTracker:
+// https://datatracker.ietf.org/doc/html/rfc5011#section-4
+type KeyState int
+
+// Supported key states
+const (
+	KeyStateAddPending KeyState = iota + 1
+	KeyStateValid
+	KeyStateMissing
+	KeyStateRevoked
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyStateAddPending:
+		return "AddPend"
+	case KeyStateValid:
+		return "Valid"
+	case KeyStateMissing:
+		return "Missing"
+	case KeyStateRevoked:
+		return "Revoked"
+	default:
+		return fmt.Sprintf("KeyState(%d)", int(s))
+	}
+}
+
+type trackedKey struct {
+	Key   DNSKEY    `json:"key"`
+	State KeyState  `json:"state"`
+	Since time.Time `json:"since"` // when the key entered its current state
+}
+
+// TrustAnchorTracker implements automated updates of a zone's trust anchors
+// as described in RFC 5011, so that validation continues to work across
+// rollovers of the zone's key signing keys: new keys are trusted once they
+// have been consistently published for the add hold-down time, and revoked
+// keys are distrusted immediately. See Opts.TrustAnchorTracker.
+//
+// https://datatracker.ietf.org/doc/html/rfc5011
+type TrustAnchorTracker struct {
+	mu   sync.Mutex
+	zone string
+	keys []*trackedKey
+	now  func() time.Time // may be overridden in tests
+}
+
+// NewTrustAnchorTracker creates a tracker for the given zone's key signing
+// keys, trusting the given initial keys.
+func NewTrustAnchorTracker(zone string, initialKeys []DNSKEY) *TrustAnchorTracker {
+	t := &TrustAnchorTracker{zone: zone, now: time.Now}
+	now := t.now()
+	for _, key := range initialKeys {
+		t.keys = append(t.keys, &trackedKey{Key: key, State: KeyStateValid, Since: now})
+	}
+	return t
+}
+
+// Update applies a newly fetched DNSKEY RRset for the tracker's zone,
+// reporting whether the state of any key changed. The caller must have
+// validated the RRset's signature with a currently trusted key, and verified
+// that any revoked keys sign the RRset themselves.
+func (t *TrustAnchorTracker) Update(keys []DNSKEY) (changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	seen := make(map[*trackedKey]bool)
+	for _, key := range keys {
+		if !key.IsSEP() {
+			continue
+		}
+		tk := t.find(key)
+		if key.IsRevoked() {
+			// a key can only be revoked once it is trusted
+			if tk != nil && (tk.State == KeyStateValid || tk.State == KeyStateMissing) {
+				tk.Key, tk.State, tk.Since = key, KeyStateRevoked, now
+				changed = true
+			}
+			if tk != nil {
+				seen[tk] = true
+			}
+			continue
+		}
+		if tk == nil {
+			tk = &trackedKey{Key: key, State: KeyStateAddPending, Since: now}
+			t.keys = append(t.keys, tk)
+			changed = true
+		}
+		seen[tk] = true
+		switch tk.State {
+		case KeyStateAddPending:
+			if now.Sub(tk.Since) >= addHoldDown {
+				tk.State, tk.Since = KeyStateValid, now
+				changed = true
+			}
+		case KeyStateMissing:
+			tk.State, tk.Since = KeyStateValid, now
+			changed = true
+		}
+	}
+
+	kept := t.keys[:0]
+	for _, tk := range t.keys {
+		if !seen[tk] {
+			switch tk.State {
+			case KeyStateAddPending:
+				// pending keys that disappear are forgotten, and their
+				// hold-down restarts if they reappear
+				changed = true
+				continue
+			case KeyStateValid:
+				tk.State, tk.Since = KeyStateMissing, now
+				changed = true
+			}
+		}
+		if tk.State == KeyStateRevoked && now.Sub(tk.Since) >= removeHoldDown {
+			changed = true
+			continue
+		}
+		kept = append(kept, tk)
+	}
+	t.keys = kept
+	return changed
+}
+
+// find returns the tracked key with the same public key as the given key,
+// ignoring the revoke flag. The caller must hold t.mu.
+func (t *TrustAnchorTracker) find(key DNSKEY) *trackedKey {
+	for _, tk := range t.keys {
+		if tk.Key.Algorithm == key.Algorithm && bytes.Equal(tk.Key.PublicKey, key.PublicKey) {
+			return tk
+		}
+	}
+	return nil
+}
+
+// KeyStates returns the state of each tracked key, keyed by key tag.
+func (t *TrustAnchorTracker) KeyStates() map[uint16]KeyState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	states := make(map[uint16]KeyState, len(t.keys))
+	for _, tk := range t.keys {
+		states[tk.Key.KeyTag()] = tk.State
+	}
+	return states
+}
+
+// TrustAnchors returns the currently trusted keys as trust anchors.
+func (t *TrustAnchorTracker) TrustAnchors() []TrustAnchor {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var anchors []TrustAnchor
+	for _, tk := range t.keys {
+		if tk.State != KeyStateValid && tk.State != KeyStateMissing {
+			continue
+		}
+		if ds, err := tk.Key.ToDS(t.zone, DigestTypeSHA256); err == nil {
+			anchors = append(anchors, TrustAnchor{Zone: t.zone, DS: ds})
+		}
+	}
+	return anchors
+}
+
+// trustAnchorTrackerVersion identifies the format written by
+// TrustAnchorTracker.Save.
+const trustAnchorTrackerVersion = 1
+
+type trustAnchorTrackerSnapshot struct {
+	Version int           `json:"version"`
+	Zone    string        `json:"zone"`
+	Keys    []*trackedKey `json:"keys"`
+}
+
+// Save writes the tracker's state to w as JSON. The state must be persisted
+// across restarts for hold-down timers to work.
+func (t *TrustAnchorTracker) Save(w io.Writer) error {
+	t.mu.Lock()
+	snapshot := trustAnchorTrackerSnapshot{Version: trustAnchorTrackerVersion, Zone: t.zone, Keys: t.keys}
+	err := json.NewEncoder(w).Encode(snapshot)
+	t.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode trust anchor state: %w", err)
+	}
+	return nil
+}
+
+// LoadTrustAnchorTracker restores a tracker from state written by Save.
+func LoadTrustAnchorTracker(r io.Reader) (*TrustAnchorTracker, error) {
+	var snapshot trustAnchorTrackerSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode trust anchor state: %w", err)
+	}
+	if snapshot.Version != trustAnchorTrackerVersion {
+		return nil, fmt.Errorf("unsupported trust anchor state version %d", snapshot.Version)
+	}
+	return &TrustAnchorTracker{zone: snapshot.Zone, keys: snapshot.Keys, now: time.Now}, nil
+}
+
+// currentTrustAnchors returns the resolver's trust anchors, with those for
+// the tracked zone taken from the trust anchor tracker, if there is one.
+func (r *Resolver) currentTrustAnchors() []TrustAnchor {
+	if r.anchorTracker == nil {
+		return r.trustAnchors
+	}
+	anchors := r.anchorTracker.TrustAnchors()
+	for _, anchor := range r.trustAnchors {
+		if canonicalName(anchor.Zone) != canonicalName(r.anchorTracker.zone) {
+			anchors = append(anchors, anchor)
+		}
+	}
+	return anchors
+}
+
+// updateTrustAnchors applies a validated DNSKEY RRset for the tracked zone
+// to the trust anchor tracker, saving its state if it changed.
+func (r *Resolver) updateTrustAnchors(ctx context.Context, keys []DNSKEY) {
+	if !r.anchorTracker.Update(keys) || r.anchorStatePath == "" {
+		return
+	}
+	if err := r.saveTrustAnchorState(); err != nil {
+		r.log(ctx).Warn("failed to save trust anchor state", slog.String("path", r.anchorStatePath), slog.String("err", err.Error()))
+	}
+}
+
+// saveTrustAnchorState saves the trust anchor tracker's state, replacing
+// the state file atomically so that it is never left partially written.
+func (r *Resolver) saveTrustAnchorState() error {
+	r.anchorSaveMu.Lock()
+	defer r.anchorSaveMu.Unlock()
+	var buf bytes.Buffer
+	if err := r.anchorTracker.Save(&buf); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(r.anchorStatePath), filepath.Base(r.anchorStatePath)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.anchorStatePath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
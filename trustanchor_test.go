@@ -0,0 +1,263 @@
+package dnstoy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// rootKSK2017 is the public key of the root zone's 2017 key signing key.
+const rootKSK2017 = "AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU="
+
+func mustDecodeBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	be.NilErr(t, err)
+	return b
+}
+
+func TestRootTrustAnchorMatchesKSK(t *testing.T) {
+	t.Parallel()
+
+	key := DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: mustDecodeBase64(t, rootKSK2017)}
+	be.Equal(t, 20326, key.KeyTag())
+	be.True(t, key.IsSEP())
+	be.True(t, RootTrustAnchors()[0].DS.Matches(".", key))
+
+	// revoking a key changes its key tag
+	revoked := key
+	revoked.Flags |= DNSKEYFlagRevoke
+	be.True(t, revoked.KeyTag() != key.KeyTag())
+	be.False(t, RootTrustAnchors()[0].DS.Matches(".", revoked))
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	t.Parallel()
+
+	wantDigest := "e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d"
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		data      string
+		wantCount int
+		wantErr   string
+	}{
+		"root-anchors.xml": {
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<TrustAnchor id="380DC50D-484E-40D0-A3AE-68F2B18F61C7" source="http://data.iana.org/root-anchors/root-anchors.xml">
+<Zone>.</Zone>
+<KeyDigest id="Kjqmt7v" validFrom="2010-07-15T00:00:00+00:00" validUntil="2019-01-11T00:00:00+00:00">
+<KeyTag>19036</KeyTag>
+<Algorithm>8</Algorithm>
+<DigestType>2</DigestType>
+<Digest>49AAC11D7B6F6446702E54A1607371607A1A41855200FD2CE1CDDE32F24E8FB5</Digest>
+</KeyDigest>
+<KeyDigest id="Klajeyz" validFrom="2017-02-02T00:00:00+00:00">
+<KeyTag>20326</KeyTag>
+<Algorithm>8</Algorithm>
+<DigestType>2</DigestType>
+<Digest>E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D</Digest>
+</KeyDigest>
+</TrustAnchor>`,
+			wantCount: 1,
+		},
+		"trusted-keys": {
+			data: `// root KSK
+trusted-keys {
+	"." 257 3 8 "` + rootKSK2017[:100] + `
+		` + rootKSK2017[100:] + `";
+};
+options { dnssec-validation yes; };`,
+			wantCount: 1,
+		},
+		"trust-anchors": {
+			data: `trust-anchors {
+	. initial-key 257 3 8 "` + rootKSK2017 + `";
+	. static-ds 20326 8 2 "E06D44B80B8F1D39A95C0B0D
+		7C65D08458E880409BBC683457104237C7F8EC8D";
+};`,
+			wantCount: 2,
+		},
+		"zone file": {
+			data: `; root anchors
+. 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+. IN DNSKEY 257 3 8 ` + rootKSK2017,
+			wantCount: 2,
+		},
+		"empty": {
+			data:    "; nothing here",
+			wantErr: "no trust anchors found",
+		},
+		"unknown anchor type": {
+			data:    `trust-anchors { . other-key 257 3 8 "AwEAAQ=="; };`,
+			wantErr: `unknown anchor type "other-key"`,
+		},
+		"empty trusted-keys entry": {
+			data:    `trusted-keys { ; };`,
+			wantErr: "invalid trusted-keys entry: expected 5 fields, got 0",
+		},
+		"short trusted-keys entry": {
+			data:    `trusted-keys { . 257 3; };`,
+			wantErr: "invalid trusted-keys entry: expected 5 fields, got 3",
+		},
+		"empty trust-anchors entry": {
+			data:    `trust-anchors { ; };`,
+			wantErr: "invalid trust-anchors entry: expected 6 fields, got 0",
+		},
+		"invalid digest": {
+			data:    `. IN DS 20326 8 2 XYZ`,
+			wantErr: "line 1: invalid digest",
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			anchors, err := ParseTrustAnchors([]byte(tc.data), now)
+			if tc.wantErr != "" {
+				be.In(t, tc.wantErr, err.Error())
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tc.wantCount, len(anchors))
+			for _, anchor := range anchors {
+				be.Equal(t, ".", anchor.Zone)
+				be.Equal(t, 20326, anchor.DS.KeyTag)
+				be.Equal(t, wantDigest, hex.EncodeToString(anchor.DS.Digest))
+			}
+		})
+	}
+}
+
+func TestTrustAnchorTracker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldKey := DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: []byte("old key")}
+	newKey := DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: []byte("new key")}
+	zsk := DNSKEY{Flags: 256, Protocol: 3, Algorithm: 8, PublicKey: []byte("zone key")}
+	revokedOldKey := oldKey
+	revokedOldKey.Flags |= DNSKEYFlagRevoke
+
+	tracker := NewTrustAnchorTracker(".", []DNSKEY{oldKey})
+	tracker.now = func() time.Time { return now }
+	trusted := func() []uint16 {
+		var tags []uint16
+		for _, anchor := range tracker.TrustAnchors() {
+			tags = append(tags, anchor.DS.KeyTag)
+		}
+		return tags
+	}
+
+	// a new key is published, but not trusted until the hold-down time has
+	// passed
+	tracker.Update([]DNSKEY{oldKey, newKey, zsk})
+	be.Equal(t, KeyStateAddPending, tracker.KeyStates()[newKey.KeyTag()])
+	be.AllEqual(t, []uint16{oldKey.KeyTag()}, trusted())
+
+	now = now.Add(addHoldDown)
+	tracker.Update([]DNSKEY{oldKey, newKey, zsk})
+	be.Equal(t, KeyStateValid, tracker.KeyStates()[newKey.KeyTag()])
+	be.AllEqual(t, []uint16{oldKey.KeyTag(), newKey.KeyTag()}, trusted())
+
+	// the old key is revoked, and immediately distrusted
+	now = now.Add(24 * time.Hour)
+	tracker.Update([]DNSKEY{revokedOldKey, newKey, zsk})
+	be.Equal(t, KeyStateRevoked, tracker.KeyStates()[revokedOldKey.KeyTag()])
+	be.AllEqual(t, []uint16{newKey.KeyTag()}, trusted())
+
+	// and eventually forgotten
+	now = now.Add(removeHoldDown)
+	tracker.Update([]DNSKEY{newKey, zsk})
+	be.Equal(t, 1, len(tracker.KeyStates()))
+
+	// a trusted key that goes missing is still trusted
+	now = now.Add(24 * time.Hour)
+	tracker.Update(nil)
+	be.Equal(t, KeyStateMissing, tracker.KeyStates()[newKey.KeyTag()])
+	be.AllEqual(t, []uint16{newKey.KeyTag()}, trusted())
+
+	// state survives a round trip
+	var buf bytes.Buffer
+	be.NilErr(t, tracker.Save(&buf))
+	restored, err := LoadTrustAnchorTracker(strings.NewReader(buf.String()))
+	be.NilErr(t, err)
+	be.Equal(t, KeyStateMissing, restored.KeyStates()[newKey.KeyTag()])
+}
+
+func TestTrustAnchorTrackerPendingKeyRemoved(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldKey := DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: []byte("old key")}
+	newKey := DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: []byte("new key")}
+
+	tracker := NewTrustAnchorTracker(".", []DNSKEY{oldKey})
+	tracker.now = func() time.Time { return now }
+	tracker.Update([]DNSKEY{oldKey, newKey})
+
+	// the pending key disappears before the hold-down time has passed, so
+	// its hold-down restarts when it reappears
+	now = now.Add(addHoldDown / 2)
+	tracker.Update([]DNSKEY{oldKey})
+	now = now.Add(addHoldDown / 2)
+	tracker.Update([]DNSKEY{oldKey, newKey})
+	be.Equal(t, KeyStateAddPending, tracker.KeyStates()[newKey.KeyTag()])
+}
+
+func TestResolverTrustAnchorTracker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rootKey := newTestZoneKey(t, ".")
+	newKey := newTestZoneKey(t, ".")
+	staleKey := newTestZoneKey(t, ".")
+	staleDS, err := staleKey.key.ToDS(".", DigestTypeSHA256)
+	be.NilErr(t, err)
+
+	// the root zone publishes a new key signing key alongside the trusted
+	// one
+	port := startTestServer(t, func(q Message) Message {
+		name, typ := canonicalName(string(q.Questions[0].Name)), q.Questions[0].Type
+		signed := func(rrset ...Record) []Record {
+			return append(rrset, rootKey.sign(t, rrset, now.Add(-time.Hour), now.Add(time.Hour)))
+		}
+		switch {
+		case name == "" && typ == RecordTypeDNSKEY:
+			return Message{Answers: signed(rootKey.record(), newKey.record())}
+		case name == "www" && typ == RecordTypeA:
+			return Message{Answers: signed(Record{Name: []byte("www"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}})}
+		}
+		return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+	})
+
+	// the tracker's keys replace the static trust anchors for its zone
+	tracker := NewTrustAnchorTracker(".", []DNSKEY{rootKey.key})
+	statePath := filepath.Join(t.TempDir(), "anchors.json")
+	r := newTestResolver(port, &Opts{
+		DNSSEC:               true,
+		TrustAnchors:         []TrustAnchor{{Zone: ".", DS: staleDS}},
+		TrustAnchorTracker:   tracker,
+		TrustAnchorStatePath: statePath,
+	})
+	result, err := r.LookupIPResult(context.Background(), "www")
+	be.NilErr(t, err)
+	be.Equal(t, SecuritySecure, result.Status)
+
+	// the new key is pending, and the state was saved
+	be.Equal(t, KeyStateAddPending, tracker.KeyStates()[newKey.key.KeyTag()])
+	f, err := os.Open(statePath)
+	be.NilErr(t, err)
+	defer f.Close()
+	restored, err := LoadTrustAnchorTracker(f)
+	be.NilErr(t, err)
+	be.Equal(t, KeyStateAddPending, restored.KeyStates()[newKey.key.KeyTag()])
+	be.Equal(t, KeyStateValid, restored.KeyStates()[rootKey.key.KeyTag()])
+}
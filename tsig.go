@@ -0,0 +1,317 @@
+package dnstoy
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// TSIGKey is a secret shared with a name server to authenticate the
+// messages exchanged with it, e.g. for zone transfers, with TSIG:
+// https://datatracker.ietf.org/doc/html/rfc8945
+type TSIGKey struct {
+	Name string
+
+	// Algorithm is the HMAC algorithm: hmac-sha1, hmac-sha224, hmac-sha256,
+	// hmac-sha384 or hmac-sha512. Defaults to hmac-sha256.
+	Algorithm string
+
+	Secret []byte
+}
+
+// ParseTSIGKey parses a key given as [algorithm:]name:secret, with the
+// secret base64-encoded, as accepted by dig -y.
+func ParseTSIGKey(s string) (TSIGKey, error) {
+	parts := strings.Split(s, ":")
+	var key TSIGKey
+	switch len(parts) {
+	case 2:
+		key.Name = parts[0]
+	case 3:
+		key.Algorithm, key.Name = parts[0], parts[1]
+	default:
+		return TSIGKey{}, fmt.Errorf("invalid TSIG key %q: must be [algorithm:]name:secret", s)
+	}
+	if err := validateName(key.Name); err != nil || key.Name == "" {
+		return TSIGKey{}, fmt.Errorf("invalid TSIG key name %q", key.Name)
+	}
+	if _, err := key.hash(); err != nil {
+		return TSIGKey{}, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return TSIGKey{}, fmt.Errorf("invalid TSIG key secret: %w", err)
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// ErrTSIG is returned when a response's TSIG record is missing or invalid,
+// or the name server rejected the query's.
+var ErrTSIG = errors.New("TSIG verification failed")
+
+// tsigFudge is the clock skew allowed between the signer and the verifier,
+// in seconds, as recommended:
+// https://datatracker.ietf.org/doc/html/rfc8945#section-10
+const tsigFudge = 300
+
+// tsigErrorNames are the mnemonics of the errors a name server may report in
+// a TSIG record's error field:
+// https://datatracker.ietf.org/doc/html/rfc8945#section-4.3
+var tsigErrorNames = map[uint16]string{
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+	22: "BADTRUNC",
+}
+
+// algorithm returns the canonical name of the key's algorithm.
+func (k TSIGKey) algorithm() string {
+	if k.Algorithm == "" {
+		return "hmac-sha256"
+	}
+	return canonicalName(k.Algorithm)
+}
+
+func (k TSIGKey) hash() (func() hash.Hash, error) {
+	switch k.algorithm() {
+	case "hmac-sha1":
+		return sha1.New, nil
+	case "hmac-sha224":
+		return sha256.New224, nil
+	case "hmac-sha256":
+		return sha256.New, nil
+	case "hmac-sha384":
+		return sha512.New384, nil
+	case "hmac-sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", k.Algorithm)
+	}
+}
+
+// tsigRecord is the data of a TSIG record:
+// https://datatracker.ietf.org/doc/html/rfc8945#section-4.2
+type tsigRecord struct {
+	algorithm  string
+	timeSigned uint64 // 48 bits
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        uint16
+	other      []byte
+}
+
+func (t tsigRecord) encode() []byte {
+	out := encodeName(t.algorithm)
+	out = appendUint48(out, t.timeSigned)
+	out = binary.BigEndian.AppendUint16(out, t.fudge)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(t.mac)))
+	out = append(out, t.mac...)
+	out = binary.BigEndian.AppendUint16(out, t.originalID)
+	out = binary.BigEndian.AppendUint16(out, t.err)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(t.other)))
+	return append(out, t.other...)
+}
+
+func parseTSIGRecord(data []byte) (tsigRecord, error) {
+	v := byteview.New(data)
+	algorithm, err := decodeName(v)
+	if err != nil {
+		return tsigRecord{}, err
+	}
+	t := tsigRecord{algorithm: string(algorithm)}
+	fixed, err := v.Next(10) // time signed, fudge and MAC size
+	if err != nil {
+		return tsigRecord{}, err
+	}
+	t.timeSigned = uint64(binary.BigEndian.Uint16(fixed))<<32 | uint64(binary.BigEndian.Uint32(fixed[2:]))
+	t.fudge = binary.BigEndian.Uint16(fixed[6:])
+	if t.mac, err = v.Next(binary.BigEndian.Uint16(fixed[8:])); err != nil {
+		return tsigRecord{}, err
+	}
+	fixed, err = v.Next(6) // original ID, error and other length
+	if err != nil {
+		return tsigRecord{}, err
+	}
+	t.originalID = binary.BigEndian.Uint16(fixed)
+	t.err = binary.BigEndian.Uint16(fixed[2:])
+	if t.other, err = v.Next(binary.BigEndian.Uint16(fixed[4:])); err != nil {
+		return tsigRecord{}, err
+	}
+	if v.Offset() != len(data) {
+		return tsigRecord{}, errors.New("trailing data")
+	}
+	return t, nil
+}
+
+func appendUint48(b []byte, n uint64) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(n>>32)), binary.BigEndian.AppendUint32(nil, uint32(n))...)
+}
+
+// mac computes the MAC of a message, given in wire format without its TSIG
+// record, and of the TSIG record's variables. The MAC of a response covers
+// that of the request, or of the previous message of a multi-message
+// response, given as prior, and only the timers of subsequent messages are
+// covered, rather than all of the variables:
+// https://datatracker.ietf.org/doc/html/rfc8945#section-4.3
+func (k TSIGKey) mac(msg, prior []byte, t tsigRecord, timersOnly bool) ([]byte, error) {
+	newHash, err := k.hash()
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(newHash, k.Secret)
+	if prior != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(prior))))
+		h.Write(prior)
+	}
+	h.Write(msg)
+	var vars []byte
+	if !timersOnly {
+		vars = encodeName(canonicalName(k.Name))
+		vars = binary.BigEndian.AppendUint16(vars, uint16(ResourceClassANY))
+		vars = binary.BigEndian.AppendUint32(vars, 0) // TTL
+		vars = append(vars, encodeName(canonicalName(t.algorithm))...)
+	}
+	vars = appendUint48(vars, t.timeSigned)
+	vars = binary.BigEndian.AppendUint16(vars, t.fudge)
+	if !timersOnly {
+		vars = binary.BigEndian.AppendUint16(vars, t.err)
+		vars = binary.BigEndian.AppendUint16(vars, uint16(len(t.other)))
+		vars = append(vars, t.other...)
+	}
+	h.Write(vars)
+	return h.Sum(nil), nil
+}
+
+// sign signs a message given in wire format, returning it with a TSIG record
+// appended, and the record's MAC.
+func (k TSIGKey) sign(msg, prior []byte, timersOnly bool, now time.Time) ([]byte, []byte, error) {
+	t := tsigRecord{
+		algorithm:  k.algorithm(),
+		timeSigned: uint64(now.Unix()),
+		fudge:      tsigFudge,
+		originalID: binary.BigEndian.Uint16(msg),
+	}
+	mac, err := k.mac(msg, prior, t, timersOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.mac = mac
+	rec := Record{Name: []byte(k.Name), Type: RecordTypeTSIG, Class: ResourceClassANY, Data: t.encode()}
+	signed := append(append([]byte(nil), msg...), rec.Encode()...)
+	// the TSIG record is counted in the additional section
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(msg[10:])+1)
+	return signed, mac, nil
+}
+
+// splitTSIG returns a message given in wire format without its TSIG record,
+// which must be the last of its additional section, and with its original
+// ID, along with the record, if it has one.
+func splitTSIG(data []byte) ([]byte, Record, tsigRecord, bool, error) {
+	v := byteview.New(data)
+	header, err := parseHeader(v)
+	if err != nil {
+		return nil, Record{}, tsigRecord{}, false, err
+	}
+	if header.AdditionalCount == 0 {
+		return data, Record{}, tsigRecord{}, false, nil
+	}
+	for i := 0; i < int(header.QuestionCount); i++ {
+		if _, err := parseQuestion(v); err != nil {
+			return nil, Record{}, tsigRecord{}, false, err
+		}
+	}
+	records := int(header.AnswerCount) + int(header.AuthorityCount) + int(header.AdditionalCount)
+	for i := 0; i < records-1; i++ {
+		if _, err := parseRecord(v); err != nil {
+			return nil, Record{}, tsigRecord{}, false, err
+		}
+	}
+	offset := v.Offset()
+	rec, err := parseRecord(v)
+	if err != nil {
+		return nil, Record{}, tsigRecord{}, false, err
+	}
+	if rec.Type != RecordTypeTSIG {
+		return data, Record{}, tsigRecord{}, false, nil
+	}
+	t, err := parseTSIGRecord(rec.Data)
+	if err != nil {
+		return nil, Record{}, tsigRecord{}, false, fmt.Errorf("%w: malformed TSIG record: %w", ErrTSIG, err)
+	}
+	msg := append([]byte(nil), data[:offset]...)
+	binary.BigEndian.PutUint16(msg, t.originalID)
+	binary.BigEndian.PutUint16(msg[10:], header.AdditionalCount-1)
+	return msg, rec, t, true, nil
+}
+
+// tsigVerifier verifies the TSIG records of the messages of a response to a
+// signed query. Messages after the first of a multi-message response, such
+// as a zone transfer, may be left unsigned, and are covered by the MAC of
+// the next signed message:
+// https://datatracker.ietf.org/doc/html/rfc8945#section-5.3.1
+type tsigVerifier struct {
+	key      TSIGKey
+	prior    []byte // the MAC of the query or the last signed message
+	signed   int    // the number of signed messages verified
+	unsigned []byte // the messages since the last signed message
+	now      func() time.Time
+}
+
+// verify verifies a message of the response, given in wire format.
+func (v *tsigVerifier) verify(data []byte) error {
+	msg, rec, t, ok, err := splitTSIG(data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if v.signed == 0 {
+			return fmt.Errorf("%w: response is not signed", ErrTSIG)
+		}
+		v.unsigned = append(v.unsigned, msg...)
+		return nil
+	}
+	if canonicalName(string(rec.Name)) != canonicalName(v.key.Name) || canonicalName(t.algorithm) != v.key.algorithm() {
+		return fmt.Errorf("%w: signed with key %s (%s), expected %s (%s)", ErrTSIG, rec.Name, t.algorithm, v.key.Name, v.key.algorithm())
+	}
+	if t.err != 0 {
+		name, ok := tsigErrorNames[t.err]
+		if !ok {
+			name = fmt.Sprintf("error %d", t.err)
+		}
+		return fmt.Errorf("%w: name server rejected the query's signature: %s", ErrTSIG, name)
+	}
+	want, err := v.key.mac(append(v.unsigned, msg...), v.prior, t, v.signed > 0)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(want, t.mac) {
+		return fmt.Errorf("%w: bad signature", ErrTSIG)
+	}
+	now := v.now()
+	if skew := now.Unix() - int64(t.timeSigned); skew > int64(t.fudge) || -skew > int64(t.fudge) {
+		return fmt.Errorf("%w: signed at %s, more than %ds from now", ErrTSIG, time.Unix(int64(t.timeSigned), 0).UTC().Format(time.RFC3339), t.fudge)
+	}
+	v.prior, v.unsigned = t.mac, nil
+	v.signed++
+	return nil
+}
+
+// done returns an error if the last messages of the response were unsigned.
+func (v *tsigVerifier) done() error {
+	if v.unsigned != nil {
+		return fmt.Errorf("%w: last message of response is not signed", ErrTSIG)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package dnstoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestTSIGSign(t *testing.T) {
+	t.Parallel()
+
+	key := TSIGKey{Name: "transfer.key", Algorithm: "hmac-sha512", Secret: []byte("secret")}
+	signed, mac, err := key.sign(NewQuery("example.test", RecordTypeAXFR).Encode(), nil, false, time.Now())
+	be.NilErr(t, err)
+	msg, rec, tr, ok, err := splitTSIG(signed)
+	be.NilErr(t, err)
+	be.True(t, ok)
+	be.Equal(t, "transfer.key", string(rec.Name))
+	want, err := key.mac(msg, nil, tr, false)
+	be.NilErr(t, err)
+	be.DeepEqual(t, mac, want)
+	be.DeepEqual(t, mac, tr.mac)
+}
+
+func TestParseTSIGKey(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		in      string
+		want    TSIGKey
+		wantErr bool
+	}{
+		"default algorithm": {in: "key.example:c2VjcmV0", want: TSIGKey{Name: "key.example", Secret: []byte("secret")}},
+		"algorithm":         {in: "hmac-sha1:key.example:c2VjcmV0", want: TSIGKey{Name: "key.example", Algorithm: "hmac-sha1", Secret: []byte("secret")}},
+		"unknown algorithm": {in: "hmac-md5:key.example:c2VjcmV0", wantErr: true},
+		"bad secret":        {in: "key.example:!!!", wantErr: true},
+		"no secret":         {in: "key.example", wantErr: true},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseTSIGKey(tc.in)
+			if tc.wantErr {
+				be.True(t, err != nil)
+				return
+			}
+			be.NilErr(t, err)
+			be.DeepEqual(t, tc.want, got)
+		})
+	}
+}
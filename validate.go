@@ -0,0 +1,703 @@
+package dnstoy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// SecurityStatus is the DNSSEC security status of DNS data:
+// https://datatracker.ietf.org/doc/html/rfc4033#section-5
+type SecurityStatus int
+
+// Security statuses
+const (
+	// SecurityIndeterminate means that there is not enough information to
+	// decide whether the data should have been signed, e.g. because DNSSEC
+	// is disabled or no trust anchor covers the name.
+	SecurityIndeterminate SecurityStatus = iota
+
+	// SecurityInsecure means that there is proof that the data comes from a
+	// zone that is not signed, so it cannot be validated.
+	SecurityInsecure
+
+	// SecurityBogus means that the data should have been signed, but its
+	// signatures are missing, expired or invalid, e.g. because it was
+	// forged.
+	SecurityBogus
+
+	// SecuritySecure means that the data's signatures were validated by a
+	// chain of trust from a trust anchor.
+	SecuritySecure
+)
+
+func (s SecurityStatus) String() string {
+	switch s {
+	case SecurityIndeterminate:
+		return "indeterminate"
+	case SecurityInsecure:
+		return "insecure"
+	case SecurityBogus:
+		return "bogus"
+	case SecuritySecure:
+		return "secure"
+	default:
+		return fmt.Sprintf("SecurityStatus(%d)", int(s))
+	}
+}
+
+// combineStatus returns the status of data made up of parts with the given
+// statuses, which is only as good as its weakest part.
+func combineStatus(a, b SecurityStatus) SecurityStatus {
+	rank := func(s SecurityStatus) int {
+		switch s {
+		case SecurityBogus:
+			return 0
+		case SecurityIndeterminate:
+			return 1
+		case SecurityInsecure:
+			return 2
+		default:
+			return 3
+		}
+	}
+	if rank(a) < rank(b) {
+		return a
+	}
+	return b
+}
+
+// LookupResult is the result of a lookup made with LookupIPResult.
+type LookupResult struct {
+	IPs []net.IP
+
+	// Addrs are the resolved addresses, in the same order as IPs, along
+	// with their remaining TTLs.
+	Addrs []AddrTTL
+
+	// CanonicalName is the name that the addresses belong to, which differs
+	// from the name looked up if CNAMEs were followed. CNAMEs holds the
+	// CNAME records followed, in order.
+	CanonicalName string
+	CNAMEs        []Record
+
+	// Source is where the answer came from. If it came from a name server,
+	// NameServer and NameServerAddr identify the name server.
+	Source         AnswerSource
+	NameServer     string
+	NameServerAddr net.IP
+
+	// Status is the DNSSEC security status of the answer, which is the
+	// weakest of the statuses of the RRsets making it up. It is
+	// SecurityIndeterminate unless Opts.DNSSEC is set.
+	Status SecurityStatus
+
+	// RRSets describes the validation of each RRset in the answer,
+	// including any CNAMEs followed, in the order they were found.
+	RRSets []RRSetStatus
+
+	// Chain describes the zones in the chains of trust used to validate the
+	// answer, from the root down.
+	Chain []ZoneStatus
+}
+
+// AddrTTL is a resolved IP address and its TTL.
+type AddrTTL struct {
+	IP  net.IP
+	TTL time.Duration
+}
+
+// AnswerSource identifies where the answer to a lookup came from.
+type AnswerSource int
+
+// Answer sources
+const (
+	SourceNameServer AnswerSource = iota
+	SourceCache
+	SourceHosts
+)
+
+func (s AnswerSource) String() string {
+	switch s {
+	case SourceNameServer:
+		return "name server"
+	case SourceCache:
+		return "cache"
+	case SourceHosts:
+		return "hosts file"
+	default:
+		return fmt.Sprintf("AnswerSource(%d)", int(s))
+	}
+}
+
+// ZoneStatus describes the validation of a zone's keys.
+type ZoneStatus struct {
+	Zone   string
+	Status SecurityStatus
+
+	// DS are the zone's DS records, published by its parent zone, which
+	// identify its key signing keys. If TrustAnchor is set, they are the
+	// configured trust anchors instead.
+	DS          []DS
+	TrustAnchor bool
+
+	// Keys are the zone's DNSKEYs, and Signatures the RRSIGs covering them.
+	Keys       []DNSKEY
+	Signatures []RRSIG
+
+	// Err explains why the zone is not secure, if it is not.
+	Err error
+}
+
+// RRSetStatus describes the DNSSEC validation of a single RRset.
+type RRSetStatus struct {
+	Name   string
+	Type   RecordType
+	Status SecurityStatus
+
+	// Signatures are the RRSIGs covering the RRset.
+	Signatures []RRSIG
+
+	// Err explains why the RRset is not secure, if it is not.
+	Err error
+}
+
+// LookupIPResult resolves the given domain name like LookupIP, returning the
+// resolved IP addresses along with their TTLs, the CNAMEs followed, the name
+// server that answered and their DNSSEC security status. Validation requires
+// Opts.DNSSEC; answers are validated using Opts.TrustAnchors and
+// Opts.TrustAnchorTracker.
+//
+// Answers that fail validation are not returned: if the status is
+// SecurityBogus, the returned error wraps ErrBogus and the result holds the
+// validation details only.
+func (r *Resolver) LookupIPResult(ctx context.Context, domainName string, opts ...LookupOption) (LookupResult, error) {
+	return r.lookupIPResult(withLookupOpts(ctx, opts), domainName, RecordTypeA, r.dnssec)
+}
+
+// signedRRset is an RRset found while resolving a name, along with any
+// RRSIG records covering it.
+type signedRRset struct {
+	name    string
+	typ     RecordType
+	records []Record
+	sigs    []Record
+
+	// the NSEC and NSEC3 records, with their RRSIGs, that accompanied the
+	// RRset, which must prove that no name closer to the RRset's owner
+	// exists if the RRset was synthesized from a wildcard
+	denial []Record
+	// whether the RRset was answered from the cache, which does not keep
+	// those proofs
+	cached bool
+}
+
+// addAnswers records the RRsets in an answer section for validation, along
+// with the RRSIGs covering them and the NSEC and NSEC3 records in the
+// response's authority section.
+func (s *lookupState) addAnswers(records, authorities []Record) {
+	denial := denialRecords(authorities)
+	rrsets := groupRRsets(records)
+	for _, rec := range records {
+		key := NewCacheKey(string(rec.Name), rec.Type, rec.Class)
+		rrset, found := rrsets[key]
+		if !found || key.Type == RecordTypeRRSIG {
+			continue
+		}
+		delete(rrsets, key)
+		s.answers = append(s.answers, signedRRset{
+			name:    string(rec.Name),
+			typ:     rec.Type,
+			records: rrset,
+			sigs:    coveringSignatures(records, key.Name, key.Type),
+			denial:  denial,
+		})
+	}
+}
+
+// addCachedAnswer records an RRset answered from the cache for validation.
+func (s *lookupState) addCachedAnswer(name string, recordType RecordType, records, sigs []Record) {
+	s.answers = append(s.answers, signedRRset{name: name, typ: recordType, records: records, sigs: sigs, cached: true})
+}
+
+// coveringSignatures returns the RRSIG records covering the given RRset.
+func coveringSignatures(records []Record, name string, recordType RecordType) []Record {
+	var sigs []Record
+	for _, rec := range records {
+		if rec.Type != RecordTypeRRSIG || canonicalName(string(rec.Name)) != canonicalName(name) {
+			continue
+		}
+		if sig, err := parseRRSIG(rec.Data); err == nil && sig.TypeCovered == recordType {
+			sigs = append(sigs, rec)
+		}
+	}
+	return sigs
+}
+
+// cachedSignatures returns the cached RRSIG records covering the given RRset.
+func (r *Resolver) cachedSignatures(name string, recordType RecordType) []Record {
+	if r.cache == nil {
+		return nil
+	}
+	sigs, _ := r.cache.Get(NewCacheKey(name, RecordTypeRRSIG, ResourceClassIN))
+	return coveringSignatures(sigs, name, recordType)
+}
+
+// mergeSignatures combines newly received RRSIG records owned by a name with
+// those already cached for it, which may cover other RRsets owned by the
+// same name. Cached signatures covering the same RRsets are replaced.
+func (r *Resolver) mergeSignatures(key CacheKey, sigs []Record) []Record {
+	cached, found := r.cache.Get(key)
+	if !found {
+		return sigs
+	}
+	covered := make(map[RecordType]bool)
+	for _, rec := range sigs {
+		if sig, err := parseRRSIG(rec.Data); err == nil {
+			covered[sig.TypeCovered] = true
+		}
+	}
+	merged := append([]Record(nil), sigs...)
+	for _, rec := range cached {
+		if sig, err := parseRRSIG(rec.Data); err == nil && !covered[sig.TypeCovered] {
+			merged = append(merged, rec)
+		}
+	}
+	return merged
+}
+
+// validator validates the RRsets found during a lookup, following the chain
+// of trust from the configured trust anchors down to the zones that signed
+// them.
+type validator struct {
+	r       *Resolver
+	state   *lookupState
+	anchors []TrustAnchor
+	now     time.Time
+	zones   map[string]zoneKeys
+}
+
+// zoneKeys holds the DNSKEYs of a zone, which may only be used to validate
+// signatures if the status is SecuritySecure. Otherwise, the status explains
+// why not.
+type zoneKeys struct {
+	keys     []DNSKEY
+	ds       []DS    // the DS records authenticating the keys
+	anchored bool    // set if the DS records are trust anchors
+	sigs     []RRSIG // the signatures over the DNSKEY RRset
+	status   SecurityStatus
+	err      error
+}
+
+// maxValidationDepth bounds the number of zones whose keys are fetched to
+// validate a single RRset.
+const maxValidationDepth = 16
+
+// newValidator returns a validator for the RRsets found during a lookup.
+func (r *Resolver) newValidator(state *lookupState) *validator {
+	return &validator{
+		r:       r,
+		state:   state,
+		anchors: r.currentTrustAnchors(),
+		now:     time.Now(),
+		zones:   make(map[string]zoneKeys),
+	}
+}
+
+// validatorKey is the context key used to carry the validator whose lookups
+// of DS and DNSKEY RRsets are in progress, so that denials of existence
+// found by those lookups are verified with the same validator, which
+// detects loops in the chain of trust.
+type validatorKey struct{}
+
+// validate returns the security status of the answers found during a lookup.
+func (r *Resolver) validate(ctx context.Context, state *lookupState) (SecurityStatus, []RRSetStatus, []ZoneStatus) {
+	v := r.newValidator(state)
+	status := SecuritySecure
+	results := make([]RRSetStatus, 0, len(state.answers))
+	for _, rrset := range state.answers {
+		result := v.validateRRset(ctx, rrset)
+		r.log(ctx).Debug(
+			"validated RRset",
+			slog.String("name", result.Name),
+			slog.String("resource_type", result.Type.String()),
+			slog.String("status", result.Status.String()),
+		)
+		status = combineStatus(status, result.Status)
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		status = SecurityIndeterminate
+	}
+	return status, results, v.chain()
+}
+
+// chain describes the zones whose keys were fetched, from the root down.
+func (v *validator) chain() []ZoneStatus {
+	chain := make([]ZoneStatus, 0, len(v.zones))
+	for zone, zk := range v.zones {
+		if zone == "" {
+			zone = "."
+		}
+		chain = append(chain, ZoneStatus{
+			Zone:        zone,
+			Status:      zk.status,
+			DS:          zk.ds,
+			TrustAnchor: zk.anchored,
+			Keys:        zk.keys,
+			Signatures:  zk.sigs,
+			Err:         zk.err,
+		})
+	}
+	sort.Slice(chain, func(i, j int) bool {
+		if a, b := len(canonicalLabels(chain[i].Zone)), len(canonicalLabels(chain[j].Zone)); a != b {
+			return a < b
+		}
+		return compareCanonical(chain[i].Zone, chain[j].Zone) < 0
+	})
+	return chain
+}
+
+func (v *validator) validateRRset(ctx context.Context, rrset signedRRset) RRSetStatus {
+	result := RRSetStatus{Name: rrset.name, Type: rrset.typ, Signatures: parseSignatures(rrset.sigs)}
+	if len(result.Signatures) == 0 {
+		result.Status, result.Err = v.unsignedStatus(ctx, rrset.name)
+		return result
+	}
+
+	signer := result.Signatures[0].SignerName
+	if !isSubdomain(rrset.name, signer) {
+		result.Status, result.Err = SecurityBogus, fmt.Errorf("signer %s is not an ancestor of %s", signer, rrset.name)
+		return result
+	}
+	// a signature covering fewer labels than the owner has shows that the
+	// RRset was synthesized from a wildcard, but one can never cover more
+	// https://datatracker.ietf.org/doc/html/rfc4035#section-5.3.1
+	labels := rrsigLabels(rrset.name)
+	wildcardLabels := labels
+	for _, sig := range result.Signatures {
+		if int(sig.Labels) > labels {
+			result.Status, result.Err = SecurityBogus, fmt.Errorf("signature by key %d covers %d labels, but %s has %d", sig.KeyTag, sig.Labels, rrset.name, labels)
+			return result
+		}
+		if int(sig.Labels) < wildcardLabels {
+			wildcardLabels = int(sig.Labels)
+		}
+	}
+	zk := v.zoneKeys(ctx, signer, 0)
+	if zk.status != SecuritySecure {
+		result.Status, result.Err = zk.status, zk.err
+		return result
+	}
+	if err := v.verify(signer, zk.keys, rrset.records, result.Signatures); err != nil {
+		result.Status, result.Err = SecurityBogus, err
+		return result
+	}
+	if wildcardLabels < labels {
+		result.Status, result.Err = v.verifyWildcardAnswer(signer, zk.keys, rrset, wildcardLabels)
+		return result
+	}
+	result.Status = SecuritySecure
+	return result
+}
+
+// verifyWildcardAnswer checks the proof that accompanied an RRset
+// synthesized from the wildcard below the owner's ancestor with the given
+// number of labels, which must show that no name closer to the owner exists:
+// https://datatracker.ietf.org/doc/html/rfc4035#section-5.3.4
+func (v *validator) verifyWildcardAnswer(zone string, keys []DNSKEY, rrset signedRRset, wildcardLabels int) (SecurityStatus, error) {
+	if rrset.cached {
+		return SecurityIndeterminate, fmt.Errorf("%s was synthesized from a wildcard, and the proof that no closer name exists is not cached", rrset.name)
+	}
+	if err := v.verifyDenialRecords(zone, keys, rrset.denial); err != nil {
+		return SecurityBogus, fmt.Errorf("proof that no name closer to %s exists: %w", rrset.name, err)
+	}
+	// the wildcard's parent is the closest encloser of the owner
+	labels := strings.Split(canonicalName(rrset.name), ".")
+	ce := strings.Join(labels[len(labels)-wildcardLabels:], ".")
+	proof, err := newDenialProof(zone, rrset.denial)
+	if err == nil {
+		err = proof.verifyNoCloserMatch(rrset.name, ce)
+	}
+	if err != nil {
+		return SecurityBogus, fmt.Errorf("proof that no name closer to %s exists: %w", rrset.name, err)
+	}
+	return SecuritySecure, nil
+}
+
+// unsignedStatus returns the status of an unsigned RRset owned by the given
+// name, which is insecure if the zone containing the name is provably
+// unsigned and bogus if it is signed. Zone cuts are only known for names
+// resolved iteratively, so answers from the cache or from upstreams are
+// indeterminate.
+func (v *validator) unsignedStatus(ctx context.Context, name string) (SecurityStatus, error) {
+	var zone string
+	found := false
+	for _, d := range v.state.delegations {
+		if isSubdomain(name, d.zone) && (!found || len(canonicalName(d.zone)) > len(zone)) {
+			zone, found = canonicalName(d.zone), true
+		}
+	}
+	if !found {
+		return SecurityIndeterminate, fmt.Errorf("%s is not signed, and its zone is unknown", name)
+	}
+	zk := v.zoneKeys(ctx, zone, 0)
+	switch zk.status {
+	case SecuritySecure:
+		return SecurityBogus, fmt.Errorf("%s is not signed, but zone %s is", name, zone)
+	case SecurityInsecure:
+		return SecurityInsecure, nil
+	default:
+		return zk.status, zk.err
+	}
+}
+
+// zoneKeys returns the validated DNSKEYs of the given zone, which are
+// authenticated by the zone's DS records, which are in turn signed by the
+// parent zone's keys, up to a trust anchor.
+func (v *validator) zoneKeys(ctx context.Context, zone string, depth int) zoneKeys {
+	zone = canonicalName(zone)
+	if zk, found := v.zones[zone]; found {
+		return zk
+	}
+	if depth > maxValidationDepth {
+		return zoneKeys{status: SecurityBogus, err: fmt.Errorf("chain of trust for %s is too long", zone)}
+	}
+	// guard against loops while the parent's keys are fetched
+	v.zones[zone] = zoneKeys{status: SecurityBogus, err: fmt.Errorf("chain of trust for %s loops", zone)}
+	zk := v.fetchZoneKeys(ctx, zone, depth)
+	v.zones[zone] = zk
+	return zk
+}
+
+func (v *validator) fetchZoneKeys(ctx context.Context, zone string, depth int) zoneKeys {
+	dsSet, zk := v.delegationDS(ctx, zone, depth)
+	if zk.status != SecuritySecure {
+		return zk
+	}
+	zk = zoneKeys{ds: dsSet, anchored: v.isAnchored(zone)}
+	supported := dsSet[:0:0]
+	for _, ds := range dsSet {
+		if v.r.algorithms.supportsDS(ds) {
+			supported = append(supported, ds)
+		}
+	}
+	if len(supported) == 0 {
+		zk.status, zk.err = SecurityInsecure, fmt.Errorf("zone %s is signed with unsupported algorithms", zone)
+		return zk
+	}
+
+	keyRecords, keySigs, err := v.lookupRRset(ctx, zone, RecordTypeDNSKEY)
+	if err != nil {
+		zk.status, zk.err = SecurityBogus, fmt.Errorf("failed to fetch DNSKEYs for %s: %w", zone, err)
+		return zk
+	}
+	zk.sigs = parseSignatures(keySigs)
+	var entryKeys []DNSKEY
+	for _, rec := range keyRecords {
+		key, err := parseDNSKEY(rec.Data)
+		if err != nil || key.Flags&DNSKEYFlagZone == 0 || key.IsRevoked() {
+			continue
+		}
+		zk.keys = append(zk.keys, key)
+		for _, ds := range supported {
+			if ds.Matches(zone, key) {
+				entryKeys = append(entryKeys, key)
+				break
+			}
+		}
+	}
+	if len(entryKeys) == 0 {
+		zk.status, zk.err = SecurityBogus, fmt.Errorf("no DNSKEY for %s matches its DS records", zone)
+		return zk
+	}
+	if err := v.verify(zone, entryKeys, keyRecords, zk.sigs); err != nil {
+		zk.status, zk.err = SecurityBogus, fmt.Errorf("DNSKEYs for %s: %w", zone, err)
+		return zk
+	}
+	zk.status = SecuritySecure
+	if t := v.r.anchorTracker; t != nil && zk.anchored && canonicalName(t.zone) == canonicalName(zone) {
+		v.r.updateTrustAnchors(ctx, v.trackedKeys(zone, keyRecords, zk.sigs))
+	}
+	return zk
+}
+
+// trackedKeys returns the keys in a validated DNSKEY RRset to pass to the
+// trust anchor tracker. Revoked keys are only included if they sign the
+// RRset themselves:
+// https://datatracker.ietf.org/doc/html/rfc5011#section-2.1
+func (v *validator) trackedKeys(zone string, keyRecords []Record, sigs []RRSIG) []DNSKEY {
+	var keys []DNSKEY
+	for _, rec := range keyRecords {
+		key, err := parseDNSKEY(rec.Data)
+		if err != nil {
+			continue
+		}
+		if key.IsRevoked() && v.verify(zone, []DNSKEY{key}, keyRecords, sigs) != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (v *validator) isAnchored(zone string) bool {
+	for _, anchor := range v.anchors {
+		if canonicalName(anchor.Zone) == canonicalName(zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// delegationDS returns the authenticated DS records for the given zone, from
+// a trust anchor or the parent zone. If there are none, the returned status
+// explains why.
+func (v *validator) delegationDS(ctx context.Context, zone string, depth int) ([]DS, zoneKeys) {
+	var anchored []DS
+	for _, anchor := range v.anchors {
+		if canonicalName(anchor.Zone) == zone {
+			anchored = append(anchored, anchor.DS)
+		}
+	}
+	if len(anchored) > 0 {
+		return anchored, zoneKeys{status: SecuritySecure}
+	}
+
+	// prefer the DS records, or proof that there are none, found while
+	// following referrals
+	var records, sigs []Record
+	for _, d := range v.state.delegations {
+		if canonicalName(d.zone) != zone {
+			continue
+		}
+		if len(d.ds) == 0 && len(d.denial) > 0 {
+			return nil, v.verifyNoDS(ctx, d, depth)
+		}
+		records, sigs = filterRecords(d.ds, RecordTypeDS), filterRecords(d.ds, RecordTypeRRSIG)
+	}
+	if len(sigs) == 0 {
+		var err error
+		records, sigs, err = v.lookupRRset(ctx, zone, RecordTypeDS)
+		if errors.Is(err, ErrBogus) {
+			return nil, zoneKeys{status: SecurityBogus, err: err}
+		}
+		if err != nil {
+			return nil, zoneKeys{status: SecurityIndeterminate, err: fmt.Errorf("failed to fetch DS records for %s: %w", zone, err)}
+		}
+	}
+	signatures := parseSignatures(sigs)
+	if len(signatures) == 0 {
+		return nil, zoneKeys{status: SecurityIndeterminate, err: fmt.Errorf("DS records for %s are not signed", zone)}
+	}
+	parent := canonicalName(signatures[0].SignerName)
+	if parent == zone || !isSubdomain(zone, parent) {
+		return nil, zoneKeys{status: SecurityBogus, err: fmt.Errorf("DS records for %s signed by %s", zone, parent)}
+	}
+	parentKeys := v.zoneKeys(ctx, parent, depth+1)
+	if parentKeys.status != SecuritySecure {
+		return nil, parentKeys
+	}
+	if err := v.verify(parent, parentKeys.keys, records, signatures); err != nil {
+		return nil, zoneKeys{status: SecurityBogus, err: fmt.Errorf("DS records for %s: %w", zone, err)}
+	}
+	var dsSet []DS
+	for _, rec := range records {
+		if ds, err := parseDS(rec.Data); err == nil {
+			dsSet = append(dsSet, ds)
+		}
+	}
+	return dsSet, zoneKeys{status: SecuritySecure}
+}
+
+// verifyNoDS checks the proof in a referral that the child zone has no DS
+// records, and so is insecure. The proof must be signed by the parent zone.
+func (v *validator) verifyNoDS(ctx context.Context, d delegation, depth int) zoneKeys {
+	parentKeys := v.zoneKeys(ctx, d.parent, depth+1)
+	if parentKeys.status != SecuritySecure {
+		return parentKeys
+	}
+	if err := v.verifyDenialRecords(d.parent, parentKeys.keys, d.denial); err != nil {
+		return zoneKeys{status: SecurityBogus, err: fmt.Errorf("proof that %s has no DS records: %w", d.zone, err)}
+	}
+	proof, err := newDenialProof(d.parent, d.denial)
+	if err == nil {
+		err = proof.verifyNoData(d.zone, RecordTypeDS)
+	}
+	if err != nil {
+		return zoneKeys{status: SecurityBogus, err: fmt.Errorf("proof that %s has no DS records: %w", d.zone, err)}
+	}
+	return zoneKeys{status: SecurityInsecure, err: fmt.Errorf("zone %s is not signed", d.zone)}
+}
+
+// verifyDenialRecords checks that each NSEC and NSEC3 RRset among the given
+// records is signed by one of the given keys of the zone.
+func (v *validator) verifyDenialRecords(zone string, keys []DNSKEY, records []Record) error {
+	for key, rrset := range groupRRsets(records) {
+		if key.Type != RecordTypeNSEC && key.Type != RecordTypeNSEC3 {
+			continue
+		}
+		sigs := parseSignatures(coveringSignatures(records, key.Name, key.Type))
+		if err := v.verify(zone, keys, rrset, sigs); err != nil {
+			return fmt.Errorf("%s %s: %w", key.Name, key.Type, err)
+		}
+	}
+	return nil
+}
+
+// verify checks that at least one of the given signatures over an RRset was
+// made by one of the given keys of the signing zone, and is currently valid.
+func (v *validator) verify(zone string, keys []DNSKEY, rrset []Record, sigs []RRSIG) error {
+	err := fmt.Errorf("no valid signatures by %s", zone)
+	for _, sig := range sigs {
+		if canonicalName(sig.SignerName) != canonicalName(zone) {
+			continue
+		}
+		if !sig.ValidAt(v.now) {
+			err = fmt.Errorf("signature by key %d has expired or is not yet valid", sig.KeyTag)
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err = sig.verify(v.r.algorithms, key, rrset); err == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// lookupRRset resolves an RRset needed for validation, returning it along
+// with the RRSIG records covering it.
+func (v *validator) lookupRRset(ctx context.Context, name string, recordType RecordType) ([]Record, []Record, error) {
+	state := newLookupState()
+	ctx = context.WithValue(ctx, validatorKey{}, v)
+	records, _, err := v.r.doLookup(ctx, state, v.r.startingNameServers(ctx, name), name, recordType, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rrset := range state.answers {
+		if rrset.typ == recordType && canonicalName(rrset.name) == canonicalName(name) {
+			return rrset.records, rrset.sigs, nil
+		}
+	}
+	return records, nil, nil
+}
+
+func parseSignatures(records []Record) []RRSIG {
+	sigs := make([]RRSIG, 0, len(records))
+	for _, rec := range records {
+		if sig, err := parseRRSIG(rec.Data); err == nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
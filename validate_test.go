@@ -0,0 +1,451 @@
+package dnstoy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+)
+
+// testZoneKey is a DNSSEC key used to sign test zones.
+type testZoneKey struct {
+	zone string
+	key  DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZoneKey(t *testing.T, zone string) testZoneKey {
+	return newTestZoneKeyWithAlgorithm(t, zone, AlgorithmRSASHA256)
+}
+
+func newTestZoneKeyWithAlgorithm(t *testing.T, zone string, algorithm uint8) testZoneKey {
+	t.Helper()
+	var (
+		priv crypto.Signer
+		pub  []byte
+		err  error
+	)
+	switch algorithm {
+	case AlgorithmRSASHA256:
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		priv = key
+		// exponent length, exponent and modulus
+		// https://datatracker.ietf.org/doc/html/rfc3110#section-2
+		if err == nil {
+			pub = append([]byte{3, 0x01, 0x00, 0x01}, key.N.Bytes()...)
+		}
+	case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+		curve := elliptic.P256()
+		if algorithm == AlgorithmECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		var key *ecdsa.PrivateKey
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+		priv = key
+		if err == nil {
+			size := curve.Params().BitSize / 8
+			pub = append(key.X.FillBytes(make([]byte, size)), key.Y.FillBytes(make([]byte, size))...)
+		}
+	case AlgorithmED25519:
+		var key ed25519.PrivateKey
+		pub, key, err = ed25519.GenerateKey(rand.Reader)
+		priv = key
+	default:
+		t.Fatalf("unsupported algorithm %d", algorithm)
+	}
+	be.NilErr(t, err)
+	return testZoneKey{
+		zone: zone,
+		key:  DNSKEY{Flags: DNSKEYFlagZone | DNSKEYFlagSEP, Protocol: 3, Algorithm: algorithm, PublicKey: pub},
+		priv: priv,
+	}
+}
+
+func (k testZoneKey) record() Record {
+	return Record{Name: []byte(k.zone), Type: RecordTypeDNSKEY, Class: ResourceClassIN, TTL: 60, Data: k.key.Encode()}
+}
+
+// sign returns an RRSIG record covering the given RRset, valid from
+// inception until expiration.
+func (k testZoneKey) sign(t *testing.T, rrset []Record, inception, expiration time.Time) Record {
+	t.Helper()
+	var labels uint8
+	if name := canonicalName(string(rrset[0].Name)); name != "" {
+		labels = uint8(strings.Count(name, ".") + 1)
+	}
+	return k.signWithLabels(t, rrset, labels, inception, expiration)
+}
+
+// signWithLabels returns an RRSIG record covering the given RRset with the
+// given number of labels, which is fewer than the owner has if the RRset was
+// synthesized from a wildcard.
+func (k testZoneKey) signWithLabels(t *testing.T, rrset []Record, labels uint8, inception, expiration time.Time) Record {
+	t.Helper()
+	sig := RRSIG{
+		TypeCovered: rrset[0].Type,
+		Algorithm:   k.key.Algorithm,
+		Labels:      labels,
+		OriginalTTL: rrset[0].TTL,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      k.key.KeyTag(),
+		SignerName:  k.zone,
+	}
+	sig.Signature = k.signData(t, sig.signedData(rrset))
+	return Record{Name: rrset[0].Name, Type: RecordTypeRRSIG, Class: ResourceClassIN, TTL: rrset[0].TTL, Data: sig.Encode()}
+}
+
+// signData signs data in the format used by RRSIG records for the key's
+// algorithm.
+func (k testZoneKey) signData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	switch priv := k.priv.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(priv, data)
+	case *ecdsa.PrivateKey:
+		hash := crypto.SHA256
+		if priv.Curve == elliptic.P384() {
+			hash = crypto.SHA384
+		}
+		h := hash.New()
+		h.Write(data)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, h.Sum(nil))
+		be.NilErr(t, err)
+		size := priv.Curve.Params().BitSize / 8
+		return append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	default:
+		digest := sha256.Sum256(data)
+		signature, err := k.priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+		be.NilErr(t, err)
+		return signature
+	}
+}
+
+func TestRRSIGVerify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	key := newTestZoneKey(t, "example.com")
+	rrset := []Record{
+		{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}},
+		{Name: []byte("www.example.com"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{5, 6, 7, 8}},
+	}
+	sigRecord := key.sign(t, rrset, now.Add(-time.Hour), now.Add(time.Hour))
+	sig, err := parseRRSIG(sigRecord.Data)
+	be.NilErr(t, err)
+	be.True(t, sig.ValidAt(now))
+	be.False(t, sig.ValidAt(now.Add(2*time.Hour)))
+	be.False(t, sig.ValidAt(now.Add(-2*time.Hour)))
+
+	// the signature covers the RRset regardless of the order and case of
+	// its records, or their current TTLs
+	reordered := []Record{rrset[1], rrset[0]}
+	reordered[0].Name = []byte("WWW.Example.com")
+	reordered[1].TTL = 30
+	be.NilErr(t, sig.Verify(key.key, rrset))
+	be.NilErr(t, sig.Verify(key.key, reordered))
+
+	tampered := []Record{rrset[0], rrset[1]}
+	tampered[1].Data = []byte{5, 6, 7, 9}
+	be.Nonzero(t, sig.Verify(key.key, tampered))
+
+	otherKey := newTestZoneKey(t, "example.com")
+	be.Nonzero(t, sig.Verify(otherKey.key, rrset))
+}
+
+func TestRRSIGVerifyCanonicalNames(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	key := newTestZoneKey(t, "example.com")
+	serial := []byte{0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, 5}
+
+	// names in the data of these records are lowercased in the canonical
+	// form that signatures cover, so they verify whatever their case
+	testCases := map[string]struct {
+		typ        RecordType
+		data, want []byte
+	}{
+		"MX": {
+			typ:  RecordTypeMX,
+			data: append([]byte{0, 10}, encodeName("Mail.EXAMPLE.com")...),
+			want: append([]byte{0, 10}, encodeName("mail.example.com")...),
+		},
+		"SRV": {
+			typ:  RecordTypeSRV,
+			data: append([]byte{0, 1, 0, 2, 0, 53}, encodeName("NS1.Example.com")...),
+			want: append([]byte{0, 1, 0, 2, 0, 53}, encodeName("ns1.example.com")...),
+		},
+		"SOA": {
+			typ:  RecordTypeSOA,
+			data: append(append(encodeName("NS1.Example.com"), encodeName("Hostmaster.Example.com")...), serial...),
+			want: append(append(encodeName("ns1.example.com"), encodeName("hostmaster.example.com")...), serial...),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rec := Record{Name: []byte("example.com"), Type: tc.typ, Class: ResourceClassIN, TTL: 60, Data: tc.data}
+			be.Equal(t, string(tc.want), string(canonicalRecordData(rec)))
+
+			lower := rec
+			lower.Data = tc.want
+			sig, err := parseRRSIG(key.sign(t, []Record{lower}, now.Add(-time.Hour), now.Add(time.Hour)).Data)
+			be.NilErr(t, err)
+			be.NilErr(t, sig.Verify(key.key, []Record{rec}))
+		})
+	}
+}
+
+func TestLookupIPResult(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rootKey := newTestZoneKey(t, ".")
+	testKey := newTestZoneKeyWithAlgorithm(t, "test", AlgorithmECDSAP256SHA256)
+	anchor, err := rootKey.key.ToDS(".", DigestTypeSHA256)
+	be.NilErr(t, err)
+	testDS, err := testKey.key.ToDS("test", DigestTypeSHA256)
+	be.NilErr(t, err)
+	withoutECDSA := DefaultAlgorithms()
+	delete(withoutECDSA, AlgorithmECDSAP256SHA256)
+
+	// a single server is authoritative for the signed root and test zones,
+	// and delegates insecure.test without DS records
+	type serverOpts struct {
+		expiration time.Time
+		tamper     bool
+		labels     uint8 // the labels of the signature over the answer
+		noProof    bool  // omit the proof that accompanies wildcard answers
+	}
+	newServer := func(opts serverOpts) string {
+		var mu sync.Mutex
+		referred := false
+		return startTestServer(t, func(q Message) Message {
+			mu.Lock()
+			defer mu.Unlock()
+			name, typ := canonicalName(string(q.Questions[0].Name)), q.Questions[0].Type
+			signed := func(key testZoneKey, rrset ...Record) []Record {
+				return append(rrset, key.sign(t, rrset, now.Add(-time.Hour), opts.expiration))
+			}
+			switch {
+			case name == "" && typ == RecordTypeDNSKEY:
+				return Message{Answers: signed(rootKey, rootKey.record())}
+			case name == "test" && typ == RecordTypeDS:
+				ds := Record{Name: []byte("test"), Type: RecordTypeDS, Class: ResourceClassIN, TTL: 60, Data: testDS.Encode()}
+				return Message{Answers: signed(rootKey, ds)}
+			case name == "test" && typ == RecordTypeDNSKEY:
+				return Message{Answers: signed(testKey, testKey.record())}
+			case name == "www.test" && typ == RecordTypeA:
+				answers := signed(testKey, Record{Name: []byte("www.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}})
+				if opts.labels != 0 {
+					answers[1] = testKey.signWithLabels(t, answers[:1], opts.labels, now.Add(-time.Hour), opts.expiration)
+				}
+				if opts.tamper {
+					answers[0].Data = []byte{6, 6, 6, 6}
+				}
+				return Message{Answers: answers}
+			case strings.HasSuffix(name, ".wild.test") && typ == RecordTypeA:
+				// synthesized from *.wild.test, with an NSEC record proving
+				// that the name does not exist
+				labels := opts.labels
+				if labels == 0 {
+					labels = 2
+				}
+				rec := Record{Name: q.Questions[0].Name, Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{9, 9, 9, 9}}
+				resp := Message{Answers: []Record{rec, testKey.signWithLabels(t, []Record{rec}, labels, now.Add(-time.Hour), opts.expiration)}}
+				if !opts.noProof {
+					resp.Authorities = signed(testKey, nsecRecord("*.wild.test", "www.test", RecordTypeA, RecordTypeRRSIG, RecordTypeNSEC))
+				}
+				return resp
+			case name == "www.insecure.test" && !referred:
+				referred = true
+				resp := referral("insecure.test", "ns1.insecure.test")
+				nsec := nsecRecord("insecure.test", "www.test", RecordTypeNS, RecordTypeRRSIG, RecordTypeNSEC)
+				resp.Authorities = append(resp.Authorities, signed(rootKey, nsec)...)
+				return resp
+			case name == "www.insecure.test":
+				return Message{Answers: []Record{{Name: []byte("www.insecure.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{5, 6, 7, 8}}}}
+			}
+			return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+		})
+	}
+
+	testCases := map[string]struct {
+		name       string
+		server     serverOpts
+		disabled   bool
+		algorithms Algorithms
+		wantStatus SecurityStatus
+		wantIPs    int
+		wantErr    error
+	}{
+		"secure": {
+			name:       "www.test",
+			wantStatus: SecuritySecure,
+			wantIPs:    1,
+		},
+		"insecure": {
+			name:       "www.insecure.test",
+			wantStatus: SecurityInsecure,
+			wantIPs:    1,
+		},
+		"forged": {
+			name:       "www.test",
+			server:     serverOpts{tamper: true},
+			wantStatus: SecurityBogus,
+			wantErr:    ErrBogus,
+		},
+		"expired": {
+			name:       "www.test",
+			server:     serverOpts{expiration: now.Add(-time.Minute)},
+			wantStatus: SecurityBogus,
+			wantErr:    ErrBogus,
+		},
+		"excess signature labels": {
+			name:       "www.test",
+			server:     serverOpts{labels: 3},
+			wantStatus: SecurityBogus,
+			wantErr:    ErrBogus,
+		},
+		"wildcard": {
+			name:       "www.wild.test",
+			wantStatus: SecuritySecure,
+			wantIPs:    1,
+		},
+		"wildcard without proof": {
+			name:       "www.wild.test",
+			server:     serverOpts{noProof: true},
+			wantStatus: SecurityBogus,
+			wantErr:    ErrBogus,
+		},
+		"wildcard below a name": {
+			name:       "www.sub.wild.test",
+			wantStatus: SecuritySecure,
+			wantIPs:    1,
+		},
+		"wildcard with closer name": {
+			name:       "www.wild.test",
+			server:     serverOpts{labels: 1},
+			wantStatus: SecurityBogus,
+			wantErr:    ErrBogus,
+		},
+		"unsupported algorithm": {
+			name:       "www.test",
+			algorithms: withoutECDSA,
+			wantStatus: SecurityInsecure,
+			wantIPs:    1,
+		},
+		"disabled": {
+			name:       "www.test",
+			disabled:   true,
+			wantStatus: SecurityIndeterminate,
+			wantIPs:    1,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if tc.server.expiration.IsZero() {
+				tc.server.expiration = now.Add(time.Hour)
+			}
+			opts := &Opts{DNSSEC: !tc.disabled, TrustAnchors: []TrustAnchor{{Zone: ".", DS: anchor}}, Algorithms: tc.algorithms}
+			r := newTestResolver(newServer(tc.server), opts)
+			result, err := r.LookupIPResult(context.Background(), tc.name)
+			if tc.wantErr != nil {
+				be.True(t, errors.Is(err, tc.wantErr))
+			} else {
+				be.NilErr(t, err)
+			}
+			be.Equal(t, tc.wantStatus, result.Status)
+			be.Equal(t, tc.wantIPs, len(result.IPs))
+			if tc.disabled {
+				be.Equal(t, 0, len(result.RRSets))
+				return
+			}
+			be.Equal(t, 1, len(result.RRSets))
+			be.Equal(t, tc.wantStatus, result.RRSets[0].Status)
+			if tc.wantStatus == SecuritySecure {
+				be.Equal(t, 2, len(result.Chain))
+				be.Equal(t, ".", result.Chain[0].Zone)
+				be.True(t, result.Chain[0].TrustAnchor)
+				be.Equal(t, "test", result.Chain[1].Zone)
+				be.Equal(t, SecuritySecure, result.Chain[1].Status)
+				be.Equal(t, testKey.key.KeyTag(), result.Chain[1].DS[0].KeyTag)
+				be.Equal(t, 1, len(result.Chain[1].Signatures))
+			}
+		})
+	}
+}
+
+func TestCombineStatus(t *testing.T) {
+	t.Parallel()
+
+	be.Equal(t, SecurityBogus, combineStatus(SecuritySecure, SecurityBogus))
+	be.Equal(t, SecurityInsecure, combineStatus(SecurityInsecure, SecuritySecure))
+	be.Equal(t, SecurityIndeterminate, combineStatus(SecurityInsecure, SecurityIndeterminate))
+	be.Equal(t, SecuritySecure, combineStatus(SecuritySecure, SecuritySecure))
+}
+
+func TestLookupIPResultMetadata(t *testing.T) {
+	t.Parallel()
+
+	port := startTestServer(t, func(q Message) Message {
+		switch canonicalName(string(q.Questions[0].Name)) {
+		case "alias.test":
+			return cnameAnswer("alias.test", "www.test")
+		case "www.test":
+			return Message{Answers: []Record{
+				{Name: []byte("www.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{1, 2, 3, 4}},
+				{Name: []byte("www.test"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 300, Data: []byte{5, 6, 7, 8}},
+			}}
+		}
+		return Message{Header: Header{Flags: uint16(RCodeNXDomain)}}
+	})
+	hosts, err := ParseHosts(strings.NewReader("10.0.0.1 printer.test\n"))
+	be.NilErr(t, err)
+	r := newTestResolver(port, &Opts{Hosts: hosts})
+
+	result, err := r.LookupIPResult(context.Background(), "alias.test")
+	be.NilErr(t, err)
+	be.Equal(t, 2, len(result.Addrs))
+	be.Equal(t, "1.2.3.4", result.Addrs[0].IP.String())
+	be.Equal(t, 300*time.Second, result.Addrs[0].TTL)
+	be.Equal(t, "www.test", result.CanonicalName)
+	be.Equal(t, 1, len(result.CNAMEs))
+	be.Equal(t, "alias.test", string(result.CNAMEs[0].Name))
+	be.Equal(t, SourceNameServer, result.Source)
+	be.Equal(t, "root.test", result.NameServer)
+	be.Equal(t, "127.0.0.1", result.NameServerAddr.String())
+
+	// the second lookup is answered from the cache, following the cached
+	// CNAME
+	result, err = r.LookupIPResult(context.Background(), "alias.test")
+	be.NilErr(t, err)
+	be.Equal(t, SourceCache, result.Source)
+	be.Equal(t, "", result.NameServer)
+	be.Equal(t, "www.test", result.CanonicalName)
+	be.Equal(t, 1, len(result.CNAMEs))
+	be.Equal(t, 2, len(result.Addrs))
+	be.True(t, result.Addrs[0].TTL <= 300*time.Second)
+
+	result, err = r.LookupIPResult(context.Background(), "printer.test")
+	be.NilErr(t, err)
+	be.Equal(t, SourceHosts, result.Source)
+	be.Equal(t, "printer.test", result.CanonicalName)
+	be.Equal(t, 1, len(result.Addrs))
+}
@@ -0,0 +1,75 @@
+// Package wire parses and encodes DNS messages in wire format, for working
+// with captured packets and other raw messages without a Resolver.
+//
+// The types are those of package dnstoy, so messages parsed here can be
+// used with the rest of dnstoy, and vice versa. Record type and class
+// constants are found there too, e.g. dnstoy.RecordTypeA.
+//
+// Names are returned decoded and without a trailing dot, including the names
+// in the data of NS, CNAME and PTR records. Compressed names in the data of
+// SOA and MX records are expanded, so that the data of every record is
+// meaningful outside of the message it was parsed from.
+package wire
+
+import "github.com/mccutchen/dnstoy"
+
+// DNS message types, see package dnstoy.
+type (
+	Message       = dnstoy.Message
+	Header        = dnstoy.Header
+	Question      = dnstoy.Question
+	Record        = dnstoy.Record
+	RecordType    = dnstoy.RecordType
+	ResourceClass = dnstoy.ResourceClass
+)
+
+// PartialMessageError is returned by ParseMessageLenient along with the parts
+// of a message that could be parsed.
+type PartialMessageError = dnstoy.PartialMessageError
+
+// ParseMessage parses a complete DNS message, failing if any part of it is
+// malformed.
+func ParseMessage(data []byte) (Message, error) {
+	return dnstoy.ParseMessage(data, dnstoy.ParseStrict)
+}
+
+// ParseMessageLenient parses as much of a DNS message as possible. If any
+// part of it is malformed, the rest is returned along with a
+// *PartialMessageError describing what was skipped.
+func ParseMessageLenient(data []byte) (Message, error) {
+	return dnstoy.ParseMessage(data, dnstoy.ParseLenient)
+}
+
+// ParseHeader parses the header at the start of a DNS message.
+func ParseHeader(msg []byte) (Header, error) {
+	return dnstoy.ParseHeader(msg)
+}
+
+// ParseQuestion parses the question at the given offset in a DNS message,
+// returning it along with the offset just past it.
+func ParseQuestion(msg []byte, offset int) (Question, int, error) {
+	return dnstoy.ParseQuestion(msg, offset)
+}
+
+// ParseRecord parses the resource record at the given offset in a DNS
+// message, returning it along with the offset just past it.
+func ParseRecord(msg []byte, offset int) (Record, int, error) {
+	return dnstoy.ParseRecord(msg, offset)
+}
+
+// ParseName decodes the possibly compressed name at the given offset in a
+// DNS message, returning it along with the offset just past it.
+func ParseName(msg []byte, offset int) (string, int, error) {
+	return dnstoy.ParseName(msg, offset)
+}
+
+// EncodeName encodes a name in wire format, without compression.
+func EncodeName(name string) ([]byte, error) {
+	return dnstoy.EncodeName(name)
+}
+
+// Encode encodes a message in wire format, compressing names where
+// possible. It is equivalent to msg.Encode().
+func Encode(msg Message) []byte {
+	return msg.Encode()
+}
@@ -0,0 +1,93 @@
+package wire
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"github.com/mccutchen/dnstoy"
+)
+
+func TestParseMessage(t *testing.T) {
+	t.Parallel()
+
+	answer, err := dnstoy.NewA("www.example.com", 300, net.IPv4(192, 0, 2, 1))
+	be.NilErr(t, err)
+	cname, err := dnstoy.NewCNAME("alias.example.com", 300, "www.example.com")
+	be.NilErr(t, err)
+	msg := Message{
+		Header:    Header{ID: 1234, QuestionCount: 1, AnswerCount: 2},
+		Questions: []Question{{Name: []byte("alias.example.com"), Type: dnstoy.RecordTypeA, Class: dnstoy.ResourceClassIN}},
+		Answers:   []Record{cname, answer},
+	}
+	data := Encode(msg)
+
+	got, err := ParseMessage(data)
+	be.NilErr(t, err)
+	be.Equal(t, msg.Header, got.Header)
+	be.Equal(t, 2, len(got.Answers))
+	be.Equal(t, "www.example.com", string(got.Answers[0].Data))
+	be.Equal(t, "www.example.com", string(got.Answers[1].Name))
+
+	// the message can also be walked piece by piece
+	header, err := ParseHeader(data)
+	be.NilErr(t, err)
+	be.Equal(t, uint16(2), header.AnswerCount)
+	question, offset, err := ParseQuestion(data, 12)
+	be.NilErr(t, err)
+	be.Equal(t, "alias.example.com", string(question.Name))
+	for i := 0; i < int(header.AnswerCount); i++ {
+		var rec Record
+		rec, offset, err = ParseRecord(data, offset)
+		be.NilErr(t, err)
+		be.Equal(t, string(msg.Answers[i].Name), string(rec.Name))
+	}
+	be.Equal(t, len(data), offset)
+
+	// truncating the message fails strict parsing, but not lenient parsing
+	_, err = ParseMessage(data[:len(data)-2])
+	be.True(t, err != nil)
+	partial, err := ParseMessageLenient(data[:len(data)-2])
+	var partialErr *PartialMessageError
+	be.True(t, errors.As(err, &partialErr))
+	be.Equal(t, 1, len(partial.Answers))
+}
+
+func TestParseName(t *testing.T) {
+	t.Parallel()
+
+	// "example.com" at offset 0, then "www" with a pointer back to it
+	data := append(mustEncodeName(t, "example.com"), 3, 'w', 'w', 'w', 0xc0, 0)
+
+	name, offset, err := ParseName(data, 0)
+	be.NilErr(t, err)
+	be.Equal(t, "example.com", name)
+	be.Equal(t, 13, offset)
+
+	name, offset, err = ParseName(data, offset)
+	be.NilErr(t, err)
+	be.Equal(t, "www.example.com", name)
+	be.Equal(t, len(data), offset)
+
+	for _, offset := range []int{-1, len(data) + 1} {
+		_, _, err := ParseName(data, offset)
+		be.True(t, err != nil)
+	}
+}
+
+func TestEncodeName(t *testing.T) {
+	t.Parallel()
+
+	be.Equal(t, "\x07example\x03com\x00", string(mustEncodeName(t, "example.com.")))
+	be.Equal(t, "\x00", string(mustEncodeName(t, ".")))
+	_, err := EncodeName("bad..name")
+	be.True(t, errors.Is(err, dnstoy.ErrInvalidName))
+}
+
+func mustEncodeName(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := EncodeName(name)
+	be.NilErr(t, err)
+	return data
+}
@@ -0,0 +1,140 @@
+package dnstoy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A ZoneProblem is a problem found in a zone file by CheckZone.
+type ZoneProblem struct {
+	Line    int // of the entry with the problem, or 0 for the zone as a whole
+	Message string
+}
+
+func (p ZoneProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// CheckZone parses a zone file like ParseZone, returning the problems found
+// in it rather than failing at the first invalid entry. Besides entries that
+// cannot be parsed, it finds:
+//
+//   - a missing or misplaced SOA record, or more than one
+//   - a missing NS RRset at the zone apex
+//   - TTLs above the maximum of RFC 2181
+//   - records outside the zone
+//   - CNAME records alongside other data for the same name, or more than
+//     one for a name
+//   - name servers within the zone without address records, i.e. glue
+//
+// The zone apex is origin or, if origin is empty, the owner of the first SOA
+// record. Problems are returned in the order of the lines they were found
+// on, after those with the zone as a whole. An error is returned only if the
+// zone file cannot be read or split into entries.
+func CheckZone(r io.Reader, origin string) ([]ZoneProblem, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tokenizeZone(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	type zoneRecord struct {
+		Record
+		line int
+	}
+	var (
+		problems []ZoneProblem
+		records  []zoneRecord
+	)
+	p := zoneParser{origin: strings.TrimSuffix(origin, ".")}
+	for _, entry := range entries {
+		rec, ok, err := p.parseEntry(entry)
+		if err != nil {
+			problems = append(problems, ZoneProblem{Line: entry.line, Message: err.Error()})
+		} else if ok {
+			records = append(records, zoneRecord{rec, entry.line})
+		}
+	}
+
+	apex := canonicalName(origin)
+	var soas []zoneRecord
+	for _, rec := range records {
+		if rec.Type == RecordTypeSOA {
+			soas = append(soas, rec)
+		}
+	}
+	if origin == "" && len(soas) > 0 {
+		apex = canonicalName(string(soas[0].Name))
+	}
+	switch {
+	case len(soas) == 0:
+		problems = append(problems, ZoneProblem{Message: "no SOA record at the zone apex"})
+	case len(soas) > 1:
+		problems = append(problems, ZoneProblem{Line: soas[1].line, Message: fmt.Sprintf("more than one SOA record, the first on line %d", soas[0].line)})
+	}
+
+	// the types of records at each name
+	types := make(map[string]map[RecordType]bool)
+	for _, rec := range records {
+		name := canonicalName(string(rec.Name))
+		if types[name] == nil {
+			types[name] = make(map[RecordType]bool)
+		}
+		types[name][rec.Type] = true
+	}
+	knownApex := origin != "" || len(soas) > 0
+	if knownApex && !types[apex][RecordTypeNS] {
+		problems = append(problems, ZoneProblem{Message: fmt.Sprintf("no NS records at the zone apex %s", presentName(apex))})
+	}
+
+	hasCNAME := make(map[string]bool)
+	for _, rec := range records {
+		name := canonicalName(string(rec.Name))
+		if rec.TTL > maxTTL {
+			problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("TTL %d of %s %s record exceeds the maximum of %d", rec.TTL, presentName(name), rec.Type, maxTTL)})
+		}
+		if knownApex && !isSubdomain(name, apex) {
+			problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("%s is outside the zone %s", presentName(name), presentName(apex))})
+			continue
+		}
+		switch rec.Type {
+		case RecordTypeSOA:
+			if name != apex {
+				problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("SOA record for %s is not at the zone apex %s", presentName(name), presentName(apex))})
+			}
+		case RecordTypeCNAME:
+			if hasCNAME[name] {
+				problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("more than one CNAME record for %s", presentName(name))})
+				break
+			}
+			hasCNAME[name] = true
+			for t := range types[name] {
+				// DNSSEC records may accompany a CNAME
+				// https://datatracker.ietf.org/doc/html/rfc4035#section-2.5
+				if t != RecordTypeCNAME && t != RecordTypeRRSIG && t != RecordTypeNSEC {
+					problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("%s has a CNAME record and other data", presentName(name))})
+					break
+				}
+			}
+		case RecordTypeNS:
+			target := canonicalName(string(rec.Data))
+			if !knownApex || !isSubdomain(target, apex) {
+				break
+			}
+			if !types[target][RecordTypeA] && !types[target][RecordTypeAAAA] {
+				problems = append(problems, ZoneProblem{Line: rec.line, Message: fmt.Sprintf("no address records (glue) for name server %s of %s", presentName(target), presentName(name))})
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems, nil
+}
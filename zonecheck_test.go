@@ -0,0 +1,97 @@
+package dnstoy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestCheckZone(t *testing.T) {
+	t.Parallel()
+
+	const head = `$ORIGIN example.com.
+$TTL 1h
+@	SOA	ns1 hostmaster 1 2h 15m 2w 300
+	NS	ns1
+ns1	A	192.0.2.53
+`
+	testCases := map[string]struct {
+		zone   string
+		origin string
+		want   []string
+	}{
+		"valid": {
+			zone: head + "www CNAME web\nweb A 192.0.2.80\n",
+			want: nil,
+		},
+		"origin from SOA": {
+			zone: head,
+			want: nil,
+		},
+		"syntax errors": {
+			zone: head + "a BOGUS 1\nb A 2001:db8::1\n",
+			want: []string{"line 6: unknown record type", "line 7: invalid A record"},
+		},
+		"no SOA": {
+			zone:   "$ORIGIN example.com.\n@ 60 NS ns.example.net.\n",
+			origin: "example.com",
+			want:   []string{"no SOA record"},
+		},
+		"second SOA": {
+			zone: head + "@ SOA ns1 hostmaster 2 2h 15m 2w 300\n",
+			want: []string{"line 6: more than one SOA record, the first on line 3"},
+		},
+		"no apex NS": {
+			zone: "$ORIGIN example.com.\n@ 60 SOA ns1 hostmaster 1 2h 15m 2w 300\n",
+			want: []string{"no NS records at the zone apex example.com."},
+		},
+		"TTL too large": {
+			zone: head + "big 4294967295 A 192.0.2.1\n",
+			want: []string{"line 6: TTL 4294967295 of big.example.com. A record exceeds the maximum"},
+		},
+		"out of zone": {
+			zone: head + "ns.example.net. A 192.0.2.1\n",
+			want: []string{"line 6: ns.example.net. is outside the zone example.com."},
+		},
+		"CNAME and other data": {
+			zone: head + "www A 192.0.2.80\nwww CNAME web\n",
+			want: []string{"line 7: www.example.com. has a CNAME record and other data"},
+		},
+		"CNAME with DNSSEC records": {
+			zone: head + "www CNAME web\nwww NSEC \\# 2 0000\n",
+			want: nil,
+		},
+		"multiple CNAMEs": {
+			zone: head + "www CNAME web\nwww CNAME web2\n",
+			want: []string{"line 7: more than one CNAME record for www.example.com."},
+		},
+		"missing glue": {
+			zone: head + "sub NS ns.sub\n",
+			want: []string{"line 6: no address records (glue) for name server ns.sub.example.com. of sub.example.com."},
+		},
+		"out of zone name server": {
+			zone: head + "sub NS ns.example.net.\n",
+			want: nil,
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			problems, err := CheckZone(strings.NewReader(tc.zone), tc.origin)
+			be.NilErr(t, err)
+			be.Equal(t, len(tc.want), len(problems))
+			for i, want := range tc.want {
+				be.In(t, want, problems[i].String())
+			}
+		})
+	}
+}
+
+func TestCheckZoneTokenizeError(t *testing.T) {
+	t.Parallel()
+
+	_, err := CheckZone(strings.NewReader("a 60 TXT \"oops\n"), "example.com")
+	be.True(t, err != nil)
+}
@@ -0,0 +1,467 @@
+package dnstoy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadZone loads and parses the zone file at the given path. See ParseZone.
+func LoadZone(path, origin string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := ParseZone(f, origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return records, nil
+}
+
+// ParseZone parses the records in a zone file, in the master file format
+// described by RFC 1035. Relative names are taken to be relative to origin
+// until a $ORIGIN directive changes it, and records without a TTL take the
+// TTL set by a $TTL directive or, failing that, the TTL of the last record
+// that has one. $INCLUDE directives are not supported.
+//
+// The data of A, AAAA, NS, CNAME, PTR, MX, TXT, SOA, SRV, CAA, DS and DNSKEY
+// records is parsed from its presentation format, and that of any type,
+// including unknown types, from the generic format of RFC 3597, e.g.
+// "TYPE65534 \# 2 ABCD".
+// https://datatracker.ietf.org/doc/html/rfc1035#section-5
+func ParseZone(r io.Reader, origin string) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := tokenizeZone(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	p := zoneParser{origin: strings.TrimSuffix(origin, ".")}
+	var records []Record
+	for _, entry := range entries {
+		rec, ok, err := p.parseEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", entry.line, err)
+		}
+		if ok {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// zoneEntry is a single entry in a zone file, which may span several lines
+// if it uses parentheses.
+type zoneEntry struct {
+	line   int
+	indent bool // the entry begins with whitespace, omitting its owner
+	tokens []string
+}
+
+// tokenizeZone splits the contents of a zone file into entries.
+func tokenizeZone(data string) ([]zoneEntry, error) {
+	var (
+		entries []zoneEntry
+		entry   zoneEntry
+		line    = 1
+		parens  = 0
+		i       = 0
+	)
+	flush := func() {
+		if len(entry.tokens) > 0 {
+			entries = append(entries, entry)
+		}
+		entry = zoneEntry{}
+	}
+	for i < len(data) {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+			if parens == 0 {
+				flush()
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			if i == 0 || data[i-1] == '\n' {
+				if parens == 0 {
+					entry.indent = true
+				}
+			}
+			i++
+		case c == ';':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			parens++
+			i++
+		case c == ')':
+			if parens == 0 {
+				return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+			}
+			parens--
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' && i+1 < len(data) {
+					i++
+				}
+				if data[i] == '\n' {
+					line++
+				}
+				b.WriteByte(data[i])
+			}
+			if i == len(data) {
+				return nil, fmt.Errorf("line %d: unterminated quoted string", line)
+			}
+			i++
+			entry.add(line, b.String())
+		default:
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n;()\"", rune(data[i])) {
+				if data[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i > len(data) {
+				i = len(data)
+			}
+			entry.add(line, data[start:i])
+		}
+	}
+	if parens != 0 {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+	}
+	flush()
+	return entries, nil
+}
+
+func (e *zoneEntry) add(line int, token string) {
+	if len(e.tokens) == 0 {
+		e.line = line
+	}
+	e.tokens = append(e.tokens, token)
+}
+
+// zoneParser holds the state carried from one entry of a zone file to the
+// next.
+type zoneParser struct {
+	origin   string
+	ttl      uint32
+	hasTTL   bool
+	lastName string
+	hasName  bool
+}
+
+// parseEntry parses a directive or record, returning true if it is a
+// record.
+func (p *zoneParser) parseEntry(entry zoneEntry) (Record, bool, error) {
+	tokens := entry.tokens
+	switch strings.ToUpper(tokens[0]) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return Record{}, false, errors.New("$ORIGIN requires a single name")
+		}
+		origin, err := p.name(tokens[1])
+		if err != nil {
+			return Record{}, false, err
+		}
+		p.origin = origin
+		return Record{}, false, nil
+	case "$TTL":
+		if len(tokens) != 2 {
+			return Record{}, false, errors.New("$TTL requires a single TTL")
+		}
+		ttl, err := parseZoneTTL(tokens[1])
+		if err != nil {
+			return Record{}, false, err
+		}
+		p.ttl, p.hasTTL = ttl, true
+		return Record{}, false, nil
+	case "$INCLUDE":
+		return Record{}, false, errors.New("$INCLUDE is not supported")
+	}
+
+	if !entry.indent {
+		owner, err := p.name(tokens[0])
+		if err != nil {
+			return Record{}, false, err
+		}
+		p.lastName, p.hasName = owner, true
+		tokens = tokens[1:]
+	} else if !p.hasName {
+		return Record{}, false, errors.New("record without an owner name")
+	}
+	owner := p.lastName
+
+	rec := Record{Name: []byte(owner), Class: ResourceClassIN, TTL: p.ttl}
+	hasTTL, hasClass := false, false
+	for {
+		if len(tokens) == 0 {
+			return Record{}, false, errors.New("missing record type")
+		}
+		if ttl, err := parseZoneTTL(tokens[0]); err == nil && !hasTTL {
+			rec.TTL, hasTTL = ttl, true
+			tokens = tokens[1:]
+			continue
+		}
+		if class, err := ParseResourceClass(tokens[0]); err == nil && !hasClass {
+			rec.Class, hasClass = class, true
+			tokens = tokens[1:]
+			continue
+		}
+		break
+	}
+	if hasTTL && !p.hasTTL {
+		// later records without a TTL take this one
+		p.ttl = rec.TTL
+	}
+	recordType, err := ParseRecordType(tokens[0])
+	if err != nil {
+		return Record{}, false, err
+	}
+	rec.Type = recordType
+	if rec.Data, err = p.recordData(recordType, tokens[1:]); err != nil {
+		return Record{}, false, fmt.Errorf("invalid %s record for %s: %w", recordType, presentName(owner), err)
+	}
+	return rec, true, nil
+}
+
+// name returns the absolute form of a name in a zone file, without the
+// trailing dot.
+func (p *zoneParser) name(s string) (string, error) {
+	var name string
+	switch {
+	case s == "@":
+		name = p.origin
+	case strings.HasSuffix(s, ".") && !strings.HasSuffix(s, `\.`):
+		name = strings.TrimSuffix(s, ".")
+	case p.origin == "":
+		name = s
+	default:
+		name = s + "." + p.origin
+	}
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// recordData parses the presentation format of a record's data.
+func (p *zoneParser) recordData(recordType RecordType, fields []string) ([]byte, error) {
+	if len(fields) > 0 && fields[0] == `\#` {
+		return parseGenericData(fields[1:])
+	}
+	want := func(n int) error {
+		if len(fields) != n {
+			return fmt.Errorf("expected %d fields, got %d", n, len(fields))
+		}
+		return nil
+	}
+	switch recordType {
+	case RecordTypeA, RecordTypeAAAA:
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0])
+		if ip4 := ip.To4(); recordType == RecordTypeA && ip4 != nil {
+			return ip4, nil
+		}
+		if recordType == RecordTypeAAAA && ip != nil && ip.To4() == nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("invalid address %q", fields[0])
+	case RecordTypeNS, RecordTypeCNAME, RecordTypePTR:
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		target, err := p.name(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return []byte(target), nil
+	case RecordTypeMX:
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		preference, err := parseUint16(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid preference: %w", err)
+		}
+		exchange, err := p.name(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return MX{Preference: preference, Exchange: exchange}.Encode(), nil
+	case RecordTypeTXT:
+		if len(fields) == 0 {
+			return nil, errors.New("no strings")
+		}
+		var data []byte
+		for _, s := range fields {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("string of %d bytes exceeds 255 bytes", len(s))
+			}
+			data = append(data, byte(len(s)))
+			data = append(data, s...)
+		}
+		return data, nil
+	case RecordTypeSOA:
+		if err := want(7); err != nil {
+			return nil, err
+		}
+		var data []byte
+		for _, s := range fields[:2] {
+			name, err := p.name(s)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, encodeName(name)...)
+		}
+		for _, s := range fields[2:] {
+			n, err := parseZoneTTL(s)
+			if err != nil {
+				return nil, err
+			}
+			data = binary.BigEndian.AppendUint32(data, n)
+		}
+		return data, nil
+	case RecordTypeSRV:
+		if err := want(4); err != nil {
+			return nil, err
+		}
+		var nums [3]uint16
+		for i, s := range fields[:3] {
+			n, err := parseUint16(s)
+			if err != nil {
+				return nil, err
+			}
+			nums[i] = n
+		}
+		target, err := p.name(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		return SRV{Priority: nums[0], Weight: nums[1], Port: nums[2], Target: target}.Encode(), nil
+	case RecordTypeCAA:
+		if err := want(3); err != nil {
+			return nil, err
+		}
+		flags, err := parseUint8(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags: %w", err)
+		}
+		if err := validateCAATag(fields[1]); err != nil {
+			return nil, err
+		}
+		return CAA{Flags: flags, Tag: fields[1], Value: fields[2]}.Encode(), nil
+	case RecordTypeDS, RecordTypeCDS:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+		}
+		anchor, err := dsTrustAnchor("", fields[0], fields[1], fields[2], strings.Join(fields[3:], ""))
+		if err != nil {
+			return nil, err
+		}
+		return anchor.DS.Encode(), nil
+	case RecordTypeDNSKEY, RecordTypeCDNSKEY:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+		}
+		var key DNSKEY
+		var err error
+		if key.Flags, err = parseUint16(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid flags: %w", err)
+		}
+		if key.Protocol, err = parseUint8(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid protocol: %w", err)
+		}
+		if key.Algorithm, err = parseUint8(fields[2]); err != nil {
+			return nil, fmt.Errorf("invalid algorithm: %w", err)
+		}
+		if key.PublicKey, err = base64.StdEncoding.DecodeString(strings.Join(fields[3:], "")); err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		return key.Encode(), nil
+	default:
+		return nil, fmt.Errorf("no presentation format for %s records; use the generic format", recordType)
+	}
+}
+
+// parseGenericData parses record data in the generic format, following the
+// "\#" token: the length of the data followed by the data in hex.
+// https://datatracker.ietf.org/doc/html/rfc3597#section-5
+func parseGenericData(fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return nil, errors.New(`missing data length after \#`)
+	}
+	length, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data length: %w", err)
+	}
+	data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %w", err)
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("data of %d bytes does not match length %d", len(data), length)
+	}
+	return data, nil
+}
+
+// parseZoneTTL parses a TTL, which may be given in seconds or, as BIND
+// allows, with units, e.g. "1h30m".
+func parseZoneTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	var total uint64
+	num := ""
+	for _, c := range strings.ToLower(s) {
+		if '0' <= c && c <= '9' {
+			num += string(c)
+			continue
+		}
+		var unit uint64
+		switch c {
+		case 's':
+			unit = 1
+		case 'm':
+			unit = 60
+		case 'h':
+			unit = 60 * 60
+		case 'd':
+			unit = 24 * 60 * 60
+		case 'w':
+			unit = 7 * 24 * 60 * 60
+		default:
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		if num == "" {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		n, _ := strconv.ParseUint(num, 10, 32)
+		total += n * unit
+		num = ""
+	}
+	if num != "" || total > 0xffffffff {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return uint32(total), nil
+}
@@ -0,0 +1,89 @@
+package dnstoy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func TestParseZone(t *testing.T) {
+	t.Parallel()
+
+	zone := `$ORIGIN example.com.
+$TTL 1h
+@	IN	SOA	ns1 hostmaster (
+		2024010101 ; serial
+		2h 15m 2w 300 )
+	NS	ns1
+	NS	ns2.example.net.
+ns1	300	A	192.0.2.53
+www	IN 60	AAAA	2001:db8::1
+	CNAME	web ; continues the previous owner
+@	MX	10 mail
+txt	TXT	"v=spf1 -all" "with \"quotes\"" bare
+_sip._tcp	SRV	10 5 5060 sip
+@	CAA	0 issue "letsencrypt.org"
+$ORIGIN sub.example.com.
+odd	TYPE65534	\# 2 ABCD
+empty	TYPE65535	\# 0
+`
+	records, err := ParseZone(strings.NewReader(zone), "")
+	be.NilErr(t, err)
+	var got []string
+	for _, rec := range records {
+		got = append(got, rec.String())
+	}
+	be.AllEqual(t, []string{
+		"example.com.\t3600\tIN\tSOA\tns1.example.com. hostmaster.example.com. 2024010101 7200 900 1209600 300",
+		"example.com.\t3600\tIN\tNS\tns1.example.com.",
+		"example.com.\t3600\tIN\tNS\tns2.example.net.",
+		"ns1.example.com.\t300\tIN\tA\t192.0.2.53",
+		"www.example.com.\t60\tIN\tAAAA\t2001:db8::1",
+		"www.example.com.\t3600\tIN\tCNAME\tweb.example.com.",
+		"example.com.\t3600\tIN\tMX\t10 mail.example.com.",
+		"txt.example.com.\t3600\tIN\tTXT\t\"v=spf1 -all\" \"with \\\"quotes\\\"\" \"bare\"",
+		"_sip._tcp.example.com.\t3600\tIN\tSRV\t10 5 5060 sip.example.com.",
+		"example.com.\t3600\tIN\tCAA\t0 issue \"letsencrypt.org\"",
+		"odd.sub.example.com.\t3600\tIN\tTYPE65534\t\\# 2 ABCD",
+		"empty.sub.example.com.\t3600\tIN\tTYPE65535\t\\# 0 ",
+	}, got)
+}
+
+func TestParseZoneTTLDefaults(t *testing.T) {
+	t.Parallel()
+
+	// without $TTL, records take the TTL of the last record that has one
+	records, err := ParseZone(strings.NewReader("a 300 A 192.0.2.1\nb A 192.0.2.2\nc 60 A 192.0.2.3\nd A 192.0.2.4\n"), "example.com")
+	be.NilErr(t, err)
+	var ttls []uint32
+	for _, rec := range records {
+		ttls = append(ttls, rec.TTL)
+	}
+	be.AllEqual(t, []uint32{300, 300, 60, 60}, ttls)
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"unbalanced parentheses": "a 60 SOA ns hm ( 1 2 3 4 5\n",
+		"unterminated string":    "a 60 TXT \"oops\n",
+		"no owner":               " 60 A 192.0.2.1\n",
+		"unknown type":           "a 60 BOGUS 1\n",
+		"bad address":            "a 60 A 2001:db8::1\n",
+		"bad generic length":     "a 60 TYPE65534 \\# 3 ABCD\n",
+		"include":                "$INCLUDE other.zone\n",
+		"invalid name":           "a..b 60 A 192.0.2.1\n",
+		"bad TTL":                "$TTL 1x\n",
+		"bad CAA tag":            "a 60 CAA 0 is-sue \"ca.example\"\n",
+	}
+	for name, zone := range testCases {
+		zone := zone
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseZone(strings.NewReader(zone), "example.com")
+			be.True(t, err != nil)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package dnstoy
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/mccutchen/dnstoy/internal/byteview"
+)
+
+// ZONEMD holds the data of a ZONEMD record, which holds a digest over the
+// contents of the zone at whose apex it is published:
+// https://datatracker.ietf.org/doc/html/rfc8976#section-2
+type ZONEMD struct {
+	Serial        uint32
+	Scheme        uint8
+	HashAlgorithm uint8
+	Digest        []byte
+}
+
+// ZONEMD schemes and hash algorithms:
+// https://datatracker.ietf.org/doc/html/rfc8976#section-5
+const (
+	ZONEMDSchemeSimple = 1
+	ZONEMDHashSHA384   = 1
+	ZONEMDHashSHA512   = 2
+)
+
+// minZONEMDDigestLen is the shortest digest allowed in a ZONEMD record.
+const minZONEMDDigestLen = 12
+
+// ErrZONEMDMismatch is returned by VerifyZONEMD when the digest computed over
+// a zone does not match any of the zone's ZONEMD records, meaning that the
+// zone has been corrupted or tampered with.
+var ErrZONEMDMismatch = errors.New("zone digest does not match ZONEMD record")
+
+// parseZONEMD parses the data of a ZONEMD record.
+func parseZONEMD(data []byte) (ZONEMD, error) {
+	if len(data) < 6+minZONEMDDigestLen {
+		return ZONEMD{}, fmt.Errorf("parseZONEMD: record data too short (%d bytes)", len(data))
+	}
+	return ZONEMD{
+		Serial:        binary.BigEndian.Uint32(data[0:4]),
+		Scheme:        data[4],
+		HashAlgorithm: data[5],
+		Digest:        data[6:],
+	}, nil
+}
+
+// Encode encodes the ZONEMD as record data in network order.
+func (z ZONEMD) Encode() []byte {
+	out := make([]byte, 0, 6+len(z.Digest))
+	out = binary.BigEndian.AppendUint32(out, z.Serial)
+	out = append(out, z.Scheme, z.HashAlgorithm)
+	out = append(out, z.Digest...)
+	return out
+}
+
+// ComputeZONEMD computes the digest of the zone with the given origin, made
+// up of the given records, using the SIMPLE scheme and the given hash
+// algorithm. Records outside the zone are ignored, as are the ZONEMD
+// records at the zone's apex and the signatures over them:
+// https://datatracker.ietf.org/doc/html/rfc8976#section-3.3
+func ComputeZONEMD(origin string, records []Record, hashAlgorithm uint8) ([]byte, error) {
+	var h hash.Hash
+	switch hashAlgorithm {
+	case ZONEMDHashSHA384:
+		h = sha512.New384()
+	case ZONEMDHashSHA512:
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported ZONEMD hash algorithm %d", hashAlgorithm)
+	}
+
+	type canonicalRecord struct {
+		owner string
+		rec   Record
+		rdata []byte
+	}
+	included := make([]canonicalRecord, 0, len(records))
+	for _, rec := range records {
+		owner := canonicalName(string(rec.Name))
+		if !isSubdomain(owner, origin) {
+			continue
+		}
+		if owner == canonicalName(origin) && isZONEMDRecord(rec) {
+			continue
+		}
+		included = append(included, canonicalRecord{owner: owner, rec: rec, rdata: canonicalRecordData(rec)})
+	}
+	sort.Slice(included, func(i, j int) bool {
+		a, b := included[i], included[j]
+		if c := compareCanonical(a.owner, b.owner); c != 0 {
+			return c < 0
+		}
+		if a.rec.Class != b.rec.Class {
+			return a.rec.Class < b.rec.Class
+		}
+		if a.rec.Type != b.rec.Type {
+			return a.rec.Type < b.rec.Type
+		}
+		return bytes.Compare(a.rdata, b.rdata) < 0
+	})
+
+	for i, cr := range included {
+		// duplicate records are only included once
+		if i > 0 {
+			prev := included[i-1]
+			if prev.owner == cr.owner && prev.rec.Class == cr.rec.Class && prev.rec.Type == cr.rec.Type && bytes.Equal(prev.rdata, cr.rdata) {
+				continue
+			}
+		}
+		out := encodeName(cr.owner)
+		out = binary.BigEndian.AppendUint16(out, uint16(cr.rec.Type))
+		out = binary.BigEndian.AppendUint16(out, uint16(cr.rec.Class))
+		out = binary.BigEndian.AppendUint32(out, cr.rec.TTL)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(cr.rdata)))
+		out = append(out, cr.rdata...)
+		h.Write(out)
+	}
+	return h.Sum(nil), nil
+}
+
+// isZONEMDRecord returns true if the record is a ZONEMD record or a signature
+// over one.
+func isZONEMDRecord(rec Record) bool {
+	if rec.Type == RecordTypeZONEMD {
+		return true
+	}
+	if rec.Type != RecordTypeRRSIG {
+		return false
+	}
+	sig, err := parseRRSIG(rec.Data)
+	return err == nil && sig.TypeCovered == RecordTypeZONEMD
+}
+
+// VerifyZONEMD verifies the ZONEMD records at the apex of the zone with the
+// given origin, made up of the given records, returning an error wrapping
+// ErrZONEMDMismatch if the digest computed over the zone does not match. It
+// is an error for the zone to have no ZONEMD records with a supported scheme
+// and hash algorithm and the same serial number as its SOA record:
+// https://datatracker.ietf.org/doc/html/rfc8976#section-4
+func VerifyZONEMD(origin string, records []Record) error {
+	apex := canonicalName(origin)
+	var (
+		serial    uint32
+		soaCount  int
+		zonemds   []ZONEMD
+		zonemdErr error
+	)
+	for _, rec := range records {
+		if canonicalName(string(rec.Name)) != apex {
+			continue
+		}
+		switch rec.Type {
+		case RecordTypeSOA:
+			s, err := soaSerial(rec.Data)
+			if err != nil {
+				return err
+			}
+			serial = s
+			soaCount++
+		case RecordTypeZONEMD:
+			zonemd, err := parseZONEMD(rec.Data)
+			if err != nil {
+				zonemdErr = err
+				continue
+			}
+			zonemds = append(zonemds, zonemd)
+		}
+	}
+	if soaCount == 0 {
+		return fmt.Errorf("zone %s has no SOA record", origin)
+	}
+	if soaCount > 1 {
+		return fmt.Errorf("zone %s has %d SOA records", origin, soaCount)
+	}
+	if len(zonemds) == 0 {
+		if zonemdErr != nil {
+			return zonemdErr
+		}
+		return fmt.Errorf("zone %s has no ZONEMD records", origin)
+	}
+
+	// each scheme and hash algorithm may only be used once
+	seen := make(map[[2]uint8]bool)
+	for _, zonemd := range zonemds {
+		key := [2]uint8{zonemd.Scheme, zonemd.HashAlgorithm}
+		if seen[key] {
+			return fmt.Errorf("zone %s has multiple ZONEMD records with scheme %d and hash algorithm %d", origin, zonemd.Scheme, zonemd.HashAlgorithm)
+		}
+		seen[key] = true
+	}
+
+	tried := false
+	for _, zonemd := range zonemds {
+		if zonemd.Serial != serial || zonemd.Scheme != ZONEMDSchemeSimple {
+			continue
+		}
+		digest, err := ComputeZONEMD(origin, records, zonemd.HashAlgorithm)
+		if err != nil {
+			continue
+		}
+		tried = true
+		if bytes.Equal(digest, zonemd.Digest) {
+			return nil
+		}
+	}
+	if !tried {
+		return fmt.Errorf("zone %s has no ZONEMD records with a supported scheme and hash algorithm for serial %d", origin, serial)
+	}
+	return fmt.Errorf("zone %s: %w", origin, ErrZONEMDMismatch)
+}
+
+// soaSerial returns the serial number from the data of an SOA record.
+func soaSerial(data []byte) (uint32, error) {
+	v := byteview.New(data)
+	for i := 0; i < 2; i++ { // the primary name server and responsible mailbox
+		if _, err := decodeName(v); err != nil {
+			return 0, fmt.Errorf("invalid SOA record: %w", err)
+		}
+	}
+	bs, err := v.Next(4)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SOA record: %w", err)
+	}
+	return binary.BigEndian.Uint32(bs), nil
+}
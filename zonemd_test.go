@@ -0,0 +1,88 @@
+package dnstoy
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+)
+
+func soaRecord(zone string, serial uint32) Record {
+	data := append(encodeName("ns1."+zone), encodeName("hostmaster."+zone)...)
+	data = binary.BigEndian.AppendUint32(data, serial)
+	for _, v := range []uint32{3600, 600, 86400, 300} { // refresh, retry, expire, minimum
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	return Record{Name: []byte(zone), Type: RecordTypeSOA, Class: ResourceClassIN, TTL: 3600, Data: data}
+}
+
+func TestVerifyZONEMD(t *testing.T) {
+	t.Parallel()
+
+	zone := []Record{
+		soaRecord("example", 2018031900),
+		{Name: []byte("example"), Type: RecordTypeNS, Class: ResourceClassIN, TTL: 3600, Data: []byte("ns1.example")},
+		{Name: []byte("ns1.example"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{127, 0, 0, 1}},
+		{Name: []byte("www.example"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{192, 0, 2, 1}},
+		{Name: []byte("www.example"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{192, 0, 2, 2}},
+	}
+	withZONEMD := func(records []Record, zonemds ...ZONEMD) []Record {
+		records = append([]Record(nil), records...)
+		for _, zonemd := range zonemds {
+			records = append(records, Record{Name: []byte("example"), Type: RecordTypeZONEMD, Class: ResourceClassIN, TTL: 3600, Data: zonemd.Encode()})
+		}
+		return records
+	}
+	digest, err := ComputeZONEMD("example", zone, ZONEMDHashSHA384)
+	be.NilErr(t, err)
+	be.Equal(t, 48, len(digest))
+	valid := ZONEMD{Serial: 2018031900, Scheme: ZONEMDSchemeSimple, HashAlgorithm: ZONEMDHashSHA384, Digest: digest}
+
+	// the digest ignores record order, case, duplicates and records outside
+	// the zone
+	reordered := []Record{zone[4], zone[3], zone[2], zone[1], zone[0], zone[3]}
+	reordered[0].Name = []byte("WWW.Example.")
+	reordered = append(reordered, Record{Name: []byte("example.org"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 60, Data: []byte{1, 2, 3, 4}})
+	reorderedDigest, err := ComputeZONEMD("example.", reordered, ZONEMDHashSHA384)
+	be.NilErr(t, err)
+	be.DeepEqual(t, digest, reorderedDigest)
+
+	tampered := append([]Record(nil), zone...)
+	tampered[3] = Record{Name: []byte("www.example"), Type: RecordTypeA, Class: ResourceClassIN, TTL: 3600, Data: []byte{203, 0, 113, 1}}
+
+	unsupported := valid
+	unsupported.HashAlgorithm = 240
+	otherSerial := valid
+	otherSerial.Serial++
+
+	testCases := map[string]struct {
+		records []Record
+		wantErr string
+	}{
+		"valid":                  {records: withZONEMD(zone, valid)},
+		"valid with unsupported": {records: withZONEMD(zone, unsupported, valid)},
+		"tampered":               {records: withZONEMD(tampered, valid), wantErr: ErrZONEMDMismatch.Error()},
+		"no ZONEMD":              {records: zone, wantErr: "has no ZONEMD records"},
+		"serial mismatch":        {records: withZONEMD(zone, otherSerial), wantErr: "no ZONEMD records with a supported scheme"},
+		"unsupported hash":       {records: withZONEMD(zone, unsupported), wantErr: "no ZONEMD records with a supported scheme"},
+		"duplicate hash":         {records: withZONEMD(zone, valid, valid), wantErr: "multiple ZONEMD records"},
+		"no SOA":                 {records: withZONEMD(zone[1:], valid), wantErr: "has no SOA record"},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := VerifyZONEMD("example", tc.records)
+			if tc.wantErr == "" {
+				be.NilErr(t, err)
+				return
+			}
+			be.Nonzero(t, err)
+			be.In(t, tc.wantErr, err.Error())
+		})
+	}
+
+	err = VerifyZONEMD("example", withZONEMD(tampered, valid))
+	be.True(t, errors.Is(err, ErrZONEMDMismatch))
+}